@@ -0,0 +1,109 @@
+package app
+
+import (
+	"encoding/json"
+	"go-microservice-assignment/app/auth"
+	"go-microservice-assignment/app/storage"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+type retentionRequest struct {
+	IdleMinutes int    `json:"idleMinutes"`
+	LegalHold   bool   `json:"legalHold"`
+	Reason      string `json:"reason"`
+}
+
+func (a *app) GetRetentionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		policy, err := a.DB.GetRetention(r.Context(), mux.Vars(r)["id"])
+		if err != nil {
+			if err.Error() == "redis: nil" {
+				notFoundResponse(w)
+			} else {
+				log.Println("Error reading retention policy:", err)
+				serverError(w)
+			}
+			return
+		}
+		jsonResponse(w, http.StatusOK, policy)
+	}
+}
+
+func (a *app) SetRetentionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			log.Println("Error processing body request:", err)
+			badRequest(w, "Invalid request")
+			return
+		}
+		var request retentionRequest
+		if err = json.Unmarshal(body, &request); err != nil {
+			log.Println("Error unmarshalling body request:", err)
+			badRequest(w, "Invalid request")
+			return
+		}
+		if request.IdleMinutes < 0 {
+			badRequest(w, "Idle minutes must not be negative")
+			return
+		}
+		if request.Reason == "" {
+			badRequest(w, "Missing reason")
+			return
+		}
+
+		policy := storage.RetentionPolicy{
+			IdleMinutes: request.IdleMinutes,
+			LegalHold:   request.LegalHold,
+		}
+		err = a.DB.SetRetention(r.Context(), mux.Vars(r)["id"], &policy, authenticatedActor(r), request.Reason)
+		if err != nil {
+			if err.Error() == "redis: nil" {
+				notFoundResponse(w)
+			} else {
+				log.Println("Error writing retention policy:", err)
+				serverError(w)
+			}
+			return
+		}
+		jsonResponse(w, http.StatusOK, &policy)
+	}
+}
+
+func (a *app) ListRetentionAuditHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entries, err := a.DB.ListRetentionAudit(r.Context(), mux.Vars(r)["id"])
+		if err != nil {
+			log.Println("Error reading retention audit:", err)
+			serverError(w)
+			return
+		}
+		jsonResponse(w, http.StatusOK, entries)
+	}
+}
+
+// actor identifies who makes the request, for audit purposes. It is the
+// authenticated caller, or caller named by X-Actor header of trusted proxy
+// when authentication is disabled.
+func (a *app) actor(r *http.Request) string {
+	if principal := auth.FromContext(r.Context()); principal != nil {
+		return principal.Subject
+	}
+	if user := r.Header.Get("X-Actor"); user != "" && a.trustedProxy(remoteIP(r)) {
+		return user
+	}
+	return "anonymous"
+}
+
+// authenticatedActor is the authenticated caller, or anonymous when
+// authentication is disabled. Used where caller must not name itself.
+func authenticatedActor(r *http.Request) string {
+	if principal := auth.FromContext(r.Context()); principal != nil {
+		return principal.Subject
+	}
+	return "anonymous"
+}
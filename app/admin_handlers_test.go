@@ -0,0 +1,94 @@
+package app
+
+import (
+	"errors"
+	"go-microservice-assignment/app/auth"
+	"go-microservice-assignment/app/storage"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func createTestRetentionRequest(body string) *http.Request {
+	testRequest, _ := http.NewRequest("PUT", "/api/v1/admin/person/"+personId+"/retention", strings.NewReader(body))
+	testRequest = testRequest.WithContext(auth.WithPrincipal(testRequest.Context(), &auth.Principal{Subject: "compliance-officer"}))
+	return mux.SetURLVars(testRequest, map[string]string{"id": personId})
+}
+
+func TestSetRetentionHandler_PlaceLegalHold(t *testing.T) {
+	mockRedis := redisMock{}
+	mockRedis.On("SetRetention", mock.Anything, personId,
+		mock.MatchedBy(func(p *storage.RetentionPolicy) bool { return p.LegalHold }),
+		"compliance-officer", "litigation 42").Return(nil)
+
+	recorder := httptest.NewRecorder()
+	app := New(&mockRedis)
+	app.SetRetentionHandler().ServeHTTP(recorder, createTestRetentionRequest(`{"legalHold":true,"reason":"litigation 42"}`))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	mockRedis.AssertExpectations(t)
+}
+
+func TestSetRetentionHandler_ActorHeaderIgnored(t *testing.T) {
+	mockRedis := redisMock{}
+	mockRedis.On("SetRetention", mock.Anything, personId, mock.Anything, "anonymous", "litigation 42").Return(nil)
+
+	// authentication disabled, caller names itself
+	testRequest, _ := http.NewRequest("PUT", "/api/v1/admin/person/"+personId+"/retention",
+		strings.NewReader(`{"legalHold":true,"reason":"litigation 42"}`))
+	testRequest.Header.Set("X-Actor", "compliance-officer")
+	testRequest = mux.SetURLVars(testRequest, map[string]string{"id": personId})
+	recorder := httptest.NewRecorder()
+	app := New(&mockRedis)
+	app.SetRetentionHandler().ServeHTTP(recorder, testRequest)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	mockRedis.AssertExpectations(t)
+}
+
+func TestSetRetentionHandler_InvalidRequest(t *testing.T) {
+	requests := []string{
+		`{"legalHold":true}`,
+		`{"idleMinutes":-1,"reason":"test"}`,
+		`{"legalHold":`,
+	}
+	for _, request := range requests {
+		recorder := httptest.NewRecorder()
+		app := New(nil)
+		app.SetRetentionHandler().ServeHTTP(recorder, createTestRetentionRequest(request))
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, request)
+	}
+}
+
+func TestSetRetentionHandler_PersonNotFound(t *testing.T) {
+	mockRedis := redisMock{}
+	mockRedis.On("SetRetention", mock.Anything, personId, mock.Anything, mock.Anything, mock.Anything).
+		Return(errors.New("redis: nil"))
+
+	recorder := httptest.NewRecorder()
+	app := New(&mockRedis)
+	app.SetRetentionHandler().ServeHTTP(recorder, createTestRetentionRequest(`{"idleMinutes":60,"reason":"test"}`))
+
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
+
+func TestListRetentionAuditHandler_OkResponse(t *testing.T) {
+	mockRedis := redisMock{}
+	mockRedis.On("ListRetentionAudit", mock.Anything, personId).Return([]storage.RetentionAudit{
+		{Action: storage.RetentionHoldPlaced, Actor: "compliance-officer"},
+	}, nil)
+
+	recorder := httptest.NewRecorder()
+	testRequest, _ := http.NewRequest("GET", "/api/v1/admin/person/"+personId+"/retention/audit", nil)
+	app := New(&mockRedis)
+	app.ListRetentionAuditHandler().ServeHTTP(recorder, mux.SetURLVars(testRequest, map[string]string{"id": personId}))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"action":"hold_placed"`)
+}
@@ -0,0 +1,130 @@
+package app
+
+import (
+	"encoding/json"
+	"go-microservice-assignment/app/auth"
+	"go-microservice-assignment/app/tenant"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+)
+
+type apiKeyRequest struct {
+	Name   string   `json:"name"`
+	Owner  string   `json:"owner"`
+	Scopes []string `json:"scopes"`
+}
+
+// issuedAPIKey carries plaintext key, which is returned only when key is issued or rotated
+type issuedAPIKey struct {
+	Key    string       `json:"key"`
+	APIKey *auth.APIKey `json:"apiKey"`
+}
+
+func (a *app) IssueAPIKeyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			log.Println("Error processing body request:", err)
+			badRequest(w, "Invalid request")
+			return
+		}
+		var request apiKeyRequest
+		if err = json.Unmarshal(body, &request); err != nil {
+			log.Println("Error unmarshalling body request:", err)
+			badRequest(w, "Invalid request")
+			return
+		}
+		if request.Name == "" || request.Owner == "" {
+			badRequest(w, "Missing name or owner")
+			return
+		}
+
+		// key is bound to tenant the request is made for
+		plaintext, key, err := auth.IssueKey(r.Context(), a.APIKeys, request.Name, request.Owner, tenant.FromContext(r.Context()), request.Scopes)
+		if err != nil {
+			log.Println("Error issuing API key:", err)
+			serverError(w)
+			return
+		}
+		key.Hash = ""
+		jsonResponse(w, http.StatusCreated, issuedAPIKey{plaintext, key})
+	}
+}
+
+func (a *app) ListAPIKeysHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		all, err := a.APIKeys.ListKeys(r.Context())
+		if err != nil {
+			log.Println("Error listing API keys:", err)
+			serverError(w)
+			return
+		}
+		keys := []auth.APIKey{}
+		for _, key := range all {
+			if key.Tenant == tenant.FromContext(r.Context()) {
+				key.Hash = ""
+				keys = append(keys, key)
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+		jsonResponse(w, http.StatusOK, keys)
+	}
+}
+
+func (a *app) RotateAPIKeyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := a.checkKeyTenant(r)
+		var plaintext string
+		var key *auth.APIKey
+		if err == nil {
+			plaintext, key, err = auth.RotateKey(r.Context(), a.APIKeys, mux.Vars(r)["id"])
+		}
+		if err == auth.ErrAPIKeyNotFound {
+			notFoundResponse(w)
+			return
+		}
+		if err != nil {
+			log.Println("Error rotating API key:", err)
+			serverError(w)
+			return
+		}
+		key.Hash = ""
+		jsonResponse(w, http.StatusOK, issuedAPIKey{plaintext, key})
+	}
+}
+
+func (a *app) RevokeAPIKeyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := a.checkKeyTenant(r)
+		if err == nil {
+			err = auth.RevokeKey(r.Context(), a.APIKeys, mux.Vars(r)["id"])
+		}
+		if err == auth.ErrAPIKeyNotFound {
+			notFoundResponse(w)
+			return
+		}
+		if err != nil {
+			log.Println("Error revoking API key:", err)
+			serverError(w)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// checkKeyTenant returns auth.ErrAPIKeyNotFound for keys of other tenants, so
+// they cannot be rotated or revoked
+func (a *app) checkKeyTenant(r *http.Request) error {
+	key, err := a.APIKeys.GetKey(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		return err
+	}
+	if key.Tenant != tenant.FromContext(r.Context()) {
+		return auth.ErrAPIKeyNotFound
+	}
+	return nil
+}
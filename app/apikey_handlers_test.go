@@ -0,0 +1,140 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go-microservice-assignment/app/auth"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// in-memory auth.APIKeyStore
+type apiKeyStoreMock struct {
+	keys map[string]auth.APIKey
+}
+
+func (s *apiKeyStoreMock) SaveKey(ctx context.Context, k *auth.APIKey) error {
+	s.keys[k.Id] = *k
+	return nil
+}
+
+func (s *apiKeyStoreMock) GetKey(ctx context.Context, id string) (*auth.APIKey, error) {
+	k, ok := s.keys[id]
+	if !ok {
+		return nil, auth.ErrAPIKeyNotFound
+	}
+	return &k, nil
+}
+
+func (s *apiKeyStoreMock) UpdateKey(ctx context.Context, id string, update func(k *auth.APIKey) error) (*auth.APIKey, error) {
+	k, ok := s.keys[id]
+	if !ok {
+		return nil, auth.ErrAPIKeyNotFound
+	}
+	if err := update(&k); err != nil {
+		return nil, err
+	}
+	s.keys[id] = k
+	return &k, nil
+}
+
+func (s *apiKeyStoreMock) ListKeys(ctx context.Context) ([]auth.APIKey, error) {
+	var keys []auth.APIKey
+	for _, k := range s.keys {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+func (s *apiKeyStoreMock) KeyUsed(ctx context.Context, id string, t time.Time) error {
+	return nil
+}
+
+func TestIssueAPIKeyHandler(t *testing.T) {
+	store := &apiKeyStoreMock{map[string]auth.APIKey{}}
+	app := New(nil, WithAPIKeys(store, "bootstrap"))
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "/api/v1/admin/apikeys", strings.NewReader(`{"name":"importer","owner":"team-data","scopes":["admin"]}`))
+	request.Header.Set("X-API-Key", "bootstrap")
+	app.Router.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusCreated, recorder.Code)
+	var issued issuedAPIKey
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &issued))
+	assert.True(t, strings.HasPrefix(issued.Key, "pk_"))
+	assert.Empty(t, issued.APIKey.Hash)
+
+	// issued key authenticates and plaintext is never listed
+	recorder = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "/api/v1/admin/apikeys", nil)
+	request.Header.Set("X-API-Key", issued.Key)
+	app.Router.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.NotContains(t, recorder.Body.String(), issued.Key)
+	assert.NotContains(t, recorder.Body.String(), `"hash"`)
+}
+
+func TestRevokeAPIKeyHandler_NotFound(t *testing.T) {
+	app := New(nil, WithAPIKeys(&apiKeyStoreMock{map[string]auth.APIKey{}}, "bootstrap"))
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("DELETE", "/api/v1/admin/apikeys/123", nil)
+	request.Header.Set("X-API-Key", "bootstrap")
+	app.Router.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
+
+func TestAPIKeyHandlers_KeysOfOtherTenantsAreHidden(t *testing.T) {
+	store := &apiKeyStoreMock{map[string]auth.APIKey{}}
+	app := New(nil, WithAPIKeys(store, "bootstrap"))
+	bootstrapRequest := func(method string, path string, tenantId string, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest(method, path, strings.NewReader(body))
+		request.Header.Set("X-API-Key", "bootstrap")
+		if tenantId != "" {
+			request.Header.Set(tenantHeader, tenantId)
+		}
+		app.Router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	recorder := bootstrapRequest("POST", "/api/v1/admin/apikeys", "acme", `{"name":"importer","owner":"team-data"}`)
+	assert.Equal(t, http.StatusCreated, recorder.Code)
+	var issued issuedAPIKey
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &issued))
+	assert.Equal(t, "acme", issued.APIKey.Tenant)
+
+	assert.Equal(t, "[]", bootstrapRequest("GET", "/api/v1/admin/apikeys", "", "").Body.String())
+	assert.Equal(t, http.StatusNotFound, bootstrapRequest("DELETE", "/api/v1/admin/apikeys/"+issued.APIKey.Id, "globex", "").Code)
+	assert.Equal(t, http.StatusNoContent, bootstrapRequest("DELETE", "/api/v1/admin/apikeys/"+issued.APIKey.Id, "acme", "").Code)
+}
+
+// auth.APIKeyStore whose storage is down
+type unavailableKeyStore struct {
+	apiKeyStoreMock
+}
+
+func (s *unavailableKeyStore) GetKey(ctx context.Context, id string) (*auth.APIKey, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestAuthMiddleware_KeyStoreUnavailable(t *testing.T) {
+	app := New(nil, WithAPIKeys(&unavailableKeyStore{}, "bootstrap"))
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/api/v1/admin/apikeys", nil)
+	request.Header.Set("X-API-Key", "pk_0123456789abcdef_secret")
+	app.Router.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Empty(t, recorder.Header().Get("WWW-Authenticate"))
+}
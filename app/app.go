@@ -2,29 +2,258 @@ package app
 
 import (
 	"github.com/gorilla/mux"
+	"go-microservice-assignment/app/archive"
+	"go-microservice-assignment/app/audit"
+	"go-microservice-assignment/app/auth"
+	"go-microservice-assignment/app/gdpr"
+	"go-microservice-assignment/app/idempotency"
+	"go-microservice-assignment/app/projection"
+	"go-microservice-assignment/app/ratelimit"
 	"go-microservice-assignment/app/storage"
+	"go-microservice-assignment/app/webhooks"
+	"net"
+	"sync"
+	"time"
 )
 
 type app struct {
 	Router *mux.Router
 	DB storage.RedisDB
+	Events storage.EventLog
+	Webhooks webhooks.Store
+	Archive archive.Sink
+	Audit audit.Store
+	Idempotency idempotency.Store
+	ErasureSigner *gdpr.Signer
+	RateLimiter ratelimit.Limiter
+	rateLimits *ratelimit.Config
+	archiveFallback bool
+	readRefreshesExpiry bool
+	heartbeatInterval time.Duration
+	// limits concurrent event streams, each holds a Redis connection
+	eventStreams chan struct{}
+	// closed on shutdown, ends event streams while other requests complete
+	shutdown chan struct{}
+	shutdownOnce sync.Once
+	maxBatchSize int
+	authenticators []auth.Authenticator
+	APIKeys auth.APIKeyStore
+	policy *auth.Policy
+	projection *projection.Policy
+	tenantRequired bool
+	// proxies whose X-Forwarded-For and X-Actor headers are trusted
+	trustedProxies []*net.IPNet
 }
 
-func New(db storage.RedisDB) *app {
+// Option configures optional dependencies and settings of the app
+type Option func(*app)
+
+// WithEventLog enables streaming of person change events
+func WithEventLog(events storage.EventLog) Option {
+	return func(a *app) {
+		a.Events = events
+	}
+}
+
+// WithWebhooks enables webhook registration API
+func WithWebhooks(store webhooks.Store) Option {
+	return func(a *app) {
+		a.Webhooks = store
+	}
+}
+
+// WithArchive enables restoring of archived persons. With readFallback, GET of
+// person missing in Redis returns its archived copy.
+func WithArchive(sink archive.Sink, readFallback bool) Option {
+	return func(a *app) {
+		a.Archive = sink
+		a.archiveFallback = readFallback
+	}
+}
+
+// WithAuthenticators requires every request, except health probes, to be
+// authenticated by one of given authenticators
+func WithAuthenticators(authenticators ...auth.Authenticator) Option {
+	return func(a *app) {
+		a.authenticators = append(a.authenticators, authenticators...)
+	}
+}
+
+// WithPolicy replaces default authorization policy of authenticated callers
+func WithPolicy(policy *auth.Policy) Option {
+	return func(a *app) {
+		a.policy = policy
+	}
+}
+
+// WithProjection replaces default policy masking and hiding fields of
+// returned persons according to scopes of caller
+func WithProjection(policy *projection.Policy) Option {
+	return func(a *app) {
+		a.projection = policy
+	}
+}
+
+// WithAPIKeys enables API key authentication and admin API managing the keys
+func WithAPIKeys(store auth.APIKeyStore, bootstrapKey string) Option {
+	return func(a *app) {
+		a.APIKeys = store
+		a.authenticators = append(a.authenticators, auth.NewAPIKeyAuthenticator(store, bootstrapKey))
+	}
+}
+
+// WithAudit enables audit log query and export API
+func WithAudit(store audit.Store) Option {
+	return func(a *app) {
+		a.Audit = store
+	}
+}
+
+// WithTrustedProxies trusts X-Forwarded-For and X-Actor headers of requests
+// coming from given networks, headers of other requests are ignored
+func WithTrustedProxies(proxies []*net.IPNet) Option {
+	return func(a *app) {
+		a.trustedProxies = proxies
+	}
+}
+
+// WithIdempotency enables Idempotency-Key header on person creation
+func WithIdempotency(store idempotency.Store) Option {
+	return func(a *app) {
+		a.Idempotency = store
+	}
+}
+
+// WithErasure enables erasure of persons, receipts of erasure are signed by signer
+func WithErasure(signer *gdpr.Signer) Option {
+	return func(a *app) {
+		a.ErasureSigner = signer
+	}
+}
+
+// WithMaxBatchSize limits number of operations in a single batch request
+func WithMaxBatchSize(size int) Option {
+	return func(a *app) {
+		a.maxBatchSize = size
+	}
+}
+
+// WithReadRefreshesExpiry makes every GET of person restart its idle period
+func WithReadRefreshesExpiry(enabled bool) Option {
+	return func(a *app) {
+		a.readRefreshesExpiry = enabled
+	}
+}
+
+// WithRateLimit limits requests of every client with per-route limits of config
+func WithRateLimit(limiter ratelimit.Limiter, config *ratelimit.Config) Option {
+	return func(a *app) {
+		a.RateLimiter = limiter
+		a.rateLimits = config
+	}
+}
+
+// WithTenantRequired rejects requests not made for a named tenant
+func WithTenantRequired(required bool) Option {
+	return func(a *app) {
+		a.tenantRequired = required
+	}
+}
+
+// WithHeartbeatInterval sets how often idle event streams receive a heartbeat
+func WithHeartbeatInterval(interval time.Duration) Option {
+	return func(a *app) {
+		a.heartbeatInterval = interval
+	}
+}
+
+// WithMaxEventStreams limits number of concurrent event streams, further
+// streams are rejected with 503
+func WithMaxEventStreams(max int) Option {
+	return func(a *app) {
+		a.eventStreams = make(chan struct{}, max)
+	}
+}
+
+func New(db storage.RedisDB, options ...Option) *app {
 	app:= &app {
 		Router: mux.NewRouter(),
 		DB: db,
+		heartbeatInterval: 15 * time.Second,
+		eventStreams: make(chan struct{}, 100),
+		shutdown: make(chan struct{}),
+		maxBatchSize: 100,
+		policy: auth.DefaultPolicy(),
+		projection: projection.DefaultPolicy(),
+	}
+	for _, option := range options {
+		option(app)
 	}
 	app.initRoutes()
 	return app
 }
 
+// CloseEventStreams ends open event streams, so that graceful shutdown of the
+// server does not wait for them. Other requests are left to complete.
+func (a *app) CloseEventStreams() {
+	a.shutdownOnce.Do(func() {
+		close(a.shutdown)
+	})
+}
+
 func (a *app) initRoutes() {
+	a.Router.Use(a.authMiddleware)
+	a.Router.Use(a.tenantMiddleware)
+	a.Router.Use(a.rateLimitMiddleware)
+	a.Router.Use(a.authzMiddleware)
+	a.Router.Use(a.personIdMiddleware)
+	a.Router.Use(a.auditMiddleware)
 	a.Router.HandleFunc("/", a.IndexHandler()).Methods("GET")
 	a.Router.HandleFunc("/health", a.HealthHandler()).Methods("GET")
 	a.Router.HandleFunc("/readiness", a.ReadinessHandler()).Methods("GET")
-	a.Router.HandleFunc("/api/v1/person", a.CreatePersonHandler()).Methods("POST")
+	a.Router.HandleFunc("/api/v1/person", a.idempotent(a.CreatePersonHandler())).Methods("POST")
+	a.Router.HandleFunc("/api/v1/person", a.ListPersonsHandler()).Methods("GET")
+	a.Router.HandleFunc("/api/v1/person:batch", a.BatchHandler()).Methods("POST")
+	a.Router.HandleFunc("/api/v1/person/export", a.ExportPersonsHandler()).Methods("GET")
+	a.Router.HandleFunc("/api/v1/person/import", a.ImportPersonsHandler()).Methods("POST")
+	a.Router.HandleFunc("/api/v1/person/events", a.PersonEventsHandler()).Methods("GET")
 	a.Router.HandleFunc("/api/v1/person/{id}", a.GetPersonHandler()).Methods("GET")
+	a.Router.HandleFunc("/api/v1/person/{id}", a.DeletePersonHandler()).Methods("DELETE")
+	a.Router.HandleFunc("/api/v1/person/{id}/undelete", a.UndeletePersonHandler()).Methods("POST")
+	a.Router.HandleFunc("/api/v1/person/{id}/events", a.notDeleted(a.PersonEventsHandler())).Methods("GET")
+	a.Router.HandleFunc("/api/v1/person/{id}/restore", a.RestorePersonHandler()).Methods("POST")
+	a.Router.HandleFunc("/api/v1/person/{id}/touch", a.notDeleted(a.TouchPersonHandler())).Methods("POST")
+	a.Router.HandleFunc("/api/v1/person/{id}/history", a.notDeleted(a.PersonHistoryHandler())).Methods("GET")
+	a.Router.HandleFunc("/api/v1/person/{id}/revert", a.RevertPersonHandler()).Methods("POST")
+	a.Router.HandleFunc("/api/v1/person/{id}/ttl", a.notDeleted(a.PersonTTLHandler())).Methods("GET")
 	a.Router.HandleFunc("/api/v1/person", a.UpdatePersonOptimisticHandler()).Methods("PATCH")
 	a.Router.HandleFunc("/api/v1/person/pessimistic", a.UpdatePersonPessimisticHandler()).Methods("PATCH")
+	a.Router.HandleFunc("/api/v1/admin/person/{id}/retention", a.notDeleted(a.GetRetentionHandler())).Methods("GET")
+	a.Router.HandleFunc("/api/v1/admin/person/{id}/retention", a.notDeleted(a.SetRetentionHandler())).Methods("PUT")
+	a.Router.HandleFunc("/api/v1/admin/person/{id}/retention/audit", a.notDeleted(a.ListRetentionAuditHandler())).Methods("GET")
+	a.Router.HandleFunc("/api/v1/admin/person/{id}/export", a.ExportPersonDataHandler()).Methods("GET")
+	if cache, ok := a.DB.(*storage.CachedDB); ok {
+		a.Router.HandleFunc("/api/v1/admin/cache", a.CacheStatsHandler(cache)).Methods("GET")
+	}
+	if a.ErasureSigner != nil {
+		a.Router.HandleFunc("/api/v1/admin/person/{id}/erase", a.ErasePersonHandler()).Methods("POST")
+		a.Router.HandleFunc("/api/v1/admin/erasure-key", a.ErasureKeyHandler()).Methods("GET")
+	}
+	if a.APIKeys != nil {
+		a.Router.HandleFunc("/api/v1/admin/apikeys", a.IssueAPIKeyHandler()).Methods("POST")
+		a.Router.HandleFunc("/api/v1/admin/apikeys", a.ListAPIKeysHandler()).Methods("GET")
+		a.Router.HandleFunc("/api/v1/admin/apikeys/{id}/rotate", a.RotateAPIKeyHandler()).Methods("POST")
+		a.Router.HandleFunc("/api/v1/admin/apikeys/{id}", a.RevokeAPIKeyHandler()).Methods("DELETE")
+	}
+	if a.Audit != nil {
+		a.Router.HandleFunc("/api/v1/audit", a.QueryAuditHandler()).Methods("GET")
+		a.Router.HandleFunc("/api/v1/audit/export", a.ExportAuditHandler()).Methods("GET")
+	}
+	if a.Webhooks != nil {
+		a.Router.HandleFunc("/api/v1/webhooks", a.CreateWebhookHandler()).Methods("POST")
+		a.Router.HandleFunc("/api/v1/webhooks", a.ListWebhooksHandler()).Methods("GET")
+		a.Router.HandleFunc("/api/v1/webhooks/{id}", a.DeleteWebhookHandler()).Methods("DELETE")
+		a.Router.HandleFunc("/api/v1/webhooks/{id}/deliveries", a.ListWebhookDeliveriesHandler()).Methods("GET")
+		a.Router.HandleFunc("/api/v1/webhooks/{id}/dead-letters", a.ListWebhookDeadLettersHandler()).Methods("GET")
+	}
 }
\ No newline at end of file
@@ -0,0 +1,208 @@
+package archive
+
+import (
+	"context"
+	"go-microservice-assignment/app/models"
+	"go-microservice-assignment/app/storage"
+	"go-microservice-assignment/app/tenant"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/go-redsync/redsync/v4"
+)
+
+const (
+	expiredKeySuffix = "_expire"
+	sweepBatchSize   = 100
+	leaderLockName   = "archiver-leader"
+)
+
+// Archiver moves idle persons to an archive sink. It reacts to Redis keyspace
+// notifications about expired "<id>_expire" keys and periodically sweeps all
+// persons in case a notification was missed (e.g. during restart). Only the
+// replica holding the leader lock archives persons.
+type Archiver struct {
+	client        *redis.Client
+	db            storage.RedisDB
+	sink          Sink
+	leader        *redsync.Mutex
+	leaderTTL     time.Duration
+	sweepInterval time.Duration
+	// 1 while this replica holds the leader lock, renewed independently of archiving
+	isLeader int32
+}
+
+func NewArchiver(client *redis.Client, rs *redsync.Redsync, db storage.RedisDB, sink Sink, sweepInterval time.Duration) *Archiver {
+	leaderTTL := 30 * time.Second
+	return &Archiver{
+		client:        client,
+		db:            db,
+		sink:          sink,
+		leader:        rs.NewMutex(leaderLockName, redsync.WithExpiry(leaderTTL), redsync.WithTries(1)),
+		leaderTTL:     leaderTTL,
+		sweepInterval: sweepInterval,
+	}
+}
+
+// Run archives idle persons until ctx is cancelled
+func (a *Archiver) Run(ctx context.Context) {
+	if err := enableExpiredNotifications(ctx, a.client); err != nil {
+		log.Println("Could not enable keyspace notifications, relying on sweep only:", err)
+	}
+
+	channel := "__keyevent@" + dbIndex(a.client) + "__:expired"
+	sub := a.client.Subscribe(ctx, channel)
+	defer sub.Close()
+
+	sweep := time.NewTicker(a.sweepInterval)
+	defer sweep.Stop()
+
+	// lock is renewed in its own goroutine, so long sweeps do not lose it
+	a.elect(ctx)
+	go a.lead(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sweep.C:
+			if a.leading() {
+				a.Sweep(ctx)
+			}
+		case msg, ok := <-sub.Channel():
+			if !ok {
+				return
+			}
+			if a.leading() && strings.HasSuffix(msg.Payload, expiredKeySuffix) {
+				tenantId, id := tenant.SplitKey(strings.TrimSuffix(msg.Payload, expiredKeySuffix))
+				a.archive(tenant.WithTenant(ctx, tenantId), id)
+			}
+		}
+	}
+}
+
+// Sweep archives persons of all tenants whose expire key no longer exists,
+// it stops when replica is no longer leader
+func (a *Archiver) Sweep(ctx context.Context) {
+	if err := storage.ForEachTenant(ctx, a.db, a.sweepTenant); err != nil {
+		log.Println("Error listing tenants for archiving:", err)
+	}
+}
+
+func (a *Archiver) sweepTenant(ctx context.Context) {
+	var cursor uint64
+	for {
+		if !a.leading() {
+			log.Println("Archiver stopped sweep after losing leadership")
+			return
+		}
+		ids, next, err := a.db.ScanPersonIds(ctx, cursor, sweepBatchSize)
+		if err != nil {
+			log.Println("Error scanning persons for archiving:", err)
+			return
+		}
+		for _, id := range ids {
+			a.archive(ctx, id)
+		}
+		if next == 0 || ctx.Err() != nil {
+			return
+		}
+		cursor = next
+	}
+}
+
+func (a *Archiver) archive(ctx context.Context, id string) {
+	put := false
+	archived, err := a.db.ExpirePerson(ctx, id, func(p *models.Person) error {
+		put = true
+		return a.sink.Put(ctx, p)
+	})
+	// person changed or was erased after it was put to the sink, the copy
+	// must not outlive it
+	if err == redis.TxFailedErr && put {
+		if derr := a.sink.Delete(ctx, id); derr != nil {
+			log.Println("Error discarding archived copy of person", id, derr)
+		}
+	}
+	if err != nil {
+		log.Println("Error archiving person", id, err)
+		return
+	}
+	if archived {
+		log.Println("Archived idle person", id)
+	}
+}
+
+// lead renews the leader lock well before it expires until ctx is cancelled
+func (a *Archiver) lead(ctx context.Context) {
+	renew := time.NewTicker(a.leaderTTL / 3)
+	defer renew.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			a.resign()
+			return
+		case <-renew.C:
+			a.elect(ctx)
+		}
+	}
+}
+
+func (a *Archiver) leading() bool {
+	return atomic.LoadInt32(&a.isLeader) == 1
+}
+
+// elect acquires or extends the leader lock
+func (a *Archiver) elect(ctx context.Context) {
+	if a.leading() {
+		if ok, err := a.leader.ExtendContext(ctx); err == nil && ok {
+			return
+		}
+		log.Println("Archiver lost leadership")
+		atomic.StoreInt32(&a.isLeader, 0)
+	}
+	if err := a.leader.LockContext(ctx); err == nil {
+		log.Println("Archiver acquired leadership")
+		atomic.StoreInt32(&a.isLeader, 1)
+	}
+}
+
+func (a *Archiver) resign() {
+	if !a.leading() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	a.leader.UnlockContext(ctx)
+	atomic.StoreInt32(&a.isLeader, 0)
+}
+
+// enableExpiredNotifications turns on keyevent notifications for expired keys
+// (off by default) and keeps any other notification flags already configured
+func enableExpiredNotifications(ctx context.Context, client *redis.Client) error {
+	config, err := client.ConfigGet(ctx, "notify-keyspace-events").Result()
+	if err != nil {
+		return err
+	}
+	flags := ""
+	if len(config) == 2 {
+		flags, _ = config[1].(string)
+	}
+	if strings.Contains(flags, "E") && (strings.Contains(flags, "x") || strings.Contains(flags, "A")) {
+		return nil
+	}
+	if !strings.Contains(flags, "E") {
+		flags += "E"
+	}
+	if !strings.Contains(flags, "x") && !strings.Contains(flags, "A") {
+		flags += "x"
+	}
+	return client.ConfigSet(ctx, "notify-keyspace-events", flags).Err()
+}
+
+func dbIndex(client *redis.Client) string {
+	return strconv.Itoa(client.Options().DB)
+}
@@ -0,0 +1,287 @@
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"go-microservice-assignment/app/models"
+	"go-microservice-assignment/app/storage"
+	"go-microservice-assignment/app/tenant"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// mock for storage.RedisDB
+type dbMock struct {
+	mock.Mock
+}
+
+func (m *dbMock) GetPerson(ctx context.Context, id string) (*models.Person, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*models.Person), args.Error(1)
+}
+
+func (m *dbMock) CreatePerson(ctx context.Context, p *models.Person) error {
+	args := m.Called(ctx, p)
+	return args.Error(0)
+}
+
+func (m *dbMock) UpdatePersonOptimistic(ctx context.Context, p *models.Person) (*models.Person, error) {
+	args := m.Called(ctx, p)
+	return args.Get(0).(*models.Person), args.Error(1)
+}
+
+func (m *dbMock) UpdatePersonPessimistic(ctx context.Context, p *models.Person) (*models.Person, error) {
+	args := m.Called(ctx, p)
+	return args.Get(0).(*models.Person), args.Error(1)
+}
+
+func (m *dbMock) ExpirePerson(ctx context.Context, id string, archive func(p *models.Person) error) (bool, error) {
+	args := m.Called(ctx, id, archive)
+	if person, ok := args.Get(0).(*models.Person); ok {
+		if err := archive(person); err != nil {
+			return false, err
+		}
+		return args.Error(1) == nil, args.Error(1)
+	}
+	return false, args.Error(1)
+}
+
+func (m *dbMock) ScanPersonIds(ctx context.Context, cursor uint64, count int64) ([]string, uint64, error) {
+	args := m.Called(ctx, cursor, count)
+	return args.Get(0).([]string), args.Get(1).(uint64), args.Error(2)
+}
+
+func (m *dbMock) RestorePerson(ctx context.Context, id string, load func(id string) (*models.Person, error)) (*models.Person, error) {
+	args := m.Called(ctx, id, load)
+	return args.Get(0).(*models.Person), args.Error(1)
+}
+
+func (m *dbMock) TouchPerson(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *dbMock) GetPersonTTL(ctx context.Context, id string) (time.Duration, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(time.Duration), args.Error(1)
+}
+
+func (m *dbMock) GetRetention(ctx context.Context, id string) (*storage.RetentionPolicy, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*storage.RetentionPolicy), args.Error(1)
+}
+
+func (m *dbMock) SetRetention(ctx context.Context, id string, policy *storage.RetentionPolicy, actor string, reason string) error {
+	args := m.Called(ctx, id, policy, actor, reason)
+	return args.Error(0)
+}
+
+func (m *dbMock) ListRetentionAudit(ctx context.Context, id string) ([]storage.RetentionAudit, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).([]storage.RetentionAudit), args.Error(1)
+}
+
+func (m *dbMock) GetHistory(ctx context.Context, id string) ([]storage.PersonVersion, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).([]storage.PersonVersion), args.Error(1)
+}
+
+func (m *dbMock) GetPersonAsOf(ctx context.Context, id string, asOf time.Time) (*models.Person, error) {
+	args := m.Called(ctx, id, asOf)
+	return args.Get(0).(*models.Person), args.Error(1)
+}
+
+func (m *dbMock) RevertPerson(ctx context.Context, id string, version int) (*models.Person, error) {
+	args := m.Called(ctx, id, version)
+	return args.Get(0).(*models.Person), args.Error(1)
+}
+
+func (m *dbMock) ListPersons(ctx context.Context) ([]*models.Person, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]*models.Person), args.Error(1)
+}
+
+func (m *dbMock) ScanPersons(ctx context.Context, cursor uint64, count int64) ([]*models.Person, uint64, error) {
+	args := m.Called(ctx, cursor, count)
+	return args.Get(0).([]*models.Person), args.Get(1).(uint64), args.Error(2)
+}
+
+func (m *dbMock) DeletePerson(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *dbMock) UndeletePerson(ctx context.Context, id string) (*models.Person, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*models.Person), args.Error(1)
+}
+
+func (m *dbMock) PurgePerson(ctx context.Context, id string, gracePeriod time.Duration) (bool, error) {
+	args := m.Called(ctx, id, gracePeriod)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *dbMock) ExecuteBatch(ctx context.Context, ops []storage.BatchOperation, atomic bool) ([]storage.BatchResult, error) {
+	args := m.Called(ctx, ops, atomic)
+	return args.Get(0).([]storage.BatchResult), args.Error(1)
+}
+
+func (m *dbMock) ListTenants(ctx context.Context) ([]string, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *dbMock) FindPersons(ctx context.Context, field string, value string) ([]*models.Person, error) {
+	args := m.Called(ctx, field, value)
+	return args.Get(0).([]*models.Person), args.Error(1)
+}
+
+func (m *dbMock) ReencryptPerson(ctx context.Context, id string) (bool, error) {
+	args := m.Called(ctx, id)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *dbMock) ListPersonsPage(ctx context.Context, query storage.PageQuery) ([]*models.Person, int, error) {
+	args := m.Called(ctx, query)
+	return args.Get(0).([]*models.Person), args.Int(1), args.Error(2)
+}
+
+func (m *dbMock) BackfillOrder(ctx context.Context, id string) (bool, error) {
+	args := m.Called(ctx, id)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *dbMock) MigrateAddress(ctx context.Context, id string, defaultCountry string) (bool, error) {
+	args := m.Called(ctx, id, defaultCountry)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *dbMock) ErasePerson(ctx context.Context, id string) (*storage.Erasure, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*storage.Erasure), args.Error(1)
+}
+
+// in-memory Sink used by archiver tests
+type memorySink struct {
+	persons map[string]models.Person
+}
+
+func (s *memorySink) Put(ctx context.Context, p *models.Person) error {
+	s.persons[p.Id] = *p
+	return nil
+}
+
+func (s *memorySink) Get(ctx context.Context, id string) (*models.Person, error) {
+	if p, ok := s.persons[id]; ok {
+		return &p, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (s *memorySink) Delete(ctx context.Context, id string) error {
+	delete(s.persons, id)
+	return nil
+}
+
+func TestArchiver_Sweep(t *testing.T) {
+	ctx := context.Background()
+	mockDB := dbMock{}
+	mockDB.On("ListTenants", ctx).Return([]string{}, nil)
+	mockDB.On("ScanPersonIds", ctx, uint64(0), int64(sweepBatchSize)).Return([]string{"1", "2"}, uint64(7), nil)
+	mockDB.On("ScanPersonIds", ctx, uint64(7), int64(sweepBatchSize)).Return([]string{"3"}, uint64(0), nil)
+	// person 1 and 3 are idle, person 2 was updated recently
+	mockDB.On("ExpirePerson", ctx, "1", mock.Anything).Return(&models.Person{Id: "1"}, nil)
+	mockDB.On("ExpirePerson", ctx, "2", mock.Anything).Return(nil, nil)
+	mockDB.On("ExpirePerson", ctx, "3", mock.Anything).Return(&models.Person{Id: "3"}, nil)
+
+	sink := &memorySink{persons: map[string]models.Person{}}
+	archiver := &Archiver{db: &mockDB, sink: sink, isLeader: 1}
+	archiver.Sweep(ctx)
+
+	mockDB.AssertExpectations(t)
+	assert.Len(t, sink.persons, 2)
+	assert.Contains(t, sink.persons, "1")
+	assert.Contains(t, sink.persons, "3")
+}
+
+func TestArchiver_DiscardsCopyWhenExpiryFails(t *testing.T) {
+	ctx := context.Background()
+	mockDB := dbMock{}
+	// person was erased concurrently, transaction deleting it was aborted
+	mockDB.On("ExpirePerson", ctx, "1", mock.Anything).Return(&models.Person{Id: "1"}, redis.TxFailedErr)
+
+	sink := &memorySink{persons: map[string]models.Person{}}
+	archiver := &Archiver{db: &mockDB, sink: sink, isLeader: 1}
+	archiver.archive(ctx, "1")
+
+	mockDB.AssertExpectations(t)
+	assert.Empty(t, sink.persons)
+}
+
+func TestArchiver_SweepStopsWithoutLeadership(t *testing.T) {
+	ctx := context.Background()
+	mockDB := dbMock{}
+	mockDB.On("ListTenants", ctx).Return([]string{}, nil)
+	mockDB.On("ScanPersonIds", ctx, uint64(0), int64(sweepBatchSize)).Return([]string{"1"}, uint64(7), nil)
+
+	sink := &memorySink{persons: map[string]models.Person{}}
+	archiver := &Archiver{db: &mockDB, sink: sink, isLeader: 1}
+	// leadership is lost while first batch is archived
+	mockDB.On("ExpirePerson", ctx, "1", mock.Anything).Return(&models.Person{Id: "1"}, nil).
+		Run(func(args mock.Arguments) { archiver.isLeader = 0 })
+	archiver.Sweep(ctx)
+
+	mockDB.AssertNotCalled(t, "ScanPersonIds", ctx, uint64(7), int64(sweepBatchSize))
+	assert.Len(t, sink.persons, 1)
+}
+
+func TestFileSink_Put(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := NewFileSink(dir)
+	assert.NoError(t, err)
+
+	err = sink.Put(context.Background(), &models.Person{Id: "123", Name: "Test123"})
+	assert.NoError(t, err)
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "123.json"))
+	assert.NoError(t, err)
+	var person models.Person
+	assert.NoError(t, json.Unmarshal(data, &person))
+	assert.Equal(t, "Test123", person.Name)
+
+	archived, err := sink.Get(context.Background(), "123")
+	assert.NoError(t, err)
+	assert.Equal(t, "Test123", archived.Name)
+
+	_, err = sink.Get(context.Background(), "456")
+	assert.Equal(t, ErrNotFound, err)
+
+	assert.NoError(t, sink.Delete(context.Background(), "123"))
+	_, err = sink.Get(context.Background(), "123")
+	assert.Equal(t, ErrNotFound, err)
+	assert.NoError(t, sink.Delete(context.Background(), "123"))
+}
+
+func TestFileSink_TenantDirectory(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := NewFileSink(dir)
+	assert.NoError(t, err)
+	acme := tenant.WithTenant(context.Background(), "acme")
+
+	assert.NoError(t, sink.Put(acme, &models.Person{Id: "123", Name: "Test123"}))
+
+	_, err = os.Stat(filepath.Join(dir, "acme", "123.json"))
+	assert.NoError(t, err)
+	_, err = sink.Get(context.Background(), "123")
+	assert.Equal(t, ErrNotFound, err)
+	archived, err := sink.Get(acme, "123")
+	assert.NoError(t, err)
+	assert.Equal(t, "Test123", archived.Name)
+}
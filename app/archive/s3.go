@@ -0,0 +1,193 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go-microservice-assignment/app/models"
+	"go-microservice-assignment/app/tenant"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Config describes S3-compatible object storage (AWS S3, MinIO, ...)
+type S3Config struct {
+	Endpoint  string // e.g. https://s3.eu-central-1.amazonaws.com or http://minio:9000
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+}
+
+type s3Sink struct {
+	config S3Config
+	client *http.Client
+}
+
+// NewS3Sink writes each archived person to object <prefix><id>.json using
+// path-style requests signed with AWS Signature Version 4
+func NewS3Sink(config S3Config) Sink {
+	return &s3Sink{
+		config: config,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (s *s3Sink) Put(ctx context.Context, p *models.Person) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	res, err := s.do(ctx, "PUT", p.Id, data)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("s3 put of %s failed with status %d", p.Id, res.StatusCode)
+	}
+	return nil
+}
+
+func (s *s3Sink) Get(ctx context.Context, id string) (*models.Person, error) {
+	res, err := s.do(ctx, "GET", id, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("s3 get of %s failed with status %d", id, res.StatusCode)
+	}
+	var person models.Person
+	if err = json.NewDecoder(res.Body).Decode(&person); err != nil {
+		return nil, err
+	}
+	return &person, nil
+}
+
+func (s *s3Sink) Delete(ctx context.Context, id string) error {
+	res, err := s.do(ctx, "DELETE", id, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	// S3 answers 204 also for missing objects
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("s3 delete of %s failed with status %d", id, res.StatusCode)
+	}
+	return nil
+}
+
+func (s *s3Sink) do(ctx context.Context, method string, id string, body []byte) (*http.Response, error) {
+	segments := strings.Split(s.config.Prefix+tenant.ObjectPrefix(ctx)+id+".json", "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	objectUrl := strings.TrimRight(s.config.Endpoint, "/") + "/" + s.config.Bucket + "/" + strings.Join(segments, "/")
+	req, err := http.NewRequestWithContext(ctx, method, objectUrl, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	signV4(req, body, s.config.AccessKey, s.config.SecretKey, s.config.Region, time.Now().UTC())
+	return s.client.Do(req)
+}
+
+// signV4 adds AWS Signature Version 4 authorization to request. All headers
+// already set on request are signed.
+func signV4(req *http.Request, body []byte, accessKey, secretKey, region string, now time.Time) {
+	const service = "s3"
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vs := values[k]
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
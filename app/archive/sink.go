@@ -0,0 +1,89 @@
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go-microservice-assignment/app/models"
+	"go-microservice-assignment/app/tenant"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var ErrNotFound = errors.New("person not found in archive")
+
+// Sink stores archived persons outside of Redis
+type Sink interface {
+	Put(ctx context.Context, p *models.Person) error
+	// Get returns archived person or ErrNotFound
+	Get(ctx context.Context, id string) (*models.Person, error)
+	// Delete removes archived person, missing person is not an error
+	Delete(ctx context.Context, id string) error
+}
+
+type fileSink struct {
+	dir string
+}
+
+// NewFileSink writes each archived person to <dir>/<id>.json, persons of
+// tenants other than the default one to <dir>/<tenant>/<id>.json
+func NewFileSink(dir string) (Sink, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	return &fileSink{dir}, nil
+}
+
+func (s *fileSink) Put(ctx context.Context, p *models.Person) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	path := s.path(ctx, p.Id)
+	if err = os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	// write to temporary file first, so readers never see partial archive
+	tmp, err := ioutil.TempFile(filepath.Dir(path), p.Id+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *fileSink) Get(ctx context.Context, id string) (*models.Person, error) {
+	data, err := ioutil.ReadFile(s.path(ctx, id))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var person models.Person
+	if err = json.Unmarshal(data, &person); err != nil {
+		return nil, err
+	}
+	return &person, nil
+}
+
+func (s *fileSink) Delete(ctx context.Context, id string) error {
+	err := os.Remove(s.path(ctx, id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *fileSink) path(ctx context.Context, id string) string {
+	return filepath.Join(s.dir, tenant.ObjectPrefix(ctx), filepath.Base(id)+".json")
+}
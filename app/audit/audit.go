@@ -0,0 +1,114 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Metadata describes who made a change and through which request
+type Metadata struct {
+	Actor     string `json:"actor"`
+	SourceIP  string `json:"sourceIp,omitempty"`
+	RequestId string `json:"requestId,omitempty"`
+	Tenant    string `json:"tenant,omitempty"`
+}
+
+type contextKey struct{}
+
+// WithMetadata returns context carrying audit metadata of the current request
+func WithMetadata(ctx context.Context, m Metadata) context.Context {
+	return context.WithValue(ctx, contextKey{}, m)
+}
+
+// FromContext returns audit metadata of the current request. Changes made
+// outside of a request (e.g. by archiver) are attributed to "system".
+func FromContext(ctx context.Context) Metadata {
+	if m, ok := ctx.Value(contextKey{}).(Metadata); ok {
+		return m
+	}
+	return Metadata{Actor: "system"}
+}
+
+// FieldChange is a change of a single field, nested fields use dotted paths
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old,omitempty"`
+	New   interface{} `json:"new,omitempty"`
+}
+
+// Entry is a single audited change of a person
+type Entry struct {
+	Id       string        `json:"id"`
+	Time     time.Time     `json:"time"`
+	Action   string        `json:"action"`
+	PersonId string        `json:"personId"`
+	Changes  []FieldChange `json:"changes,omitempty"`
+	Metadata
+}
+
+// Diff returns field-level differences between two versions of a value,
+// using their JSON representation. Either of them can be nil.
+func Diff(before, after interface{}) ([]FieldChange, error) {
+	oldFields, err := flatten(before)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := flatten(after)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]bool{}
+	for f := range oldFields {
+		fields[f] = true
+	}
+	for f := range newFields {
+		fields[f] = true
+	}
+	names := make([]string, 0, len(fields))
+	for f := range fields {
+		names = append(names, f)
+	}
+	sort.Strings(names)
+
+	var changes []FieldChange
+	for _, f := range names {
+		if !reflect.DeepEqual(oldFields[f], newFields[f]) {
+			changes = append(changes, FieldChange{Field: f, Old: oldFields[f], New: newFields[f]})
+		}
+	}
+	return changes, nil
+}
+
+func flatten(v interface{}) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	if v == nil {
+		return fields, nil
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return fields, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err = json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	flattenInto(fields, "", m)
+	return fields, nil
+}
+
+func flattenInto(fields map[string]interface{}, prefix string, m map[string]interface{}) {
+	for k, v := range m {
+		if nested, ok := v.(map[string]interface{}); ok {
+			flattenInto(fields, prefix+k+".", nested)
+			continue
+		}
+		fields[prefix+k] = v
+	}
+}
@@ -0,0 +1,62 @@
+package audit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type address struct {
+	City string `json:"city"`
+}
+
+type person struct {
+	Name    string  `json:"name"`
+	Address address `json:"address"`
+}
+
+func TestDiff(t *testing.T) {
+	before := &person{Name: "Test", Address: address{City: "Zagreb"}}
+	after := &person{Name: "Test", Address: address{City: "Split"}}
+
+	changes, err := Diff(before, after)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []FieldChange{{Field: "address.city", Old: "Zagreb", New: "Split"}}, changes)
+}
+
+func TestDiff_Created(t *testing.T) {
+	var before *person
+	changes, err := Diff(before, &person{Name: "Test"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []FieldChange{
+		{Field: "address.city", New: ""},
+		{Field: "name", New: "Test"},
+	}, changes)
+}
+
+func TestFromContext(t *testing.T) {
+	assert.Equal(t, "system", FromContext(context.Background()).Actor)
+
+	ctx := WithMetadata(context.Background(), Metadata{Actor: "jane", RequestId: "1"})
+	assert.Equal(t, Metadata{Actor: "jane", RequestId: "1"}, FromContext(ctx))
+}
+
+func TestFilter_MatchesOnlySameTenant(t *testing.T) {
+	entry := &Entry{PersonId: "1", Metadata: Metadata{Actor: "jane", Tenant: "acme"}}
+
+	assert.True(t, (&Filter{Tenant: "acme"}).matches(entry))
+	assert.True(t, (&Filter{Tenant: "acme", Actor: "jane"}).matches(entry))
+	// empty tenant is the default tenant, not a wildcard
+	assert.False(t, (&Filter{}).matches(entry))
+	assert.False(t, (&Filter{Tenant: "globex"}).matches(entry))
+}
+
+func TestIdLess(t *testing.T) {
+	assert.True(t, idLess("1-5", "2-0"))
+	assert.True(t, idLess("2-9", "2-10"))
+	assert.False(t, idLess("2-10", "2-9"))
+	assert.False(t, idLess("2-0", "2-0"))
+}
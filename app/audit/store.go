@@ -0,0 +1,235 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"go-microservice-assignment/app/tenant"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Stream is the Redis Stream holding audit entries of all persons
+const Stream = "person-audit"
+
+// IndexedSinceKey holds id of the first entry also written to stream of its
+// person, older entries are only in Stream
+const IndexedSinceKey = "person-audit-indexed-since"
+
+const (
+	queryBatchSize = 500
+	// entries kept in stream of a single person and how long after the last
+	// change of person its stream is kept
+	personStreamMaxLen = 1000
+	personStreamTTL    = 400 * 24 * time.Hour
+)
+
+// PersonStream returns key of the Redis Stream holding audit entries of
+// person of tenant, entries have the same ids as in Stream
+func PersonStream(tenantId string, personId string) string {
+	return tenant.KeyPrefix(tenantId) + personId + "_audit"
+}
+
+// appendScript adds entry to the stream of all persons and with the same id
+// to the stream of its person. Max length of 0 keeps all entries.
+var appendScript = redis.NewScript(`
+local id
+if tonumber(ARGV[1]) > 0 then
+	id = redis.call("XADD", KEYS[1], "MAXLEN", "~", ARGV[1], "*", "personId", ARGV[2], "entry", ARGV[3])
+else
+	id = redis.call("XADD", KEYS[1], "*", "personId", ARGV[2], "entry", ARGV[3])
+end
+redis.call("XADD", KEYS[2], "MAXLEN", "~", ARGV[4], id, "entry", ARGV[3])
+redis.call("EXPIRE", KEYS[2], ARGV[5])
+redis.call("SET", KEYS[3], id, "NX")
+return id
+`)
+
+// Filter selects audit entries, empty fields match everything except Tenant,
+// which always has to match
+type Filter struct {
+	Tenant   string
+	PersonId string
+	Actor    string
+	From     time.Time
+	To       time.Time
+	Limit    int
+}
+
+func (f *Filter) matches(e *Entry) bool {
+	return f.Tenant == e.Tenant && (f.PersonId == "" || f.PersonId == e.PersonId) && (f.Actor == "" || f.Actor == e.Actor)
+}
+
+// Append queues audit entry on the given pipeline, so it is written in the
+// same transaction as the audited change. Entry is written to Stream and to
+// stream of its person, which serves queries of single person.
+func Append(ctx context.Context, pipe redis.Pipeliner, entry *Entry, maxLen int64) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	// script is sent in full, NOSCRIPT error of EVALSHA would not abort transaction
+	appendScript.Eval(ctx, pipe,
+		[]string{Stream, PersonStream(entry.Tenant, entry.PersonId), IndexedSinceKey},
+		maxLen, entry.PersonId, string(data), personStreamMaxLen, int64(personStreamTTL/time.Second))
+	return nil
+}
+
+// Redact queues replacing entries of person, oldest first, with copies changed
+// by redact, as stream entries cannot be changed in place. Copies keep their
+// ids and are written to stream of the person. Entries redact changed and
+// those older than since (legacy entries read from Stream, all when since is
+// empty) are removed from Stream, others stay there unchanged.
+func Redact(ctx context.Context, pipe redis.Pipeliner, tenantId string, personId string, entries []*Entry, since string, redact func(e *Entry) bool) error {
+	key := PersonStream(tenantId, personId)
+	pipe.Del(ctx, key)
+	var removed []string
+	for _, entry := range entries {
+		if redact(entry) || since == "" || idLess(entry.Id, since) {
+			removed = append(removed, entry.Id)
+		}
+		stored := *entry
+		stored.Id = ""
+		data, err := json.Marshal(&stored)
+		if err != nil {
+			return err
+		}
+		pipe.XAdd(ctx, &redis.XAddArgs{Stream: key, ID: entry.Id, Values: map[string]interface{}{"entry": string(data)}})
+	}
+	if len(entries) > 0 {
+		pipe.Expire(ctx, key, personStreamTTL)
+	}
+	if len(removed) > 0 {
+		pipe.XDel(ctx, Stream, removed...)
+	}
+	return nil
+}
+
+type Store interface {
+	// Query calls fn for every entry matching filter, oldest first, until
+	// fn returns error or Limit entries were passed
+	Query(ctx context.Context, filter Filter, fn func(e *Entry) error) error
+}
+
+type redisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(client *redis.Client) Store {
+	return &redisStore{client}
+}
+
+func (s *redisStore) Query(ctx context.Context, filter Filter, fn func(e *Entry) error) error {
+	// stream ids start with milliseconds, so time range maps to id range
+	start, end := "-", "+"
+	if !filter.From.IsZero() {
+		start = strconv.FormatInt(filter.From.UnixNano()/int64(time.Millisecond), 10)
+	}
+	if !filter.To.IsZero() {
+		end = strconv.FormatInt(filter.To.UnixNano()/int64(time.Millisecond), 10)
+	}
+
+	q := query{client: s.client, filter: &filter, fn: fn}
+	if filter.PersonId == "" {
+		_, err := q.scan(ctx, Stream, start, end)
+		return err
+	}
+
+	// entries written before streams of persons existed are only in stream of
+	// all persons, it is scanned only up to the first entry of those streams
+	since, err := s.client.Get(ctx, IndexedSinceKey).Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	legacyEnd := end
+	if since != "" && (end == "+" || millis(end) >= millis(since)) {
+		legacyEnd = "(" + since
+	}
+	if since == "" || start == "-" || millis(start) <= millis(since) {
+		done, err := q.scan(ctx, Stream, start, legacyEnd)
+		if err != nil || done || since == "" {
+			return err
+		}
+	}
+	_, err = q.scan(ctx, PersonStream(filter.Tenant, filter.PersonId), start, end)
+	return err
+}
+
+// query passes matching entries of streams to fn, counting them against limit
+type query struct {
+	client  *redis.Client
+	filter  *Filter
+	fn      func(e *Entry) error
+	matched int
+}
+
+// scan passes matching entries of stream in id range to fn, returns true when limit was reached
+func (q *query) scan(ctx context.Context, stream string, start string, end string) (bool, error) {
+	for {
+		messages, err := q.client.XRangeN(ctx, stream, start, end, queryBatchSize).Result()
+		if err != nil {
+			return false, err
+		}
+		for _, msg := range messages {
+			var entry Entry
+			data, _ := msg.Values["entry"].(string)
+			if err = json.Unmarshal([]byte(data), &entry); err != nil {
+				return false, err
+			}
+			entry.Id = msg.ID
+			if !q.filter.matches(&entry) {
+				continue
+			}
+			if err = q.fn(&entry); err != nil {
+				return false, err
+			}
+			q.matched++
+			if q.filter.Limit > 0 && q.matched >= q.filter.Limit {
+				return true, nil
+			}
+		}
+		if len(messages) < queryBatchSize {
+			return false, nil
+		}
+		start = nextId(messages[len(messages)-1].ID)
+	}
+}
+
+// millis returns milliseconds part of stream id
+func millis(id string) int64 {
+	ms, _ := strconv.ParseInt(strings.SplitN(id, "-", 2)[0], 10, 64)
+	return ms
+}
+
+// idLess reports whether stream id a is lower than b
+func idLess(a string, b string) bool {
+	if millis(a) != millis(b) {
+		return millis(a) < millis(b)
+	}
+	return sequence(a) < sequence(b)
+}
+
+// sequence returns sequence part of stream id
+func sequence(id string) uint64 {
+	parts := strings.SplitN(id, "-", 2)
+	if len(parts) != 2 {
+		return 0
+	}
+	seq, _ := strconv.ParseUint(parts[1], 10, 64)
+	return seq
+}
+
+// nextId returns smallest stream id greater than the given one
+func nextId(id string) string {
+	parts := strings.SplitN(id, "-", 2)
+	if len(parts) != 2 {
+		return id
+	}
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return id
+	}
+	return parts[0] + "-" + strconv.FormatUint(seq+1, 10)
+}
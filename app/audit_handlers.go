@@ -0,0 +1,162 @@
+package app
+
+import (
+	"encoding/json"
+	"go-microservice-assignment/app/audit"
+	"go-microservice-assignment/app/tenant"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const defaultAuditLimit = 100
+
+// auditMiddleware puts actor, source IP and request ID into request context,
+// from where storage layer takes them when recording audit entries
+func (a *app) auditMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestId := r.Header.Get("X-Request-ID")
+		if requestId == "" {
+			requestId = uuid.New().String()
+		}
+		w.Header().Set("X-Request-ID", requestId)
+
+		ctx := audit.WithMetadata(r.Context(), audit.Metadata{
+			Actor:     a.actor(r),
+			SourceIP:  a.clientIP(r),
+			RequestId: requestId,
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (a *app) QueryAuditHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := auditFilter(r)
+		if err != nil {
+			badRequest(w, err.Error())
+			return
+		}
+		if filter.Limit == 0 {
+			filter.Limit = defaultAuditLimit
+		}
+
+		entries := []audit.Entry{}
+		err = a.Audit.Query(r.Context(), filter, func(e *audit.Entry) error {
+			entries = append(entries, *e)
+			return nil
+		})
+		if err != nil {
+			log.Println("Error querying audit log:", err)
+			serverError(w)
+			return
+		}
+		jsonResponse(w, http.StatusOK, entries)
+	}
+}
+
+// ExportAuditHandler streams matching audit entries as JSON Lines
+func (a *app) ExportAuditHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := auditFilter(r)
+		if err != nil {
+			badRequest(w, err.Error())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+		w.WriteHeader(http.StatusOK)
+
+		encoder := json.NewEncoder(w)
+		err = a.Audit.Query(r.Context(), filter, func(e *audit.Entry) error {
+			return encoder.Encode(e)
+		})
+		if err != nil {
+			// headers are already sent, the client sees truncated export
+			log.Println("Error exporting audit log:", err)
+		}
+	}
+}
+
+func auditFilter(r *http.Request) (audit.Filter, error) {
+	query := r.URL.Query()
+	filter := audit.Filter{
+		// callers only see audit of their own tenant
+		Tenant:   tenant.FromContext(r.Context()),
+		PersonId: query.Get("personId"),
+		Actor:    query.Get("actor"),
+	}
+	var err error
+	if from := query.Get("from"); from != "" {
+		if filter.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return filter, errInvalidParameter("from")
+		}
+	}
+	if to := query.Get("to"); to != "" {
+		if filter.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return filter, errInvalidParameter("to")
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 0 {
+			return filter, errInvalidParameter("limit")
+		}
+	}
+	return filter, nil
+}
+
+type errInvalidParameter string
+
+func (e errInvalidParameter) Error() string {
+	return "Invalid parameter " + string(e)
+}
+
+// clientIP is the address request came from. Behind trusted proxies it is
+// the rightmost address of X-Forwarded-For not belonging to trusted proxy,
+// as addresses left of it can be sent by the client.
+func (a *app) clientIP(r *http.Request) string {
+	ip := remoteIP(r)
+	if !a.trustedProxy(ip) {
+		return ip
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if hop == "" {
+			continue
+		}
+		ip = hop
+		if !a.trustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// trustedProxy reports whether ip belongs to one of trusted proxies
+func (a *app) trustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, proxy := range a.trustedProxies {
+		if proxy.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,109 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"go-microservice-assignment/app/audit"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type auditStoreMock struct {
+	mock.Mock
+	entries []audit.Entry
+}
+
+func (m *auditStoreMock) Query(ctx context.Context, filter audit.Filter, fn func(e *audit.Entry) error) error {
+	args := m.Called(ctx, filter)
+	for i := range m.entries {
+		if err := fn(&m.entries[i]); err != nil {
+			return err
+		}
+	}
+	return args.Error(0)
+}
+
+func TestQueryAuditHandler(t *testing.T) {
+	store := auditStoreMock{entries: []audit.Entry{{Id: "1-0", PersonId: personId, Action: "person.created"}}}
+	store.On("Query", mock.Anything, audit.Filter{PersonId: personId, Actor: "jane", Limit: defaultAuditLimit}).Return(nil)
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/api/v1/audit?personId="+personId+"&actor=jane", nil)
+	app := New(nil, WithAudit(&store))
+	app.QueryAuditHandler().ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var entries []audit.Entry
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &entries))
+	assert.Equal(t, store.entries, entries)
+	store.AssertExpectations(t)
+}
+
+func TestQueryAuditHandler_InvalidParameter(t *testing.T) {
+	for _, query := range []string{"from=yesterday", "to=2021-13-01", "limit=-1"} {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "/api/v1/audit?"+query, nil)
+		app := New(nil, WithAudit(&auditStoreMock{}))
+		app.QueryAuditHandler().ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, query)
+	}
+}
+
+func TestExportAuditHandler(t *testing.T) {
+	store := auditStoreMock{entries: []audit.Entry{{Id: "1-0"}, {Id: "2-0"}}}
+	store.On("Query", mock.Anything, audit.Filter{}).Return(nil)
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/api/v1/audit/export", nil)
+	app := New(nil, WithAudit(&store))
+	app.ExportAuditHandler().ServeHTTP(recorder, request)
+
+	assert.Equal(t, "application/x-ndjson", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, 2, len(strings.Split(strings.TrimSpace(recorder.Body.String()), "\n")))
+}
+
+var testProxies = []*net.IPNet{{IP: net.IPv4(10, 0, 0, 0), Mask: net.CIDRMask(8, 32)}}
+
+func TestAuditMiddleware(t *testing.T) {
+	var metadata audit.Metadata
+	app := New(nil, WithTrustedProxies(testProxies))
+	handler := app.auditMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metadata = audit.FromContext(r.Context())
+	}))
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/api/v1/person/"+personId, nil)
+	request.RemoteAddr = "10.0.0.2:41234"
+	request.Header.Set("X-Actor", "jane")
+	// leftmost address is sent by client, 10.0.0.1 is another trusted proxy
+	request.Header.Set("X-Forwarded-For", "1.2.3.4, 203.0.113.7, 10.0.0.1")
+	request.Header.Set("X-Request-ID", "req-1")
+	handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, audit.Metadata{Actor: "jane", SourceIP: "203.0.113.7", RequestId: "req-1"}, metadata)
+	assert.Equal(t, "req-1", recorder.Header().Get("X-Request-ID"))
+}
+
+func TestAuditMiddleware_UntrustedHeaders(t *testing.T) {
+	var metadata audit.Metadata
+	app := New(nil, WithTrustedProxies(testProxies))
+	handler := app.auditMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metadata = audit.FromContext(r.Context())
+	}))
+
+	request, _ := http.NewRequest("GET", "/api/v1/person/"+personId, nil)
+	request.RemoteAddr = "198.51.100.9:41234"
+	request.Header.Set("X-Actor", "jane")
+	request.Header.Set("X-Forwarded-For", "10.0.0.1")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	assert.Equal(t, "anonymous", metadata.Actor)
+	assert.Equal(t, "198.51.100.9", metadata.SourceIP)
+}
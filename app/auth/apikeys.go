@@ -0,0 +1,291 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	apiKeysKey         = "apikeys"
+	apiKeysLastUsedKey = "apikeys:last-used"
+	// plaintext key is "pk_<id>_<secret>"
+	apiKeyPrefix = "pk_"
+)
+
+var (
+	ErrAPIKeyNotFound = errors.New("API key not found")
+	ErrInvalidAPIKey  = errors.New("invalid API key")
+)
+
+// APIKey describes an issued key. Only hash of its secret is stored.
+type APIKey struct {
+	Id         string     `json:"id"`
+	Name       string     `json:"name"`
+	Owner      string     `json:"owner"`
+	Scopes     []string   `json:"scopes"`
+	Tenant     string     `json:"tenant,omitempty"`
+	Hash       string     `json:"hash,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	RotatedAt  *time.Time `json:"rotatedAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+type APIKeyStore interface {
+	SaveKey(ctx context.Context, k *APIKey) error
+	GetKey(ctx context.Context, id string) (*APIKey, error)
+	// UpdateKey applies update to stored key atomically, so concurrent
+	// updates never overwrite each other, and returns the updated key
+	UpdateKey(ctx context.Context, id string, update func(k *APIKey) error) (*APIKey, error)
+	ListKeys(ctx context.Context) ([]APIKey, error)
+	KeyUsed(ctx context.Context, id string, t time.Time) error
+}
+
+// IssueKey creates new API key and returns its plaintext, which is not stored.
+// Key of non-empty tenant can only act for that tenant.
+func IssueKey(ctx context.Context, store APIKeyStore, name string, owner string, tenant string, scopes []string) (string, *APIKey, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return "", nil, err
+	}
+	k := &APIKey{
+		Id:        id,
+		Name:      name,
+		Owner:     owner,
+		Scopes:    scopes,
+		Tenant:    tenant,
+		CreatedAt: time.Now().UTC(),
+	}
+	plaintext, err := newSecret(k)
+	if err != nil {
+		return "", nil, err
+	}
+	if err = store.SaveKey(ctx, k); err != nil {
+		return "", nil, err
+	}
+	return plaintext, k, nil
+}
+
+// RotateKey replaces secret of API key, the old plaintext stops working immediately
+func RotateKey(ctx context.Context, store APIKeyStore, id string) (string, *APIKey, error) {
+	var plaintext string
+	k, err := store.UpdateKey(ctx, id, func(k *APIKey) error {
+		if k.RevokedAt != nil {
+			return ErrAPIKeyNotFound
+		}
+		var err error
+		if plaintext, err = newSecret(k); err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		k.RotatedAt = &now
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return plaintext, k, nil
+}
+
+// RevokeKey permanently disables API key
+func RevokeKey(ctx context.Context, store APIKeyStore, id string) error {
+	_, err := store.UpdateKey(ctx, id, func(k *APIKey) error {
+		if k.RevokedAt == nil {
+			now := time.Now().UTC()
+			k.RevokedAt = &now
+		}
+		return nil
+	})
+	return err
+}
+
+func newSecret(k *APIKey) (string, error) {
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+	k.Hash = hashSecret(secret)
+	return apiKeyPrefix + k.Id + "_" + secret, nil
+}
+
+func hashSecret(secret string) string {
+	// secrets are random, so a fast hash is enough
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+type apiKeyAuthenticator struct {
+	store        APIKeyStore
+	bootstrapKey string
+}
+
+// NewAPIKeyAuthenticator authenticates requests with "X-API-Key: <key>" or
+// "Authorization: ApiKey <key>" header. Non-empty bootstrapKey is accepted
+// as admin, so the first keys can be issued.
+func NewAPIKeyAuthenticator(store APIKeyStore, bootstrapKey string) Authenticator {
+	return &apiKeyAuthenticator{store: store, bootstrapKey: bootstrapKey}
+}
+
+func (a *apiKeyAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	plaintext := r.Header.Get("X-API-Key")
+	if header := r.Header.Get("Authorization"); plaintext == "" && strings.HasPrefix(header, "ApiKey ") {
+		plaintext = strings.TrimSpace(strings.TrimPrefix(header, "ApiKey "))
+	}
+	if plaintext == "" {
+		return nil, ErrNoCredentials
+	}
+
+	if a.bootstrapKey != "" && subtle.ConstantTimeCompare([]byte(plaintext), []byte(a.bootstrapKey)) == 1 {
+		return &Principal{Subject: "bootstrap", Scopes: []string{"admin"}, Method: "api-key"}, nil
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(plaintext, apiKeyPrefix), "_", 2)
+	if !strings.HasPrefix(plaintext, apiKeyPrefix) || len(parts) != 2 {
+		return nil, ErrInvalidAPIKey
+	}
+	k, err := a.store.GetKey(r.Context(), parts[0])
+	if err == ErrAPIKeyNotFound {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(parts[1])), []byte(k.Hash)) != 1 || k.RevokedAt != nil {
+		return nil, ErrInvalidAPIKey
+	}
+	// failure to record usage does not fail the request
+	a.store.KeyUsed(r.Context(), k.Id, time.Now().UTC())
+
+	return &Principal{
+		Subject: k.Owner,
+		Scopes:  k.Scopes,
+		Claims:  map[string]interface{}{"keyId": k.Id, "keyName": k.Name},
+		Method:  "api-key",
+		Tenant:  k.Tenant,
+	}, nil
+}
+
+type redisAPIKeyStore struct {
+	client *redis.Client
+}
+
+// NewRedisAPIKeyStore keeps API keys in a Redis hash and their last use in another one,
+// so authentication does not rewrite the whole key
+func NewRedisAPIKeyStore(client *redis.Client) APIKeyStore {
+	return &redisAPIKeyStore{client}
+}
+
+func (s *redisAPIKeyStore) SaveKey(ctx context.Context, k *APIKey) error {
+	stored := *k
+	stored.LastUsedAt = nil
+	data, err := json.Marshal(&stored)
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(ctx, apiKeysKey, k.Id, data).Err()
+}
+
+func (s *redisAPIKeyStore) UpdateKey(ctx context.Context, id string, update func(k *APIKey) error) (*APIKey, error) {
+	for {
+		var updated *APIKey
+		err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+			data, err := tx.HGet(ctx, apiKeysKey, id).Result()
+			if err == redis.Nil {
+				return ErrAPIKeyNotFound
+			}
+			if err != nil {
+				return err
+			}
+			var k APIKey
+			if err = json.Unmarshal([]byte(data), &k); err != nil {
+				return err
+			}
+			if err = update(&k); err != nil {
+				return err
+			}
+			stored, err := json.Marshal(&k)
+			if err != nil {
+				return err
+			}
+			if _, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				return pipe.HSet(ctx, apiKeysKey, id, stored).Err()
+			}); err != nil {
+				return err
+			}
+			updated = &k
+			return nil
+		}, apiKeysKey)
+		// key was changed concurrently, apply update to its new state
+		if err != redis.TxFailedErr {
+			return updated, err
+		}
+	}
+}
+
+func (s *redisAPIKeyStore) GetKey(ctx context.Context, id string) (*APIKey, error) {
+	pipe := s.client.Pipeline()
+	data := pipe.HGet(ctx, apiKeysKey, id)
+	lastUsed := pipe.HGet(ctx, apiKeysLastUsedKey, id)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	if data.Err() == redis.Nil {
+		return nil, ErrAPIKeyNotFound
+	}
+	var k APIKey
+	if err := json.Unmarshal([]byte(data.Val()), &k); err != nil {
+		return nil, err
+	}
+	k.LastUsedAt = parseTime(lastUsed.Val())
+	return &k, nil
+}
+
+func (s *redisAPIKeyStore) ListKeys(ctx context.Context) ([]APIKey, error) {
+	pipe := s.client.Pipeline()
+	all := pipe.HGetAll(ctx, apiKeysKey)
+	lastUsed := pipe.HGetAll(ctx, apiKeysLastUsedKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	keys := make([]APIKey, 0, len(all.Val()))
+	for id, data := range all.Val() {
+		var k APIKey
+		if err := json.Unmarshal([]byte(data), &k); err != nil {
+			return nil, err
+		}
+		k.LastUsedAt = parseTime(lastUsed.Val()[id])
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+func (s *redisAPIKeyStore) KeyUsed(ctx context.Context, id string, t time.Time) error {
+	return s.client.HSet(ctx, apiKeysLastUsedKey, id, t.Format(time.RFC3339Nano)).Err()
+}
+
+func parseTime(s string) *time.Time {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// in-memory APIKeyStore
+type memoryKeyStore struct {
+	keys map[string]APIKey
+}
+
+func (s *memoryKeyStore) SaveKey(ctx context.Context, k *APIKey) error {
+	s.keys[k.Id] = *k
+	return nil
+}
+
+func (s *memoryKeyStore) GetKey(ctx context.Context, id string) (*APIKey, error) {
+	k, ok := s.keys[id]
+	if !ok {
+		return nil, ErrAPIKeyNotFound
+	}
+	return &k, nil
+}
+
+func (s *memoryKeyStore) UpdateKey(ctx context.Context, id string, update func(k *APIKey) error) (*APIKey, error) {
+	k, ok := s.keys[id]
+	if !ok {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err := update(&k); err != nil {
+		return nil, err
+	}
+	s.keys[id] = k
+	return &k, nil
+}
+
+func (s *memoryKeyStore) ListKeys(ctx context.Context) ([]APIKey, error) {
+	var keys []APIKey
+	for _, k := range s.keys {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+func (s *memoryKeyStore) KeyUsed(ctx context.Context, id string, t time.Time) error {
+	k := s.keys[id]
+	k.LastUsedAt = &t
+	s.keys[id] = k
+	return nil
+}
+
+func apiKeyRequest(key string) *http.Request {
+	r, _ := http.NewRequest("GET", "/api/v1/person", nil)
+	r.Header.Set("X-API-Key", key)
+	return r
+}
+
+func TestAPIKeyAuthenticator(t *testing.T) {
+	ctx := context.Background()
+	store := &memoryKeyStore{map[string]APIKey{}}
+	authenticator := NewAPIKeyAuthenticator(store, "")
+
+	plaintext, key, err := IssueKey(ctx, store, "importer", "team-data", "acme", []string{"person:write"})
+	assert.NoError(t, err)
+	assert.NotContains(t, store.keys[key.Id].Hash, plaintext)
+
+	principal, err := authenticator.Authenticate(apiKeyRequest(plaintext))
+	assert.NoError(t, err)
+	assert.Equal(t, "team-data", principal.Subject)
+	assert.Equal(t, []string{"person:write"}, principal.Scopes)
+	assert.Equal(t, "acme", principal.Tenant)
+	assert.NotNil(t, store.keys[key.Id].LastUsedAt)
+
+	_, err = authenticator.Authenticate(apiKeyRequest(plaintext + "0"))
+	assert.Equal(t, ErrInvalidAPIKey, err)
+	_, err = authenticator.Authenticate(apiKeyRequest("pk_unknown_secret"))
+	assert.Equal(t, ErrInvalidAPIKey, err)
+}
+
+func TestAPIKeyAuthenticator_RotateAndRevoke(t *testing.T) {
+	ctx := context.Background()
+	store := &memoryKeyStore{map[string]APIKey{}}
+	authenticator := NewAPIKeyAuthenticator(store, "")
+	old, key, _ := IssueKey(ctx, store, "importer", "team-data", "", nil)
+
+	rotated, _, err := RotateKey(ctx, store, key.Id)
+	assert.NoError(t, err)
+	_, err = authenticator.Authenticate(apiKeyRequest(old))
+	assert.Equal(t, ErrInvalidAPIKey, err)
+
+	r, _ := http.NewRequest("GET", "/api/v1/person", nil)
+	r.Header.Set("Authorization", "ApiKey "+rotated)
+	_, err = authenticator.Authenticate(r)
+	assert.NoError(t, err)
+
+	assert.NoError(t, RevokeKey(ctx, store, key.Id))
+	_, err = authenticator.Authenticate(apiKeyRequest(rotated))
+	assert.Equal(t, ErrInvalidAPIKey, err)
+
+	// revoked key cannot be rotated back to life
+	_, _, err = RotateKey(ctx, store, key.Id)
+	assert.Equal(t, ErrAPIKeyNotFound, err)
+	assert.NotNil(t, store.keys[key.Id].RevokedAt)
+}
+
+func TestAPIKeyAuthenticator_Bootstrap(t *testing.T) {
+	authenticator := NewAPIKeyAuthenticator(&memoryKeyStore{map[string]APIKey{}}, "bootstrap-secret")
+
+	principal, err := authenticator.Authenticate(apiKeyRequest("bootstrap-secret"))
+
+	assert.NoError(t, err)
+	assert.True(t, principal.HasScope("admin"))
+}
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// ErrNoCredentials is returned by authenticator when request carries no
+// credentials it understands, so another authenticator can be tried
+var ErrNoCredentials = errors.New("no credentials")
+
+// ErrUnavailable wraps failures of storage authenticator depends on, so
+// they are not mistaken for invalid credentials
+var ErrUnavailable = errors.New("authentication unavailable")
+
+// Principal is the authenticated caller
+type Principal struct {
+	Subject string                 `json:"subject"`
+	Scopes  []string               `json:"scopes,omitempty"`
+	Claims  map[string]interface{} `json:"claims,omitempty"`
+	// Method is how the caller authenticated, e.g. "jwt"
+	Method string `json:"method"`
+	// Tenant the caller is bound to, empty when credentials name no tenant
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// HasScope reports whether principal was granted given scope
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type Authenticator interface {
+	// Authenticate returns caller of request, ErrNoCredentials when request
+	// has no credentials for this authenticator or error when they are invalid
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+type contextKey struct{}
+
+// WithPrincipal returns context carrying authenticated caller
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns authenticated caller or nil when request was not authenticated
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(contextKey{}).(*Principal)
+	return p
+}
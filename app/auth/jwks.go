@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	jwksRefreshInterval = 15 * time.Minute
+	// unknown key id triggers refresh of remote key set at most this often
+	jwksMinRefreshInterval = time.Minute
+)
+
+var ErrKeyNotFound = errors.New("signing key not found")
+
+// KeySet provides public keys verifying token signatures
+type KeySet interface {
+	// Key returns key with given id, empty kid matches key set with a single key
+	Key(ctx context.Context, kid string) (crypto.PublicKey, error)
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type keys map[string]crypto.PublicKey
+
+func (k keys) find(kid string) (crypto.PublicKey, error) {
+	if kid == "" && len(k) == 1 {
+		for _, key := range k {
+			return key, nil
+		}
+	}
+	if key, ok := k[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrKeyNotFound
+}
+
+// ParseJWKS reads RSA and P-256 EC signing keys of JSON Web Key Set, other keys are skipped
+func ParseJWKS(data []byte) (KeySet, error) {
+	k, err := parseKeys(data)
+	if err != nil {
+		return nil, err
+	}
+	return staticKeySet{k}, nil
+}
+
+// LoadJWKSFile reads JSON Web Key Set from local file
+func LoadJWKSFile(path string) (KeySet, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(data)
+}
+
+type staticKeySet struct {
+	keys keys
+}
+
+func (s staticKeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	return s.keys.find(kid)
+}
+
+type remoteKeySet struct {
+	url    string
+	client *http.Client
+
+	mutex     sync.Mutex
+	keys      keys
+	fetchedAt time.Time
+}
+
+// NewRemoteKeySet fetches JSON Web Key Set from URL when first needed and
+// refreshes it periodically and when token is signed with unknown key
+func NewRemoteKeySet(url string) KeySet {
+	return &remoteKeySet{url: url, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (s *remoteKeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	age := time.Since(s.fetchedAt)
+	if s.keys == nil || age > jwksRefreshInterval {
+		if err := s.fetch(ctx); err != nil && s.keys == nil {
+			return nil, err
+		}
+	}
+	key, err := s.keys.find(kid)
+	if err == ErrKeyNotFound && time.Since(s.fetchedAt) > jwksMinRefreshInterval {
+		// keys were probably rotated
+		if err = s.fetch(ctx); err != nil {
+			return nil, err
+		}
+		return s.keys.find(kid)
+	}
+	return key, err
+}
+
+func (s *remoteKeySet) fetch(ctx context.Context) error {
+	s.fetchedAt = time.Now()
+	req, err := http.NewRequestWithContext(ctx, "GET", s.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching JWKS: status %d", resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	k, err := parseKeys(data)
+	if err != nil {
+		return err
+	}
+	s.keys = k
+	return nil
+}
+
+func parseKeys(data []byte) (keys, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	k := keys{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		switch jwk.Kty {
+		case "RSA":
+			n, err := decodeBigInt(jwk.N)
+			if err != nil {
+				return nil, err
+			}
+			e, err := decodeBigInt(jwk.E)
+			if err != nil {
+				return nil, err
+			}
+			k[jwk.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			if jwk.Crv != "P-256" {
+				continue
+			}
+			x, err := decodeBigInt(jwk.X)
+			if err != nil {
+				return nil, err
+			}
+			y, err := decodeBigInt(jwk.Y)
+			if err != nil {
+				return nil, err
+			}
+			k[jwk.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		}
+	}
+	return k, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWK: %v", err)
+	}
+	return new(big.Int).SetBytes(data), nil
+}
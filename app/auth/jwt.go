@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// tolerated difference between our clock and clock of token issuer
+const clockSkew = time.Minute
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// JWTConfig configures validation of JWT bearer tokens. HS256 tokens are
+// accepted when HMACSecret is set, RS256 and ES256 tokens when Keys is set.
+// Empty Issuer or Audience is not checked.
+type JWTConfig struct {
+	Issuer     string
+	Audience   string
+	HMACSecret []byte
+	Keys       KeySet
+}
+
+type jwtAuthenticator struct {
+	config JWTConfig
+	now    func() time.Time
+}
+
+// NewJWTAuthenticator authenticates requests with "Authorization: Bearer <JWT>" header
+func NewJWTAuthenticator(config JWTConfig) Authenticator {
+	return &jwtAuthenticator{config: config, now: time.Now}
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+func (a *jwtAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, ErrNoCredentials
+	}
+	claims, err := a.verify(r, strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")))
+	if err != nil {
+		return nil, err
+	}
+	subject, _ := claims["sub"].(string)
+	tenant, _ := claims["tenant"].(string)
+	return &Principal{
+		Subject: subject,
+		Scopes:  tokenScopes(claims),
+		Claims:  claims,
+		Method:  "jwt",
+		Tenant:  tenant,
+	}, nil
+}
+
+func (a *jwtAuthenticator) verify(r *http.Request, token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	if err = a.verifySignature(r, header, signed, signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if err = a.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (a *jwtAuthenticator) verifySignature(r *http.Request, header jwtHeader, signed []byte, signature []byte) error {
+	badSignature := fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	digest := sha256.Sum256(signed)
+
+	switch header.Alg {
+	case "HS256":
+		if a.config.HMACSecret == nil {
+			return fmt.Errorf("%w: HS256 not accepted", ErrInvalidToken)
+		}
+		mac := hmac.New(sha256.New, a.config.HMACSecret)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return badSignature
+		}
+		return nil
+
+	case "RS256", "ES256":
+		if a.config.Keys == nil {
+			return fmt.Errorf("%w: %s not accepted", ErrInvalidToken, header.Alg)
+		}
+		key, err := a.config.Keys.Key(r.Context(), header.Kid)
+		if errors.Is(err, ErrKeyNotFound) {
+			return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+		}
+		if err != nil {
+			// key set could not be fetched, token may well be valid
+			return fmt.Errorf("%w: %v", ErrUnavailable, err)
+		}
+		if header.Alg == "RS256" {
+			rsaKey, ok := key.(*rsa.PublicKey)
+			if !ok || rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature) != nil {
+				return badSignature
+			}
+			return nil
+		}
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return badSignature
+		}
+		// JWS carries ECDSA signature as r || s
+		rInt := new(big.Int).SetBytes(signature[:32])
+		sInt := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, digest[:], rInt, sInt) {
+			return badSignature
+		}
+		return nil
+	}
+	// "none" and algorithms we do not know are rejected
+	return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, header.Alg)
+}
+
+func (a *jwtAuthenticator) validateClaims(claims map[string]interface{}) error {
+	now := a.now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("%w: missing exp", ErrInvalidToken)
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(clockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return fmt.Errorf("%w: not yet valid", ErrInvalidToken)
+	}
+	if subject, _ := claims["sub"].(string); subject == "" {
+		return fmt.Errorf("%w: missing sub", ErrInvalidToken)
+	}
+	if a.config.Issuer != "" && claims["iss"] != a.config.Issuer {
+		return fmt.Errorf("%w: wrong issuer", ErrInvalidToken)
+	}
+	if a.config.Audience != "" && !hasAudience(claims["aud"], a.config.Audience) {
+		return fmt.Errorf("%w: wrong audience", ErrInvalidToken)
+	}
+	return nil
+}
+
+// audience claim is either a single string or an array of strings
+func hasAudience(aud interface{}, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []interface{}:
+		for _, a := range v {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// tokenScopes reads space separated "scope" claim or "scp" array
+func tokenScopes(claims map[string]interface{}) []string {
+	if scope, ok := claims["scope"].(string); ok {
+		return strings.Fields(scope)
+	}
+	var scopes []string
+	if scp, ok := claims["scp"].([]interface{}); ok {
+		for _, s := range scp {
+			if str, ok := s.(string); ok {
+				scopes = append(scopes, str)
+			}
+		}
+	}
+	return scopes
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	if err = json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var secret = []byte("test-secret")
+
+func encodeSegment(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// signToken creates JWT signed with HMAC secret, RSA or EC private key
+func signToken(alg string, kid string, key interface{}, claims map[string]interface{}) string {
+	signed := encodeSegment(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + encodeSegment(claims)
+	digest := sha256.Sum256([]byte(signed))
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		r, s, _ := ecdsa.Sign(rand.Reader, k, digest[:])
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"sub":   "jane",
+		"iss":   "https://issuer.example.com",
+		"aud":   []string{"person-service"},
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"scope": "person:read person:write",
+	}
+}
+
+func bearerRequest(token string) *http.Request {
+	r, _ := http.NewRequest("GET", "/api/v1/person", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func encodeBigInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func TestJWTAuthenticator_HS256(t *testing.T) {
+	authenticator := NewJWTAuthenticator(JWTConfig{
+		Issuer:     "https://issuer.example.com",
+		Audience:   "person-service",
+		HMACSecret: secret,
+	})
+
+	claims := validClaims()
+	claims["tenant"] = "acme"
+	principal, err := authenticator.Authenticate(bearerRequest(signToken("HS256", "", secret, claims)))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "jane", principal.Subject)
+	assert.Equal(t, "acme", principal.Tenant)
+	assert.Equal(t, []string{"person:read", "person:write"}, principal.Scopes)
+	assert.True(t, principal.HasScope("person:write"))
+}
+
+func TestJWTAuthenticator_RejectsInvalidTokens(t *testing.T) {
+	authenticator := NewJWTAuthenticator(JWTConfig{
+		Issuer:     "https://issuer.example.com",
+		Audience:   "person-service",
+		HMACSecret: secret,
+	})
+	expired := validClaims()
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	wrongIssuer := validClaims()
+	wrongIssuer["iss"] = "https://evil.example.com"
+	wrongAudience := validClaims()
+	wrongAudience["aud"] = "other-service"
+	noExpiry := validClaims()
+	delete(noExpiry, "exp")
+	noSubject := validClaims()
+	delete(noSubject, "sub")
+	emptySubject := validClaims()
+	emptySubject["sub"] = ""
+
+	tokens := map[string]string{
+		"expired":        signToken("HS256", "", secret, expired),
+		"wrong issuer":   signToken("HS256", "", secret, wrongIssuer),
+		"wrong audience": signToken("HS256", "", secret, wrongAudience),
+		"no expiry":      signToken("HS256", "", secret, noExpiry),
+		"no subject":     signToken("HS256", "", secret, noSubject),
+		"empty subject":  signToken("HS256", "", secret, emptySubject),
+		"wrong secret":   signToken("HS256", "", []byte("other"), validClaims()),
+		"alg none":       encodeSegment(map[string]string{"alg": "none"}) + "." + encodeSegment(validClaims()) + ".",
+		"RS256 disabled": signToken("RS256", "", secret, validClaims()),
+		"malformed":      "abc",
+	}
+	for name, token := range tokens {
+		_, err := authenticator.Authenticate(bearerRequest(token))
+		assert.ErrorIs(t, err, ErrInvalidToken, name)
+	}
+}
+
+func TestJWTAuthenticator_NoCredentials(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/api/v1/person", nil)
+	_, err := NewJWTAuthenticator(JWTConfig{HMACSecret: secret}).Authenticate(r)
+	assert.Equal(t, ErrNoCredentials, err)
+}
+
+func TestJWTAuthenticator_JWKS(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwks := fmt.Sprintf(`{"keys":[
+		{"kty":"RSA","kid":"rsa-1","use":"sig","n":"%s","e":"%s"},
+		{"kty":"EC","kid":"ec-1","crv":"P-256","x":"%s","y":"%s"},
+		{"kty":"RSA","kid":"enc-1","use":"enc","n":"AQAB","e":"AQAB"}
+	]}`, encodeBigInt(rsaKey.N), encodeBigInt(big.NewInt(int64(rsaKey.E))), encodeBigInt(ecKey.X), encodeBigInt(ecKey.Y))
+	keys, err := ParseJWKS([]byte(jwks))
+	assert.NoError(t, err)
+	authenticator := NewJWTAuthenticator(JWTConfig{Keys: keys})
+
+	_, err = authenticator.Authenticate(bearerRequest(signToken("RS256", "rsa-1", rsaKey, validClaims())))
+	assert.NoError(t, err)
+	_, err = authenticator.Authenticate(bearerRequest(signToken("ES256", "ec-1", ecKey, validClaims())))
+	assert.NoError(t, err)
+
+	// key of other type or unknown key id must not verify
+	_, err = authenticator.Authenticate(bearerRequest(signToken("ES256", "rsa-1", ecKey, validClaims())))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, err = authenticator.Authenticate(bearerRequest(signToken("RS256", "rsa-2", rsaKey, validClaims())))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, err = authenticator.Authenticate(bearerRequest(signToken("HS256", "", secret, validClaims())))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestJWTAuthenticator_JWKSUnavailable(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+	authenticator := NewJWTAuthenticator(JWTConfig{Keys: NewRemoteKeySet(server.URL)})
+
+	// failure to fetch keys is not an invalid token
+	_, err := authenticator.Authenticate(bearerRequest(signToken("RS256", "rsa-1", rsaKey, validClaims())))
+	assert.ErrorIs(t, err, ErrUnavailable)
+	assert.NotErrorIs(t, err, ErrInvalidToken)
+}
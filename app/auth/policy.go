@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	ScopePersonRead   = "person:read"
+	ScopePersonWrite  = "person:write"
+	ScopePersonDelete = "person:delete"
+	// ScopePersonReadMasked reads single persons and lists with personal data masked
+	ScopePersonReadMasked = "person:read:masked"
+	// ScopeAdmin grants access to every route
+	ScopeAdmin = "admin"
+)
+
+// Rule requires one of Scopes for requests of Method ("*" for any) to route
+// with Path template. Path ending with "*" matches templates with that prefix.
+// Empty Scopes only require caller to be authenticated.
+type Rule struct {
+	Method string   `json:"method"`
+	Path   string   `json:"path"`
+	Scopes []string `json:"scopes"`
+}
+
+// Policy maps routes to required scopes, first matching rule applies. Routes
+// matching no rule require admin scope.
+type Policy struct {
+	Rules []Rule `json:"rules"`
+}
+
+// DefaultPolicy separates read-only, read-write, delete and admin access to
+// persons. Masked readers only get single persons and lists, which are
+// projected, not history, events or exports.
+func DefaultPolicy() *Policy {
+	read := []string{ScopePersonRead}
+	masked := []string{ScopePersonRead, ScopePersonReadMasked}
+	write := []string{ScopePersonWrite}
+	return &Policy{Rules: []Rule{
+		{Method: "GET", Path: "/", Scopes: []string{}},
+		{Method: "GET", Path: "/api/v1/person", Scopes: masked},
+		{Method: "GET", Path: "/api/v1/person/{id}", Scopes: masked},
+		{Method: "GET", Path: "/api/v1/person/*", Scopes: read},
+		{Method: "POST", Path: "/api/v1/person", Scopes: write},
+		{Method: "POST", Path: "/api/v1/person:batch", Scopes: write},
+		{Method: "POST", Path: "/api/v1/person/*", Scopes: write},
+		{Method: "PATCH", Path: "/api/v1/person*", Scopes: write},
+		{Method: "DELETE", Path: "/api/v1/person/{id}", Scopes: []string{ScopePersonDelete}},
+	}}
+}
+
+// LoadPolicy reads policy from JSON file
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var policy Policy
+	if err = json.Unmarshal(data, &policy); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// RequiredScopes returns scopes of which caller needs one to call route
+func (p *Policy) RequiredScopes(method string, pathTemplate string) []string {
+	for _, rule := range p.Rules {
+		if rule.matches(method, pathTemplate) {
+			return rule.Scopes
+		}
+	}
+	return []string{ScopeAdmin}
+}
+
+// Allowed reports whether principal may call route and, if not, which scopes it lacks
+func (p *Policy) Allowed(principal *Principal, method string, pathTemplate string) (bool, []string) {
+	if principal.HasScope(ScopeAdmin) {
+		return true, nil
+	}
+	required := p.RequiredScopes(method, pathTemplate)
+	if len(required) == 0 {
+		return true, nil
+	}
+	for _, scope := range required {
+		if principal.HasScope(scope) {
+			return true, nil
+		}
+	}
+	return false, required
+}
+
+func (r *Rule) matches(method string, pathTemplate string) bool {
+	if r.Method != "*" && !strings.EqualFold(r.Method, method) {
+		return false
+	}
+	if strings.HasSuffix(r.Path, "*") {
+		return strings.HasPrefix(pathTemplate, strings.TrimSuffix(r.Path, "*"))
+	}
+	return r.Path == pathTemplate
+}
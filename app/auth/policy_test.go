@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultPolicy(t *testing.T) {
+	policy := DefaultPolicy()
+	reader := &Principal{Subject: "reader", Scopes: []string{ScopePersonRead}}
+	writer := &Principal{Subject: "writer", Scopes: []string{ScopePersonRead, ScopePersonWrite}}
+	admin := &Principal{Subject: "admin", Scopes: []string{ScopeAdmin}}
+
+	allowed, _ := policy.Allowed(reader, "GET", "/api/v1/person/{id}")
+	assert.True(t, allowed)
+	allowed, missing := policy.Allowed(reader, "PATCH", "/api/v1/person/{id}")
+	assert.False(t, allowed)
+	assert.Equal(t, []string{ScopePersonWrite}, missing)
+
+	allowed, _ = policy.Allowed(writer, "POST", "/api/v1/person:batch")
+	assert.True(t, allowed)
+	allowed, missing = policy.Allowed(writer, "DELETE", "/api/v1/person/{id}")
+	assert.False(t, allowed)
+	assert.Equal(t, []string{ScopePersonDelete}, missing)
+
+	// routes not covered by any rule are reserved for admin
+	allowed, missing = policy.Allowed(writer, "GET", "/api/v1/audit")
+	assert.False(t, allowed)
+	assert.Equal(t, []string{ScopeAdmin}, missing)
+	allowed, _ = policy.Allowed(admin, "GET", "/api/v1/audit")
+	assert.True(t, allowed)
+
+	// masked readers only get persons that are projected
+	supporter := &Principal{Subject: "support", Scopes: []string{ScopePersonReadMasked}}
+	allowed, _ = policy.Allowed(supporter, "GET", "/api/v1/person/{id}")
+	assert.True(t, allowed)
+	allowed, _ = policy.Allowed(supporter, "GET", "/api/v1/person")
+	assert.True(t, allowed)
+	allowed, missing = policy.Allowed(supporter, "GET", "/api/v1/person/{id}/history")
+	assert.False(t, allowed)
+	assert.Equal(t, []string{ScopePersonRead}, missing)
+
+	allowed, _ = policy.Allowed(&Principal{Subject: "nobody"}, "GET", "/")
+	assert.True(t, allowed)
+}
+
+func TestLoadPolicy(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "policy")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy.json")
+	ioutil.WriteFile(path, []byte(`{"rules":[{"method":"*","path":"/api/v1/person*","scopes":["people"]}]}`), 0600)
+
+	policy, err := LoadPolicy(path)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"people"}, policy.RequiredScopes("DELETE", "/api/v1/person/{id}"))
+	assert.Equal(t, []string{ScopeAdmin}, policy.RequiredScopes("GET", "/api/v1/audit"))
+
+	_, err = LoadPolicy(filepath.Join(dir, "missing.json"))
+	assert.Error(t, err)
+}
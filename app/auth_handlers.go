@@ -0,0 +1,100 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"go-microservice-assignment/app/auth"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// openPaths are reachable without authentication, so orchestrators can probe the service
+var openPaths = map[string]bool{
+	"/health":    true,
+	"/readiness": true,
+}
+
+// authMiddleware rejects requests not authenticated by any of configured
+// authenticators and puts the caller into request context. Authentication is
+// disabled when no authenticator is configured.
+func (a *app) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(a.authenticators) == 0 || openPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for _, authenticator := range a.authenticators {
+			principal, err := authenticator.Authenticate(r)
+			if err == auth.ErrNoCredentials {
+				continue
+			}
+			if errors.Is(err, auth.ErrUnavailable) {
+				log.Println("Authentication unavailable:", err)
+				problemResponse(w, r, http.StatusServiceUnavailable, "Authentication is temporarily unavailable")
+				return
+			}
+			if err != nil {
+				log.Println("Authentication failed:", err)
+				unauthorized(w, `Bearer error="invalid_token"`)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+			return
+		}
+		unauthorized(w, "Bearer")
+	})
+}
+
+// problem is error response body as defined by RFC 7807
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// authzMiddleware rejects authenticated callers lacking scope required by
+// authorization policy for the matched route
+func (a *app) authzMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal := auth.FromContext(r.Context())
+		route := mux.CurrentRoute(r)
+		if principal == nil || route == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			template = r.URL.Path
+		}
+		if allowed, required := a.policy.Allowed(principal, r.Method, template); !allowed {
+			log.Println("Access denied to", principal.Subject, r.Method, template)
+			problemResponse(w, r, http.StatusForbidden, "Requires one of scopes: "+strings.Join(required, ", "))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func problemResponse(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	body, _ := json.Marshal(problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+	})
+	w.Write(body)
+}
+
+func unauthorized(w http.ResponseWriter, challenge string) {
+	w.Header().Set("WWW-Authenticate", challenge)
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write([]byte("Unauthorized"))
+}
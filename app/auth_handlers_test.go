@@ -0,0 +1,204 @@
+package app
+
+import (
+	"context"
+	"go-microservice-assignment/app/auth"
+	"go-microservice-assignment/app/models"
+	"go-microservice-assignment/app/projection"
+	"go-microservice-assignment/app/storage"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// authenticator accepting single token
+type tokenAuthenticator struct {
+	token     string
+	principal *auth.Principal
+}
+
+func (a *tokenAuthenticator) Authenticate(r *http.Request) (*auth.Principal, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return nil, auth.ErrNoCredentials
+	}
+	if header != "Bearer "+a.token {
+		return nil, auth.ErrInvalidToken
+	}
+	return a.principal, nil
+}
+
+func authenticatedRequest(app *app, path string, token string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", path, nil)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	app.Router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	app := New(nil, WithAuthenticators(&tokenAuthenticator{"secret", &auth.Principal{Subject: "jane", Scopes: []string{"admin"}}}))
+	var subject string
+	app.Router.HandleFunc("/whoami", func(w http.ResponseWriter, r *http.Request) {
+		subject = app.actor(r)
+	})
+
+	assert.Equal(t, http.StatusOK, authenticatedRequest(app, "/health", "").Code)
+	assert.Equal(t, http.StatusOK, authenticatedRequest(app, "/readiness", "").Code)
+
+	recorder := authenticatedRequest(app, "/", "")
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	assert.Equal(t, "Bearer", recorder.Header().Get("WWW-Authenticate"))
+
+	recorder = authenticatedRequest(app, "/", "wrong")
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	assert.Equal(t, `Bearer error="invalid_token"`, recorder.Header().Get("WWW-Authenticate"))
+
+	assert.Equal(t, http.StatusOK, authenticatedRequest(app, "/whoami", "secret").Code)
+	assert.Equal(t, "jane", subject)
+}
+
+func TestAuthzMiddleware(t *testing.T) {
+	mockRedis := redisMock{}
+	mockRedis.On("GetPerson", mock.Anything, personId).Return(&models.Person{Id: personId}, nil)
+	app := New(&mockRedis, WithAuthenticators(&tokenAuthenticator{"secret", &auth.Principal{Subject: "jane", Scopes: []string{"person:read"}}}))
+
+	assert.Equal(t, http.StatusOK, authenticatedRequest(app, "/api/v1/person/"+personId, "secret").Code)
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("DELETE", "/api/v1/person/"+personId, nil)
+	request.Header.Set("Authorization", "Bearer secret")
+	app.Router.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	assert.Equal(t, "application/problem+json", recorder.Header().Get("Content-Type"))
+	assert.Contains(t, recorder.Body.String(), "person:delete")
+
+	// routes not covered by policy require admin
+	assert.Equal(t, http.StatusForbidden, authenticatedRequest(app, "/api/v1/admin/person/"+personId+"/retention", "secret").Code)
+}
+
+func TestAuthzMiddleware_PolicyFromConfiguration(t *testing.T) {
+	policy := &auth.Policy{Rules: []auth.Rule{{Method: "*", Path: "/api/v1/admin/*", Scopes: []string{"compliance"}}}}
+	mockRedis := redisMock{}
+	mockRedis.On("GetPerson", mock.Anything, personId).Return(&models.Person{Id: personId}, nil)
+	mockRedis.On("GetRetention", mock.Anything, personId).Return(&storage.RetentionPolicy{}, nil)
+	app := New(&mockRedis, WithPolicy(policy),
+		WithAuthenticators(&tokenAuthenticator{"secret", &auth.Principal{Subject: "jane", Scopes: []string{"compliance"}}}))
+
+	assert.Equal(t, http.StatusOK, authenticatedRequest(app, "/api/v1/admin/person/"+personId+"/retention", "secret").Code)
+	assert.Equal(t, http.StatusForbidden, authenticatedRequest(app, "/api/v1/person/"+personId, "secret").Code)
+}
+
+func TestProjection_MaskedReader(t *testing.T) {
+	person := &models.Person{Id: personId, Name: "Jane Doe", Address: "Baker Street 221B"}
+	mockRedis := redisMock{}
+	mockRedis.On("GetPerson", mock.Anything, personId).Return(person, nil)
+	mockRedis.On("ListPersonsPage", mock.Anything, mock.Anything).Return([]*models.Person{person}, 1, nil)
+	app := New(&mockRedis,
+		WithAuthenticators(&tokenAuthenticator{"secret", &auth.Principal{Subject: "support", Scopes: []string{auth.ScopePersonReadMasked}}}))
+
+	recorder := authenticatedRequest(app, "/api/v1/person/"+personId, "secret")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"name":"Jane Doe"`)
+	assert.Contains(t, recorder.Body.String(), `"address":"B**** S***** 2***"`)
+	assert.Contains(t, recorder.Body.String(), `"dateOfBirth":"**/**/0001"`)
+
+	recorder = authenticatedRequest(app, "/api/v1/person", "secret")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"address":"B**** S***** 2***"`)
+
+	// searching by masked field would reveal it
+	assert.Equal(t, http.StatusForbidden, authenticatedRequest(app, "/api/v1/person?address=Baker", "secret").Code)
+	assert.Equal(t, http.StatusForbidden, authenticatedRequest(app, "/api/v1/person/"+personId+"/history", "secret").Code)
+}
+
+func TestProjection_PolicyFromConfiguration(t *testing.T) {
+	policy := &projection.Policy{Fields: map[string]projection.Field{
+		"address": {Default: projection.Hidden},
+	}}
+	mockRedis := redisMock{}
+	mockRedis.On("GetPerson", mock.Anything, personId).Return(&models.Person{Id: personId, Address: "Baker Street"}, nil)
+	app := New(&mockRedis, WithProjection(policy),
+		WithAuthenticators(&tokenAuthenticator{"secret", &auth.Principal{Subject: "jane", Scopes: []string{auth.ScopePersonRead}}}))
+
+	recorder := authenticatedRequest(app, "/api/v1/person/"+personId, "secret")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.NotContains(t, recorder.Body.String(), "address")
+}
+
+func TestProjection_MaskedWriterBatch(t *testing.T) {
+	person := &models.Person{Id: personId, Name: "Jane Doe", Address: "Baker Street 221B"}
+	mockRedis := redisMock{}
+	mockRedis.On("ExecuteBatch", mock.Anything, mock.Anything, false).Return([]storage.BatchResult{{Person: person}}, nil)
+	app := New(&mockRedis,
+		WithAuthenticators(&tokenAuthenticator{"secret", &auth.Principal{Subject: "support", Scopes: []string{auth.ScopePersonWrite, auth.ScopePersonReadMasked}}}))
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "/api/v1/person:batch", strings.NewReader(`{"operations":[{"op":"get","id":"`+personId+`"}]}`))
+	request.Header.Set("Authorization", "Bearer secret")
+	app.Router.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"address":"B**** S***** 2***"`)
+	assert.Contains(t, recorder.Body.String(), `"dateOfBirth":"**/**/0001"`)
+	assert.NotContains(t, recorder.Body.String(), "Baker Street")
+}
+
+// masked readers are denied exports and history by default policy, other
+// policies still must not reveal what they mask
+func TestProjection_MaskedReaderExportAndHistory(t *testing.T) {
+	person := &models.Person{Id: personId, Name: "Jane Doe", Address: "Baker Street 221B",
+		DateOfBirth: models.JSONDate(time.Date(1990, 5, 17, 0, 0, 0, 0, time.UTC))}
+	mockRedis := redisMock{}
+	mockRedis.On("ScanPersons", mock.Anything, uint64(0), int64(exportBatchSize)).Return([]*models.Person{person}, uint64(0), nil)
+	mockRedis.On("GetHistory", mock.Anything, personId).Return([]storage.PersonVersion{{Version: 1, Person: *person}}, nil)
+	app := New(&mockRedis)
+	masked := auth.WithPrincipal(context.Background(), &auth.Principal{Subject: "support", Scopes: []string{auth.ScopePersonReadMasked}})
+
+	for _, accept := range []string{contentTypeNDJSON, contentTypeCSV} {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequestWithContext(masked, "GET", "/api/v1/person/export", nil)
+		request.Header.Set("Accept", accept)
+		app.ExportPersonsHandler().ServeHTTP(recorder, request)
+
+		assert.Contains(t, recorder.Body.String(), "B**** S***** 2***", accept)
+		assert.Contains(t, recorder.Body.String(), "**/**/1990", accept)
+		assert.NotContains(t, recorder.Body.String(), "Baker Street", accept)
+		assert.NotContains(t, recorder.Body.String(), "17/05/1990", accept)
+	}
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequestWithContext(masked, "GET", "/api/v1/person/"+personId+"/history", nil)
+	request = mux.SetURLVars(request, map[string]string{"id": personId})
+	app.PersonHistoryHandler().ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"address":"B**** S***** 2***"`)
+	assert.NotContains(t, recorder.Body.String(), "Baker Street")
+}
+
+func TestCacheStatsHandler_RequiresAdmin(t *testing.T) {
+	cache := storage.NewCachedDB(&redisMock{}, nil, 10, time.Minute)
+	for scope, expected := range map[string]int{
+		auth.ScopePersonRead: http.StatusForbidden,
+		auth.ScopeAdmin:      http.StatusOK,
+	} {
+		app := New(cache, WithAuthenticators(&tokenAuthenticator{"secret", &auth.Principal{Subject: "jane", Scopes: []string{scope}}}))
+
+		recorder := authenticatedRequest(app, "/api/v1/admin/cache", "secret")
+
+		assert.Equal(t, expected, recorder.Code, scope)
+		if expected == http.StatusOK {
+			assert.Contains(t, recorder.Body.String(), `"hits":0`)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-microservice-assignment/app/models"
+	"go-microservice-assignment/app/projection"
+	"go-microservice-assignment/app/storage"
+	"log"
+	"net/http"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+type batchRequest struct {
+	// Atomic executes all operations in one transaction, nothing is written when any fails
+	Atomic     bool             `json:"atomic"`
+	Operations []batchOperation `json:"operations"`
+}
+
+type batchOperation struct {
+	Op     storage.BatchOp `json:"op"`
+	Id     string          `json:"id,omitempty"`
+	Person *models.Person  `json:"person,omitempty"`
+}
+
+type batchResult struct {
+	Status int `json:"status"`
+	// person projected for the caller
+	Body  interface{} `json:"body,omitempty"`
+	Error string      `json:"error,omitempty"`
+}
+
+type batchResponse struct {
+	Results []batchResult `json:"results"`
+}
+
+// BatchHandler executes create, update and get operations on persons in a
+// single request, returning status and body of each operation
+func (a *app) BatchHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request batchRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			log.Println("Error unmarshalling batch request:", err)
+			badRequest(w, "Invalid request")
+			return
+		}
+		if len(request.Operations) == 0 {
+			badRequest(w, "Missing operations")
+			return
+		}
+		if len(request.Operations) > a.maxBatchSize {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			w.Write([]byte(fmt.Sprintf("Batch exceeds maximum size of %d operations", a.maxBatchSize)))
+			return
+		}
+
+		results := make([]batchResult, len(request.Operations))
+		var ops []storage.BatchOperation
+		var positions []int
+		for i, op := range request.Operations {
+			valid, message := validBatchOperation(op)
+			if message != "" {
+				results[i] = batchResult{Status: http.StatusBadRequest, Error: message}
+				continue
+			}
+			ops = append(ops, valid)
+			positions = append(positions, i)
+		}
+
+		if request.Atomic && len(ops) < len(request.Operations) {
+			for _, i := range positions {
+				results[i] = batchResult{Status: http.StatusFailedDependency, Error: storage.ErrBatchAborted.Error()}
+			}
+			jsonResponse(w, http.StatusOK, batchResponse{results})
+			return
+		}
+
+		if len(ops) > 0 {
+			executed, err := a.DB.ExecuteBatch(r.Context(), ops, request.Atomic)
+			if err == redis.TxFailedErr {
+				conflictResponse(w, "Batch conflicted with concurrent change, retry")
+				return
+			}
+			if err != nil {
+				log.Println("Error executing batch:", err)
+				serverError(w)
+				return
+			}
+			restrictions := a.restrictions(r)
+			for j, result := range executed {
+				results[positions[j]] = toBatchResult(ops[j].Op, result)
+				if result.Err != nil || result.Person == nil {
+					continue
+				}
+				if results[positions[j]].Body, err = projection.Apply(result.Person, restrictions, nil); err != nil {
+					log.Println("Error projecting person:", err)
+					serverError(w)
+					return
+				}
+			}
+		}
+		jsonResponse(w, http.StatusOK, batchResponse{results})
+	}
+}
+
+// validBatchOperation converts operation to storage form, assigning identifier
+// of created person. Returns error message for invalid operation.
+func validBatchOperation(op batchOperation) (storage.BatchOperation, string) {
+	switch op.Op {
+	case storage.BatchCreate:
+		if op.Person == nil {
+			return storage.BatchOperation{}, "Missing person"
+		}
+		person := *op.Person
+		person.Id = uuid.New().String()
+		if err := person.NormalizeAddress(); err != nil {
+			return storage.BatchOperation{}, err.Error()
+		}
+		return storage.BatchOperation{Op: op.Op, Person: &person}, ""
+	case storage.BatchUpdate:
+		if op.Person == nil {
+			return storage.BatchOperation{}, "Missing person"
+		}
+		person := *op.Person
+		if person.Id == "" {
+			person.Id = op.Id
+		}
+		if person.Id == "" {
+			return storage.BatchOperation{}, "Missing person ID"
+		}
+		if !models.ValidId(person.Id) {
+			return storage.BatchOperation{}, "Invalid person ID"
+		}
+		if err := person.NormalizeAddress(); err != nil {
+			return storage.BatchOperation{}, err.Error()
+		}
+		return storage.BatchOperation{Op: op.Op, Person: &person}, ""
+	case storage.BatchGet:
+		if op.Id == "" {
+			return storage.BatchOperation{}, "Missing person ID"
+		}
+		if !models.ValidId(op.Id) {
+			return storage.BatchOperation{}, "Invalid person ID"
+		}
+		return storage.BatchOperation{Op: op.Op, Id: op.Id}, ""
+	}
+	return storage.BatchOperation{}, "Unknown operation " + string(op.Op)
+}
+
+func toBatchResult(op storage.BatchOp, result storage.BatchResult) batchResult {
+	switch {
+	case result.Err == nil && op == storage.BatchCreate:
+		return batchResult{Status: http.StatusCreated}
+	case result.Err == nil:
+		return batchResult{Status: http.StatusOK}
+	case result.Err == redis.Nil || result.Err == storage.ErrPersonDeleted:
+		return batchResult{Status: http.StatusNotFound, Error: "Not found"}
+	case result.Err == storage.ErrPersonExists:
+		return batchResult{Status: http.StatusConflict, Error: result.Err.Error()}
+	case result.Err == redis.TxFailedErr:
+		return batchResult{Status: http.StatusConflict, Error: "Person changed concurrently, retry"}
+	case result.Err == storage.ErrBatchAborted:
+		return batchResult{Status: http.StatusFailedDependency, Error: result.Err.Error()}
+	}
+	log.Println("Error executing batch operation:", result.Err)
+	return batchResult{Status: http.StatusInternalServerError, Error: "Internal server error"}
+}
@@ -0,0 +1,85 @@
+package app
+
+import (
+	"encoding/json"
+	"go-microservice-assignment/app/models"
+	"go-microservice-assignment/app/storage"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func executeBatch(app *app, body string) (*httptest.ResponseRecorder, batchResponse) {
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "/api/v1/person:batch", strings.NewReader(body))
+	app.BatchHandler().ServeHTTP(recorder, request)
+	var response batchResponse
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	return recorder, response
+}
+
+func TestBatchHandler_PerItemStatus(t *testing.T) {
+	mockRedis := redisMock{}
+	mockRedis.On("ExecuteBatch", mock.Anything, mock.MatchedBy(func(ops []storage.BatchOperation) bool {
+		return len(ops) == 2 && ops[0].Op == storage.BatchCreate && ops[0].Person.Id != "" && ops[1].Id == personId
+	}), false).Return([]storage.BatchResult{
+		{Person: &models.Person{Id: "new", Name: "Test123"}},
+		{Err: redis.Nil},
+	}, nil)
+
+	recorder, response := executeBatch(New(&mockRedis), `{"operations":[
+		{"op":"create","person":{"name":"Test123"}},
+		{"op":"get","id":"`+personId+`"},
+		{"op":"update","person":{"name":"Test456"}}
+	]}`)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, 3, len(response.Results))
+	assert.Equal(t, http.StatusCreated, response.Results[0].Status)
+	assert.Equal(t, "Test123", response.Results[0].Body.(map[string]interface{})["name"])
+	assert.Equal(t, http.StatusNotFound, response.Results[1].Status)
+	assert.Equal(t, http.StatusBadRequest, response.Results[2].Status)
+	mockRedis.AssertExpectations(t)
+}
+
+func TestBatchHandler_ConflictingOperations(t *testing.T) {
+	mockRedis := redisMock{}
+	mockRedis.On("ExecuteBatch", mock.Anything, mock.Anything, false).Return([]storage.BatchResult{
+		{Err: redis.TxFailedErr},
+	}, nil)
+
+	recorder, response := executeBatch(New(&mockRedis), `{"operations":[{"op":"update","person":{"id":"`+personId+`","name":"Test456"}}]}`)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, http.StatusConflict, response.Results[0].Status)
+}
+
+func TestBatchHandler_AtomicWithInvalidOperation(t *testing.T) {
+	mockRedis := redisMock{}
+
+	recorder, response := executeBatch(New(&mockRedis), `{"atomic":true,"operations":[
+		{"op":"create","person":{"name":"Test123"}},
+		{"op":"delete","id":"`+personId+`"}
+	]}`)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, http.StatusFailedDependency, response.Results[0].Status)
+	assert.Equal(t, http.StatusBadRequest, response.Results[1].Status)
+	mockRedis.AssertNotCalled(t, "ExecuteBatch", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestBatchHandler_TooLarge(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "/api/v1/person:batch", strings.NewReader(`{"operations":[
+		{"op":"get","id":"1"},
+		{"op":"get","id":"2"}
+	]}`))
+	New(nil, WithMaxBatchSize(1)).Router.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+}
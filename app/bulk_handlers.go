@@ -0,0 +1,392 @@
+package app
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"go-microservice-assignment/app/models"
+	"go-microservice-assignment/app/projection"
+	"go-microservice-assignment/app/storage"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+const (
+	contentTypeNDJSON = "application/x-ndjson"
+	contentTypeCSV    = "text/csv"
+	exportBatchSize   = 500
+	importBatchSize   = 100
+	// longest accepted JSON line of import
+	maxImportLineSize = 1 << 20
+)
+
+// csvColumns are columns of exported CSV, import reads id, name, address,
+// dateOfBirth and postal address columns
+var csvColumns = []string{"id", "name", "address", "dateOfBirth", "createdAt", "updatedAt", "version", "createdBy", "deletedAt",
+	"postalAddress.street", "postalAddress.houseNumber", "postalAddress.postalCode", "postalAddress.city", "postalAddress.region", "postalAddress.country"}
+
+type importLineError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// importResult reports outcome of import. When storage failed, StoppedAtLine
+// is the first line not imported, lines before it without error were imported.
+type importResult struct {
+	DryRun        bool              `json:"dryRun"`
+	Imported      int               `json:"imported"`
+	Failed        int               `json:"failed"`
+	Errors        []importLineError `json:"errors"`
+	StoppedAtLine int               `json:"stoppedAtLine,omitempty"`
+}
+
+// exportedPerson is person projected for the caller, masked values of its
+// fields do not fit types of models.Person
+type exportedPerson struct {
+	Id            string          `json:"id"`
+	Name          string          `json:"name"`
+	Address       string          `json:"address"`
+	PostalAddress *models.Address `json:"postalAddress"`
+	DateOfBirth   string          `json:"dateOfBirth"`
+	CreatedAt     time.Time       `json:"createdAt"`
+	UpdatedAt     time.Time       `json:"updatedAt"`
+	Version       int             `json:"version"`
+	CreatedBy     string          `json:"createdBy"`
+	DeletedAt     *time.Time      `json:"deletedAt"`
+}
+
+// ExportPersonsHandler streams all persons as JSON Lines or CSV, selected by
+// Accept header. Persons are read page by page while iterating the keyspace,
+// which can return a person more than once, so ids of exported persons are
+// kept to write each only once. Fields are masked or hidden according to
+// scopes of caller.
+func (a *app) ExportPersonsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		withDeleted := includeDeleted(r)
+		restrictions := a.restrictions(r)
+		var write func(p *models.Person) error
+		var flush func() error
+
+		if strings.Contains(r.Header.Get("Accept"), contentTypeCSV) {
+			w.Header().Set("Content-Type", contentTypeCSV)
+			w.Header().Set("Content-Disposition", `attachment; filename="persons.csv"`)
+			writer := csv.NewWriter(w)
+			if err := writer.Write(csvColumns); err != nil {
+				return
+			}
+			write = func(p *models.Person) error {
+				record, err := personCSVRecord(p, restrictions)
+				if err != nil {
+					return err
+				}
+				return writer.Write(record)
+			}
+			flush = func() error {
+				writer.Flush()
+				return writer.Error()
+			}
+		} else {
+			w.Header().Set("Content-Type", contentTypeNDJSON)
+			w.Header().Set("Content-Disposition", `attachment; filename="persons.jsonl"`)
+			encoder := json.NewEncoder(w)
+			write = func(p *models.Person) error {
+				projected, err := projection.Apply(p, restrictions, nil)
+				if err != nil {
+					return err
+				}
+				return encoder.Encode(projected)
+			}
+			flush = func() error { return nil }
+		}
+
+		exported := map[string]bool{}
+		var cursor uint64
+		for {
+			persons, next, err := a.DB.ScanPersons(r.Context(), cursor, exportBatchSize)
+			if err != nil {
+				// headers are already sent, the client sees truncated export
+				log.Println("Error exporting persons:", err)
+				return
+			}
+			for _, p := range persons {
+				if p.DeletedAt != nil && !withDeleted || exported[p.Id] {
+					continue
+				}
+				exported[p.Id] = true
+				if err = write(p); err != nil {
+					log.Println("Error writing export:", err)
+					return
+				}
+			}
+			if err = flush(); err != nil {
+				log.Println("Error writing export:", err)
+				return
+			}
+			if next == 0 || r.Context().Err() != nil {
+				return
+			}
+			cursor = next
+		}
+	}
+}
+
+// ImportPersonsHandler creates persons from JSON Lines or CSV body, selected by
+// Content-Type header. Every line is validated and failures are reported per
+// line. With ?dryRun=true lines are only validated and checked against stored
+// persons. Storage failure stops the import with 500 and report of lines
+// imported before it.
+func (a *app) ImportPersonsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		result := importResult{DryRun: r.URL.Query().Get("dryRun") == "true", Errors: []importLineError{}}
+
+		var ops []storage.BatchOperation
+		var lines []int
+		// line where each given id first appeared, ids repeated in body are rejected
+		seen := map[string]int{}
+		var storageErr error
+		flush := func() error {
+			if len(ops) == 0 {
+				return nil
+			}
+			results, err := a.DB.ExecuteBatch(r.Context(), ops, false)
+			if err != nil {
+				storageErr = err
+				result.StoppedAtLine = lines[0]
+				return err
+			}
+			for i, res := range results {
+				switch {
+				case result.DryRun && res.Err == redis.Nil:
+					result.Imported++
+				case result.DryRun && (res.Err == nil || res.Err == storage.ErrPersonDeleted):
+					result.addError(lines[i], storage.ErrPersonExists.Error())
+				case res.Err != nil:
+					result.addError(lines[i], res.Err.Error())
+				default:
+					result.Imported++
+				}
+			}
+			ops, lines = ops[:0], lines[:0]
+			return nil
+		}
+		add := func(line int, p *models.Person, err error) error {
+			given := ""
+			if err == nil {
+				given = p.Id
+				err = validateImportedPerson(p)
+			}
+			if err == nil && given != "" {
+				if first, ok := seen[given]; ok {
+					err = fmt.Errorf("duplicate id, first given on line %d", first)
+				} else {
+					seen[given] = line
+				}
+			}
+			if err != nil {
+				result.addError(line, err.Error())
+				return nil
+			}
+			if result.DryRun {
+				// generated ids are new, given ones are looked up in batches
+				if given == "" {
+					result.Imported++
+					return nil
+				}
+				ops = append(ops, storage.BatchOperation{Op: storage.BatchGet, Id: given})
+			} else {
+				ops = append(ops, storage.BatchOperation{Op: storage.BatchCreate, Person: p})
+			}
+			lines = append(lines, line)
+			if len(ops) >= importBatchSize {
+				return flush()
+			}
+			return nil
+		}
+
+		var err error
+		if strings.HasPrefix(r.Header.Get("Content-Type"), contentTypeCSV) {
+			err = readCSVPersons(r.Body, add)
+		} else {
+			err = readNDJSONPersons(r.Body, add)
+		}
+		if err == nil {
+			err = flush()
+		}
+		if storageErr != nil {
+			log.Println("Error importing persons:", storageErr)
+			jsonResponse(w, http.StatusInternalServerError, result)
+			return
+		}
+		if err != nil {
+			log.Println("Error importing persons:", err)
+			badRequest(w, "Import failed after "+strconv.Itoa(result.Imported)+" persons: "+err.Error())
+			return
+		}
+		jsonResponse(w, http.StatusOK, result)
+	}
+}
+
+func (r *importResult) addError(line int, message string) {
+	r.Failed++
+	r.Errors = append(r.Errors, importLineError{Line: line, Error: message})
+}
+
+func readNDJSONPersons(body io.Reader, add func(line int, p *models.Person, err error) error) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxImportLineSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var person models.Person
+		err := json.Unmarshal([]byte(text), &person)
+		if err != nil {
+			err = fmt.Errorf("invalid JSON: %v", err)
+		}
+		if err = add(line, &person, err); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func readCSVPersons(body io.Reader, add func(line int, p *models.Person, err error) error) error {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("missing CSV header: %v", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	if _, ok := columns["name"]; !ok {
+		return fmt.Errorf("CSV header has no name column")
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			parseErr, ok := err.(*csv.ParseError)
+			if !ok {
+				return err
+			}
+			if err = add(parseErr.StartLine, nil, parseErr.Err); err != nil {
+				return err
+			}
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+		value := func(column string) string {
+			if i, ok := columns[column]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		person := models.Person{Id: value("id"), Name: value("name"), Address: value("address")}
+		postal := models.Address{
+			Street:      value("postalAddress.street"),
+			HouseNumber: value("postalAddress.houseNumber"),
+			PostalCode:  value("postalAddress.postalCode"),
+			City:        value("postalAddress.city"),
+			Region:      value("postalAddress.region"),
+			Country:     value("postalAddress.country"),
+		}
+		if postal != (models.Address{}) {
+			person.PostalAddress = &postal
+		}
+		if dob := value("dateOfBirth"); dob != "" {
+			err = person.DateOfBirth.UnmarshalJSON([]byte(dob))
+			if err != nil {
+				err = fmt.Errorf("invalid dateOfBirth, expected %s", models.DobDateFormat)
+			}
+		}
+		if err = add(line, &person, err); err != nil {
+			return err
+		}
+	}
+}
+
+// validateImportedPerson checks mandatory fields and assigns identifier when missing
+func validateImportedPerson(p *models.Person) error {
+	if p.Name == "" {
+		return fmt.Errorf("missing name")
+	}
+	if p.Id == "" {
+		p.Id = uuid.New().String()
+	} else if !models.ValidId(p.Id) {
+		return fmt.Errorf("invalid id, expected UUID")
+	}
+	return p.NormalizeAddress()
+}
+
+// personCSVRecord returns row of person projected with restrictions, hidden
+// fields are left empty
+func personCSVRecord(p *models.Person, restrictions map[string]projection.Mode) ([]string, error) {
+	projected, err := projection.Apply(p, restrictions, nil)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(projected)
+	if err != nil {
+		return nil, err
+	}
+	var e exportedPerson
+	if err = json.Unmarshal(data, &e); err != nil {
+		return nil, err
+	}
+	if time.Time(p.DateOfBirth).IsZero() {
+		e.DateOfBirth = ""
+	}
+	deletedAt := ""
+	if e.DeletedAt != nil {
+		deletedAt = e.DeletedAt.Format(time.RFC3339)
+	}
+	version := ""
+	if restrictions["version"] != projection.Hidden {
+		version = strconv.Itoa(e.Version)
+	}
+	postal := models.Address{}
+	if e.PostalAddress != nil {
+		postal = *e.PostalAddress
+	}
+	return []string{
+		e.Id,
+		e.Name,
+		e.Address,
+		e.DateOfBirth,
+		formatTime(e.CreatedAt),
+		formatTime(e.UpdatedAt),
+		version,
+		e.CreatedBy,
+		deletedAt,
+		postal.Street,
+		postal.HouseNumber,
+		postal.PostalCode,
+		postal.City,
+		postal.Region,
+		postal.Country,
+	}, nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,159 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"go-microservice-assignment/app/models"
+	"go-microservice-assignment/app/storage"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestExportPersonsHandler_NDJSON(t *testing.T) {
+	deletedAt := time.Now()
+	mockRedis := redisMock{}
+	mockRedis.On("ScanPersons", mock.Anything, uint64(0), int64(exportBatchSize)).
+		Return([]*models.Person{{Id: "1"}, {Id: "2", DeletedAt: &deletedAt}}, uint64(5), nil)
+	mockRedis.On("ScanPersons", mock.Anything, uint64(5), int64(exportBatchSize)).
+		Return([]*models.Person{{Id: "3"}}, uint64(0), nil)
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/api/v1/person/export", nil)
+	New(&mockRedis).ExportPersonsHandler().ServeHTTP(recorder, request)
+
+	assert.Equal(t, contentTypeNDJSON, recorder.Header().Get("Content-Type"))
+	lines := strings.Split(strings.TrimSpace(recorder.Body.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+	assert.Contains(t, lines[1], `"id":"3"`)
+}
+
+func TestExportPersonsHandler_CSV(t *testing.T) {
+	mockRedis := redisMock{}
+	postal := &models.Address{Street: "Unter den Linden", HouseNumber: "5", PostalCode: "10117", City: "Berlin", Country: "DE"}
+	mockRedis.On("ScanPersons", mock.Anything, uint64(0), int64(exportBatchSize)).
+		Return([]*models.Person{{Id: "1", Name: "Test, Jr.", Version: 2}}, uint64(5), nil)
+	// keyspace scan returns person again
+	mockRedis.On("ScanPersons", mock.Anything, uint64(5), int64(exportBatchSize)).
+		Return([]*models.Person{{Id: "2", Name: "Test2", PostalAddress: postal}, {Id: "1", Name: "Test, Jr.", Version: 2}}, uint64(0), nil)
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/api/v1/person/export", nil)
+	request.Header.Set("Accept", "text/csv")
+	New(&mockRedis).ExportPersonsHandler().ServeHTTP(recorder, request)
+
+	assert.Equal(t, contentTypeCSV, recorder.Header().Get("Content-Type"))
+	assert.Equal(t, "id,name,address,dateOfBirth,createdAt,updatedAt,version,createdBy,deletedAt,"+
+		"postalAddress.street,postalAddress.houseNumber,postalAddress.postalCode,postalAddress.city,postalAddress.region,postalAddress.country\n"+
+		"1,\"Test, Jr.\",,,,,2,,,,,,,,\n"+
+		"2,Test2,,,,,0,,,Unter den Linden,5,10117,Berlin,,DE\n", recorder.Body.String())
+}
+
+func importPersons(app *app, contentType string, query string, body string) importResult {
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "/api/v1/person/import"+query, strings.NewReader(body))
+	request.Header.Set("Content-Type", contentType)
+	app.ImportPersonsHandler().ServeHTTP(recorder, request)
+	var result importResult
+	json.Unmarshal(recorder.Body.Bytes(), &result)
+	return result
+}
+
+func TestImportPersonsHandler_NDJSON(t *testing.T) {
+	mockRedis := redisMock{}
+	mockRedis.On("ExecuteBatch", mock.Anything, mock.MatchedBy(func(ops []storage.BatchOperation) bool {
+		return len(ops) == 2 && ops[0].Person.Name == "Test123" && ops[1].Person.Id == "410ffb3f-bddf-409d-a397-f0e37e9f3294"
+	}), false).Return([]storage.BatchResult{{}, {Err: storage.ErrPersonExists}}, nil)
+
+	result := importPersons(New(&mockRedis), contentTypeNDJSON, "", `{"name":"Test123","dateOfBirth":"01/05/1991"}
+{"name":"Test456","dateOfBirth":"1991-05-01"}
+
+{"address":"Berlin 1"}
+{"id":"410ffb3f-bddf-409d-a397-f0e37e9f3294","name":"Test789"}
+`)
+
+	assert.Equal(t, 1, result.Imported)
+	assert.Equal(t, 3, result.Failed)
+	assert.Equal(t, []int{2, 4, 5}, []int{result.Errors[0].Line, result.Errors[1].Line, result.Errors[2].Line})
+	assert.Equal(t, storage.ErrPersonExists.Error(), result.Errors[2].Error)
+	mockRedis.AssertExpectations(t)
+}
+
+func TestImportPersonsHandler_CSVDryRun(t *testing.T) {
+	mockRedis := redisMock{}
+
+	result := importPersons(New(&mockRedis), "text/csv; charset=utf-8", "?dryRun=true", `name,address,dateOfBirth
+Test123,Berlin 1,01/05/1991
+Test456,Berlin 2,05/31/1991
+`)
+
+	assert.True(t, result.DryRun)
+	assert.Equal(t, 1, result.Imported)
+	assert.Equal(t, []importLineError{{Line: 3, Error: "invalid dateOfBirth, expected 02/01/2006"}}, result.Errors)
+	mockRedis.AssertNotCalled(t, "ExecuteBatch", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestImportPersonsHandler_CSVPostalAddress(t *testing.T) {
+	mockRedis := redisMock{}
+	mockRedis.On("ExecuteBatch", mock.Anything, mock.MatchedBy(func(ops []storage.BatchOperation) bool {
+		return len(ops) == 2 && ops[0].Person.PostalAddress == nil &&
+			*ops[1].Person.PostalAddress == models.Address{Street: "Unter den Linden", HouseNumber: "5", PostalCode: "10117", City: "Berlin", Country: "DE"}
+	}), false).Return([]storage.BatchResult{{}, {}}, nil)
+
+	result := importPersons(New(&mockRedis), contentTypeCSV, "", `name,postalAddress.street,postalAddress.houseNumber,postalAddress.postalCode,postalAddress.city,postalAddress.country
+Test123,,,,,
+Test456,Unter den Linden,5,10117,Berlin,de
+Test789,Unter den Linden,5,1011,Berlin,DE
+`)
+
+	assert.Equal(t, 2, result.Imported)
+	assert.Equal(t, 1, result.Failed)
+	assert.Equal(t, 4, result.Errors[0].Line)
+	mockRedis.AssertExpectations(t)
+}
+
+func TestImportPersonsHandler_DryRunChecksIds(t *testing.T) {
+	existing := "410ffb3f-bddf-409d-a397-f0e37e9f3294"
+	fresh := "8e1a1b0e-5c6f-4b8e-9d1e-3f1f2b7c6d5a"
+	mockRedis := redisMock{}
+	mockRedis.On("ExecuteBatch", mock.Anything, mock.MatchedBy(func(ops []storage.BatchOperation) bool {
+		return len(ops) == 2 && ops[0].Op == storage.BatchGet && ops[0].Id == existing && ops[1].Id == fresh
+	}), false).Return([]storage.BatchResult{{Person: &models.Person{Id: existing}}, {Err: redis.Nil}}, nil)
+
+	result := importPersons(New(&mockRedis), contentTypeNDJSON, "?dryRun=true", `{"id":"`+existing+`","name":"Test123"}
+{"id":"`+fresh+`","name":"Test456"}
+{"id":"`+fresh+`","name":"Test789"}
+{"name":"Test000"}
+`)
+
+	assert.Equal(t, 2, result.Imported)
+	assert.Equal(t, []importLineError{
+		{Line: 3, Error: "duplicate id, first given on line 2"},
+		{Line: 1, Error: storage.ErrPersonExists.Error()},
+	}, result.Errors)
+	mockRedis.AssertExpectations(t)
+}
+
+func TestImportPersonsHandler_StorageFailure(t *testing.T) {
+	mockRedis := redisMock{}
+	mockRedis.On("ExecuteBatch", mock.Anything, mock.Anything, false).Return([]storage.BatchResult(nil), errors.New("connection refused"))
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "/api/v1/person/import", strings.NewReader(`{"address":"Berlin 1"}
+{"name":"Test123"}
+`))
+	New(&mockRedis).ImportPersonsHandler().ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	var result importResult
+	json.Unmarshal(recorder.Body.Bytes(), &result)
+	assert.Equal(t, 0, result.Imported)
+	assert.Equal(t, 2, result.StoppedAtLine)
+	assert.Equal(t, 1, result.Failed)
+}
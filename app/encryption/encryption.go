@@ -0,0 +1,166 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+const dataKeySize = 32
+
+// length of blind index, shorter index leaks less about equal values of different fields
+const blindIndexSize = 16
+
+var (
+	ErrUnknownKey = errors.New("unknown encryption key")
+	ErrDecrypt    = errors.New("cannot decrypt value")
+)
+
+// Keyring holds key encryption keys by id. Data is encrypted with random data
+// keys, only the data keys are encrypted with keys of the keyring.
+type Keyring interface {
+	// CurrentKeyId returns id of key new data keys are encrypted with
+	CurrentKeyId() string
+	// Key returns key encryption key with given id, ErrUnknownKey when there is none
+	Key(id string) ([]byte, error)
+	// IndexKey returns key of blind indexes. Changing it requires rebuilding all indexes.
+	IndexKey() []byte
+}
+
+// Envelope tells how fields of a record were encrypted: with data key
+// DataKey, itself encrypted by key KeyId of the keyring
+type Envelope struct {
+	KeyId   string   `json:"keyId"`
+	DataKey string   `json:"dataKey"`
+	Fields  []string `json:"fields"`
+}
+
+// FieldEncryptor encrypts selected fields of JSON records with AES-GCM
+type FieldEncryptor struct {
+	keyring Keyring
+}
+
+func NewFieldEncryptor(keyring Keyring) *FieldEncryptor {
+	return &FieldEncryptor{keyring: keyring}
+}
+
+// CurrentKeyId returns id of key that encrypts new records, records with
+// other key ids are due for re-encryption
+func (e *FieldEncryptor) CurrentKeyId() string {
+	return e.keyring.CurrentKeyId()
+}
+
+// Encrypt replaces values of fields present in record with base64 of their
+// ciphertext under a fresh data key. Ciphertext is bound to recordId and
+// field, so it cannot be moved to another record or field.
+func (e *FieldEncryptor) Encrypt(record map[string]json.RawMessage, fields []string, recordId string) (*Envelope, error) {
+	keyId := e.keyring.CurrentKeyId()
+	kek, err := e.keyring.Key(keyId)
+	if err != nil {
+		return nil, err
+	}
+	dataKey := make([]byte, dataKeySize)
+	if _, err = io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+	wrapped, err := seal(kek, dataKey, []byte(keyId))
+	if err != nil {
+		return nil, err
+	}
+
+	envelope := &Envelope{KeyId: keyId, DataKey: wrapped, Fields: []string{}}
+	for _, field := range fields {
+		value, ok := record[field]
+		if !ok {
+			continue
+		}
+		ciphertext, err := seal(dataKey, value, additionalData(recordId, field))
+		if err != nil {
+			return nil, err
+		}
+		if record[field], err = json.Marshal(ciphertext); err != nil {
+			return nil, err
+		}
+		envelope.Fields = append(envelope.Fields, field)
+	}
+	return envelope, nil
+}
+
+// Decrypt restores plaintext values of fields listed in envelope
+func (e *FieldEncryptor) Decrypt(record map[string]json.RawMessage, envelope *Envelope, recordId string) error {
+	kek, err := e.keyring.Key(envelope.KeyId)
+	if err != nil {
+		return err
+	}
+	dataKey, err := open(kek, envelope.DataKey, []byte(envelope.KeyId))
+	if err != nil {
+		return err
+	}
+	for _, field := range envelope.Fields {
+		var ciphertext string
+		if err = json.Unmarshal(record[field], &ciphertext); err != nil {
+			return ErrDecrypt
+		}
+		if record[field], err = open(dataKey, ciphertext, additionalData(recordId, field)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BlindIndex returns keyed hash of value of field. Equal values get equal
+// index, so records can be looked up by value without decrypting them.
+func (e *FieldEncryptor) BlindIndex(field string, value string) string {
+	mac := hmac.New(sha256.New, e.keyring.IndexKey())
+	mac.Write([]byte(field))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:blindIndexSize])
+}
+
+func additionalData(recordId string, field string) []byte {
+	return []byte(recordId + "/" + field)
+}
+
+// seal returns base64 of random nonce followed by ciphertext
+func seal(key []byte, plaintext []byte, additionalData []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plaintext, additionalData)), nil
+}
+
+func open(key []byte, sealed string, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < gcm.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], additionalData)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
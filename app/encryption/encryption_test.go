@@ -0,0 +1,89 @@
+package encryption
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	oldKey   = base64.StdEncoding.EncodeToString([]byte(strings.Repeat("o", 32)))
+	newKey   = base64.StdEncoding.EncodeToString([]byte(strings.Repeat("n", 32)))
+	indexKey = base64.StdEncoding.EncodeToString([]byte(strings.Repeat("i", 32)))
+)
+
+func testKeyring(t *testing.T, current string) Keyring {
+	keyring, err := NewStaticKeyring(current, map[string]string{"old": oldKey, "new": newKey}, indexKey)
+	assert.NoError(t, err)
+	return keyring
+}
+
+func TestFieldEncryptor(t *testing.T) {
+	encryptor := NewFieldEncryptor(testKeyring(t, "new"))
+	record := map[string]json.RawMessage{
+		"id":   json.RawMessage(`"1"`),
+		"name": json.RawMessage(`"Jane Doe"`),
+	}
+
+	envelope, err := encryptor.Encrypt(record, []string{"name", "address"}, "1")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "new", envelope.KeyId)
+	assert.Equal(t, []string{"name"}, envelope.Fields)
+	assert.NotContains(t, string(record["name"]), "Jane")
+	assert.Equal(t, `"1"`, string(record["id"]))
+
+	// ciphertext is bound to its record
+	moved := map[string]json.RawMessage{"name": record["name"]}
+	assert.Equal(t, ErrDecrypt, encryptor.Decrypt(moved, envelope, "2"))
+
+	assert.NoError(t, encryptor.Decrypt(record, envelope, "1"))
+	assert.Equal(t, `"Jane Doe"`, string(record["name"]))
+}
+
+func TestFieldEncryptor_RotatedKey(t *testing.T) {
+	record := map[string]json.RawMessage{"name": json.RawMessage(`"Jane Doe"`)}
+	envelope, _ := NewFieldEncryptor(testKeyring(t, "old")).Encrypt(record, []string{"name"}, "1")
+
+	rotated := NewFieldEncryptor(testKeyring(t, "new"))
+	assert.NoError(t, rotated.Decrypt(record, envelope, "1"))
+	assert.Equal(t, `"Jane Doe"`, string(record["name"]))
+
+	envelope.KeyId = "retired"
+	assert.Equal(t, ErrUnknownKey, rotated.Decrypt(record, envelope, "1"))
+}
+
+func TestBlindIndex(t *testing.T) {
+	encryptor := NewFieldEncryptor(testKeyring(t, "old"))
+	rotated := NewFieldEncryptor(testKeyring(t, "new"))
+
+	assert.Equal(t, encryptor.BlindIndex("name", "jane doe"), rotated.BlindIndex("name", "jane doe"))
+	assert.NotEqual(t, encryptor.BlindIndex("name", "jane doe"), encryptor.BlindIndex("name", "john doe"))
+	assert.NotEqual(t, encryptor.BlindIndex("name", "x"), encryptor.BlindIndex("address", "x"))
+}
+
+func TestLoadFileKeyring(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "keyring")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keyring.json")
+	ioutil.WriteFile(path, []byte(`{"current":"new","keys":{"old":"`+oldKey+`","new":"`+newKey+`"},"indexKey":"`+indexKey+`"}`), 0600)
+
+	keyring, err := LoadFileKeyring(path)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "new", keyring.CurrentKeyId())
+	key, err := keyring.Key("old")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(strings.Repeat("o", 32)), key)
+
+	_, err = NewStaticKeyring("missing", map[string]string{"old": oldKey}, indexKey)
+	assert.Error(t, err)
+	_, err = NewStaticKeyring("old", map[string]string{"old": "c2hvcnQ="}, indexKey)
+	assert.Error(t, err)
+}
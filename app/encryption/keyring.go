@@ -0,0 +1,84 @@
+package encryption
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// keyring file, keys are base64 of 32 random bytes (e.g. `openssl rand -base64 32`):
+//
+//	{"current": "2024-06", "keys": {"2024-01": "...", "2024-06": "..."}, "indexKey": "..."}
+type keyringFile struct {
+	Current  string            `json:"current"`
+	Keys     map[string]string `json:"keys"`
+	IndexKey string            `json:"indexKey"`
+}
+
+type staticKeyring struct {
+	current  string
+	keys     map[string][]byte
+	indexKey []byte
+}
+
+// LoadFileKeyring reads keyring from local JSON file. Meant for development,
+// production keys belong to a key management service.
+func LoadFileKeyring(path string) (Keyring, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file keyringFile
+	if err = json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	return NewStaticKeyring(file.Current, file.Keys, file.IndexKey)
+}
+
+// NewStaticKeyring creates keyring of base64 encoded 256-bit keys
+func NewStaticKeyring(current string, keys map[string]string, indexKey string) (Keyring, error) {
+	k := &staticKeyring{current: current, keys: map[string][]byte{}}
+	for id, encoded := range keys {
+		key, err := decodeKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		k.keys[id] = key
+	}
+	if _, ok := k.keys[current]; !ok {
+		return nil, fmt.Errorf("current key %q not in keyring", current)
+	}
+	var err error
+	if k.indexKey, err = decodeKey(indexKey); err != nil {
+		return nil, fmt.Errorf("index key: %w", err)
+	}
+	return k, nil
+}
+
+func decodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("expected 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+func (k *staticKeyring) CurrentKeyId() string {
+	return k.current
+}
+
+func (k *staticKeyring) Key(id string) ([]byte, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+func (k *staticKeyring) IndexKey() []byte {
+	return k.indexKey
+}
@@ -0,0 +1,118 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-microservice-assignment/app/tenant"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const eventsReadCount = 100
+
+// eventIdPattern matches ids of Redis Stream entries, "<ms>-<seq>" or just "<ms>"
+var eventIdPattern = regexp.MustCompile(`^\d+(-\d+)?$`)
+
+// PersonEventsHandler streams person change events as Server-Sent Events.
+// When route contains person id, only events of that person are sent. Only
+// events of caller's tenant are sent.
+// Clients can resume the stream by sending Last-Event-ID header. Streams are
+// limited by WithMaxEventStreams and end on CloseEventStreams.
+func (a *app) PersonEventsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.Events == nil {
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+		lastId := r.Header.Get("Last-Event-ID")
+		if lastId != "" && !eventIdPattern.MatchString(lastId) {
+			badRequest(w, "Invalid Last-Event-ID")
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			log.Println("Streaming is not supported by response writer")
+			serverError(w)
+			return
+		}
+		select {
+		case a.eventStreams <- struct{}{}:
+			defer func() { <-a.eventStreams }()
+		default:
+			w.Header().Set("Retry-After", "5")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("Too many event streams"))
+			return
+		}
+		personId := mux.Vars(r)["id"]
+		tenantId := tenant.FromContext(r.Context())
+
+		// stream ends when client disconnects or server shuts down
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		go func() {
+			select {
+			case <-a.shutdown:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		if lastId == "" {
+			var err error
+			lastId, err = a.Events.LastEventId(ctx)
+			if err != nil {
+				log.Println("Error reading last event id:", err)
+				serverError(w)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+		lastWrite := time.Now()
+
+		for {
+			// stop when client disconnects or server shuts down
+			if ctx.Err() != nil {
+				return
+			}
+
+			events, err := a.Events.ReadEvents(ctx, lastId, eventsReadCount, a.heartbeatInterval)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Println("Error reading person events:", err)
+				}
+				return
+			}
+			for _, event := range events {
+				lastId = event.Id
+				// events of other tenants share the stream but are never sent
+				if event.Tenant != tenantId || (personId != "" && event.PersonId != personId) {
+					continue
+				}
+				data, err := json.Marshal(event)
+				if err != nil {
+					log.Println("Error marshalling person event:", err)
+					continue
+				}
+				fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.Id, event.Type, data)
+				lastWrite = time.Now()
+			}
+
+			// keep idle connections (and proxies in between) alive
+			if time.Since(lastWrite) >= a.heartbeatInterval {
+				fmt.Fprint(w, ": heartbeat\n\n")
+				lastWrite = time.Now()
+			}
+			flusher.Flush()
+		}
+	}
+}
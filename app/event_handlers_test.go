@@ -0,0 +1,201 @@
+package app
+
+import (
+	"context"
+	"go-microservice-assignment/app/storage"
+	"go-microservice-assignment/app/tenant"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// mock for storage.EventLog
+type eventLogMock struct {
+	mock.Mock
+}
+
+func (l *eventLogMock) LastEventId(ctx context.Context) (string, error) {
+	args := l.Called(ctx)
+	return args.String(0), args.Error(1)
+}
+
+func (l *eventLogMock) ReadEvents(ctx context.Context, afterId string, count int64, block time.Duration) ([]storage.PersonEvent, error) {
+	args := l.Called(ctx, afterId, count, block)
+	return args.Get(0).([]storage.PersonEvent), args.Error(1)
+}
+
+func TestPersonEventsHandler_StreamsEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mockEvents := eventLogMock{}
+	mockEvents.On("LastEventId", mock.Anything).Return("1-0", nil)
+	mockEvents.On("ReadEvents", mock.Anything, "1-0", mock.Anything, mock.Anything).
+		Return([]storage.PersonEvent{
+			{Id: "2-0", Type: storage.EventPersonCreated, PersonId: personId},
+			{Id: "3-0", Type: storage.EventPersonUpdated, PersonId: "other"},
+		}, nil).
+		Run(func(args mock.Arguments) { cancel() })
+
+	testRequest, _ := http.NewRequestWithContext(ctx, "GET", "/api/v1/person/events", nil)
+	recorder := httptest.NewRecorder()
+
+	app := New(nil, WithEventLog(&mockEvents))
+	app.PersonEventsHandler().ServeHTTP(recorder, testRequest)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "text/event-stream", recorder.Header().Get("Content-Type"))
+	body := recorder.Body.String()
+	assert.Contains(t, body, "id: 2-0\nevent: person.created\n")
+	assert.Contains(t, body, "id: 3-0\nevent: person.updated\n")
+	mockEvents.AssertExpectations(t)
+}
+
+func TestPersonEventsHandler_FiltersByPersonAndResumes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mockEvents := eventLogMock{}
+	mockEvents.On("ReadEvents", mock.Anything, "5-0", mock.Anything, mock.Anything).
+		Return([]storage.PersonEvent{
+			{Id: "6-0", Type: storage.EventPersonUpdated, PersonId: "other"},
+			{Id: "7-0", Type: storage.EventPersonUpdated, PersonId: personId},
+		}, nil).
+		Run(func(args mock.Arguments) { cancel() })
+
+	testRequest, _ := http.NewRequestWithContext(ctx, "GET", "/api/v1/person/"+personId+"/events", nil)
+	testRequest.Header.Set("Last-Event-ID", "5-0")
+	testRequest = mux.SetURLVars(testRequest, map[string]string{"id": personId})
+	recorder := httptest.NewRecorder()
+
+	app := New(nil, WithEventLog(&mockEvents))
+	app.PersonEventsHandler().ServeHTTP(recorder, testRequest)
+
+	body := recorder.Body.String()
+	assert.NotContains(t, body, "id: 6-0")
+	assert.Contains(t, body, "id: 7-0")
+	mockEvents.AssertNotCalled(t, "LastEventId", mock.Anything)
+}
+
+func TestPersonEventsHandler_OnlyEventsOfTenant(t *testing.T) {
+	ctx, cancel := context.WithCancel(tenant.WithTenant(context.Background(), "acme"))
+	defer cancel()
+
+	mockEvents := eventLogMock{}
+	mockEvents.On("ReadEvents", mock.Anything, "5-0", mock.Anything, mock.Anything).
+		Return([]storage.PersonEvent{
+			{Id: "6-0", Type: storage.EventPersonCreated, PersonId: personId},
+			{Id: "7-0", Type: storage.EventPersonCreated, PersonId: personId, Tenant: "acme"},
+			{Id: "8-0", Type: storage.EventPersonCreated, PersonId: personId, Tenant: "globex"},
+		}, nil).
+		Run(func(args mock.Arguments) { cancel() })
+
+	testRequest, _ := http.NewRequestWithContext(ctx, "GET", "/api/v1/person/events", nil)
+	testRequest.Header.Set("Last-Event-ID", "5-0")
+	recorder := httptest.NewRecorder()
+
+	app := New(nil, WithEventLog(&mockEvents))
+	app.PersonEventsHandler().ServeHTTP(recorder, testRequest)
+
+	body := recorder.Body.String()
+	assert.NotContains(t, body, "id: 6-0")
+	assert.Contains(t, body, "id: 7-0")
+	assert.NotContains(t, body, "id: 8-0")
+}
+
+func TestPersonEventsHandler_Heartbeat(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mockEvents := eventLogMock{}
+	mockEvents.On("LastEventId", mock.Anything).Return("0-0", nil)
+	mockEvents.On("ReadEvents", mock.Anything, "0-0", mock.Anything, time.Millisecond).
+		Return([]storage.PersonEvent{}, nil).
+		Run(func(args mock.Arguments) {
+			time.Sleep(2 * time.Millisecond)
+			cancel()
+		})
+
+	testRequest, _ := http.NewRequestWithContext(ctx, "GET", "/api/v1/person/events", nil)
+	recorder := httptest.NewRecorder()
+
+	app := New(nil, WithEventLog(&mockEvents), WithHeartbeatInterval(time.Millisecond))
+	app.PersonEventsHandler().ServeHTTP(recorder, testRequest)
+
+	assert.True(t, strings.HasSuffix(recorder.Body.String(), ": heartbeat\n\n"))
+}
+
+func TestPersonEventsHandler_NotConfigured(t *testing.T) {
+	testRequest, _ := http.NewRequest("GET", "/api/v1/person/events", nil)
+	recorder := httptest.NewRecorder()
+
+	app := New(nil)
+	app.PersonEventsHandler().ServeHTTP(recorder, testRequest)
+
+	assert.Equal(t, http.StatusNotImplemented, recorder.Code)
+}
+
+func TestPersonEventsHandler_InvalidLastEventId(t *testing.T) {
+	for _, lastId := range []string{"abc", "5-", "-1", "5-0-0", "$", "+"} {
+		testRequest, _ := http.NewRequest("GET", "/api/v1/person/events", nil)
+		testRequest.Header.Set("Last-Event-ID", lastId)
+		recorder := httptest.NewRecorder()
+
+		mockEvents := eventLogMock{}
+		app := New(nil, WithEventLog(&mockEvents))
+		app.PersonEventsHandler().ServeHTTP(recorder, testRequest)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, lastId)
+		assert.NotEqual(t, "text/event-stream", recorder.Header().Get("Content-Type"))
+		mockEvents.AssertNotCalled(t, "ReadEvents", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	}
+}
+
+func TestPersonEventsHandler_TooManyStreams(t *testing.T) {
+	testRequest, _ := http.NewRequest("GET", "/api/v1/person/events", nil)
+	recorder := httptest.NewRecorder()
+
+	app := New(nil, WithEventLog(&eventLogMock{}), WithMaxEventStreams(1))
+	app.eventStreams <- struct{}{}
+	app.PersonEventsHandler().ServeHTTP(recorder, testRequest)
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, "5", recorder.Header().Get("Retry-After"))
+}
+
+func TestPersonEventsHandler_EndsOnShutdown(t *testing.T) {
+	mockEvents := eventLogMock{}
+	mockEvents.On("LastEventId", mock.Anything).Return("0-0", nil)
+	mockEvents.On("ReadEvents", mock.Anything, "0-0", mock.Anything, mock.Anything).
+		Return([]storage.PersonEvent{}, nil).
+		Run(func(args mock.Arguments) {
+			// blocks like XREAD until stream is ended
+			<-args.Get(0).(context.Context).Done()
+		})
+
+	testRequest, _ := http.NewRequest("GET", "/api/v1/person/events", nil)
+	recorder := httptest.NewRecorder()
+
+	app := New(nil, WithEventLog(&mockEvents))
+	done := make(chan struct{})
+	go func() {
+		app.PersonEventsHandler().ServeHTTP(recorder, testRequest)
+		close(done)
+	}()
+	app.CloseEventStreams()
+	app.CloseEventStreams()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("stream did not end on shutdown")
+	}
+	// stream slot is released
+	assert.Empty(t, app.eventStreams)
+}
@@ -0,0 +1,96 @@
+package gdpr
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Scope lists what was erased, audit entries are kept with personal data redacted
+type Scope struct {
+	Record             bool `json:"record"`
+	HistoryVersions    int  `json:"historyVersions"`
+	Events             int  `json:"events"`
+	AuditEntries       int  `json:"auditEntries"`
+	IdempotencyRecords int  `json:"idempotencyRecords"`
+	Archive            bool `json:"archive"`
+}
+
+// Receipt certifies that data of person was erased. It holds no personal data
+// besides person id, so it can be kept as evidence after the erasure.
+type Receipt struct {
+	Id        string    `json:"id"`
+	PersonId  string    `json:"personId"`
+	Tenant    string    `json:"tenant,omitempty"`
+	ErasedAt  time.Time `json:"erasedAt"`
+	ErasedBy  string    `json:"erasedBy"`
+	Reason    string    `json:"reason"`
+	Erased    Scope     `json:"erased"`
+	KeyId     string    `json:"keyId"`
+	Signature string    `json:"signature"`
+}
+
+// Signer signs erasure receipts with Ed25519, so anyone with its public key
+// can verify them
+type Signer struct {
+	key   ed25519.PrivateKey
+	keyId string
+}
+
+// NewSigner creates signer from 32 byte Ed25519 seed
+func NewSigner(seed []byte) (*Signer, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("expected %d bytes of signing key seed, got %d", ed25519.SeedSize, len(seed))
+	}
+	key := ed25519.NewKeyFromSeed(seed)
+	return &Signer{key: key, keyId: KeyId(key.Public().(ed25519.PublicKey))}, nil
+}
+
+// KeyId identifies public key, so receipts signed with rotated keys can be told apart
+func KeyId(publicKey ed25519.PublicKey) string {
+	sum := sha256.Sum256(publicKey)
+	return hex.EncodeToString(sum[:8])
+}
+
+func (s *Signer) PublicKey() ed25519.PublicKey {
+	return s.key.Public().(ed25519.PublicKey)
+}
+
+func (s *Signer) KeyId() string {
+	return s.keyId
+}
+
+// Sign sets key id and signature of receipt
+func (s *Signer) Sign(r *Receipt) error {
+	r.KeyId = s.keyId
+	payload, err := signedPayload(r)
+	if err != nil {
+		return err
+	}
+	r.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, payload))
+	return nil
+}
+
+// Verify reports whether receipt was signed by key and not changed since
+func Verify(r *Receipt, publicKey ed25519.PublicKey) bool {
+	signature, err := base64.StdEncoding.DecodeString(r.Signature)
+	if err != nil || r.KeyId != KeyId(publicKey) {
+		return false
+	}
+	payload, err := signedPayload(r)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(publicKey, payload, signature)
+}
+
+// signedPayload is JSON of receipt without signature, fields in declaration order
+func signedPayload(r *Receipt) ([]byte, error) {
+	unsigned := *r
+	unsigned.Signature = ""
+	return json.Marshal(&unsigned)
+}
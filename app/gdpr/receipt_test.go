@@ -0,0 +1,37 @@
+package gdpr
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSigner(t *testing.T) {
+	signer, err := NewSigner([]byte(strings.Repeat("s", 32)))
+	assert.NoError(t, err)
+	receipt := &Receipt{
+		Id:       "r1",
+		PersonId: "1",
+		ErasedAt: time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC),
+		ErasedBy: "dpo",
+		Reason:   "erasure request #42",
+		Erased:   Scope{Record: true, HistoryVersions: 2, Events: 3, AuditEntries: 3},
+	}
+
+	assert.NoError(t, signer.Sign(receipt))
+
+	assert.Equal(t, signer.KeyId(), receipt.KeyId)
+	assert.True(t, Verify(receipt, signer.PublicKey()))
+
+	tampered := *receipt
+	tampered.Erased.Archive = true
+	assert.False(t, Verify(&tampered, signer.PublicKey()))
+
+	other, _ := NewSigner([]byte(strings.Repeat("o", 32)))
+	assert.False(t, Verify(receipt, other.PublicKey()))
+
+	_, err = NewSigner([]byte("short"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,203 @@
+package app
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"go-microservice-assignment/app/archive"
+	"go-microservice-assignment/app/audit"
+	"go-microservice-assignment/app/gdpr"
+	"go-microservice-assignment/app/models"
+	"go-microservice-assignment/app/storage"
+	"go-microservice-assignment/app/tenant"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// personExport is everything stored about a person, answering subject access request
+type personExport struct {
+	PersonId       string                   `json:"personId"`
+	Tenant         string                   `json:"tenant,omitempty"`
+	ExportedAt     time.Time                `json:"exportedAt"`
+	Person         *models.Person           `json:"person"`
+	History        []storage.PersonVersion  `json:"history"`
+	Retention      *storage.RetentionPolicy `json:"retention"`
+	RetentionAudit []storage.RetentionAudit `json:"retentionAudit"`
+	AuditEntries   []audit.Entry            `json:"auditEntries"`
+	Archived       *models.Person           `json:"archived"`
+}
+
+type erasureRequest struct {
+	Reason string `json:"reason"`
+}
+
+// ExportPersonDataHandler returns all data of person as a single JSON document
+func (a *app) ExportPersonDataHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id := mux.Vars(r)["id"]
+		export := personExport{
+			PersonId:     id,
+			Tenant:       tenant.FromContext(ctx),
+			ExportedAt:   time.Now().UTC(),
+			AuditEntries: []audit.Entry{},
+		}
+
+		var err error
+		if export.Person, err = a.DB.GetPerson(ctx, id); err != nil && !isNotFound(err) {
+			log.Println("Error exporting person:", err)
+			serverError(w)
+			return
+		}
+		if export.History, err = a.DB.GetHistory(ctx, id); err != nil {
+			log.Println("Error exporting person history:", err)
+			serverError(w)
+			return
+		}
+		if export.Retention, err = a.DB.GetRetention(ctx, id); err != nil && !isNotFound(err) {
+			log.Println("Error exporting retention policy:", err)
+			serverError(w)
+			return
+		}
+		if export.RetentionAudit, err = a.DB.ListRetentionAudit(ctx, id); err != nil {
+			log.Println("Error exporting retention audit:", err)
+			serverError(w)
+			return
+		}
+		if a.Audit != nil {
+			filter := audit.Filter{Tenant: export.Tenant, PersonId: id}
+			err = a.Audit.Query(ctx, filter, func(e *audit.Entry) error {
+				export.AuditEntries = append(export.AuditEntries, *e)
+				return nil
+			})
+			if err != nil {
+				log.Println("Error exporting audit entries:", err)
+				serverError(w)
+				return
+			}
+		}
+		if a.Archive != nil {
+			if export.Archived, err = a.Archive.Get(ctx, id); err != nil && err != archive.ErrNotFound {
+				log.Println("Error exporting archived person:", err)
+				serverError(w)
+				return
+			}
+		}
+
+		if export.Person == nil && export.Archived == nil && len(export.History) == 0 && len(export.AuditEntries) == 0 {
+			notFoundResponse(w)
+			return
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="person-%s.json"`, id))
+		jsonResponse(w, http.StatusOK, &export)
+	}
+}
+
+// ErasePersonHandler removes person from live storage, history, indexes,
+// event stream, audit log and archive and returns signed erasure receipt
+func (a *app) ErasePersonHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id := mux.Vars(r)["id"]
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			log.Println("Error processing body request:", err)
+			badRequest(w, "Invalid request")
+			return
+		}
+		var request erasureRequest
+		if err = json.Unmarshal(body, &request); err != nil {
+			log.Println("Error unmarshalling body request:", err)
+			badRequest(w, "Invalid request")
+			return
+		}
+		if request.Reason == "" {
+			badRequest(w, "Missing reason")
+			return
+		}
+
+		erasure, err := a.DB.ErasePerson(ctx, id)
+		if err == storage.ErrLegalHold {
+			conflictResponse(w, "Person is under legal hold")
+			return
+		}
+		if err != nil {
+			log.Println("Error erasing person:", err)
+			serverError(w)
+			return
+		}
+		archived := false
+		if a.Archive != nil {
+			_, err = a.Archive.Get(ctx, id)
+			if err != nil && err != archive.ErrNotFound {
+				log.Println("Error reading archived person:", err)
+				serverError(w)
+				return
+			}
+			if archived = err == nil; archived {
+				if err = a.Archive.Delete(ctx, id); err != nil {
+					log.Println("Error erasing archived person:", err)
+					serverError(w)
+					return
+				}
+			}
+		}
+		// replays of responses that created the person hold its data too
+		idempotencyRecords := 0
+		if a.Idempotency != nil {
+			if idempotencyRecords, err = a.Idempotency.ForgetPerson(ctx, id); err != nil {
+				log.Println("Error erasing idempotency records of person:", err)
+				serverError(w)
+				return
+			}
+		}
+		if erasure.Empty() && !archived && idempotencyRecords == 0 {
+			notFoundResponse(w)
+			return
+		}
+
+		receipt := &gdpr.Receipt{
+			Id:       uuid.New().String(),
+			PersonId: id,
+			Tenant:   tenant.FromContext(ctx),
+			ErasedAt: time.Now().UTC(),
+			ErasedBy: authenticatedActor(r),
+			Reason:   request.Reason,
+			Erased: gdpr.Scope{
+				Record:             erasure.Record,
+				HistoryVersions:    erasure.HistoryVersions,
+				Events:             erasure.Events,
+				AuditEntries:       erasure.AuditEntries,
+				IdempotencyRecords: idempotencyRecords,
+				Archive:            archived,
+			},
+		}
+		if err = a.ErasureSigner.Sign(receipt); err != nil {
+			log.Println("Error signing erasure receipt:", err)
+			serverError(w)
+			return
+		}
+		log.Println("Erased person", id, "receipt", receipt.Id)
+		jsonResponse(w, http.StatusOK, receipt)
+	}
+}
+
+// ErasureKeyHandler returns public key verifying erasure receipts
+func (a *app) ErasureKeyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse(w, http.StatusOK, map[string]string{
+			"keyId":     a.ErasureSigner.KeyId(),
+			"algorithm": "Ed25519",
+			"publicKey": base64.StdEncoding.EncodeToString(a.ErasureSigner.PublicKey()),
+		})
+	}
+}
+
+func isNotFound(err error) bool {
+	return err != nil && err.Error() == "redis: nil"
+}
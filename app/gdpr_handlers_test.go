@@ -0,0 +1,129 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"go-microservice-assignment/app/archive"
+	"go-microservice-assignment/app/audit"
+	"go-microservice-assignment/app/auth"
+	"go-microservice-assignment/app/gdpr"
+	"go-microservice-assignment/app/idempotency"
+	"go-microservice-assignment/app/models"
+	"go-microservice-assignment/app/storage"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func testSigner(t *testing.T) *gdpr.Signer {
+	signer, err := gdpr.NewSigner([]byte(strings.Repeat("s", 32)))
+	assert.NoError(t, err)
+	return signer
+}
+
+func TestExportPersonDataHandler(t *testing.T) {
+	mockRedis := redisMock{}
+	mockRedis.On("GetPerson", mock.Anything, personId).Return((*models.Person)(nil), errors.New("redis: nil"))
+	mockRedis.On("GetHistory", mock.Anything, personId).Return([]storage.PersonVersion{{Version: 1, Person: models.Person{Id: personId, Name: "Test123"}}}, nil)
+	mockRedis.On("GetRetention", mock.Anything, personId).Return((*storage.RetentionPolicy)(nil), errors.New("redis: nil"))
+	mockRedis.On("ListRetentionAudit", mock.Anything, personId).Return([]storage.RetentionAudit{}, nil)
+	store := auditStoreMock{entries: []audit.Entry{{Id: "1-0", PersonId: personId, Action: "person.created"}}}
+	store.On("Query", mock.Anything, audit.Filter{PersonId: personId}).Return(nil)
+	mockArchive := archiveMock{}
+	mockArchive.On("Get", mock.Anything, personId).Return(&models.Person{Id: personId, Name: "Test456"}, nil)
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/api/v1/admin/person/"+personId+"/export", nil)
+	app := New(&mockRedis, WithAudit(&store), WithArchive(&mockArchive, false))
+	app.Router.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, `attachment; filename="person-`+personId+`.json"`, recorder.Header().Get("Content-Disposition"))
+	var export personExport
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &export))
+	assert.Nil(t, export.Person)
+	assert.Equal(t, "Test123", export.History[0].Person.Name)
+	assert.Equal(t, store.entries, export.AuditEntries)
+	assert.Equal(t, "Test456", export.Archived.Name)
+}
+
+func TestExportPersonDataHandler_NotFound(t *testing.T) {
+	mockRedis := redisMock{}
+	mockRedis.On("GetPerson", mock.Anything, personId).Return((*models.Person)(nil), errors.New("redis: nil"))
+	mockRedis.On("GetHistory", mock.Anything, personId).Return([]storage.PersonVersion{}, nil)
+	mockRedis.On("GetRetention", mock.Anything, personId).Return((*storage.RetentionPolicy)(nil), errors.New("redis: nil"))
+	mockRedis.On("ListRetentionAudit", mock.Anything, personId).Return([]storage.RetentionAudit{}, nil)
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/api/v1/admin/person/"+personId+"/export", nil)
+	New(&mockRedis).Router.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
+
+func erasePerson(app *app, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "/api/v1/admin/person/"+personId+"/erase", strings.NewReader(body))
+	request.Header.Set("Authorization", "Bearer secret")
+	// actor named by trusted proxy is not who signs the receipt
+	request.RemoteAddr = "10.0.0.1:41234"
+	request.Header.Set("X-Actor", "intern")
+	app.Router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestErasePersonHandler(t *testing.T) {
+	mockRedis := redisMock{}
+	mockRedis.On("ErasePerson", mock.Anything, personId).Return(&storage.Erasure{Record: true, HistoryVersions: 2, Events: 3, AuditEntries: 3}, nil)
+	mockArchive := archiveMock{}
+	mockArchive.On("Get", mock.Anything, personId).Return(&models.Person{Id: personId}, nil)
+	mockArchive.On("Delete", mock.Anything, personId).Return(nil)
+	signer := testSigner(t)
+	idempotencyStore := &idempotencyStoreMock{map[string]*idempotency.Record{
+		"key-1": {Completed: true, PersonId: personId},
+		"key-2": {Completed: true, PersonId: "other"},
+	}}
+	dpo := &tokenAuthenticator{"secret", &auth.Principal{Subject: "dpo", Scopes: []string{auth.ScopeAdmin}}}
+	app := New(&mockRedis, WithArchive(&mockArchive, false), WithErasure(signer), WithTrustedProxies(testProxies), WithIdempotency(idempotencyStore), WithAuthenticators(dpo))
+
+	recorder := erasePerson(app, `{"reason":"erasure request #42"}`)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.NotContains(t, idempotencyStore.records, "key-1")
+	assert.Contains(t, idempotencyStore.records, "key-2")
+	var receipt gdpr.Receipt
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &receipt))
+	assert.Equal(t, personId, receipt.PersonId)
+	assert.Equal(t, "dpo", receipt.ErasedBy)
+	assert.Equal(t, gdpr.Scope{Record: true, HistoryVersions: 2, Events: 3, AuditEntries: 3, IdempotencyRecords: 1, Archive: true}, receipt.Erased)
+	assert.True(t, gdpr.Verify(&receipt, signer.PublicKey()))
+	mockArchive.AssertExpectations(t)
+}
+
+func TestErasePersonHandler_Rejected(t *testing.T) {
+	mockRedis := redisMock{}
+	mockRedis.On("ErasePerson", mock.Anything, personId).Return((*storage.Erasure)(nil), storage.ErrLegalHold).Once()
+	mockRedis.On("ErasePerson", mock.Anything, personId).Return(&storage.Erasure{}, nil)
+	mockArchive := archiveMock{}
+	mockArchive.On("Get", mock.Anything, personId).Return((*models.Person)(nil), archive.ErrNotFound)
+	app := New(&mockRedis, WithArchive(&mockArchive, false), WithErasure(testSigner(t)))
+
+	assert.Equal(t, http.StatusBadRequest, erasePerson(app, `{}`).Code)
+	assert.Equal(t, http.StatusConflict, erasePerson(app, `{"reason":"request"}`).Code)
+	assert.Equal(t, http.StatusNotFound, erasePerson(app, `{"reason":"request"}`).Code)
+	mockArchive.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+}
+
+func TestErasureKeyHandler(t *testing.T) {
+	signer := testSigner(t)
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/api/v1/admin/erasure-key", nil)
+	New(nil, WithErasure(signer)).Router.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), signer.KeyId())
+}
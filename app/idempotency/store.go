@@ -0,0 +1,212 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go-microservice-assignment/app/auth"
+	"go-microservice-assignment/app/encryption"
+	"go-microservice-assignment/app/tenant"
+	"net/url"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// record field holding encryption.Envelope of encrypted record
+const envelopeField = "encryption"
+
+// encryptedFields of record hold response with personal data
+var encryptedFields = []string{"body"}
+
+const (
+	keyPrefix = "idempotency:"
+	// set of keys of records with response about a person
+	personKeyPrefix = "idempotency-person:"
+	// reservation of request that never completes (e.g. replica crashed) is freed after this time
+	pendingTimeout = time.Minute
+)
+
+// Record is a request made with an idempotency key. Until the request
+// completes, only RequestHash is set.
+type Record struct {
+	RequestHash string `json:"requestHash"`
+	Completed   bool   `json:"completed"`
+	StatusCode  int    `json:"statusCode,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+	// person the response is about, its erasure removes the record
+	PersonId string `json:"personId,omitempty"`
+}
+
+// Store keeps records per tenant and authenticated principal of context
+type Store interface {
+	// Reserve claims key for request with given hash. Returns nil when key was
+	// claimed and record of earlier request when key was already used.
+	Reserve(ctx context.Context, key string, requestHash string) (*Record, error)
+	// Complete stores response of request, so it can be replayed on retry
+	Complete(ctx context.Context, key string, record *Record) error
+	// Release frees key of failed request, so it can be retried
+	Release(ctx context.Context, key string) error
+	// ForgetPerson removes records about person of any caller in tenant of
+	// context, returns number of removed records
+	ForgetPerson(ctx context.Context, personId string) (int, error)
+}
+
+type redisStore struct {
+	client    *redis.Client
+	window    time.Duration
+	encryptor *encryption.FieldEncryptor
+}
+
+// Option configures optional behaviour of the store created by NewRedisStore
+type Option func(*redisStore)
+
+// WithEncryption encrypts stored responses, as they hold the same personal
+// data as persons themselves
+func WithEncryption(encryptor *encryption.FieldEncryptor) Option {
+	return func(s *redisStore) {
+		s.encryptor = encryptor
+	}
+}
+
+// NewRedisStore keeps idempotency records in Redis strings expiring after window
+func NewRedisStore(client *redis.Client, window time.Duration, options ...Option) Store {
+	s := &redisStore{client: client, window: window}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+func (s *redisStore) Reserve(ctx context.Context, key string, requestHash string) (*Record, error) {
+	pending, err := json.Marshal(&Record{RequestHash: requestHash})
+	if err != nil {
+		return nil, err
+	}
+	for {
+		claimed, err := s.client.SetNX(ctx, recordKey(ctx, key), pending, pendingTimeout).Result()
+		if err != nil {
+			return nil, err
+		}
+		if claimed {
+			return nil, nil
+		}
+		data, err := s.client.Get(ctx, recordKey(ctx, key)).Result()
+		if err == redis.Nil {
+			// expired or released in the meantime, try to claim again
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return s.decode(recordKey(ctx, key), data)
+	}
+}
+
+func (s *redisStore) Complete(ctx context.Context, key string, record *Record) error {
+	record.Completed = true
+	data, err := s.encode(recordKey(ctx, key), record)
+	if err != nil {
+		return err
+	}
+	if record.PersonId == "" {
+		return s.client.Set(ctx, recordKey(ctx, key), data, s.window).Err()
+	}
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, recordKey(ctx, key), data, s.window)
+		pipe.SAdd(ctx, personKey(ctx, record.PersonId), recordKey(ctx, key))
+		pipe.Expire(ctx, personKey(ctx, record.PersonId), s.window)
+		return nil
+	})
+	return err
+}
+
+func (s *redisStore) ForgetPerson(ctx context.Context, personId string) (int, error) {
+	keys, err := s.client.SMembers(ctx, personKey(ctx, personId)).Result()
+	if err != nil {
+		return 0, err
+	}
+	var removed *redis.IntCmd
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if len(keys) > 0 {
+			removed = pipe.Del(ctx, keys...)
+		}
+		pipe.Del(ctx, personKey(ctx, personId))
+		return nil
+	})
+	if err != nil || removed == nil {
+		return 0, err
+	}
+	return int(removed.Val()), nil
+}
+
+// encode returns record as stored in Redis, with body encrypted and bound to
+// key of the record when encryption is enabled
+func (s *redisStore) encode(id string, record *Record) ([]byte, error) {
+	data, err := json.Marshal(record)
+	if err != nil || s.encryptor == nil {
+		return data, err
+	}
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	envelope, err := s.encryptor.Encrypt(fields, encryptedFields, id)
+	if err != nil {
+		return nil, err
+	}
+	if fields[envelopeField], err = json.Marshal(envelope); err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
+
+func (s *redisStore) decode(id string, data string) (*Record, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(data), &fields); err != nil {
+		return nil, err
+	}
+	if envelopeData, encrypted := fields[envelopeField]; encrypted {
+		if s.encryptor == nil {
+			return nil, errors.New("idempotency record is encrypted, but encryption is not configured")
+		}
+		var envelope encryption.Envelope
+		if err := json.Unmarshal(envelopeData, &envelope); err != nil {
+			return nil, err
+		}
+		if err := s.encryptor.Decrypt(fields, &envelope, id); err != nil {
+			return nil, err
+		}
+		delete(fields, envelopeField)
+		decrypted, err := json.Marshal(fields)
+		if err != nil {
+			return nil, err
+		}
+		data = string(decrypted)
+	}
+	var record Record
+	if err := json.Unmarshal([]byte(data), &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (s *redisStore) Release(ctx context.Context, key string) error {
+	return s.client.Del(ctx, recordKey(ctx, key)).Err()
+}
+
+// records of tenants and of callers within tenant are kept apart, so equal
+// keys of two callers never collide and nobody replays response of another
+func recordKey(ctx context.Context, key string) string {
+	subject := ""
+	if principal := auth.FromContext(ctx); principal != nil {
+		subject = principal.Subject
+	}
+	return tenant.Key(ctx, keyPrefix+url.QueryEscape(subject)+":"+key)
+}
+
+// personKey returns key of set of records about person in tenant of context
+func personKey(ctx context.Context, personId string) string {
+	return tenant.Key(ctx, personKeyPrefix+personId)
+}
@@ -0,0 +1,30 @@
+package idempotency
+
+import (
+	"encoding/base64"
+	"go-microservice-assignment/app/encryption"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisStore_EncryptsBody(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+	keyring, err := encryption.NewStaticKeyring("k1", map[string]string{"k1": key}, key)
+	assert.NoError(t, err)
+	s := &redisStore{encryptor: encryption.NewFieldEncryptor(keyring)}
+
+	record := &Record{RequestHash: "abc", Completed: true, StatusCode: 201, Body: []byte(`{"name":"Jane Doe"}`)}
+	data, err := s.encode("idempotency:jane:key-1", record)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), base64.StdEncoding.EncodeToString(record.Body))
+
+	decoded, err := s.decode("idempotency:jane:key-1", string(data))
+	assert.NoError(t, err)
+	assert.Equal(t, record, decoded)
+
+	// ciphertext is bound to key of the record
+	_, err = s.decode("idempotency:john:key-1", string(data))
+	assert.Error(t, err)
+}
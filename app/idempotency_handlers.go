@@ -0,0 +1,135 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"go-microservice-assignment/app/auth"
+	"go-microservice-assignment/app/idempotency"
+	"go-microservice-assignment/app/tenant"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+)
+
+const maxIdempotencyKeyLength = 255
+
+// responseCapture passes response through while keeping a copy of it
+type responseCapture struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (c *responseCapture) WriteHeader(statusCode int) {
+	c.statusCode = statusCode
+	c.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (c *responseCapture) Write(b []byte) (int, error) {
+	if c.statusCode == 0 {
+		c.statusCode = http.StatusOK
+	}
+	c.body.Write(b)
+	return c.ResponseWriter.Write(b)
+}
+
+// idempotent replays stored response of request repeated with the same
+// Idempotency-Key header by the same caller. Reusing key with a different body
+// is rejected with 422, key of request still in progress with 409. Failed
+// requests (5xx) can be retried with the same key.
+func (a *app) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if a.Idempotency == nil || key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			badRequest(w, "Idempotency-Key is too long")
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			badRequest(w, "Invalid request")
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		requestHash := hashRequest(body)
+
+		record, err := a.Idempotency.Reserve(r.Context(), key, requestHash)
+		if err != nil {
+			log.Println("Error reserving idempotency key:", err)
+			serverError(w)
+			return
+		}
+		if record != nil {
+			replay(w, record, requestHash)
+			return
+		}
+
+		capture := &responseCapture{ResponseWriter: w}
+		next(capture, r)
+
+		// record is stored even when client has gone away meanwhile, for the same tenant and caller
+		ctx := auth.WithPrincipal(tenant.WithTenant(context.Background(), tenant.FromContext(r.Context())), auth.FromContext(r.Context()))
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		if capture.statusCode >= http.StatusInternalServerError {
+			err = a.Idempotency.Release(ctx, key)
+		} else {
+			// response about a person is removed on its erasure
+			var person struct {
+				Id string `json:"id"`
+			}
+			json.Unmarshal(capture.body.Bytes(), &person)
+			err = a.Idempotency.Complete(ctx, key, &idempotency.Record{
+				RequestHash: requestHash,
+				StatusCode:  capture.statusCode,
+				ContentType: capture.Header().Get("Content-Type"),
+				Body:        capture.body.Bytes(),
+				PersonId:    person.Id,
+			})
+		}
+		if err != nil {
+			log.Println("Error storing idempotency record:", err)
+		}
+	}
+}
+
+// hashRequest hashes canonical form of JSON body, so retry that differs only in
+// whitespace or order of fields is the same request. Other bodies are hashed as they are.
+func hashRequest(body []byte) string {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err == nil && !decoder.More() {
+		if canonical, err := json.Marshal(value); err == nil {
+			body = canonical
+		}
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+func replay(w http.ResponseWriter, record *idempotency.Record, requestHash string) {
+	if record.RequestHash != requestHash {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte("Idempotency-Key was already used with a different request"))
+		return
+	}
+	if !record.Completed {
+		conflictResponse(w, "Request with this Idempotency-Key is in progress")
+		return
+	}
+	if record.ContentType != "" {
+		w.Header().Set("Content-Type", record.ContentType)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(record.StatusCode)
+	w.Write(record.Body)
+}
@@ -0,0 +1,129 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"go-microservice-assignment/app/idempotency"
+	"go-microservice-assignment/app/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// in-memory idempotency.Store
+type idempotencyStoreMock struct {
+	records map[string]*idempotency.Record
+}
+
+func (s *idempotencyStoreMock) Reserve(ctx context.Context, key string, requestHash string) (*idempotency.Record, error) {
+	if record, ok := s.records[key]; ok {
+		return record, nil
+	}
+	s.records[key] = &idempotency.Record{RequestHash: requestHash}
+	return nil, nil
+}
+
+func (s *idempotencyStoreMock) Complete(ctx context.Context, key string, record *idempotency.Record) error {
+	record.Completed = true
+	s.records[key] = record
+	return nil
+}
+
+func (s *idempotencyStoreMock) Release(ctx context.Context, key string) error {
+	delete(s.records, key)
+	return nil
+}
+
+func (s *idempotencyStoreMock) ForgetPerson(ctx context.Context, personId string) (int, error) {
+	removed := 0
+	for key, record := range s.records {
+		if record.PersonId == personId {
+			delete(s.records, key)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+func createPersonWithKey(app *app, key string, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "/api/v1/person", strings.NewReader(body))
+	request.Header.Set("Idempotency-Key", key)
+	app.Router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestIdempotentCreatePerson_Replayed(t *testing.T) {
+	mockRedis := redisMock{}
+	mockRedis.On("CreatePerson", mock.Anything, mock.Anything).Return(nil)
+	store := &idempotencyStoreMock{map[string]*idempotency.Record{}}
+	app := New(&mockRedis, WithIdempotency(store))
+
+	first := createPersonWithKey(app, "key-1", `{"name":"Test123"}`)
+	second := createPersonWithKey(app, "key-1", `{"name":"Test123"}`)
+
+	assert.Equal(t, http.StatusCreated, first.Code)
+	assert.Equal(t, http.StatusCreated, second.Code)
+	assert.Equal(t, first.Body.String(), second.Body.String())
+	assert.Equal(t, "true", second.Header().Get("Idempotent-Replayed"))
+	mockRedis.AssertNumberOfCalls(t, "CreatePerson", 1)
+	// record is linked to created person, so its erasure removes it
+	var created models.Person
+	assert.NoError(t, json.Unmarshal(first.Body.Bytes(), &created))
+	assert.Equal(t, created.Id, store.records["key-1"].PersonId)
+}
+
+func TestIdempotentCreatePerson_DifferentBody(t *testing.T) {
+	mockRedis := redisMock{}
+	mockRedis.On("CreatePerson", mock.Anything, mock.Anything).Return(nil)
+	app := New(&mockRedis, WithIdempotency(&idempotencyStoreMock{map[string]*idempotency.Record{}}))
+
+	createPersonWithKey(app, "key-1", `{"name":"Test123"}`)
+	recorder := createPersonWithKey(app, "key-1", `{"name":"Test456"}`)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+	mockRedis.AssertNumberOfCalls(t, "CreatePerson", 1)
+}
+
+func TestIdempotentCreatePerson_EquivalentBody(t *testing.T) {
+	mockRedis := redisMock{}
+	mockRedis.On("CreatePerson", mock.Anything, mock.Anything).Return(nil)
+	app := New(&mockRedis, WithIdempotency(&idempotencyStoreMock{map[string]*idempotency.Record{}}))
+
+	createPersonWithKey(app, "key-1", `{"name":"Test123","address":"Berlin 1"}`)
+	recorder := createPersonWithKey(app, "key-1", `{ "address": "Berlin 1",
+  "name": "Test123" }`)
+
+	assert.Equal(t, http.StatusCreated, recorder.Code)
+	assert.Equal(t, "true", recorder.Header().Get("Idempotent-Replayed"))
+	mockRedis.AssertNumberOfCalls(t, "CreatePerson", 1)
+}
+
+func TestIdempotentCreatePerson_InProgress(t *testing.T) {
+	store := &idempotencyStoreMock{map[string]*idempotency.Record{}}
+	app := New(&redisMock{}, WithIdempotency(store))
+	sum := sha256.Sum256([]byte(`{"name":"Test123"}`))
+	store.Reserve(context.Background(), "key-1", hex.EncodeToString(sum[:]))
+
+	recorder := createPersonWithKey(app, "key-1", `{"name":"Test123"}`)
+
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+}
+
+func TestIdempotentCreatePerson_FailedRequestCanBeRetried(t *testing.T) {
+	mockRedis := redisMock{}
+	mockRedis.On("CreatePerson", mock.Anything, mock.Anything).Return(assert.AnError)
+	store := &idempotencyStoreMock{map[string]*idempotency.Record{}}
+	app := New(&mockRedis, WithIdempotency(store))
+
+	recorder := createPersonWithKey(app, "key-1", `{"name":"Test123"}`)
+
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	assert.Empty(t, store.records)
+}
@@ -0,0 +1,203 @@
+package app
+
+import (
+	"go-microservice-assignment/app/models"
+	"go-microservice-assignment/app/projection"
+	"go-microservice-assignment/app/storage"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// personOrders are fields persons can be sorted by, prefix "-" sorts descending
+var personOrders = map[string]func(a, b *models.Person) bool{
+	"id":        func(a, b *models.Person) bool { return a.Id < b.Id },
+	"name":      func(a, b *models.Person) bool { return a.Name < b.Name },
+	"createdAt": func(a, b *models.Person) bool { return a.CreatedAt.Before(b.CreatedAt) },
+	"updatedAt": func(a, b *models.Person) bool { return a.UpdatedAt.Before(b.UpdatedAt) },
+	"version":   func(a, b *models.Person) bool { return a.Version < b.Version },
+}
+
+// ListPersonsHandler returns page of persons sorted by ?sort (default createdAt),
+// optionally only those matching ?name, ?address and ?dateOfBirth and only
+// fields selected by ?fields
+func (a *app) ListPersonsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		less, err := personOrder(query.Get("sort"))
+		if err != nil {
+			badRequest(w, err.Error())
+			return
+		}
+		limit, err := intParameter(query.Get("limit"), "limit", defaultListLimit)
+		if err != nil || limit > maxListLimit {
+			badRequest(w, errInvalidParameter("limit").Error())
+			return
+		}
+		offset, err := intParameter(query.Get("offset"), "offset", 0)
+		if err != nil {
+			badRequest(w, err.Error())
+			return
+		}
+		fields, err := fieldsParameter(r)
+		if err != nil {
+			badRequest(w, err.Error())
+			return
+		}
+
+		// searching by a field would reveal the value caller cannot see
+		restrictions := a.restrictions(r)
+		for _, field := range storage.SearchFields {
+			if _, restricted := restrictions[field]; restricted && query.Get(field) != "" {
+				problemResponse(w, r, http.StatusForbidden, "Cannot search by field "+field)
+				return
+			}
+		}
+
+		page, total, err := a.indexedPage(r, limit, offset)
+		if err == storage.ErrOrderNotIndexed {
+			page, total, err = a.sortedPage(r, less, limit, offset)
+		}
+		if err != nil {
+			log.Println("Error listing persons:", err)
+			serverError(w)
+			return
+		}
+		projected, err := projection.ApplyAll(page, restrictions, fields)
+		if err != nil {
+			log.Println("Error projecting persons:", err)
+			serverError(w)
+			return
+		}
+		w.Header().Set("X-Total-Count", strconv.Itoa(total))
+		jsonResponse(w, http.StatusOK, projected)
+	}
+}
+
+// indexedPage reads page of persons sorted by indexed field from its index.
+// Returns storage.ErrOrderNotIndexed when page has to be sorted in memory:
+// for searches, sorting by other fields and before indexes are backfilled.
+func (a *app) indexedPage(r *http.Request, limit int, offset int) ([]*models.Person, int, error) {
+	query := r.URL.Query()
+	for _, field := range storage.SearchFields {
+		if query.Get(field) != "" {
+			return nil, 0, storage.ErrOrderNotIndexed
+		}
+	}
+	sortBy := query.Get("sort")
+	if sortBy == "" {
+		sortBy = "createdAt"
+	}
+	order := strings.TrimPrefix(sortBy, "-")
+	indexed := false
+	for _, field := range storage.OrderFields {
+		indexed = indexed || field == order
+	}
+	if !indexed {
+		return nil, 0, storage.ErrOrderNotIndexed
+	}
+	return a.DB.ListPersonsPage(r.Context(), storage.PageQuery{
+		Order:          order,
+		Descending:     order != sortBy,
+		Offset:         offset,
+		Limit:          limit,
+		IncludeDeleted: includeDeleted(r),
+	})
+}
+
+// sortedPage loads all matching persons and sorts them in memory
+func (a *app) sortedPage(r *http.Request, less func(a, b *models.Person) bool, limit int, offset int) ([]*models.Person, int, error) {
+	persons, err := a.searchPersons(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !includeDeleted(r) {
+		persons = withoutDeleted(persons)
+	}
+	sort.SliceStable(persons, func(i, j int) bool {
+		return less(persons[i], persons[j])
+	})
+
+	page := []*models.Person{}
+	if offset < len(persons) {
+		page = persons[offset:]
+	}
+	if len(page) > limit {
+		page = page[:limit]
+	}
+	return page, len(persons), nil
+}
+
+// searchPersons returns persons whose PII fields given as query parameters
+// match exactly, ignoring case and whitespace. Looks up by the first field,
+// so encrypted persons are found by blind index.
+func (a *app) searchPersons(r *http.Request) ([]*models.Person, error) {
+	query := r.URL.Query()
+	var fields []string
+	for _, field := range storage.SearchFields {
+		if query.Get(field) != "" {
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) == 0 {
+		return a.DB.ListPersons(r.Context())
+	}
+	persons, err := a.DB.FindPersons(r.Context(), fields[0], query.Get(fields[0]))
+	if err != nil {
+		return nil, err
+	}
+	matching := []*models.Person{}
+	for _, p := range persons {
+		matches := true
+		for _, field := range fields[1:] {
+			matches = matches && storage.PersonMatches(p, field, query.Get(field))
+		}
+		if matches {
+			matching = append(matching, p)
+		}
+	}
+	return matching, nil
+}
+
+func withoutDeleted(persons []*models.Person) []*models.Person {
+	live := persons[:0]
+	for _, p := range persons {
+		if p.DeletedAt == nil {
+			live = append(live, p)
+		}
+	}
+	return live
+}
+
+func personOrder(sortBy string) (func(a, b *models.Person) bool, error) {
+	if sortBy == "" {
+		sortBy = "createdAt"
+	}
+	field := strings.TrimPrefix(sortBy, "-")
+	less, ok := personOrders[field]
+	if !ok {
+		return nil, errInvalidParameter("sort")
+	}
+	if field != sortBy {
+		return func(a, b *models.Person) bool { return less(b, a) }, nil
+	}
+	return less, nil
+}
+
+func intParameter(value string, name string, defaultValue int) (int, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil || i < 0 {
+		return 0, errInvalidParameter(name)
+	}
+	return i, nil
+}
@@ -0,0 +1,118 @@
+package app
+
+import (
+	"encoding/json"
+	"go-microservice-assignment/app/models"
+	"go-microservice-assignment/app/storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestListPersonsHandler_Indexed(t *testing.T) {
+	mockRedis := redisMock{}
+	mockRedis.On("ListPersonsPage", mock.Anything, storage.PageQuery{Order: "updatedAt", Descending: true, Offset: 2, Limit: 2}).
+		Return([]*models.Person{{Id: "3"}}, 3, nil)
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/api/v1/person?sort=-updatedAt&limit=2&offset=2", nil)
+	app := New(&mockRedis)
+	app.ListPersonsHandler().ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "3", recorder.Header().Get("X-Total-Count"))
+	var persons []models.Person
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &persons))
+	assert.Equal(t, 1, len(persons))
+	assert.Equal(t, "3", persons[0].Id)
+	mockRedis.AssertNotCalled(t, "ListPersons", mock.Anything)
+}
+
+func TestListPersonsHandler_SortedBeforeBackfill(t *testing.T) {
+	now := time.Now().UTC()
+	mockRedis := redisMock{}
+	mockRedis.On("ListPersonsPage", mock.Anything, mock.Anything).Return([]*models.Person(nil), 0, storage.ErrOrderNotIndexed)
+	mockRedis.On("ListPersons", mock.Anything).Return([]*models.Person{
+		{Id: "1", UpdatedAt: now.Add(-time.Hour)},
+		{Id: "2", UpdatedAt: now},
+		{Id: "3", UpdatedAt: now.Add(-time.Minute)},
+	}, nil)
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/api/v1/person?sort=-updatedAt&limit=2", nil)
+	app := New(&mockRedis)
+	app.ListPersonsHandler().ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "3", recorder.Header().Get("X-Total-Count"))
+	var persons []models.Person
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &persons))
+	assert.Equal(t, 2, len(persons))
+	assert.Equal(t, "2", persons[0].Id)
+	assert.Equal(t, "3", persons[1].Id)
+}
+
+func TestListPersonsHandler_SortedByNotIndexedField(t *testing.T) {
+	mockRedis := redisMock{}
+	mockRedis.On("ListPersons", mock.Anything).Return([]*models.Person{{Id: "1", Version: 2}, {Id: "2", Version: 1}}, nil)
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/api/v1/person?sort=version", nil)
+	app := New(&mockRedis)
+	app.ListPersonsHandler().ServeHTTP(recorder, request)
+
+	var persons []models.Person
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &persons))
+	assert.Equal(t, "2", persons[0].Id)
+	mockRedis.AssertNotCalled(t, "ListPersonsPage", mock.Anything, mock.Anything)
+}
+
+func TestListPersonsHandler_InvalidParameter(t *testing.T) {
+	for _, query := range []string{"sort=dateOfBirth", "limit=5000", "offset=-1", "fields=id,ssn"} {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "/api/v1/person?"+query, nil)
+		app := New(nil)
+		app.ListPersonsHandler().ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, query)
+	}
+}
+
+func TestListPersonsHandler_Search(t *testing.T) {
+	mockRedis := redisMock{}
+	mockRedis.On("FindPersons", mock.Anything, "name", "jane doe").Return([]*models.Person{
+		{Id: "1", Name: "Jane Doe", Address: "Main St 1"},
+		{Id: "2", Name: "Jane  DOE", Address: "Elm St 2"},
+	}, nil)
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/api/v1/person?name=jane+doe&address=main+st+1", nil)
+	app := New(&mockRedis)
+	app.ListPersonsHandler().ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "1", recorder.Header().Get("X-Total-Count"))
+	var persons []models.Person
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &persons))
+	assert.Equal(t, "1", persons[0].Id)
+	mockRedis.AssertNotCalled(t, "ListPersons", mock.Anything)
+}
+
+func TestListPersonsHandler_Fields(t *testing.T) {
+	mockRedis := redisMock{}
+	mockRedis.On("FindPersons", mock.Anything, "name", "jane").Return([]*models.Person{
+		{Id: "1", Name: "Jane", Address: "Berlin"},
+	}, nil)
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/api/v1/person?name=jane&fields=id,name", nil)
+	app := New(&mockRedis)
+	app.ListPersonsHandler().ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `[{"id":"1","name":"Jane"}]`, recorder.Body.String())
+}
@@ -0,0 +1,216 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var countryCode = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// postal codes of countries without a pattern are checked only for allowed characters
+var anyPostalCode = regexp.MustCompile(`^[A-Z0-9][A-Z0-9 -]{0,9}$`)
+
+// postalCodes are formats of postal codes by ISO 3166-1 alpha-2 country code
+var postalCodes = map[string]*regexp.Regexp{
+	"AT": regexp.MustCompile(`^\d{4}$`),
+	"AU": regexp.MustCompile(`^\d{4}$`),
+	"BE": regexp.MustCompile(`^\d{4}$`),
+	"CA": regexp.MustCompile(`^[A-Z]\d[A-Z] ?\d[A-Z]\d$`),
+	"CH": regexp.MustCompile(`^\d{4}$`),
+	"CZ": regexp.MustCompile(`^\d{3} ?\d{2}$`),
+	"DE": regexp.MustCompile(`^\d{5}$`),
+	"DK": regexp.MustCompile(`^\d{4}$`),
+	"ES": regexp.MustCompile(`^\d{5}$`),
+	"FI": regexp.MustCompile(`^\d{5}$`),
+	"FR": regexp.MustCompile(`^\d{5}$`),
+	"GB": regexp.MustCompile(`^[A-Z]{1,2}\d[A-Z\d]? ?\d[A-Z]{2}$`),
+	"HR": regexp.MustCompile(`^\d{5}$`),
+	"HU": regexp.MustCompile(`^\d{4}$`),
+	"IT": regexp.MustCompile(`^\d{5}$`),
+	"JP": regexp.MustCompile(`^\d{3}-\d{4}$`),
+	"NL": regexp.MustCompile(`^\d{4} ?[A-Z]{2}$`),
+	"NO": regexp.MustCompile(`^\d{4}$`),
+	"PL": regexp.MustCompile(`^\d{2}-\d{3}$`),
+	"PT": regexp.MustCompile(`^\d{4}-\d{3}$`),
+	"SE": regexp.MustCompile(`^\d{3} ?\d{2}$`),
+	"US": regexp.MustCompile(`^\d{5}(-\d{4})?$`),
+}
+
+// countryNames are lower-case English and native names of countries and
+// their common abbreviations by ISO 3166-1 alpha-2 code
+var countryNames = map[string][]string{
+	"AT": {"austria", "österreich"},
+	"AU": {"australia"},
+	"BE": {"belgium", "belgië", "belgique"},
+	"CA": {"canada"},
+	"CH": {"switzerland", "schweiz", "suisse", "svizzera"},
+	"CZ": {"czech republic", "czechia", "česko"},
+	"DE": {"germany", "deutschland"},
+	"DK": {"denmark", "danmark"},
+	"ES": {"spain", "españa"},
+	"FI": {"finland", "suomi"},
+	"FR": {"france"},
+	"GB": {"united kingdom", "great britain", "uk", "england", "scotland", "wales"},
+	"HR": {"croatia", "hrvatska"},
+	"HU": {"hungary", "magyarország"},
+	"IE": {"ireland"},
+	"IT": {"italy", "italia"},
+	"JP": {"japan"},
+	"NL": {"netherlands", "the netherlands", "nederland"},
+	"NO": {"norway", "norge"},
+	"PL": {"poland", "polska"},
+	"PT": {"portugal"},
+	"SE": {"sweden", "sverige"},
+	"SI": {"slovenia", "slovenija"},
+	"US": {"united states", "united states of america", "usa"},
+}
+
+// Address is structured postal address, Country is ISO 3166-1 alpha-2 code
+type Address struct {
+	Street      string `json:"street"`
+	HouseNumber string `json:"houseNumber,omitempty"`
+	PostalCode  string `json:"postalCode,omitempty"`
+	City        string `json:"city"`
+	Region      string `json:"region,omitempty"`
+	Country     string `json:"country"`
+}
+
+// Normalize trims all fields and upper-cases country and postal code
+func (a *Address) Normalize() {
+	a.Street = strings.TrimSpace(a.Street)
+	a.HouseNumber = strings.TrimSpace(a.HouseNumber)
+	a.PostalCode = strings.ToUpper(strings.TrimSpace(a.PostalCode))
+	a.City = strings.TrimSpace(a.City)
+	a.Region = strings.TrimSpace(a.Region)
+	a.Country = strings.ToUpper(strings.TrimSpace(a.Country))
+}
+
+// Validate checks mandatory fields, country code and, for countries with
+// known format, that postal code is given and matches it
+func (a *Address) Validate() error {
+	if a.Street == "" {
+		return fmt.Errorf("missing postalAddress.street")
+	}
+	if a.City == "" {
+		return fmt.Errorf("missing postalAddress.city")
+	}
+	if !countryCode.MatchString(a.Country) {
+		return fmt.Errorf("invalid postalAddress.country, expected ISO 3166-1 alpha-2 code")
+	}
+	format, known := postalCodes[a.Country]
+	if known && a.PostalCode == "" {
+		return fmt.Errorf("missing postalAddress.postalCode")
+	}
+	if !known {
+		format = anyPostalCode
+	}
+	if a.PostalCode != "" && !format.MatchString(a.PostalCode) {
+		return fmt.Errorf("invalid postalAddress.postalCode for country %s", a.Country)
+	}
+	return nil
+}
+
+// String formats address as single line, e.g. "Baker Street 221B, NW1 6XE London, GB",
+// which is returned to clients in the legacy address field
+func (a *Address) String() string {
+	return joinNonEmpty(", ",
+		joinNonEmpty(" ", a.Street, a.HouseNumber),
+		joinNonEmpty(" ", a.PostalCode, a.City),
+		a.Region,
+		a.Country)
+}
+
+// ParseAddress parses single-line address in the format of Address.String,
+// "<street> <house number>, <postal code> <city>[, <region>], <country>".
+// House number may also precede the street and country may be given by its
+// name. Line without country gets defaultCountry, unless it is empty. Returns
+// error when line is not in that format or the parsed address is not valid.
+func ParseAddress(line string, defaultCountry string) (*Address, error) {
+	parts := strings.Split(line, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	a := &Address{}
+	if country, ok := parseCountry(parts[len(parts)-1]); ok && len(parts) > 2 {
+		a.Country, parts = country, parts[:len(parts)-1]
+	} else if defaultCountry != "" {
+		a.Country = defaultCountry
+	} else {
+		return nil, fmt.Errorf("missing country")
+	}
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("expected street, city and optional region separated by commas")
+	}
+	if len(parts) == 3 {
+		a.Region = parts[2]
+	}
+	a.Country = strings.ToUpper(strings.TrimSpace(a.Country))
+	a.Street, a.HouseNumber = splitHouseNumber(strings.Fields(parts[0]))
+	a.PostalCode, a.City = splitPostalCode(strings.Fields(parts[1]), a.Country)
+	a.Normalize()
+	if err := a.Validate(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// parseCountry returns code of country given by code or name
+func parseCountry(s string) (string, bool) {
+	for code, names := range countryNames {
+		for _, name := range names {
+			if strings.EqualFold(s, name) {
+				return code, true
+			}
+		}
+	}
+	if countryCode.MatchString(strings.ToUpper(s)) {
+		return strings.ToUpper(s), true
+	}
+	return "", false
+}
+
+// splitHouseNumber takes house number from the end or, if there is none, from
+// the start of street line
+func splitHouseNumber(words []string) (string, string) {
+	if len(words) > 1 && hasDigit(words[len(words)-1]) {
+		return strings.Join(words[:len(words)-1], " "), words[len(words)-1]
+	}
+	if len(words) > 1 && hasDigit(words[0]) {
+		return strings.Join(words[1:], " "), words[0]
+	}
+	return strings.Join(words, " "), ""
+}
+
+// splitPostalCode takes postal code from the start of city line. In countries
+// with known format it may consist of two words like "NW1 6XE".
+func splitPostalCode(words []string, country string) (string, string) {
+	format, known := postalCodes[country]
+	longest := 2
+	if !known {
+		format, longest = anyPostalCode, 1
+	}
+	for n := longest; n >= 1; n-- {
+		if len(words) > n {
+			code := strings.ToUpper(strings.Join(words[:n], " "))
+			if format.MatchString(code) && hasDigit(code) {
+				return code, strings.Join(words[n:], " ")
+			}
+		}
+	}
+	return "", strings.Join(words, " ")
+}
+
+func hasDigit(s string) bool {
+	return strings.IndexAny(s, "0123456789") >= 0
+}
+
+func joinNonEmpty(sep string, values ...string) string {
+	var nonEmpty []string
+	for _, v := range values {
+		if v != "" {
+			nonEmpty = append(nonEmpty, v)
+		}
+	}
+	return strings.Join(nonEmpty, sep)
+}
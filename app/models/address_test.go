@@ -0,0 +1,98 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddress_Validate(t *testing.T) {
+	valid := []Address{
+		{Street: "Unter den Linden", HouseNumber: "77", PostalCode: "10117", City: "Berlin", Country: "DE"},
+		{Street: "Baker Street", HouseNumber: "221B", PostalCode: "NW1 6XE", City: "London", Country: "GB"},
+		{Street: "Damrak", HouseNumber: "1", PostalCode: "1012LG", City: "Amsterdam", Country: "NL"},
+		{Street: "Main Street", PostalCode: "62704-1234", City: "Springfield", Region: "IL", Country: "US"},
+		// no postal code format known, postal code is optional
+		{Street: "O'Connell Street", City: "Dublin", Country: "IE"},
+	}
+	for _, a := range valid {
+		assert.NoError(t, a.Validate(), a.String())
+	}
+
+	invalid := map[string]Address{
+		"missing postalAddress.street":                                    {PostalCode: "10117", City: "Berlin", Country: "DE"},
+		"missing postalAddress.city":                                      {Street: "Unter den Linden", PostalCode: "10117", Country: "DE"},
+		"missing postalAddress.postalCode":                                {Street: "Unter den Linden", City: "Berlin", Country: "DE"},
+		"invalid postalAddress.postalCode for country DE":                 {Street: "Unter den Linden", PostalCode: "1011", City: "Berlin", Country: "DE"},
+		"invalid postalAddress.postalCode for country PL":                 {Street: "Nowy Świat", PostalCode: "00373", City: "Warszawa", Country: "PL"},
+		"invalid postalAddress.country, expected ISO 3166-1 alpha-2 code": {Street: "Unter den Linden", City: "Berlin", Country: "Germany"},
+	}
+	for message, a := range invalid {
+		assert.EqualError(t, a.Validate(), message)
+	}
+}
+
+func TestPerson_NormalizeAddress(t *testing.T) {
+	var p Person
+	err := json.Unmarshal([]byte(`{"name":"Jane","address":"ignored","postalAddress":
+		{"street":" Baker Street ","houseNumber":"221B","postalCode":"nw1 6xe","city":"London","country":"gb"}}`), &p)
+	assert.NoError(t, err)
+
+	assert.NoError(t, p.NormalizeAddress())
+	assert.Equal(t, "Baker Street 221B, NW1 6XE London, GB", p.Address)
+	assert.Equal(t, "GB", p.PostalAddress.Country)
+
+	legacy := Person{Address: "Berlin 123"}
+	assert.NoError(t, legacy.NormalizeAddress())
+	assert.Equal(t, "Berlin 123", legacy.Address)
+	assert.Nil(t, legacy.PostalAddress)
+}
+
+func TestParseAddress(t *testing.T) {
+	address, err := ParseAddress("Baker Street 221B, NW1 6XE London, GB", "")
+	assert.NoError(t, err)
+	assert.Equal(t, &Address{Street: "Baker Street", HouseNumber: "221B", PostalCode: "NW1 6XE", City: "London", Country: "GB"}, address)
+
+	address, err = ParseAddress("742 Evergreen Terrace, 62704 Springfield, Illinois, us", "")
+	assert.NoError(t, err)
+	assert.Equal(t, &Address{Street: "Evergreen Terrace", HouseNumber: "742", PostalCode: "62704", City: "Springfield", Region: "Illinois", Country: "US"}, address)
+
+	for _, line := range []string{
+		"Berlin 123",
+		"Unter den Linden 77, Berlin, DE",
+		"Unter den Linden 77, 10117 Berlin, Atlantis",
+		"",
+	} {
+		_, err = ParseAddress(line, "")
+		assert.Error(t, err, line)
+	}
+}
+
+func TestParseAddress_CountryName(t *testing.T) {
+	address, err := ParseAddress("Ilica 1, 10000 Zagreb, Croatia", "")
+	assert.NoError(t, err)
+	assert.Equal(t, &Address{Street: "Ilica", HouseNumber: "1", PostalCode: "10000", City: "Zagreb", Country: "HR"}, address)
+
+	address, err = ParseAddress("Unter den Linden 77, 10117 Berlin, Deutschland", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "DE", address.Country)
+}
+
+func TestParseAddress_DefaultCountry(t *testing.T) {
+	_, err := ParseAddress("221B Baker St, London", "")
+	assert.EqualError(t, err, "missing country")
+
+	// postal code is still required in countries with known format
+	_, err = ParseAddress("221B Baker St, London", "GB")
+	assert.EqualError(t, err, "missing postalAddress.postalCode")
+
+	address, err := ParseAddress("221B Baker St, NW1 6XE London", "GB")
+	assert.NoError(t, err)
+	assert.Equal(t, &Address{Street: "Baker St", HouseNumber: "221B", PostalCode: "NW1 6XE", City: "London", Country: "GB"}, address)
+
+	// country given in line wins over default
+	address, err = ParseAddress("Ilica 1, 10000 Zagreb, Croatia", "GB")
+	assert.NoError(t, err)
+	assert.Equal(t, "HR", address.Country)
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"strings"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 const DobDateFormat = "02/01/2006" // DD/MM/YYYY
@@ -28,11 +30,41 @@ func (date JSONDate) MarshalText() (string, error) {
 	return d, nil
 }
 
+// ValidId reports whether id is a UUID in canonical form, which persons are
+// stored under. Other ids could address keys of another tenant.
+func ValidId(id string) bool {
+	_, err := uuid.Parse(id)
+	return err == nil && len(id) == 36
+}
+
 type Person struct {
 	Id string `json:"id"`
 	Name string `json:"name"`
+	// single-line address kept for old clients, derived from PostalAddress when it is set
 	Address string `json:"address"`
+	PostalAddress *Address `json:"postalAddress,omitempty"`
 	DateOfBirth JSONDate `json:"dateOfBirth"`
+	// metadata managed by storage layer, values sent by clients are ignored
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Version int `json:"version"`
+	CreatedBy string `json:"createdBy,omitempty"`
+	// set when person is soft deleted, tombstone is purged after grace period
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+// NormalizeAddress validates structured address, if given, and derives
+// single-line address from it
+func (p *Person) NormalizeAddress() error {
+	if p.PostalAddress == nil {
+		return nil
+	}
+	p.PostalAddress.Normalize()
+	if err := p.PostalAddress.Validate(); err != nil {
+		return err
+	}
+	p.Address = p.PostalAddress.String()
+	return nil
 }
 
 func (p *Person) MarshalBinary() ([]byte, error) {
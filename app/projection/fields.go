@@ -0,0 +1,114 @@
+package projection
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-microservice-assignment/app/models"
+	"reflect"
+	"strings"
+)
+
+var marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// Fieldset is tree of fields of person selected by client, nil child selects
+// the whole field
+type Fieldset map[string]Fieldset
+
+// ParseFields parses comma separated JSON names of fields of person, nested
+// fields are given by dotted path, e.g. "id,name,postalAddress.city". Returns nil
+// for empty spec, which selects all fields, and error for unknown fields.
+func ParseFields(spec string) (Fieldset, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	fields := Fieldset{}
+	for _, path := range strings.Split(spec, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			return nil, fmt.Errorf("empty field name")
+		}
+		if !fields.add(path, reflect.TypeOf(models.Person{})) {
+			return nil, fmt.Errorf("unknown field %q", path)
+		}
+	}
+	return fields, nil
+}
+
+// add selects field at path of type t, returns false when there is no such field
+func (f Fieldset) add(path string, t reflect.Type) bool {
+	name, rest := path, ""
+	if i := strings.Index(path, "."); i >= 0 {
+		name, rest = path[:i], path[i+1:]
+	}
+	field, ok := jsonFields(t)[name]
+	if !ok {
+		return false
+	}
+	if rest == "" {
+		f[name] = nil
+		return true
+	}
+	if !isObject(field) {
+		return false
+	}
+	child, selected := f[name]
+	if selected && child == nil {
+		// whole field is already selected
+		return true
+	}
+	if child == nil {
+		child = Fieldset{}
+		f[name] = child
+	}
+	return child.add(rest, field)
+}
+
+// selectFrom keeps only selected fields of JSON object
+func (f Fieldset) selectFrom(record map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+	selected := make(map[string]json.RawMessage, len(f))
+	for name, child := range f {
+		value, ok := record[name]
+		if !ok {
+			continue
+		}
+		if child != nil && string(value) != "null" {
+			var nested map[string]json.RawMessage
+			if err := json.Unmarshal(value, &nested); err != nil {
+				return nil, err
+			}
+			nested, err := child.selectFrom(nested)
+			if err != nil {
+				return nil, err
+			}
+			if value, err = json.Marshal(nested); err != nil {
+				return nil, err
+			}
+		}
+		selected[name] = value
+	}
+	return selected, nil
+}
+
+// jsonFields returns types of fields of struct by their JSON names
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			fields[name] = t.Field(i).Type
+		}
+	}
+	return fields
+}
+
+// isObject reports whether field is marshalled as JSON object of its fields,
+// which can be selected one by one
+func isObject(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && !t.Implements(marshalerType) && !reflect.PtrTo(t).Implements(marshalerType)
+}
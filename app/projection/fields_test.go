@@ -0,0 +1,72 @@
+package projection
+
+import (
+	"encoding/json"
+	"go-microservice-assignment/app/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFields(t *testing.T) {
+	fields, err := ParseFields("")
+	assert.NoError(t, err)
+	assert.Nil(t, fields)
+
+	fields, err = ParseFields(" id, name ,dateOfBirth")
+	assert.NoError(t, err)
+	assert.Equal(t, Fieldset{"id": nil, "name": nil, "dateOfBirth": nil}, fields)
+
+	_, err = ParseFields("id,ssn")
+	assert.EqualError(t, err, `unknown field "ssn"`)
+	_, err = ParseFields("id,,name")
+	assert.Error(t, err)
+	fields, err = ParseFields("id,postalAddress.city,postalAddress.country")
+	assert.NoError(t, err)
+	assert.Equal(t, Fieldset{"id": nil, "postalAddress": Fieldset{"city": nil, "country": nil}}, fields)
+	fields, err = ParseFields("postalAddress.city,postalAddress")
+	assert.NoError(t, err)
+	assert.Equal(t, Fieldset{"postalAddress": nil}, fields)
+	_, err = ParseFields("postalAddress.zip")
+	assert.EqualError(t, err, `unknown field "postalAddress.zip"`)
+
+	// dates are marshalled as strings, they have no fields
+	_, err = ParseFields("dateOfBirth.year")
+	assert.EqualError(t, err, `unknown field "dateOfBirth.year"`)
+}
+
+func TestApply_Fields(t *testing.T) {
+	person := testPerson()
+	fields, _ := ParseFields("id,name,address")
+
+	v, err := Apply(person, map[string]Mode{"address": Hidden}, fields)
+	assert.NoError(t, err)
+	data, _ := json.Marshal(v)
+	assert.JSONEq(t, `{"id":"1","name":"Jane Doe"}`, string(data))
+}
+
+func TestApply_NestedFields(t *testing.T) {
+	person := testPerson()
+	person.PostalAddress = &models.Address{Street: "Baker Street", HouseNumber: "221B", PostalCode: "NW1 6XE", City: "London", Country: "GB"}
+	fields, _ := ParseFields("id,postalAddress.city,postalAddress.country")
+
+	v, err := Apply(person, map[string]Mode{"postalAddress": Masked}, fields)
+	assert.NoError(t, err)
+	data, _ := json.Marshal(v)
+	assert.JSONEq(t, `{"id":"1","postalAddress":{"city":"L*****","country":"GB"}}`, string(data))
+}
+
+func TestFieldset_SelectNested(t *testing.T) {
+	record := map[string]json.RawMessage{
+		"id":      json.RawMessage(`"1"`),
+		"address": json.RawMessage(`{"city":"London","street":"Baker Street"}`),
+		"contact": json.RawMessage(`null`),
+	}
+	fields := Fieldset{"id": nil, "address": Fieldset{"city": nil}, "contact": Fieldset{"email": nil}}
+
+	selected, err := fields.selectFrom(record)
+
+	assert.NoError(t, err)
+	data, _ := json.Marshal(selected)
+	assert.JSONEq(t, `{"id":"1","address":{"city":"London"},"contact":null}`, string(data))
+}
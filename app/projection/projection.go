@@ -0,0 +1,235 @@
+package projection
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-microservice-assignment/app/auth"
+	"go-microservice-assignment/app/models"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// Mode tells how a field of person is shown to caller
+type Mode string
+
+const (
+	Full   Mode = "full"
+	Masked Mode = "masked"
+	Hidden Mode = "hidden"
+)
+
+// how much of the field each mode reveals, the most revealing mode of caller applies
+var revealed = map[Mode]int{Hidden: 0, Masked: 1, Full: 2}
+
+// maskers of fields that can be masked, they get the field as marshalled by models.Person
+var maskers = map[string]func(json.RawMessage) (json.RawMessage, error){
+	"name":          maskText,
+	"address":       maskText,
+	"postalAddress": maskPostalAddress,
+	"dateOfBirth":   maskDate,
+}
+
+// Field gives mode of callers holding one of Scopes, callers holding none of
+// them get Default. Caller holding several of them gets the most revealing mode.
+type Field struct {
+	Default Mode            `json:"default"`
+	Scopes  map[string]Mode `json:"scopes"`
+}
+
+// Policy maps JSON fields of person to their modes, fields not in policy are shown in full
+type Policy struct {
+	Fields map[string]Field `json:"fields"`
+}
+
+// DefaultPolicy masks address and date of birth for masked readers, unless
+// they can also read persons in full
+func DefaultPolicy() *Policy {
+	masked := Field{Default: Full, Scopes: map[string]Mode{
+		auth.ScopePersonRead:       Full,
+		auth.ScopePersonReadMasked: Masked,
+	}}
+	return &Policy{Fields: map[string]Field{
+		"address":       masked,
+		"postalAddress": masked,
+		"dateOfBirth":   masked,
+	}}
+}
+
+// LoadPolicy reads policy from JSON file
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var policy Policy
+	if err = json.Unmarshal(data, &policy); err != nil {
+		return nil, err
+	}
+	if err = policy.Validate(); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// Validate checks that policy names only fields of person, never hides id and
+// masks only fields that have a mask
+func (p *Policy) Validate() error {
+	fields := jsonFields(reflect.TypeOf(models.Person{}))
+	for name, field := range p.Fields {
+		if _, ok := fields[name]; !ok {
+			return fmt.Errorf("unknown field %q", name)
+		}
+		modes := []Mode{field.Default}
+		for _, mode := range field.Scopes {
+			modes = append(modes, mode)
+		}
+		for _, mode := range modes {
+			if _, ok := revealed[mode]; !ok {
+				return fmt.Errorf("invalid mode %q of field %q", mode, name)
+			}
+			if mode == Hidden && name == "id" {
+				return fmt.Errorf("field %q cannot be hidden", name)
+			}
+			if _, ok := maskers[name]; mode == Masked && !ok {
+				return fmt.Errorf("field %q cannot be masked", name)
+			}
+		}
+	}
+	return nil
+}
+
+// Restrictions returns modes of fields principal does not see in full. Nil
+// principal (authentication disabled) and admin see everything.
+func (p *Policy) Restrictions(principal *auth.Principal) map[string]Mode {
+	if principal == nil || principal.HasScope(auth.ScopeAdmin) {
+		return nil
+	}
+	var restrictions map[string]Mode
+	for name, field := range p.Fields {
+		if mode := field.modeOf(principal); mode != Full {
+			if restrictions == nil {
+				restrictions = map[string]Mode{}
+			}
+			restrictions[name] = mode
+		}
+	}
+	return restrictions
+}
+
+func (f *Field) modeOf(principal *auth.Principal) Mode {
+	mode, granted := Hidden, false
+	for _, scope := range principal.Scopes {
+		if m, ok := f.Scopes[scope]; ok && (!granted || revealed[m] > revealed[mode]) {
+			mode, granted = m, true
+		}
+	}
+	if !granted {
+		return f.Default
+	}
+	return mode
+}
+
+// Apply returns person with restricted fields masked or omitted and only
+// selected fields, or person itself when there are no restrictions and all
+// fields are selected
+func Apply(person *models.Person, restrictions map[string]Mode, fields Fieldset) (interface{}, error) {
+	if len(restrictions) == 0 && fields == nil {
+		return person, nil
+	}
+	data, err := json.Marshal(person)
+	if err != nil {
+		return nil, err
+	}
+	var record map[string]json.RawMessage
+	if err = json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	for name, mode := range restrictions {
+		value, ok := record[name]
+		if !ok {
+			continue
+		}
+		switch mode {
+		case Hidden:
+			delete(record, name)
+		case Masked:
+			if record[name], err = maskers[name](value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if fields == nil {
+		return record, nil
+	}
+	return fields.selectFrom(record)
+}
+
+// ApplyAll projects every person of list
+func ApplyAll(persons []*models.Person, restrictions map[string]Mode, fields Fieldset) (interface{}, error) {
+	if len(restrictions) == 0 && fields == nil {
+		return persons, nil
+	}
+	projected := make([]interface{}, len(persons))
+	for i, person := range persons {
+		var err error
+		if projected[i], err = Apply(person, restrictions, fields); err != nil {
+			return nil, err
+		}
+	}
+	return projected, nil
+}
+
+// maskText keeps first character of every word and masks its other letters and digits
+func maskText(value json.RawMessage) (json.RawMessage, error) {
+	var text string
+	if err := json.Unmarshal(value, &text); err != nil {
+		return nil, err
+	}
+	masked := []rune(text)
+	first := true
+	for i, r := range masked {
+		if unicode.IsSpace(r) {
+			first = true
+			continue
+		}
+		if !first && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			masked[i] = '*'
+		}
+		first = false
+	}
+	return json.Marshal(string(masked))
+}
+
+// maskPostalAddress masks every field of structured address except country
+func maskPostalAddress(value json.RawMessage) (json.RawMessage, error) {
+	var address map[string]json.RawMessage
+	if err := json.Unmarshal(value, &address); err != nil || address == nil {
+		return value, err
+	}
+	for name, field := range address {
+		if name == "country" {
+			continue
+		}
+		masked, err := maskText(field)
+		if err != nil {
+			return nil, err
+		}
+		address[name] = masked
+	}
+	return json.Marshal(address)
+}
+
+// maskDate keeps only the year of DD/MM/YYYY date
+func maskDate(value json.RawMessage) (json.RawMessage, error) {
+	var date string
+	if err := json.Unmarshal(value, &date); err != nil {
+		return nil, err
+	}
+	year := date
+	if i := strings.LastIndex(date, "/"); i >= 0 {
+		year = date[i+1:]
+	}
+	return json.Marshal("**/**/" + year)
+}
@@ -0,0 +1,97 @@
+package projection
+
+import (
+	"encoding/json"
+	"go-microservice-assignment/app/auth"
+	"go-microservice-assignment/app/models"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testPerson() *models.Person {
+	return &models.Person{
+		Id:          "1",
+		Name:        "Jane Doe",
+		Address:     "221B Baker Street, London",
+		DateOfBirth: models.JSONDate(time.Date(1985, 3, 14, 0, 0, 0, 0, time.UTC)),
+	}
+}
+
+func projected(t *testing.T, person *models.Person, restrictions map[string]Mode) map[string]interface{} {
+	v, err := Apply(person, restrictions, nil)
+	assert.NoError(t, err)
+	data, _ := json.Marshal(v)
+	var record map[string]interface{}
+	json.Unmarshal(data, &record)
+	return record
+}
+
+func TestDefaultPolicy(t *testing.T) {
+	policy := DefaultPolicy()
+	supporter := &auth.Principal{Subject: "support", Scopes: []string{auth.ScopePersonReadMasked}}
+	reader := &auth.Principal{Subject: "reader", Scopes: []string{auth.ScopePersonReadMasked, auth.ScopePersonRead}}
+	writer := &auth.Principal{Subject: "writer", Scopes: []string{auth.ScopePersonWrite}}
+	admin := &auth.Principal{Subject: "admin", Scopes: []string{auth.ScopeAdmin, auth.ScopePersonReadMasked}}
+
+	assert.Equal(t, map[string]Mode{"address": Masked, "postalAddress": Masked, "dateOfBirth": Masked}, policy.Restrictions(supporter))
+	assert.Empty(t, policy.Restrictions(reader))
+	assert.Empty(t, policy.Restrictions(writer))
+	assert.Empty(t, policy.Restrictions(admin))
+	// authentication disabled
+	assert.Empty(t, policy.Restrictions(nil))
+}
+
+func TestApply(t *testing.T) {
+	person := testPerson()
+
+	v, err := Apply(person, nil, nil)
+	assert.NoError(t, err)
+	assert.Same(t, person, v)
+
+	record := projected(t, person, map[string]Mode{"name": Masked, "address": Masked, "dateOfBirth": Masked, "createdAt": Hidden})
+	assert.Equal(t, "1", record["id"])
+	assert.Equal(t, "J*** D**", record["name"])
+	assert.Equal(t, "2*** B**** S*****, L*****", record["address"])
+	assert.Equal(t, "**/**/1985", record["dateOfBirth"])
+	assert.NotContains(t, record, "createdAt")
+	assert.Contains(t, record, "updatedAt")
+
+	all, err := ApplyAll([]*models.Person{person}, map[string]Mode{"address": Hidden}, nil)
+	assert.NoError(t, err)
+	assert.Len(t, all, 1)
+}
+
+func TestLoadPolicy(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "projection")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "projection.json")
+	ioutil.WriteFile(path, []byte(`{"fields":{
+		"name":{"default":"masked","scopes":{"person:read":"full"}},
+		"createdBy":{"default":"hidden","scopes":{"audit":"full","person:read":"masked"}}}}`), 0600)
+
+	_, err := LoadPolicy(path)
+	assert.EqualError(t, err, `field "createdBy" cannot be masked`)
+
+	ioutil.WriteFile(path, []byte(`{"fields":{
+		"name":{"default":"masked","scopes":{"person:read":"full"}},
+		"createdBy":{"default":"hidden","scopes":{"audit":"full"}}}}`), 0600)
+	policy, err := LoadPolicy(path)
+	assert.NoError(t, err)
+	restrictions := policy.Restrictions(&auth.Principal{Subject: "auditor", Scopes: []string{"audit"}})
+	assert.Equal(t, map[string]Mode{"name": Masked}, restrictions)
+
+	for _, invalid := range []string{
+		`{"fields":{"ssn":{"default":"hidden"}}}`,
+		`{"fields":{"id":{"default":"hidden"}}}`,
+		`{"fields":{"name":{"default":"partial"}}}`,
+	} {
+		ioutil.WriteFile(path, []byte(invalid), 0600)
+		_, err = LoadPolicy(path)
+		assert.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,94 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// Limit allows Requests per Window, zero Requests means no limit
+type Limit struct {
+	Requests      int `json:"requests"`
+	WindowSeconds int `json:"windowSeconds"`
+}
+
+func (l Limit) Window() time.Duration {
+	return time.Duration(l.WindowSeconds) * time.Second
+}
+
+// Rule applies Limit to requests of Method ("*" for any) to route with Path
+// template. Path ending with "*" matches templates with that prefix.
+type Rule struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Limit
+}
+
+// Config maps routes to limits, first matching rule applies. Routes matching
+// no rule get Default limit.
+type Config struct {
+	Default Limit  `json:"default"`
+	Rules   []Rule `json:"rules"`
+}
+
+// DefaultConfig limits pessimistic updates, which serialize on a lock, much
+// more than other requests
+func DefaultConfig() *Config {
+	return &Config{
+		Default: Limit{Requests: 600, WindowSeconds: 60},
+		Rules: []Rule{
+			{Method: "PATCH", Path: "/api/v1/person/pessimistic", Limit: Limit{Requests: 30, WindowSeconds: 60}},
+		},
+	}
+}
+
+// LoadConfig reads config from JSON file
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err = json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// LimitFor returns limit of route and name of bucket shared by requests counted together
+func (c *Config) LimitFor(method string, pathTemplate string) (Limit, string) {
+	for _, rule := range c.Rules {
+		if rule.matches(method, pathTemplate) {
+			return rule.Limit, rule.Method + " " + rule.Path
+		}
+	}
+	return c.Default, "default"
+}
+
+func (r *Rule) matches(method string, pathTemplate string) bool {
+	if r.Method != "*" && !strings.EqualFold(r.Method, method) {
+		return false
+	}
+	if strings.HasSuffix(r.Path, "*") {
+		return strings.HasPrefix(pathTemplate, strings.TrimSuffix(r.Path, "*"))
+	}
+	return r.Path == pathTemplate
+}
+
+// Result is the outcome of counting a request
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is time until the client has its full limit again
+	Reset time.Duration
+	// RetryAfter is time until next request is allowed, zero when it is allowed now
+	RetryAfter time.Duration
+}
+
+type Limiter interface {
+	// Allow counts request of client in bucket against limit
+	Allow(ctx context.Context, bucket string, client string, limit Limit) (*Result, error)
+}
@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimitFor(t *testing.T) {
+	config := DefaultConfig()
+
+	limit, bucket := config.LimitFor("PATCH", "/api/v1/person/pessimistic")
+	assert.Equal(t, Limit{Requests: 30, WindowSeconds: 60}, limit)
+	assert.Equal(t, "PATCH /api/v1/person/pessimistic", bucket)
+
+	limit, bucket = config.LimitFor("GET", "/api/v1/person/{id}")
+	assert.Equal(t, config.Default, limit)
+	assert.Equal(t, "default", bucket)
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "ratelimit")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ratelimit.json")
+	ioutil.WriteFile(path, []byte(`{"default":{"requests":100,"windowSeconds":60},
+		"rules":[{"method":"*","path":"/api/v1/admin/*","requests":10,"windowSeconds":1}]}`), 0600)
+
+	config, err := LoadConfig(path)
+
+	assert.NoError(t, err)
+	limit, bucket := config.LimitFor("put", "/api/v1/admin/person/{id}/retention")
+	assert.Equal(t, Limit{Requests: 10, WindowSeconds: 1}, limit)
+	assert.Equal(t, "* /api/v1/admin/*", bucket)
+	limit, _ = config.LimitFor("GET", "/api/v1/person")
+	assert.Equal(t, 100, limit.Requests)
+
+	_, err = LoadConfig(filepath.Join(dir, "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestResult(t *testing.T) {
+	limit := Limit{Requests: 60, WindowSeconds: 60}
+
+	r := result(true, 58.5, limit)
+	assert.True(t, r.Allowed)
+	assert.Equal(t, 58, r.Remaining)
+	assert.Equal(t, 1500*time.Millisecond, r.Reset)
+	assert.Zero(t, r.RetryAfter)
+
+	r = result(false, 0.25, limit)
+	assert.False(t, r.Allowed)
+	assert.Equal(t, 0, r.Remaining)
+	assert.Equal(t, 750*time.Millisecond, r.RetryAfter)
+}
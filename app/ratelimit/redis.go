@@ -0,0 +1,90 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"go-microservice-assignment/app/tenant"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const keyPrefix = "ratelimit:"
+
+// token bucket refilled continuously, so the limit is spread over the window
+// instead of resetting at its boundary. Returns whether request is allowed and
+// tokens left afterwards.
+var tokenBucketScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = capacity
+	ts = now
+end
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * capacity / window)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", tostring(now))
+redis.call("PEXPIRE", KEYS[1], window)
+return {allowed, tostring(tokens)}
+`)
+
+type redisLimiter struct {
+	client *redis.Client
+	now    func() time.Time
+}
+
+// NewRedisLimiter keeps token bucket of every client and bucket in a Redis hash,
+// so all replicas share the limit
+func NewRedisLimiter(client *redis.Client) Limiter {
+	return &redisLimiter{client: client, now: time.Now}
+}
+
+func (l *redisLimiter) Allow(ctx context.Context, bucket string, client string, limit Limit) (*Result, error) {
+	if limit.Requests <= 0 || limit.WindowSeconds <= 0 {
+		return &Result{Allowed: true}, nil
+	}
+	key := tenant.Key(ctx, keyPrefix+bucket+":"+client)
+	window := limit.Window().Milliseconds()
+	reply, err := tokenBucketScript.Run(ctx, l.client, []string{key},
+		limit.Requests, window, l.now().UnixNano()/int64(time.Millisecond)).Result()
+	if err != nil {
+		return nil, err
+	}
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 2 {
+		return nil, fmt.Errorf("unexpected rate limit reply %v", reply)
+	}
+	allowed, _ := values[0].(int64)
+	left, _ := values[1].(string)
+	tokens, err := strconv.ParseFloat(left, 64)
+	if err != nil {
+		return nil, err
+	}
+	return result(allowed == 1, tokens, limit), nil
+}
+
+// result derives remaining requests and waiting times from tokens left in bucket
+func result(allowed bool, tokens float64, limit Limit) *Result {
+	// time to refill one token
+	perToken := float64(limit.Window()) / float64(limit.Requests)
+	r := &Result{
+		Allowed:   allowed,
+		Limit:     limit.Requests,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration(math.Ceil((float64(limit.Requests) - tokens) * perToken)),
+	}
+	if !allowed {
+		r.RetryAfter = time.Duration(math.Ceil((1 - tokens) * perToken))
+	}
+	return r
+}
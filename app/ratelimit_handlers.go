@@ -0,0 +1,69 @@
+package app
+
+import (
+	"fmt"
+	"go-microservice-assignment/app/auth"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// rateLimitMiddleware counts requests of every client against limit of the
+// matched route and rejects requests over the limit with 429. Rate limiting
+// is disabled when no limiter is configured.
+func (a *app) rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if a.RateLimiter == nil || route == nil || openPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			template = r.URL.Path
+		}
+		limit, bucket := a.rateLimits.LimitFor(r.Method, template)
+		result, err := a.RateLimiter.Allow(r.Context(), bucket, a.rateLimitClient(r), limit)
+		if err != nil {
+			// outage of limiter must not make the service unavailable
+			log.Println("Error checking rate limit:", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if result.Limit > 0 {
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(seconds(result.Reset)))
+			w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Requests, limit.WindowSeconds))
+		}
+		if !result.Allowed {
+			retryAfter := seconds(result.RetryAfter)
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			problemResponse(w, r, http.StatusTooManyRequests, fmt.Sprintf("Rate limit exceeded, retry in %d seconds", retryAfter))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitClient identifies whose requests are counted together: API key,
+// authenticated subject or, for anonymous callers, client IP behind trusted proxies
+func (a *app) rateLimitClient(r *http.Request) string {
+	if principal := auth.FromContext(r.Context()); principal != nil {
+		if keyId, ok := principal.Claims["keyId"].(string); ok {
+			return "key:" + keyId
+		}
+		return "sub:" + principal.Subject
+	}
+	return "ip:" + a.clientIP(r)
+}
+
+// seconds rounds duration up to whole seconds, as used by rate limit headers
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,113 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"go-microservice-assignment/app/auth"
+	"go-microservice-assignment/app/models"
+	"go-microservice-assignment/app/ratelimit"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// in-memory ratelimit.Limiter counting requests without refill
+type limiterMock struct {
+	counts  map[string]int
+	clients []string
+	err     error
+}
+
+func (l *limiterMock) Allow(ctx context.Context, bucket string, client string, limit ratelimit.Limit) (*ratelimit.Result, error) {
+	if l.err != nil {
+		return nil, l.err
+	}
+	l.clients = append(l.clients, client)
+	key := bucket + ":" + client
+	l.counts[key]++
+	remaining := limit.Requests - l.counts[key]
+	result := &ratelimit.Result{Allowed: remaining >= 0, Limit: limit.Requests, Remaining: remaining, Reset: limit.Window()}
+	if !result.Allowed {
+		result.Remaining = 0
+		result.RetryAfter = 1500 * time.Millisecond
+	}
+	return result, nil
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	mockRedis := redisMock{}
+	mockRedis.On("GetPerson", mock.Anything, personId).Return(&models.Person{Id: personId}, nil)
+	limiter := &limiterMock{counts: map[string]int{}}
+	config := &ratelimit.Config{Default: ratelimit.Limit{Requests: 2, WindowSeconds: 60}}
+	app := New(&mockRedis, WithRateLimit(limiter, config))
+
+	get := func() *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "/api/v1/person/"+personId, nil)
+		request.RemoteAddr = "10.0.0.1:5555"
+		app.Router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	recorder := get()
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "2", recorder.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", recorder.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "60", recorder.Header().Get("RateLimit-Reset"))
+	assert.Equal(t, "2;w=60", recorder.Header().Get("RateLimit-Policy"))
+	assert.Equal(t, http.StatusOK, get().Code)
+
+	recorder = get()
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	assert.Equal(t, "2", recorder.Header().Get("Retry-After"))
+	assert.Equal(t, "0", recorder.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "application/problem+json", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, []string{"ip:10.0.0.1", "ip:10.0.0.1", "ip:10.0.0.1"}, limiter.clients)
+	mockRedis.AssertNumberOfCalls(t, "GetPerson", 2)
+
+	// probes are never limited
+	recorder = httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/health", nil)
+	app.Router.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Len(t, limiter.clients, 3)
+}
+
+func TestRateLimitMiddleware_LimiterError(t *testing.T) {
+	mockRedis := redisMock{}
+	mockRedis.On("GetPerson", mock.Anything, personId).Return(&models.Person{Id: personId}, nil)
+	limiter := &limiterMock{err: errors.New("connection refused")}
+	app := New(&mockRedis, WithRateLimit(limiter, ratelimit.DefaultConfig()))
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/api/v1/person/"+personId, nil)
+	app.Router.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Empty(t, recorder.Header().Get("RateLimit-Limit"))
+}
+
+func TestRateLimitClient(t *testing.T) {
+	app := New(nil, WithTrustedProxies(testProxies))
+	r, _ := http.NewRequest("GET", "/api/v1/person", nil)
+	r.RemoteAddr = "10.0.0.2:43512"
+	r.Header.Set("X-Forwarded-For", "203.0.113.9, 192.0.2.7, 10.0.0.1")
+	assert.Equal(t, "ip:192.0.2.7", app.rateLimitClient(r))
+
+	// spoofed header of client connecting directly is ignored
+	direct, _ := http.NewRequest("GET", "/api/v1/person", nil)
+	direct.RemoteAddr = "192.0.2.8:43512"
+	direct.Header.Set("X-Forwarded-For", "203.0.113.9")
+	assert.Equal(t, "ip:192.0.2.8", app.rateLimitClient(direct))
+
+	jwt := r.WithContext(auth.WithPrincipal(r.Context(), &auth.Principal{Subject: "jane"}))
+	assert.Equal(t, "sub:jane", app.rateLimitClient(jwt))
+
+	apiKey := r.WithContext(auth.WithPrincipal(r.Context(), &auth.Principal{Subject: "team-data",
+		Claims: map[string]interface{}{"keyId": "k1"}}))
+	assert.Equal(t, "key:k1", app.rateLimitClient(apiKey))
+}
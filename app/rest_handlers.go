@@ -3,10 +3,15 @@ package app
 import (
 	"encoding/json"
 	"fmt"
+	"go-microservice-assignment/app/archive"
+	"go-microservice-assignment/app/auth"
 	"go-microservice-assignment/app/models"
+	"go-microservice-assignment/app/projection"
+	"go-microservice-assignment/app/storage"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
@@ -30,6 +35,13 @@ func (a *app) ReadinessHandler() http.HandlerFunc {
 	}
 }
 
+// CacheStatsHandler returns counters of in-process person cache
+func (a *app) CacheStatsHandler(cache *storage.CachedDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse(w, http.StatusOK, cache.Stats())
+	}
+}
+
 func (a *app) CreatePersonHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		body, err := ioutil.ReadAll(r.Body)
@@ -45,6 +57,10 @@ func (a *app) CreatePersonHandler() http.HandlerFunc {
 			badRequest(w, "Invalid request")
 			return
 		}
+		if err = person.NormalizeAddress(); err != nil {
+			badRequest(w, err.Error())
+			return
+		}
 
 		key := uuid.New().String()
 		person.Id = key
@@ -56,7 +72,7 @@ func (a *app) CreatePersonHandler() http.HandlerFunc {
 			return
 		}
 
-		createdResponse(w, &person)
+		a.createdResponse(w, r, &person)
 	}
 }
 
@@ -69,16 +85,147 @@ func (a *app) GetPersonHandler() http.HandlerFunc {
 			badRequest(w, "ID parameter is missing")
 			return
 		}
+		if _, err := fieldsParameter(r); err != nil {
+			badRequest(w, err.Error())
+			return
+		}
+		if asOf := r.URL.Query().Get("asOf"); asOf != "" {
+			a.personAsOfResponse(w, r, id, asOf)
+			return
+		}
 		person, err := a.DB.GetPerson(r.Context(), id)
 		if err != nil {
 			if err.Error() == "redis: nil" {
-				notFoundResponse(w)
+				a.archivedPersonResponse(w, r, id)
 			} else {
 				serverError(w)
 			}
 			return
 		}
-		okResponse(w, person)
+		if person.DeletedAt != nil && !includeDeleted(r) {
+			notFoundResponse(w)
+			return
+		}
+		if a.readRefreshesExpiry {
+			// read counts as activity, failure to refresh does not fail the read
+			if err = a.DB.TouchPerson(r.Context(), id); err != nil {
+				log.Println("Error refreshing person expiry:", err)
+			}
+		}
+		a.okResponse(w, r, person)
+	}
+}
+
+// personAsOfResponse returns version of person valid at time given in RFC3339 format
+func (a *app) personAsOfResponse(w http.ResponseWriter, r *http.Request, id string, asOf string) {
+	t, err := time.Parse(time.RFC3339, asOf)
+	if err != nil {
+		badRequest(w, "Invalid asOf timestamp, expected RFC3339 format")
+		return
+	}
+	if a.rejectDeleted(w, r, id) {
+		return
+	}
+	person, err := a.DB.GetPersonAsOf(r.Context(), id, t)
+	if err != nil {
+		if err == storage.ErrVersionNotFound || err.Error() == "redis: nil" {
+			notFoundResponse(w)
+		} else {
+			log.Println("Error reading person version:", err)
+			serverError(w)
+		}
+		return
+	}
+	a.okResponse(w, r, person)
+}
+
+// archivedPersonResponse returns archived copy of person when read fallback
+// is enabled, otherwise (or when person is not archived) it responds with 404
+func (a *app) archivedPersonResponse(w http.ResponseWriter, r *http.Request, id string) {
+	if a.Archive == nil || !a.archiveFallback {
+		notFoundResponse(w)
+		return
+	}
+	person, err := a.Archive.Get(r.Context(), id)
+	if err == archive.ErrNotFound {
+		notFoundResponse(w)
+		return
+	}
+	if err != nil {
+		log.Println("Error reading person from archive:", err)
+		serverError(w)
+		return
+	}
+	w.Header().Set("X-Person-Archived", "true")
+	a.okResponse(w, r, person)
+}
+
+func (a *app) RestorePersonHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.Archive == nil {
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+		id, ok := mux.Vars(r)["id"]
+		if !ok {
+			log.Println("ID parameter is missing")
+			badRequest(w, "ID parameter is missing")
+			return
+		}
+
+		person, err := a.DB.RestorePerson(r.Context(), id, func(id string) (*models.Person, error) {
+			return a.Archive.Get(r.Context(), id)
+		})
+		if err == archive.ErrNotFound {
+			notFoundResponse(w)
+			return
+		}
+		if err == storage.ErrPersonExists {
+			conflictResponse(w, "Person is not archived")
+			return
+		}
+		if err != nil {
+			log.Println("Error restoring person from archive:", err)
+			serverError(w)
+			return
+		}
+		a.okResponse(w, r, person)
+	}
+}
+
+func (a *app) DeletePersonHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := a.DB.DeletePerson(r.Context(), mux.Vars(r)["id"])
+		if err == storage.ErrPersonDeleted || (err != nil && err.Error() == "redis: nil") {
+			notFoundResponse(w)
+			return
+		}
+		if err != nil {
+			log.Println("Error deleting person:", err)
+			serverError(w)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (a *app) UndeletePersonHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		person, err := a.DB.UndeletePerson(r.Context(), mux.Vars(r)["id"])
+		if err == storage.ErrPersonNotDeleted {
+			conflictResponse(w, "Person is not deleted")
+			return
+		}
+		if err != nil && err.Error() == "redis: nil" {
+			notFoundResponse(w)
+			return
+		}
+		if err != nil {
+			log.Println("Error undeleting person:", err)
+			serverError(w)
+			return
+		}
+		a.okResponse(w, r, person)
 	}
 }
 
@@ -106,14 +253,26 @@ func (a *app) UpdatePersonOptimisticHandler() http.HandlerFunc {
 			badRequest(w, msg)
 			return
 		}
+		if !models.ValidId(person.Id) {
+			badRequest(w, "Invalid person ID")
+			return
+		}
+		if err = person.NormalizeAddress(); err != nil {
+			badRequest(w, err.Error())
+			return
+		}
 
 		modifiedPerson, err := a.DB.UpdatePersonOptimistic(r.Context(), &person)
+		if err == storage.ErrPersonDeleted {
+			notFoundResponse(w)
+			return
+		}
 		if err != nil {
 			log.Println("Error while calling UpdatePersonOptimistic", err)
 			serverError(w)
 			return
 		}
-		okResponse(w, modifiedPerson)
+		a.okResponse(w, r, modifiedPerson)
 	}
 }
 
@@ -141,14 +300,188 @@ func (a *app) UpdatePersonPessimisticHandler() http.HandlerFunc {
 			badRequest(w, msg)
 			return
 		}
+		if !models.ValidId(person.Id) {
+			badRequest(w, "Invalid person ID")
+			return
+		}
+		if err = person.NormalizeAddress(); err != nil {
+			badRequest(w, err.Error())
+			return
+		}
 
 		modifiedPerson, err := a.DB.UpdatePersonPessimistic(r.Context(), &person)
+		if err == storage.ErrPersonDeleted {
+			notFoundResponse(w)
+			return
+		}
 		if err != nil {
 			log.Println("Error while calling UpdatePersonOptimistic", err)
 			serverError(w)
 			return
 		}
-		okResponse(w, modifiedPerson)
+		a.okResponse(w, r, modifiedPerson)
+	}
+}
+
+func (a *app) PersonHistoryHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		history, err := a.DB.GetHistory(r.Context(), mux.Vars(r)["id"])
+		if err != nil {
+			log.Println("Error reading person history:", err)
+			serverError(w)
+			return
+		}
+		restrictions := a.restrictions(r)
+		versions := make([]personVersion, len(history))
+		for i := range history {
+			person, err := projection.Apply(&history[i].Person, restrictions, nil)
+			if err != nil {
+				log.Println("Error projecting person:", err)
+				serverError(w)
+				return
+			}
+			versions[i] = personVersion{Version: history[i].Version, ValidFrom: history[i].ValidFrom, ValidTo: history[i].ValidTo, Person: person}
+		}
+		jsonResponse(w, http.StatusOK, versions)
+	}
+}
+
+// personVersion is storage.PersonVersion with person projected for the caller
+type personVersion struct {
+	Version   int         `json:"version"`
+	ValidFrom time.Time   `json:"validFrom"`
+	ValidTo   time.Time   `json:"validTo"`
+	Person    interface{} `json:"person"`
+}
+
+type revertRequest struct {
+	Version int `json:"version"`
+}
+
+func (a *app) RevertPersonHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			log.Println("Error processing body request:", err)
+			badRequest(w, "Invalid request")
+			return
+		}
+		var request revertRequest
+		if err = json.Unmarshal(body, &request); err != nil || request.Version <= 0 {
+			badRequest(w, "Invalid request")
+			return
+		}
+
+		person, err := a.DB.RevertPerson(r.Context(), mux.Vars(r)["id"], request.Version)
+		if err != nil {
+			if err == storage.ErrVersionNotFound || err == storage.ErrPersonDeleted || err.Error() == "redis: nil" {
+				notFoundResponse(w)
+			} else {
+				log.Println("Error reverting person:", err)
+				serverError(w)
+			}
+			return
+		}
+		a.okResponse(w, r, person)
+	}
+}
+
+func (a *app) TouchPersonHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := mux.Vars(r)["id"]
+		if !ok {
+			log.Println("ID parameter is missing")
+			badRequest(w, "ID parameter is missing")
+			return
+		}
+		err := a.DB.TouchPerson(r.Context(), id)
+		if err != nil {
+			if err.Error() == "redis: nil" {
+				notFoundResponse(w)
+			} else {
+				log.Println("Error touching person:", err)
+				serverError(w)
+			}
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type personTTL struct {
+	Id               string     `json:"id"`
+	ExpiresInSeconds int64      `json:"expiresInSeconds"`
+	ExpiresAt        *time.Time `json:"expiresAt,omitempty"`
+}
+
+func (a *app) PersonTTLHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := mux.Vars(r)["id"]
+		if !ok {
+			log.Println("ID parameter is missing")
+			badRequest(w, "ID parameter is missing")
+			return
+		}
+		ttl, err := a.DB.GetPersonTTL(r.Context(), id)
+		if err != nil {
+			if err.Error() == "redis: nil" {
+				notFoundResponse(w)
+			} else {
+				log.Println("Error reading person TTL:", err)
+				serverError(w)
+			}
+			return
+		}
+		// person under legal hold never expires
+		if ttl < 0 {
+			jsonResponse(w, http.StatusOK, &personTTL{Id: id, ExpiresInSeconds: -1})
+			return
+		}
+		expiresAt := time.Now().Add(ttl).UTC()
+		jsonResponse(w, http.StatusOK, &personTTL{
+			Id:               id,
+			ExpiresInSeconds: int64(ttl.Seconds()),
+			ExpiresAt:        &expiresAt,
+		})
+	}
+}
+
+// includeDeleted tells whether soft deleted persons are requested with ?includeDeleted=true
+func includeDeleted(r *http.Request) bool {
+	return r.URL.Query().Get("includeDeleted") == "true"
+}
+
+// rejectDeleted responds with 404 and returns true when person is soft deleted
+// and ?includeDeleted=true is not given. Persons that are not stored are left
+// to the caller, which tells them from archived ones.
+func (a *app) rejectDeleted(w http.ResponseWriter, r *http.Request, id string) bool {
+	if includeDeleted(r) {
+		return false
+	}
+	person, err := a.DB.GetPerson(r.Context(), id)
+	if isNotFound(err) {
+		return false
+	}
+	if err != nil {
+		log.Println("Error reading person:", err)
+		serverError(w)
+		return true
+	}
+	if person.DeletedAt != nil {
+		notFoundResponse(w)
+		return true
+	}
+	return false
+}
+
+// notDeleted hides soft deleted person from per-person route like
+// GetPersonHandler does, unless ?includeDeleted=true is given
+func (a *app) notDeleted(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.rejectDeleted(w, r, mux.Vars(r)["id"]) {
+			return
+		}
+		next(w, r)
 	}
 }
 
@@ -161,21 +494,62 @@ func badRequest(w http.ResponseWriter, message string) {
 	w.Write([]byte(message))
 }
 
+func conflictResponse(w http.ResponseWriter, message string) {
+	w.WriteHeader(http.StatusConflict)
+	w.Write([]byte(message))
+}
+
 func notFoundResponse(w http.ResponseWriter) {
 	w.WriteHeader(http.StatusNotFound)
 	w.Write([]byte("Not found"))
 }
 
-func createdResponse(w http.ResponseWriter, person *models.Person) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	res, _ := json.Marshal(person)
-	w.Write(res)
+func (a *app) createdResponse(w http.ResponseWriter, r *http.Request, person *models.Person) {
+	a.personResponse(w, r, http.StatusCreated, person)
+}
+
+func (a *app) okResponse(w http.ResponseWriter, r *http.Request, person *models.Person) {
+	a.personResponse(w, r, http.StatusOK, person)
+}
+
+// personResponse writes person with fields masked or hidden according to scopes of caller
+func (a *app) personResponse(w http.ResponseWriter, r *http.Request, statusCode int, person *models.Person) {
+	fields, err := fieldsParameter(r)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	projected, err := projection.Apply(person, a.restrictions(r), fields)
+	if err != nil {
+		log.Println("Error projecting person:", err)
+		serverError(w)
+		return
+	}
+	jsonResponse(w, statusCode, projected)
+}
+
+// fieldsParameter returns fields selected by ?fields of GET request, nil
+// selects all fields. Other requests always return all fields, so a bad
+// parameter cannot fail them after they took effect.
+func fieldsParameter(r *http.Request) (projection.Fieldset, error) {
+	if r.Method != http.MethodGet {
+		return nil, nil
+	}
+	fields, err := projection.ParseFields(r.URL.Query().Get("fields"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid parameter fields: %v", err)
+	}
+	return fields, nil
+}
+
+// restrictions returns fields caller of request does not see in full
+func (a *app) restrictions(r *http.Request) map[string]projection.Mode {
+	return a.projection.Restrictions(auth.FromContext(r.Context()))
 }
 
-func okResponse(w http.ResponseWriter, person *models.Person) {
+func jsonResponse(w http.ResponseWriter, statusCode int, v interface{}) {
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	res, _ := json.Marshal(person)
+	w.WriteHeader(statusCode)
+	res, _ := json.Marshal(v)
 	w.Write(res)
 }
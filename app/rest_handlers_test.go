@@ -5,15 +5,19 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"go-microservice-assignment/app/archive"
 	"go-microservice-assignment/app/models"
+	"go-microservice-assignment/app/storage"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
 )
 
-const personId = "123"
+const personId = "9b3c2d8e-5f1a-4e7b-8c6d-0a1b2c3d4e5f"
 
 // mock for http.ResponseWriter
 type rwMock struct {
@@ -59,6 +63,145 @@ func (redis *redisMock) UpdatePersonPessimistic(ctx context.Context, p *models.P
 	return args.Get(0).(*models.Person), args.Error(1)
 }
 
+func (redis *redisMock) ExpirePerson(ctx context.Context, id string, archive func(p *models.Person) error) (bool, error) {
+	args := redis.Called(ctx, id, archive)
+	return args.Bool(0), args.Error(1)
+}
+
+func (redis *redisMock) ScanPersonIds(ctx context.Context, cursor uint64, count int64) ([]string, uint64, error) {
+	args := redis.Called(ctx, cursor, count)
+	return args.Get(0).([]string), args.Get(1).(uint64), args.Error(2)
+}
+
+func (redis *redisMock) RestorePerson(ctx context.Context, id string, load func(id string) (*models.Person, error)) (*models.Person, error) {
+	args := redis.Called(ctx, id, load)
+	return args.Get(0).(*models.Person), args.Error(1)
+}
+
+func (redis *redisMock) TouchPerson(ctx context.Context, id string) error {
+	args := redis.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (redis *redisMock) GetPersonTTL(ctx context.Context, id string) (time.Duration, error) {
+	args := redis.Called(ctx, id)
+	return args.Get(0).(time.Duration), args.Error(1)
+}
+
+func (redis *redisMock) GetRetention(ctx context.Context, id string) (*storage.RetentionPolicy, error) {
+	args := redis.Called(ctx, id)
+	return args.Get(0).(*storage.RetentionPolicy), args.Error(1)
+}
+
+func (redis *redisMock) SetRetention(ctx context.Context, id string, policy *storage.RetentionPolicy, actor string, reason string) error {
+	args := redis.Called(ctx, id, policy, actor, reason)
+	return args.Error(0)
+}
+
+func (redis *redisMock) ListRetentionAudit(ctx context.Context, id string) ([]storage.RetentionAudit, error) {
+	args := redis.Called(ctx, id)
+	return args.Get(0).([]storage.RetentionAudit), args.Error(1)
+}
+
+func (redis *redisMock) GetHistory(ctx context.Context, id string) ([]storage.PersonVersion, error) {
+	args := redis.Called(ctx, id)
+	return args.Get(0).([]storage.PersonVersion), args.Error(1)
+}
+
+func (redis *redisMock) GetPersonAsOf(ctx context.Context, id string, asOf time.Time) (*models.Person, error) {
+	args := redis.Called(ctx, id, asOf)
+	return args.Get(0).(*models.Person), args.Error(1)
+}
+
+func (redis *redisMock) RevertPerson(ctx context.Context, id string, version int) (*models.Person, error) {
+	args := redis.Called(ctx, id, version)
+	return args.Get(0).(*models.Person), args.Error(1)
+}
+
+func (redis *redisMock) ListPersons(ctx context.Context) ([]*models.Person, error) {
+	args := redis.Called(ctx)
+	return args.Get(0).([]*models.Person), args.Error(1)
+}
+
+func (redis *redisMock) ScanPersons(ctx context.Context, cursor uint64, count int64) ([]*models.Person, uint64, error) {
+	args := redis.Called(ctx, cursor, count)
+	return args.Get(0).([]*models.Person), args.Get(1).(uint64), args.Error(2)
+}
+
+func (redis *redisMock) DeletePerson(ctx context.Context, id string) error {
+	args := redis.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (redis *redisMock) UndeletePerson(ctx context.Context, id string) (*models.Person, error) {
+	args := redis.Called(ctx, id)
+	return args.Get(0).(*models.Person), args.Error(1)
+}
+
+func (redis *redisMock) PurgePerson(ctx context.Context, id string, gracePeriod time.Duration) (bool, error) {
+	args := redis.Called(ctx, id, gracePeriod)
+	return args.Bool(0), args.Error(1)
+}
+
+func (redis *redisMock) ExecuteBatch(ctx context.Context, ops []storage.BatchOperation, atomic bool) ([]storage.BatchResult, error) {
+	args := redis.Called(ctx, ops, atomic)
+	return args.Get(0).([]storage.BatchResult), args.Error(1)
+}
+
+func (redis *redisMock) ListTenants(ctx context.Context) ([]string, error) {
+	args := redis.Called(ctx)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (redis *redisMock) FindPersons(ctx context.Context, field string, value string) ([]*models.Person, error) {
+	args := redis.Called(ctx, field, value)
+	return args.Get(0).([]*models.Person), args.Error(1)
+}
+
+func (redis *redisMock) ReencryptPerson(ctx context.Context, id string) (bool, error) {
+	args := redis.Called(ctx, id)
+	return args.Bool(0), args.Error(1)
+}
+
+func (redis *redisMock) ListPersonsPage(ctx context.Context, query storage.PageQuery) ([]*models.Person, int, error) {
+	args := redis.Called(ctx, query)
+	return args.Get(0).([]*models.Person), args.Int(1), args.Error(2)
+}
+
+func (redis *redisMock) BackfillOrder(ctx context.Context, id string) (bool, error) {
+	args := redis.Called(ctx, id)
+	return args.Bool(0), args.Error(1)
+}
+
+func (redis *redisMock) MigrateAddress(ctx context.Context, id string, defaultCountry string) (bool, error) {
+	args := redis.Called(ctx, id, defaultCountry)
+	return args.Bool(0), args.Error(1)
+}
+
+func (redis *redisMock) ErasePerson(ctx context.Context, id string) (*storage.Erasure, error) {
+	args := redis.Called(ctx, id)
+	return args.Get(0).(*storage.Erasure), args.Error(1)
+}
+
+// mock for archive.Sink
+type archiveMock struct {
+	mock.Mock
+}
+
+func (a *archiveMock) Put(ctx context.Context, p *models.Person) error {
+	args := a.Called(ctx, p)
+	return args.Error(0)
+}
+
+func (a *archiveMock) Get(ctx context.Context, id string) (*models.Person, error) {
+	args := a.Called(ctx, id)
+	return args.Get(0).(*models.Person), args.Error(1)
+}
+
+func (a *archiveMock) Delete(ctx context.Context, id string) error {
+	args := a.Called(ctx, id)
+	return args.Error(0)
+}
 
 func TestIndexHandler(t *testing.T) {
 	mockResponseWriter := rwMock{}
@@ -123,6 +266,26 @@ func TestGetPersonHandler_OkResponse(t *testing.T) {
 	mockResponseWriter.AssertExpectations(t)
 }
 
+func TestGetPersonHandler_Fields(t *testing.T) {
+	mockRedis := redisMock{}
+	mockRedis.On("GetPerson", mock.Anything, personId).Return(&models.Person{Id: personId, Name: "Test123", Address: "Berlin 123"}, nil)
+	app := New(&mockRedis)
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/api/v1/person/"+personId+"?fields=id,name", nil)
+	app.Router.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"id":"`+personId+`","name":"Test123"}`, recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "/api/v1/person/"+personId+"?fields=id,password", nil)
+	app.Router.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `unknown field "password"`)
+}
+
 func TestGetPersonHandler_MissingId(t *testing.T) {
 	mockResponseWriter := rwMock{}
 	mockResponseWriter.On("WriteHeader", http.StatusBadRequest)
@@ -190,10 +353,10 @@ func TestCreatePersonHandler_OkResponse(t *testing.T) {
 	}
 	request, _ := json.Marshal(&dummyPerson)
 	body := strings.NewReader(string(request))
-	testRequest,_ := http.NewRequest("POST", "/api/v1/person/123", body)
+	testRequest,_ := http.NewRequest("POST", "/api/v1/person/"+personId, body)
 
 	mockRedis := redisMock{}
-	mockRedis.On("CreatePerson", context.TODO(), mock.AnythingOfType("*models.Person")).Return(nil)
+	mockRedis.On("CreatePerson", mock.Anything, mock.AnythingOfType("*models.Person")).Return(nil)
 
 	app := New(&mockRedis)
 	handler := app.CreatePersonHandler()
@@ -208,13 +371,37 @@ func TestCreatePersonHandler_OkResponse(t *testing.T) {
 	mockResponseWriter.AssertExpectations(t)
 }
 
+func TestCreatePersonHandler_PostalAddress(t *testing.T) {
+	mockRedis := redisMock{}
+	mockRedis.On("CreatePerson", mock.Anything, mock.AnythingOfType("*models.Person")).Return(nil)
+	app := New(&mockRedis)
+
+	recorder := httptest.NewRecorder()
+	body := strings.NewReader(`{"name":"Test123","postalAddress":{"street":"Unter den Linden","houseNumber":"77","postalCode":"10117","city":"Berlin","country":"de"}}`)
+	testRequest, _ := http.NewRequest("POST", "/api/v1/person", body)
+	app.CreatePersonHandler().ServeHTTP(recorder, testRequest)
+
+	assert.Equal(t, http.StatusCreated, recorder.Code)
+	created := mockRedis.Calls[0].Arguments.Get(1).(*models.Person)
+	assert.Equal(t, "Unter den Linden 77, 10117 Berlin, DE", created.Address)
+
+	recorder = httptest.NewRecorder()
+	body = strings.NewReader(`{"name":"Test123","postalAddress":{"street":"Unter den Linden","postalCode":"1011","city":"Berlin","country":"DE"}}`)
+	testRequest, _ = http.NewRequest("POST", "/api/v1/person", body)
+	app.CreatePersonHandler().ServeHTTP(recorder, testRequest)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Equal(t, "invalid postalAddress.postalCode for country DE", recorder.Body.String())
+	mockRedis.AssertNumberOfCalls(t, "CreatePerson", 1)
+}
+
 func TestCreatePersonHandler_BodyInvalidJson(t *testing.T) {
 	mockResponseWriter := rwMock{}
 	mockResponseWriter.On("WriteHeader", http.StatusBadRequest)
 	mockResponseWriter.On("Write", mock.Anything).Return(1, nil)
 
 	body := strings.NewReader("{\"wrong\":\"body\"")
-	testRequest,_ := http.NewRequest("POST", "/api/v1/person/123", body)
+	testRequest,_ := http.NewRequest("POST", "/api/v1/person/"+personId, body)
 
 	app := New(nil)
 	handler := app.CreatePersonHandler()
@@ -237,10 +424,10 @@ func TestCreatePersonHandler_CreatePerson_ServerError(t *testing.T) {
 	}
 	request, _ := json.Marshal(&dummyPerson)
 	body := strings.NewReader(string(request))
-	testRequest,_ := http.NewRequest("POST", "/api/v1/person/123", body)
+	testRequest,_ := http.NewRequest("POST", "/api/v1/person/"+personId, body)
 
 	mockRedis := redisMock{}
-	mockRedis.On("CreatePerson", context.TODO(), mock.AnythingOfType("*models.Person")).Return(errors.New("server error"))
+	mockRedis.On("CreatePerson", mock.Anything, mock.AnythingOfType("*models.Person")).Return(errors.New("server error"))
 
 	app := New(&mockRedis)
 	handler := app.CreatePersonHandler()
@@ -260,16 +447,16 @@ func TestUpdatePersonOptimisticHandler_OkResponse(t *testing.T) {
 	mockResponseWriter.On("Write", mock.Anything).Return(1, nil)
 
 	dummyPerson := models.Person{
-		Id: "testId",
+		Id: personId,
 		Name: "Test123",
 		Address: "Berlin 123",
 	}
 	request, _ := json.Marshal(&dummyPerson)
 	body := strings.NewReader(string(request))
-	testRequest,_ := http.NewRequest("PATCH", "/api/v1/person/123", body)
+	testRequest,_ := http.NewRequest("PATCH", "/api/v1/person/"+personId, body)
 
 	mockRedis := redisMock{}
-	mockRedis.On("UpdatePersonOptimistic", context.TODO(), mock.AnythingOfType("*models.Person")).Return(&dummyPerson, nil)
+	mockRedis.On("UpdatePersonOptimistic", mock.Anything, mock.AnythingOfType("*models.Person")).Return(&dummyPerson, nil)
 
 	app := New(&mockRedis)
 	handler := app.UpdatePersonOptimisticHandler()
@@ -290,7 +477,7 @@ func TestUpdatePersonOptimisticHandler_BodyInvalidJson(t *testing.T) {
 	mockResponseWriter.On("Write", mock.Anything).Return(1, nil)
 
 	body := strings.NewReader("{\"wrong\":\"body\"")
-	testRequest,_ := http.NewRequest("PATCH", "/api/v1/person/123", body)
+	testRequest,_ := http.NewRequest("PATCH", "/api/v1/person/"+personId, body)
 
 	app := New(nil)
 	handler := app.UpdatePersonOptimisticHandler()
@@ -313,7 +500,7 @@ func TestUpdatePersonOptimisticHandler_MissingPersonId(t *testing.T) {
 	}
 	request, _ := json.Marshal(&dummyPerson)
 	body := strings.NewReader(string(request))
-	testRequest,_ := http.NewRequest("PATCH", "/api/v1/person/123", body)
+	testRequest,_ := http.NewRequest("PATCH", "/api/v1/person/"+personId, body)
 
 	app := New(nil)
 	handler := app.UpdatePersonOptimisticHandler()
@@ -329,16 +516,16 @@ func TestUpdatePersonOptimisticHandler_UpdatePersonOptimistic_ServerError(t *tes
 	mockResponseWriter.On("WriteHeader", http.StatusInternalServerError)
 
 	dummyPerson := models.Person{
-		Id: "testId",
+		Id: personId,
 		Name: "Test123",
 		Address: "Berlin 123",
 	}
 	request, _ := json.Marshal(&dummyPerson)
 	body := strings.NewReader(string(request))
-	testRequest,_ := http.NewRequest("PATCH", "/api/v1/person/123", body)
+	testRequest,_ := http.NewRequest("PATCH", "/api/v1/person/"+personId, body)
 
 	mockRedis := redisMock{}
-	mockRedis.On("UpdatePersonOptimistic", context.TODO(), mock.AnythingOfType("*models.Person")).Return(&dummyPerson, errors.New("server error"))
+	mockRedis.On("UpdatePersonOptimistic", mock.Anything, mock.AnythingOfType("*models.Person")).Return(&dummyPerson, errors.New("server error"))
 
 	app := New(&mockRedis)
 	handler := app.UpdatePersonOptimisticHandler()
@@ -358,16 +545,16 @@ func TestUpdatePersonPessimisticHandler_OkResponse(t *testing.T) {
 	mockResponseWriter.On("Write", mock.Anything).Return(1, nil)
 
 	dummyPerson := models.Person{
-		Id: "testId",
+		Id: personId,
 		Name: "Test123",
 		Address: "Berlin 123",
 	}
 	request, _ := json.Marshal(&dummyPerson)
 	body := strings.NewReader(string(request))
-	testRequest,_ := http.NewRequest("PATCH", "/api/v1/person/123", body)
+	testRequest,_ := http.NewRequest("PATCH", "/api/v1/person/"+personId, body)
 
 	mockRedis := redisMock{}
-	mockRedis.On("UpdatePersonPessimistic", context.TODO(), mock.AnythingOfType("*models.Person")).Return(&dummyPerson, nil)
+	mockRedis.On("UpdatePersonPessimistic", mock.Anything, mock.AnythingOfType("*models.Person")).Return(&dummyPerson, nil)
 
 	app := New(&mockRedis)
 	handler := app.UpdatePersonPessimisticHandler()
@@ -388,7 +575,7 @@ func TestUpdatePersonPessimisticHandler_BodyInvalidJson(t *testing.T) {
 	mockResponseWriter.On("Write", mock.Anything).Return(1, nil)
 
 	body := strings.NewReader("{\"wrong\":\"body\"")
-	testRequest,_ := http.NewRequest("PATCH", "/api/v1/person/123", body)
+	testRequest,_ := http.NewRequest("PATCH", "/api/v1/person/"+personId, body)
 
 	app := New(nil)
 	handler := app.UpdatePersonPessimisticHandler()
@@ -411,7 +598,7 @@ func TestUpdatePersonPessimisticHandler_MissingPersonId(t *testing.T) {
 	}
 	request, _ := json.Marshal(&dummyPerson)
 	body := strings.NewReader(string(request))
-	testRequest,_ := http.NewRequest("PATCH", "/api/v1/person/123", body)
+	testRequest,_ := http.NewRequest("PATCH", "/api/v1/person/"+personId, body)
 
 	app := New(nil)
 	handler := app.UpdatePersonPessimisticHandler()
@@ -427,16 +614,16 @@ func TestUpdatePersonPessimisticHandler_UpdatePersonPessimistic_ServerError(t *t
 	mockResponseWriter.On("WriteHeader", http.StatusInternalServerError)
 
 	dummyPerson := models.Person{
-		Id: "testId",
+		Id: personId,
 		Name: "Test123",
 		Address: "Berlin 123",
 	}
 	request, _ := json.Marshal(&dummyPerson)
 	body := strings.NewReader(string(request))
-	testRequest,_ := http.NewRequest("PATCH", "/api/v1/person/123", body)
+	testRequest,_ := http.NewRequest("PATCH", "/api/v1/person/"+personId, body)
 
 	mockRedis := redisMock{}
-	mockRedis.On("UpdatePersonPessimistic", context.TODO(), mock.AnythingOfType("*models.Person")).Return(&dummyPerson, errors.New("server error"))
+	mockRedis.On("UpdatePersonPessimistic", mock.Anything, mock.AnythingOfType("*models.Person")).Return(&dummyPerson, errors.New("server error"))
 
 	app := New(&mockRedis)
 	handler := app.UpdatePersonPessimisticHandler()
@@ -449,6 +636,379 @@ func TestUpdatePersonPessimisticHandler_UpdatePersonPessimistic_ServerError(t *t
 	mockResponseWriter.AssertExpectations(t)
 }
 
+func TestGetPersonHandler_ArchiveFallback(t *testing.T) {
+	mockResponseWriter := rwMock{}
+	mockResponseWriter.On("Header").Return(http.Header{})
+	mockResponseWriter.On("WriteHeader", http.StatusOK)
+	mockResponseWriter.On("Write", mock.Anything).Return(1, nil)
+
+	mockRedis := redisMock{}
+	mockRedis.On("GetPerson", mock.Anything, personId).Return(&models.Person{}, errors.New("redis: nil"))
+	mockArchive := archiveMock{}
+	mockArchive.On("Get", mock.Anything, personId).Return(&models.Person{Id: personId, Name: "Test123"}, nil)
+
+	app := New(&mockRedis, WithArchive(&mockArchive, true))
+	handler := app.GetPersonHandler()
+	handler.ServeHTTP(&mockResponseWriter, createTestGetRequest(false))
+
+	mockArchive.AssertNumberOfCalls(t, "Get", 1)
+	// archived header and content type
+	mockResponseWriter.AssertNumberOfCalls(t, "Header", 2)
+	mockResponseWriter.AssertNumberOfCalls(t, "WriteHeader", 1)
+	mockResponseWriter.AssertExpectations(t)
+}
+
+func TestGetPersonHandler_ArchiveFallbackDisabled(t *testing.T) {
+	mockResponseWriter := rwMock{}
+	mockResponseWriter.On("WriteHeader", http.StatusNotFound)
+	mockResponseWriter.On("Write", mock.Anything).Return(1, nil)
+
+	mockRedis := redisMock{}
+	mockRedis.On("GetPerson", mock.Anything, personId).Return(&models.Person{}, errors.New("redis: nil"))
+	mockArchive := archiveMock{}
+
+	app := New(&mockRedis, WithArchive(&mockArchive, false))
+	handler := app.GetPersonHandler()
+	handler.ServeHTTP(&mockResponseWriter, createTestGetRequest(false))
+
+	mockArchive.AssertNotCalled(t, "Get", mock.Anything, mock.Anything)
+	mockResponseWriter.AssertExpectations(t)
+}
+
+func TestRestorePersonHandler_OkResponse(t *testing.T) {
+	mockResponseWriter := rwMock{}
+	mockResponseWriter.On("Header").Return(http.Header{})
+	mockResponseWriter.On("WriteHeader", http.StatusOK)
+	mockResponseWriter.On("Write", mock.Anything).Return(1, nil)
+
+	mockRedis := redisMock{}
+	mockRedis.On("RestorePerson", mock.Anything, personId, mock.Anything).Return(&models.Person{Id: personId}, nil)
+
+	app := New(&mockRedis, WithArchive(&archiveMock{}, false))
+	handler := app.RestorePersonHandler()
+	handler.ServeHTTP(&mockResponseWriter, createTestGetRequest(false))
+
+	mockRedis.AssertNumberOfCalls(t, "RestorePerson", 1)
+	mockResponseWriter.AssertExpectations(t)
+}
+
+func TestRestorePersonHandler_NotArchived(t *testing.T) {
+	mockResponseWriter := rwMock{}
+	mockResponseWriter.On("WriteHeader", http.StatusNotFound)
+	mockResponseWriter.On("Write", mock.Anything).Return(1, nil)
+
+	mockRedis := redisMock{}
+	mockRedis.On("RestorePerson", mock.Anything, personId, mock.Anything).Return((*models.Person)(nil), archive.ErrNotFound)
+
+	app := New(&mockRedis, WithArchive(&archiveMock{}, false))
+	handler := app.RestorePersonHandler()
+	handler.ServeHTTP(&mockResponseWriter, createTestGetRequest(false))
+
+	mockResponseWriter.AssertExpectations(t)
+}
+
+func TestRestorePersonHandler_PersonExists(t *testing.T) {
+	mockResponseWriter := rwMock{}
+	mockResponseWriter.On("WriteHeader", http.StatusConflict)
+	mockResponseWriter.On("Write", mock.Anything).Return(1, nil)
+
+	mockRedis := redisMock{}
+	mockRedis.On("RestorePerson", mock.Anything, personId, mock.Anything).Return((*models.Person)(nil), storage.ErrPersonExists)
+
+	app := New(&mockRedis, WithArchive(&archiveMock{}, false))
+	handler := app.RestorePersonHandler()
+	handler.ServeHTTP(&mockResponseWriter, createTestGetRequest(false))
+
+	mockResponseWriter.AssertExpectations(t)
+}
+
+func TestGetPersonHandler_ReadRefreshesExpiry(t *testing.T) {
+	mockResponseWriter := rwMock{}
+	mockResponseWriter.On("Header").Return(http.Header{})
+	mockResponseWriter.On("WriteHeader", http.StatusOK)
+	mockResponseWriter.On("Write", mock.Anything).Return(1, nil)
+
+	mockRedis := redisMock{}
+	mockRedis.On("GetPerson", mock.Anything, personId).Return(&models.Person{Id: personId}, nil)
+	mockRedis.On("TouchPerson", mock.Anything, personId).Return(nil)
+
+	app := New(&mockRedis, WithReadRefreshesExpiry(true))
+	handler := app.GetPersonHandler()
+	handler.ServeHTTP(&mockResponseWriter, createTestGetRequest(false))
+
+	mockRedis.AssertNumberOfCalls(t, "TouchPerson", 1)
+	mockRedis.AssertExpectations(t)
+	mockResponseWriter.AssertExpectations(t)
+}
+
+func TestTouchPersonHandler_OkResponse(t *testing.T) {
+	mockResponseWriter := rwMock{}
+	mockResponseWriter.On("WriteHeader", http.StatusNoContent)
+
+	mockRedis := redisMock{}
+	mockRedis.On("TouchPerson", mock.Anything, personId).Return(nil)
+
+	app := New(&mockRedis)
+	handler := app.TouchPersonHandler()
+	handler.ServeHTTP(&mockResponseWriter, createTestGetRequest(false))
+
+	mockRedis.AssertNumberOfCalls(t, "TouchPerson", 1)
+	mockResponseWriter.AssertExpectations(t)
+}
+
+func TestTouchPersonHandler_PersonNotFound(t *testing.T) {
+	mockResponseWriter := rwMock{}
+	mockResponseWriter.On("WriteHeader", http.StatusNotFound)
+	mockResponseWriter.On("Write", mock.Anything).Return(1, nil)
+
+	mockRedis := redisMock{}
+	mockRedis.On("TouchPerson", mock.Anything, personId).Return(errors.New("redis: nil"))
+
+	app := New(&mockRedis)
+	handler := app.TouchPersonHandler()
+	handler.ServeHTTP(&mockResponseWriter, createTestGetRequest(false))
+
+	mockResponseWriter.AssertExpectations(t)
+}
+
+func TestPersonTTLHandler_OkResponse(t *testing.T) {
+	mockResponseWriter := rwMock{}
+	mockResponseWriter.On("Header").Return(http.Header{})
+	mockResponseWriter.On("WriteHeader", http.StatusOK)
+	mockResponseWriter.On("Write", mock.MatchedBy(func(b []byte) bool {
+		return strings.Contains(string(b), `"expiresInSeconds":90`)
+	})).Return(1, nil)
+
+	mockRedis := redisMock{}
+	mockRedis.On("GetPersonTTL", mock.Anything, personId).Return(90*time.Second, nil)
+
+	app := New(&mockRedis)
+	handler := app.PersonTTLHandler()
+	handler.ServeHTTP(&mockResponseWriter, createTestGetRequest(false))
+
+	mockResponseWriter.AssertExpectations(t)
+}
+
+func TestGetPersonHandler_AsOf(t *testing.T) {
+	mockResponseWriter := rwMock{}
+	mockResponseWriter.On("Header").Return(http.Header{})
+	mockResponseWriter.On("WriteHeader", http.StatusOK)
+	mockResponseWriter.On("Write", mock.Anything).Return(1, nil)
+
+	asOf := time.Date(2021, time.August, 1, 10, 0, 0, 0, time.UTC)
+	mockRedis := redisMock{}
+	mockRedis.On("GetPerson", mock.Anything, personId).Return(&models.Person{Id: personId}, nil)
+	mockRedis.On("GetPersonAsOf", mock.Anything, personId, asOf).Return(&models.Person{Id: personId}, nil)
+
+	testRequest := createTestGetRequest(false)
+	testRequest.URL.RawQuery = "asOf=2021-08-01T10:00:00Z"
+
+	app := New(&mockRedis)
+	handler := app.GetPersonHandler()
+	handler.ServeHTTP(&mockResponseWriter, testRequest)
+
+	mockRedis.AssertExpectations(t)
+	mockResponseWriter.AssertExpectations(t)
+}
+
+func TestGetPersonHandler_AsOfVersionNotFound(t *testing.T) {
+	mockResponseWriter := rwMock{}
+	mockResponseWriter.On("WriteHeader", http.StatusNotFound)
+	mockResponseWriter.On("Write", mock.Anything).Return(1, nil)
+
+	mockRedis := redisMock{}
+	mockRedis.On("GetPerson", mock.Anything, personId).Return((*models.Person)(nil), errors.New("redis: nil"))
+	mockRedis.On("GetPersonAsOf", mock.Anything, personId, mock.Anything).Return((*models.Person)(nil), storage.ErrVersionNotFound)
+
+	testRequest := createTestGetRequest(false)
+	testRequest.URL.RawQuery = "asOf=2001-08-01T10:00:00Z"
+
+	app := New(&mockRedis)
+	handler := app.GetPersonHandler()
+	handler.ServeHTTP(&mockResponseWriter, testRequest)
+
+	mockResponseWriter.AssertExpectations(t)
+}
+
+func TestGetPersonHandler_AsOfInvalid(t *testing.T) {
+	mockResponseWriter := rwMock{}
+	mockResponseWriter.On("WriteHeader", http.StatusBadRequest)
+	mockResponseWriter.On("Write", mock.Anything).Return(1, nil)
+
+	testRequest := createTestGetRequest(false)
+	testRequest.URL.RawQuery = "asOf=last-month"
+
+	app := New(nil)
+	handler := app.GetPersonHandler()
+	handler.ServeHTTP(&mockResponseWriter, testRequest)
+
+	mockResponseWriter.AssertExpectations(t)
+}
+
+func TestRevertPersonHandler_OkResponse(t *testing.T) {
+	mockResponseWriter := rwMock{}
+	mockResponseWriter.On("Header").Return(http.Header{})
+	mockResponseWriter.On("WriteHeader", http.StatusOK)
+	mockResponseWriter.On("Write", mock.Anything).Return(1, nil)
+
+	mockRedis := redisMock{}
+	mockRedis.On("RevertPerson", mock.Anything, personId, 2).Return(&models.Person{Id: personId}, nil)
+
+	testRequest, _ := http.NewRequest("POST", "/api/v1/person/"+personId+"/revert", strings.NewReader(`{"version":2}`))
+	testRequest = mux.SetURLVars(testRequest, map[string]string{"id": personId})
+
+	app := New(&mockRedis)
+	handler := app.RevertPersonHandler()
+	handler.ServeHTTP(&mockResponseWriter, testRequest)
+
+	mockRedis.AssertExpectations(t)
+	mockResponseWriter.AssertExpectations(t)
+}
+
+func TestGetPersonHandler_Deleted(t *testing.T) {
+	deletedAt := time.Now()
+	mockRedis := redisMock{}
+	mockRedis.On("GetPerson", mock.Anything, personId).Return(&models.Person{Id: personId, DeletedAt: &deletedAt}, nil)
+	app := New(&mockRedis)
+
+	recorder := httptest.NewRecorder()
+	app.GetPersonHandler().ServeHTTP(recorder, createTestGetRequest(false))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	testRequest, _ := http.NewRequest("GET", "/api/v1/person/"+personId+"?includeDeleted=true", nil)
+	app.GetPersonHandler().ServeHTTP(recorder, mux.SetURLVars(testRequest, map[string]string{"id": personId}))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestGetPersonHandler_AsOfDeleted(t *testing.T) {
+	deletedAt := time.Now()
+	mockRedis := redisMock{}
+	mockRedis.On("GetPerson", mock.Anything, personId).Return(&models.Person{Id: personId, DeletedAt: &deletedAt}, nil)
+	mockRedis.On("GetPersonAsOf", mock.Anything, personId, mock.Anything).Return(&models.Person{Id: personId}, nil)
+	app := New(&mockRedis)
+
+	recorder := httptest.NewRecorder()
+	testRequest, _ := http.NewRequest("GET", "/api/v1/person/"+personId+"?asOf=2021-08-01T10:00:00Z", nil)
+	app.GetPersonHandler().ServeHTTP(recorder, mux.SetURLVars(testRequest, map[string]string{"id": personId}))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	mockRedis.AssertNotCalled(t, "GetPersonAsOf", mock.Anything, mock.Anything, mock.Anything)
+
+	recorder = httptest.NewRecorder()
+	testRequest, _ = http.NewRequest("GET", "/api/v1/person/"+personId+"?asOf=2021-08-01T10:00:00Z&includeDeleted=true", nil)
+	app.GetPersonHandler().ServeHTTP(recorder, mux.SetURLVars(testRequest, map[string]string{"id": personId}))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestNotDeleted_HidesDeletedPerson(t *testing.T) {
+	deletedAt := time.Now()
+	mockRedis := redisMock{}
+	mockRedis.On("GetPerson", mock.Anything, personId).Return(&models.Person{Id: personId, DeletedAt: &deletedAt}, nil)
+	mockRedis.On("TouchPerson", mock.Anything, personId).Return(nil)
+	app := New(&mockRedis)
+	handler := app.notDeleted(app.TouchPersonHandler())
+
+	recorder := httptest.NewRecorder()
+	testRequest, _ := http.NewRequest("POST", "/api/v1/person/"+personId+"/touch", nil)
+	handler.ServeHTTP(recorder, mux.SetURLVars(testRequest, map[string]string{"id": personId}))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	mockRedis.AssertNotCalled(t, "TouchPerson", mock.Anything, mock.Anything)
+
+	recorder = httptest.NewRecorder()
+	testRequest, _ = http.NewRequest("POST", "/api/v1/person/"+personId+"/touch?includeDeleted=true", nil)
+	handler.ServeHTTP(recorder, mux.SetURLVars(testRequest, map[string]string{"id": personId}))
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+}
+
+func TestNotDeleted_PassesLiveAndMissingPerson(t *testing.T) {
+	mockRedis := redisMock{}
+	mockRedis.On("GetPerson", mock.Anything, personId).Return(&models.Person{Id: personId}, nil).Once()
+	mockRedis.On("GetPerson", mock.Anything, personId).Return((*models.Person)(nil), errors.New("redis: nil")).Once()
+	mockRedis.On("GetHistory", mock.Anything, personId).Return([]storage.PersonVersion{}, nil)
+	app := New(&mockRedis)
+	handler := app.notDeleted(app.PersonHistoryHandler())
+
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, createTestGetRequest(false))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+	}
+	mockRedis.AssertExpectations(t)
+}
+
+func TestRevertPersonHandler_Deleted(t *testing.T) {
+	mockRedis := redisMock{}
+	mockRedis.On("RevertPerson", mock.Anything, personId, 2).Return((*models.Person)(nil), storage.ErrPersonDeleted)
+
+	testRequest, _ := http.NewRequest("POST", "/api/v1/person/"+personId+"/revert", strings.NewReader(`{"version":2}`))
+	recorder := httptest.NewRecorder()
+	New(&mockRedis).RevertPersonHandler().ServeHTTP(recorder, mux.SetURLVars(testRequest, map[string]string{"id": personId}))
+
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
+
+func TestDeletePersonHandler_OkResponse(t *testing.T) {
+	mockResponseWriter := rwMock{}
+	mockResponseWriter.On("WriteHeader", http.StatusNoContent)
+
+	mockRedis := redisMock{}
+	mockRedis.On("DeletePerson", mock.Anything, personId).Return(nil)
+
+	app := New(&mockRedis)
+	handler := app.DeletePersonHandler()
+	handler.ServeHTTP(&mockResponseWriter, createTestGetRequest(false))
+
+	mockRedis.AssertExpectations(t)
+	mockResponseWriter.AssertExpectations(t)
+}
+
+func TestDeletePersonHandler_AlreadyDeleted(t *testing.T) {
+	mockResponseWriter := rwMock{}
+	mockResponseWriter.On("WriteHeader", http.StatusNotFound)
+	mockResponseWriter.On("Write", mock.Anything).Return(1, nil)
+
+	mockRedis := redisMock{}
+	mockRedis.On("DeletePerson", mock.Anything, personId).Return(storage.ErrPersonDeleted)
+
+	app := New(&mockRedis)
+	handler := app.DeletePersonHandler()
+	handler.ServeHTTP(&mockResponseWriter, createTestGetRequest(false))
+
+	mockResponseWriter.AssertExpectations(t)
+}
+
+func TestUndeletePersonHandler_OkResponse(t *testing.T) {
+	mockResponseWriter := rwMock{}
+	mockResponseWriter.On("Header").Return(http.Header{})
+	mockResponseWriter.On("WriteHeader", http.StatusOK)
+	mockResponseWriter.On("Write", mock.Anything).Return(1, nil)
+
+	mockRedis := redisMock{}
+	mockRedis.On("UndeletePerson", mock.Anything, personId).Return(&models.Person{Id: personId}, nil)
+
+	app := New(&mockRedis)
+	handler := app.UndeletePersonHandler()
+	handler.ServeHTTP(&mockResponseWriter, createTestGetRequest(false))
+
+	mockRedis.AssertExpectations(t)
+	mockResponseWriter.AssertExpectations(t)
+}
+
+func TestUndeletePersonHandler_NotDeleted(t *testing.T) {
+	mockResponseWriter := rwMock{}
+	mockResponseWriter.On("WriteHeader", http.StatusConflict)
+	mockResponseWriter.On("Write", mock.Anything).Return(1, nil)
+
+	mockRedis := redisMock{}
+	mockRedis.On("UndeletePerson", mock.Anything, personId).Return((*models.Person)(nil), storage.ErrPersonNotDeleted)
+
+	app := New(&mockRedis)
+	handler := app.UndeletePersonHandler()
+	handler.ServeHTTP(&mockResponseWriter, createTestGetRequest(false))
+
+	mockResponseWriter.AssertExpectations(t)
+}
+
 func createTestGetRequest(useEmptyVars bool) *http.Request {
 	var vars map[string]string
 	if useEmptyVars {
@@ -458,7 +1018,7 @@ func createTestGetRequest(useEmptyVars bool) *http.Request {
 			"id": personId,
 		}
 	}
-	testRequest,_ := http.NewRequest("GET", "/api/v1/person/123", nil)
+	testRequest,_ := http.NewRequest("GET", "/api/v1/person/"+personId, nil)
 	testRequest = mux.SetURLVars(testRequest, vars)
 	return testRequest
 }
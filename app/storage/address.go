@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-microservice-assignment/app/models"
+	"go-microservice-assignment/app/tenant"
+	"log"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const migrateBatchSize = 100
+
+// ErrInvalidAddress tells that single-line address cannot be structured
+var ErrInvalidAddress = errors.New("address cannot be structured")
+
+// MigrateAddress parses single-line address of person stored before addresses
+// were structured and stores the structured address next to it. Single-line
+// address is kept as it is, so old clients read the same value. Addresses
+// without country get defaultCountry. Returns true when person was migrated,
+// persons whose address cannot be parsed keep only the single-line address
+// and fail with ErrInvalidAddress.
+func (d *db) MigrateAddress(ctx context.Context, id string, defaultCountry string) (bool, error) {
+	migrated := false
+	err := d.client.Watch(ctx, func(tx *redis.Tx) error {
+		person, err := d.readPerson(ctx, tx, id)
+		if err == redis.Nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if person.PostalAddress != nil || person.Address == "" {
+			return nil
+		}
+		address, err := models.ParseAddress(person.Address, defaultCountry)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidAddress, err)
+		}
+		person.PostalAddress = address
+
+		trans := tx.TxPipeline()
+		// same values are indexed, version and history are left unchanged
+		if err = d.setPerson(ctx, trans, person, person); err != nil {
+			return err
+		}
+		if _, err = trans.Exec(ctx); err != nil {
+			return err
+		}
+		migrated = true
+		return nil
+	}, personKey(ctx, id))
+
+	return migrated, err
+}
+
+// AddressMigration is the result of migration of addresses
+type AddressMigration struct {
+	Migrated  int
+	Unchanged int
+	Failed    []FailedMigration
+}
+
+// FailedMigration is person whose address was not migrated and why
+type FailedMigration struct {
+	Tenant   string
+	PersonId string
+	Reason   string
+}
+
+// AddressMigrator structures single-line addresses of persons of all tenants.
+// Migration is transactional and idempotent, so it can run on every replica.
+type AddressMigrator struct {
+	db             RedisDB
+	defaultCountry string
+}
+
+// NewAddressMigrator creates migrator giving defaultCountry to addresses
+// without country, empty one leaves them unmigrated
+func NewAddressMigrator(db RedisDB, defaultCountry string) *AddressMigrator {
+	return &AddressMigrator{db: db, defaultCountry: defaultCountry}
+}
+
+// Run migrates addresses of all persons once and logs ids of persons that
+// failed, so their addresses can be fixed by hand
+func (m *AddressMigrator) Run(ctx context.Context) *AddressMigration {
+	result := &AddressMigration{}
+	err := ForEachTenant(ctx, m.db, func(ctx context.Context) {
+		m.migrateTenant(ctx, result)
+	})
+	if err != nil {
+		log.Println("Error listing tenants for address migration:", err)
+	}
+	log.Println("Migrated addresses of", result.Migrated, "persons,", result.Unchanged, "left unchanged,", len(result.Failed), "failed")
+	return result
+}
+
+func (m *AddressMigrator) migrateTenant(ctx context.Context, result *AddressMigration) {
+	var cursor uint64
+	for {
+		ids, next, err := m.db.ScanPersonIds(ctx, cursor, migrateBatchSize)
+		if err != nil {
+			log.Println("Error scanning persons for address migration:", err)
+			return
+		}
+		for _, id := range ids {
+			done, err := m.db.MigrateAddress(ctx, id, m.defaultCountry)
+			if err != nil {
+				log.Println("Error migrating address of person", id, "of tenant", tenant.FromContext(ctx), err)
+				result.Failed = append(result.Failed, FailedMigration{Tenant: tenant.FromContext(ctx), PersonId: id, Reason: err.Error()})
+				continue
+			}
+			if done {
+				result.Migrated++
+			} else {
+				result.Unchanged++
+			}
+		}
+		if next == 0 || ctx.Err() != nil {
+			return
+		}
+		cursor = next
+	}
+}
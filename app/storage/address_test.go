@@ -0,0 +1,26 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddressMigrator_Run(t *testing.T) {
+	ctx := context.Background()
+	mockDB := dbMock{}
+	mockDB.On("ListTenants", ctx).Return([]string{}, nil)
+	mockDB.On("ScanPersonIds", ctx, uint64(0), int64(migrateBatchSize)).Return([]string{"1", "2", "3"}, uint64(0), nil)
+	mockDB.On("MigrateAddress", ctx, "1", "HR").Return(true, nil)
+	mockDB.On("MigrateAddress", ctx, "2", "HR").Return(false, nil)
+	mockDB.On("MigrateAddress", ctx, "3", "HR").Return(false, fmt.Errorf("%w: missing country", ErrInvalidAddress))
+
+	result := NewAddressMigrator(&mockDB, "HR").Run(ctx)
+
+	mockDB.AssertNumberOfCalls(t, "MigrateAddress", 3)
+	assert.Equal(t, 1, result.Migrated)
+	assert.Equal(t, 1, result.Unchanged)
+	assert.Equal(t, []FailedMigration{{PersonId: "3", Reason: "address cannot be structured: missing country"}}, result.Failed)
+}
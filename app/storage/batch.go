@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go-microservice-assignment/app/audit"
+	"go-microservice-assignment/app/models"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+type BatchOp string
+
+const (
+	BatchCreate BatchOp = "create"
+	BatchUpdate BatchOp = "update"
+	BatchGet    BatchOp = "get"
+)
+
+// ErrBatchAborted is result of operations not executed because another
+// operation of all-or-nothing batch failed
+var ErrBatchAborted = errors.New("batch aborted")
+
+// BatchOperation is a single operation of a batch. Create and update take
+// Person (with Id set), get takes Id.
+type BatchOperation struct {
+	Op     BatchOp
+	Id     string
+	Person *models.Person
+}
+
+// BatchResult is outcome of a single batch operation. Err is redis.Nil when
+// person does not exist and ErrPersonDeleted when it is soft deleted.
+type BatchResult struct {
+	Person *models.Person
+	Err    error
+}
+
+// batchState is person as seen by operations of a batch, so later operations
+// on the same person see changes of earlier ones
+type batchState struct {
+	person  *models.Person
+	version int
+	since   time.Time
+	policy  *RetentionPolicy
+}
+
+// batchRetries is how many times non-atomic batch is run again when persons
+// it read were changed concurrently
+const batchRetries = 3
+
+// ExecuteBatch runs operations with two Redis round trips: one pipeline reading
+// all referenced persons and one transaction writing all changes. Read persons
+// are watched, so no change is written over a concurrent modification. In
+// atomic mode nothing is written when any operation fails and concurrent
+// modification fails the batch with redis.TxFailedErr. Non-atomic batch is run
+// again on concurrent modification and when it keeps conflicting, results of
+// its operations are redis.TxFailedErr.
+func (d *db) ExecuteBatch(ctx context.Context, ops []BatchOperation, atomic bool) ([]BatchResult, error) {
+	var ids []string
+	seen := map[string]bool{}
+	for _, op := range ops {
+		id := op.Id
+		if op.Person != nil {
+			id = op.Person.Id
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	var results []BatchResult
+	var watched []string
+	for _, id := range ids {
+		watched = append(watched, personKey(ctx, id), getVersionKey(ctx, id), getRetentionKey(ctx, id))
+	}
+	for attempt := 0; ; attempt++ {
+		err := d.client.Watch(ctx, func(tx *redis.Tx) error {
+			var err error
+			results, err = d.executeBatch(ctx, tx, ids, ops, atomic)
+			return err
+		}, watched...)
+		if err != redis.TxFailedErr || atomic {
+			return results, err
+		}
+		if attempt == batchRetries {
+			results = make([]BatchResult, len(ops))
+			for i := range results {
+				results[i].Err = redis.TxFailedErr
+			}
+			return results, nil
+		}
+	}
+}
+
+func (d *db) executeBatch(ctx context.Context, tx *redis.Tx, ids []string, ops []BatchOperation, atomic bool) ([]BatchResult, error) {
+	states, err := d.readBatchStates(ctx, tx, ids)
+	if err != nil {
+		return nil, err
+	}
+	pipe := tx.TxPipeline()
+
+	now := time.Now()
+	results := make([]BatchResult, len(ops))
+	failed := false
+	for i, op := range ops {
+		results[i].Person, results[i].Err = d.queueBatchOperation(ctx, pipe, states, op, now)
+		if results[i].Err != nil {
+			failed = true
+		}
+	}
+
+	if atomic && failed {
+		for i := range results {
+			if results[i].Err == nil {
+				results[i] = BatchResult{Err: ErrBatchAborted}
+			}
+		}
+		pipe.Discard()
+		return results, nil
+	}
+	if _, err = pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (d *db) queueBatchOperation(ctx context.Context, pipe redis.Pipeliner, states map[string]*batchState, op BatchOperation, now time.Time) (*models.Person, error) {
+	switch op.Op {
+	case BatchGet:
+		state := states[op.Id]
+		if state.person == nil {
+			return nil, redis.Nil
+		}
+		if state.person.DeletedAt != nil {
+			return nil, ErrPersonDeleted
+		}
+		person := *state.person
+		return &person, nil
+
+	case BatchCreate:
+		state := states[op.Person.Id]
+		if state.person != nil {
+			return nil, ErrPersonExists
+		}
+		person := *op.Person
+		person.CreatedAt = now.UTC()
+		person.CreatedBy = audit.FromContext(ctx).Actor
+		person.DeletedAt = nil
+		stampUpdated(&person, 1, now)
+
+		if err := d.setPerson(ctx, pipe, &person, nil); err != nil {
+			return nil, err
+		}
+		pipe.Set(ctx, getExpireKey(ctx, person.Id), now, d.idleTime(ctx, state.policy))
+		startHistory(ctx, pipe, person.Id, now)
+		registerTenant(ctx, pipe)
+		if err := d.appendEvent(ctx, pipe, EventPersonCreated, person.Id, nil, &person); err != nil {
+			return nil, err
+		}
+		state.person, state.version, state.since = &person, 1, now.UTC()
+		return &person, nil
+
+	case BatchUpdate:
+		state := states[op.Person.Id]
+		if state.person == nil {
+			return nil, redis.Nil
+		}
+		if state.person.DeletedAt != nil {
+			return nil, ErrPersonDeleted
+		}
+		before := *state.person
+		person := before
+		applyChanges(&person, op.Person)
+		stampUpdated(&person, state.version+1, now)
+
+		if err := d.queueHistory(ctx, pipe, &before, state.version, state.since, now); err != nil {
+			return nil, err
+		}
+		if err := d.setPerson(ctx, pipe, &person, &before); err != nil {
+			return nil, err
+		}
+		pipe.Set(ctx, getExpireKey(ctx, person.Id), now, d.idleTime(ctx, state.policy))
+		if err := d.appendEvent(ctx, pipe, EventPersonUpdated, person.Id, &before, &person); err != nil {
+			return nil, err
+		}
+		state.person, state.version, state.since = &person, state.version+1, now.UTC()
+		return &person, nil
+	}
+	return nil, errors.New("unknown batch operation " + string(op.Op))
+}
+
+// readBatchStates reads persons with their version and retention policy in one pipeline
+func (d *db) readBatchStates(ctx context.Context, c redis.Cmdable, ids []string) (map[string]*batchState, error) {
+	pipe := c.Pipeline()
+	persons := make([]*redis.StringCmd, len(ids))
+	versions := make([]*redis.StringStringMapCmd, len(ids))
+	policies := make([]*redis.StringCmd, len(ids))
+	for i, id := range ids {
+		persons[i] = pipe.Get(ctx, personKey(ctx, id))
+		versions[i] = pipe.HGetAll(ctx, getVersionKey(ctx, id))
+		policies[i] = pipe.Get(ctx, getRetentionKey(ctx, id))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	states := make(map[string]*batchState, len(ids))
+	for i, id := range ids {
+		state := &batchState{policy: &RetentionPolicy{}}
+		if data, err := persons[i].Result(); err == nil {
+			if state.person, err = d.decodePerson(data); err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		if state.version, state.since, err = parseVersion(versions[i].Val()); err != nil {
+			return nil, err
+		}
+		if data, err := policies[i].Result(); err == nil {
+			if err = json.Unmarshal([]byte(data), state.policy); err != nil {
+				return nil, err
+			}
+		}
+		states[id] = state
+	}
+	return states, nil
+}
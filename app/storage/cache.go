@@ -0,0 +1,320 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"go-microservice-assignment/app/models"
+	"go-microservice-assignment/app/tenant"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// channel used to tell other replicas that a cached person is stale
+const cacheInvalidationChannel = "person-cache-invalidate"
+
+type cacheEntry struct {
+	key     string
+	person  models.Person
+	expires time.Time
+}
+
+// load tracks reads of a key from Redis in progress. Eviction bumps its
+// generation, so a read that started before the eviction does not put the
+// stale person back. It is dropped when the last read ends, so the map only
+// holds keys being read.
+type load struct {
+	readers    int
+	generation uint64
+}
+
+// CacheStats holds counters exposed by the read-through cache
+type CacheStats struct {
+	Hits          int64 `json:"hits"`
+	Misses        int64 `json:"misses"`
+	Evictions     int64 `json:"evictions"`
+	Invalidations int64 `json:"invalidations"`
+	Size          int   `json:"size"`
+}
+
+// CachedDB is a RedisDB decorator that keeps recently read persons in a
+// bounded in-process LRU. Entries live for a short TTL and are dropped on
+// local writes and, when a Redis client is given, on writes made by other
+// replicas (announced via pub/sub). Entries are keyed by tenant and id.
+type CachedDB struct {
+	RedisDB
+	client  *redis.Client
+	size    int
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	loads   map[string]*load
+
+	hits          int64
+	misses        int64
+	evictions     int64
+	invalidations int64
+}
+
+func NewCachedDB(next RedisDB, client *redis.Client, size int, ttl time.Duration) *CachedDB {
+	return &CachedDB{
+		RedisDB: next,
+		client:  client,
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		loads:   make(map[string]*load),
+	}
+}
+
+func (c *CachedDB) GetPerson(ctx context.Context, id string) (*models.Person, error) {
+	key := tenant.Key(ctx, id)
+	if person, ok := c.get(key); ok {
+		atomic.AddInt64(&c.hits, 1)
+		return person, nil
+	}
+	atomic.AddInt64(&c.misses, 1)
+
+	generation := c.beginLoad(key)
+	defer c.endLoad(key)
+	person, err := c.RedisDB.GetPerson(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	c.put(key, person, generation)
+	return person, nil
+}
+
+func (c *CachedDB) CreatePerson(ctx context.Context, p *models.Person) error {
+	err := c.RedisDB.CreatePerson(ctx, p)
+	c.invalidate(ctx, p.Id)
+	return err
+}
+
+func (c *CachedDB) UpdatePersonOptimistic(ctx context.Context, p *models.Person) (*models.Person, error) {
+	person, err := c.RedisDB.UpdatePersonOptimistic(ctx, p)
+	c.invalidate(ctx, p.Id)
+	return person, err
+}
+
+func (c *CachedDB) UpdatePersonPessimistic(ctx context.Context, p *models.Person) (*models.Person, error) {
+	person, err := c.RedisDB.UpdatePersonPessimistic(ctx, p)
+	c.invalidate(ctx, p.Id)
+	return person, err
+}
+
+func (c *CachedDB) ExpirePerson(ctx context.Context, id string, archive func(p *models.Person) error) (bool, error) {
+	expired, err := c.RedisDB.ExpirePerson(ctx, id, archive)
+	if expired {
+		c.invalidate(ctx, id)
+	}
+	return expired, err
+}
+
+func (c *CachedDB) RestorePerson(ctx context.Context, id string, load func(id string) (*models.Person, error)) (*models.Person, error) {
+	person, err := c.RedisDB.RestorePerson(ctx, id, load)
+	c.invalidate(ctx, id)
+	return person, err
+}
+
+func (c *CachedDB) RevertPerson(ctx context.Context, id string, version int) (*models.Person, error) {
+	person, err := c.RedisDB.RevertPerson(ctx, id, version)
+	c.invalidate(ctx, id)
+	return person, err
+}
+
+func (c *CachedDB) DeletePerson(ctx context.Context, id string) error {
+	err := c.RedisDB.DeletePerson(ctx, id)
+	c.invalidate(ctx, id)
+	return err
+}
+
+func (c *CachedDB) UndeletePerson(ctx context.Context, id string) (*models.Person, error) {
+	person, err := c.RedisDB.UndeletePerson(ctx, id)
+	c.invalidate(ctx, id)
+	return person, err
+}
+
+func (c *CachedDB) PurgePerson(ctx context.Context, id string, gracePeriod time.Duration) (bool, error) {
+	purged, err := c.RedisDB.PurgePerson(ctx, id, gracePeriod)
+	if purged {
+		c.invalidate(ctx, id)
+	}
+	return purged, err
+}
+
+func (c *CachedDB) ErasePerson(ctx context.Context, id string) (*Erasure, error) {
+	erasure, err := c.RedisDB.ErasePerson(ctx, id)
+	c.invalidate(ctx, id)
+	return erasure, err
+}
+
+func (c *CachedDB) MigrateAddress(ctx context.Context, id string, defaultCountry string) (bool, error) {
+	migrated, err := c.RedisDB.MigrateAddress(ctx, id, defaultCountry)
+	c.invalidate(ctx, id)
+	return migrated, err
+}
+
+func (c *CachedDB) BackfillOrder(ctx context.Context, id string) (bool, error) {
+	stamped, err := c.RedisDB.BackfillOrder(ctx, id)
+	if stamped {
+		c.invalidate(ctx, id)
+	}
+	return stamped, err
+}
+
+func (c *CachedDB) ExecuteBatch(ctx context.Context, ops []BatchOperation, atomic bool) ([]BatchResult, error) {
+	results, err := c.RedisDB.ExecuteBatch(ctx, ops, atomic)
+	for i, result := range results {
+		if result.Err == nil && ops[i].Op != BatchGet {
+			c.invalidate(ctx, ops[i].Person.Id)
+		}
+	}
+	return results, err
+}
+
+// Listen evicts entries announced by other replicas until ctx is cancelled
+func (c *CachedDB) Listen(ctx context.Context) {
+	if c.client == nil {
+		return
+	}
+	sub := c.client.Subscribe(ctx, cacheInvalidationChannel)
+	defer sub.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-sub.Channel():
+			if !ok {
+				return
+			}
+			c.evict(msg.Payload)
+		}
+	}
+}
+
+func (c *CachedDB) Stats() CacheStats {
+	c.mu.Lock()
+	size := c.lru.Len()
+	c.mu.Unlock()
+
+	return CacheStats{
+		Hits:          atomic.LoadInt64(&c.hits),
+		Misses:        atomic.LoadInt64(&c.misses),
+		Evictions:     atomic.LoadInt64(&c.evictions),
+		Invalidations: atomic.LoadInt64(&c.invalidations),
+		Size:          size,
+	}
+}
+
+func (c *CachedDB) get(key string) (*models.Person, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.removeElement(elem)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	// return a copy so callers cannot modify the cached value
+	person := entry.person
+	return &person, true
+}
+
+// put caches person read in the given generation of its key, unless the key
+// was evicted since the read started
+func (c *CachedDB) put(key string, p *models.Person, generation uint64) {
+	if c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if l, ok := c.loads[key]; ok && l.generation != generation {
+		return
+	}
+
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		entry.person = *p
+		entry.expires = time.Now().Add(c.ttl)
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&cacheEntry{
+		key:     key,
+		person:  *p,
+		expires: time.Now().Add(c.ttl),
+	})
+	for c.lru.Len() > c.size {
+		c.removeElement(c.lru.Back())
+		atomic.AddInt64(&c.evictions, 1)
+	}
+}
+
+func (c *CachedDB) beginLoad(key string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	l, ok := c.loads[key]
+	if !ok {
+		l = &load{}
+		c.loads[key] = l
+	}
+	l.readers++
+	return l.generation
+}
+
+func (c *CachedDB) endLoad(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if l, ok := c.loads[key]; ok {
+		if l.readers--; l.readers == 0 {
+			delete(c.loads, key)
+		}
+	}
+}
+
+func (c *CachedDB) evict(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if l, ok := c.loads[key]; ok {
+		l.generation++
+	}
+
+	if elem, ok := c.entries[key]; ok {
+		c.removeElement(elem)
+		atomic.AddInt64(&c.invalidations, 1)
+	}
+}
+
+// invalidate drops the local entry and tells other replicas to do the same
+func (c *CachedDB) invalidate(ctx context.Context, id string) {
+	key := tenant.Key(ctx, id)
+	c.evict(key)
+	if c.client == nil {
+		return
+	}
+	if err := c.client.Publish(ctx, cacheInvalidationChannel, key).Err(); err != nil {
+		log.Println("Error publishing cache invalidation:", err)
+	}
+}
+
+func (c *CachedDB) removeElement(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+}
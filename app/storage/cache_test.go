@@ -0,0 +1,275 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"go-microservice-assignment/app/models"
+	"go-microservice-assignment/app/tenant"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// mock for RedisDB wrapped by the cache
+type dbMock struct {
+	mock.Mock
+}
+
+func (m *dbMock) GetPerson(ctx context.Context, id string) (*models.Person, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*models.Person), args.Error(1)
+}
+
+func (m *dbMock) CreatePerson(ctx context.Context, p *models.Person) error {
+	args := m.Called(ctx, p)
+	return args.Error(0)
+}
+
+func (m *dbMock) UpdatePersonOptimistic(ctx context.Context, p *models.Person) (*models.Person, error) {
+	args := m.Called(ctx, p)
+	return args.Get(0).(*models.Person), args.Error(1)
+}
+
+func (m *dbMock) UpdatePersonPessimistic(ctx context.Context, p *models.Person) (*models.Person, error) {
+	args := m.Called(ctx, p)
+	return args.Get(0).(*models.Person), args.Error(1)
+}
+
+func (m *dbMock) ExpirePerson(ctx context.Context, id string, archive func(p *models.Person) error) (bool, error) {
+	args := m.Called(ctx, id, archive)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *dbMock) ScanPersonIds(ctx context.Context, cursor uint64, count int64) ([]string, uint64, error) {
+	args := m.Called(ctx, cursor, count)
+	return args.Get(0).([]string), args.Get(1).(uint64), args.Error(2)
+}
+
+func (m *dbMock) RestorePerson(ctx context.Context, id string, load func(id string) (*models.Person, error)) (*models.Person, error) {
+	args := m.Called(ctx, id, load)
+	return args.Get(0).(*models.Person), args.Error(1)
+}
+
+func (m *dbMock) TouchPerson(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *dbMock) GetPersonTTL(ctx context.Context, id string) (time.Duration, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(time.Duration), args.Error(1)
+}
+
+func (m *dbMock) GetRetention(ctx context.Context, id string) (*RetentionPolicy, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*RetentionPolicy), args.Error(1)
+}
+
+func (m *dbMock) SetRetention(ctx context.Context, id string, policy *RetentionPolicy, actor string, reason string) error {
+	args := m.Called(ctx, id, policy, actor, reason)
+	return args.Error(0)
+}
+
+func (m *dbMock) ListRetentionAudit(ctx context.Context, id string) ([]RetentionAudit, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).([]RetentionAudit), args.Error(1)
+}
+
+func (m *dbMock) GetHistory(ctx context.Context, id string) ([]PersonVersion, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).([]PersonVersion), args.Error(1)
+}
+
+func (m *dbMock) GetPersonAsOf(ctx context.Context, id string, asOf time.Time) (*models.Person, error) {
+	args := m.Called(ctx, id, asOf)
+	return args.Get(0).(*models.Person), args.Error(1)
+}
+
+func (m *dbMock) RevertPerson(ctx context.Context, id string, version int) (*models.Person, error) {
+	args := m.Called(ctx, id, version)
+	return args.Get(0).(*models.Person), args.Error(1)
+}
+
+func (m *dbMock) ListPersons(ctx context.Context) ([]*models.Person, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]*models.Person), args.Error(1)
+}
+
+func (m *dbMock) ScanPersons(ctx context.Context, cursor uint64, count int64) ([]*models.Person, uint64, error) {
+	args := m.Called(ctx, cursor, count)
+	return args.Get(0).([]*models.Person), args.Get(1).(uint64), args.Error(2)
+}
+
+func (m *dbMock) DeletePerson(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *dbMock) UndeletePerson(ctx context.Context, id string) (*models.Person, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*models.Person), args.Error(1)
+}
+
+func (m *dbMock) PurgePerson(ctx context.Context, id string, gracePeriod time.Duration) (bool, error) {
+	args := m.Called(ctx, id, gracePeriod)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *dbMock) ExecuteBatch(ctx context.Context, ops []BatchOperation, atomic bool) ([]BatchResult, error) {
+	args := m.Called(ctx, ops, atomic)
+	return args.Get(0).([]BatchResult), args.Error(1)
+}
+
+func (m *dbMock) ListTenants(ctx context.Context) ([]string, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *dbMock) FindPersons(ctx context.Context, field string, value string) ([]*models.Person, error) {
+	args := m.Called(ctx, field, value)
+	return args.Get(0).([]*models.Person), args.Error(1)
+}
+
+func (m *dbMock) ReencryptPerson(ctx context.Context, id string) (bool, error) {
+	args := m.Called(ctx, id)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *dbMock) ListPersonsPage(ctx context.Context, query PageQuery) ([]*models.Person, int, error) {
+	args := m.Called(ctx, query)
+	return args.Get(0).([]*models.Person), args.Int(1), args.Error(2)
+}
+
+func (m *dbMock) BackfillOrder(ctx context.Context, id string) (bool, error) {
+	args := m.Called(ctx, id)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *dbMock) MigrateAddress(ctx context.Context, id string, defaultCountry string) (bool, error) {
+	args := m.Called(ctx, id, defaultCountry)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *dbMock) ErasePerson(ctx context.Context, id string) (*Erasure, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*Erasure), args.Error(1)
+}
+
+func TestCachedDB_GetPersonHit(t *testing.T) {
+	ctx := context.Background()
+	mockDB := dbMock{}
+	mockDB.On("GetPerson", ctx, "1").Return(&models.Person{Id: "1", Name: "Test123"}, nil)
+
+	cache := NewCachedDB(&mockDB, nil, 10, time.Minute)
+	first, err := cache.GetPerson(ctx, "1")
+	assert.NoError(t, err)
+	second, err := cache.GetPerson(ctx, "1")
+	assert.NoError(t, err)
+
+	assert.Equal(t, first, second)
+	mockDB.AssertNumberOfCalls(t, "GetPerson", 1)
+	stats := cache.Stats()
+	assert.Equal(t, int64(1), stats.Hits)
+	assert.Equal(t, int64(1), stats.Misses)
+}
+
+func TestCachedDB_ErrorsAreNotCached(t *testing.T) {
+	ctx := context.Background()
+	mockDB := dbMock{}
+	mockDB.On("GetPerson", ctx, "1").Return(&models.Person{}, errors.New("redis: nil"))
+
+	cache := NewCachedDB(&mockDB, nil, 10, time.Minute)
+	_, err := cache.GetPerson(ctx, "1")
+	assert.Error(t, err)
+	_, err = cache.GetPerson(ctx, "1")
+	assert.Error(t, err)
+
+	mockDB.AssertNumberOfCalls(t, "GetPerson", 2)
+}
+
+func TestCachedDB_ExpiredEntry(t *testing.T) {
+	ctx := context.Background()
+	mockDB := dbMock{}
+	mockDB.On("GetPerson", ctx, "1").Return(&models.Person{Id: "1"}, nil)
+
+	cache := NewCachedDB(&mockDB, nil, 10, time.Millisecond)
+	cache.GetPerson(ctx, "1")
+	time.Sleep(5 * time.Millisecond)
+	cache.GetPerson(ctx, "1")
+
+	mockDB.AssertNumberOfCalls(t, "GetPerson", 2)
+}
+
+func TestCachedDB_EvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	mockDB := dbMock{}
+	mockDB.On("GetPerson", ctx, "1").Return(&models.Person{Id: "1"}, nil)
+	mockDB.On("GetPerson", ctx, "2").Return(&models.Person{Id: "2"}, nil)
+	mockDB.On("GetPerson", ctx, "3").Return(&models.Person{Id: "3"}, nil)
+
+	cache := NewCachedDB(&mockDB, nil, 2, time.Minute)
+	cache.GetPerson(ctx, "1")
+	cache.GetPerson(ctx, "2")
+	cache.GetPerson(ctx, "1")
+	cache.GetPerson(ctx, "3")
+	// "2" was least recently used and must be fetched again
+	cache.GetPerson(ctx, "2")
+
+	mockDB.AssertNumberOfCalls(t, "GetPerson", 4)
+	assert.Equal(t, int64(2), cache.Stats().Evictions)
+}
+
+func TestCachedDB_UpdateInvalidates(t *testing.T) {
+	ctx := context.Background()
+	person := models.Person{Id: "1", Name: "Person1"}
+	mockDB := dbMock{}
+	mockDB.On("GetPerson", ctx, "1").Return(&person, nil)
+	mockDB.On("UpdatePersonOptimistic", ctx, &person).Return(&person, nil)
+
+	cache := NewCachedDB(&mockDB, nil, 10, time.Minute)
+	cache.GetPerson(ctx, "1")
+	cache.UpdatePersonOptimistic(ctx, &person)
+	cache.GetPerson(ctx, "1")
+
+	mockDB.AssertNumberOfCalls(t, "GetPerson", 2)
+	assert.Equal(t, int64(1), cache.Stats().Invalidations)
+}
+
+func TestCachedDB_TenantsDoNotShareEntries(t *testing.T) {
+	ctx := context.Background()
+	acme := tenant.WithTenant(ctx, "acme")
+	mockDB := dbMock{}
+	mockDB.On("GetPerson", ctx, "1").Return(&models.Person{Id: "1", Name: "Default"}, nil)
+	mockDB.On("GetPerson", acme, "1").Return(&models.Person{}, errors.New("redis: nil"))
+
+	cache := NewCachedDB(&mockDB, nil, 10, time.Minute)
+	cache.GetPerson(ctx, "1")
+	_, err := cache.GetPerson(acme, "1")
+
+	assert.Error(t, err)
+	mockDB.AssertNumberOfCalls(t, "GetPerson", 2)
+}
+
+func TestCachedDB_ReadRacingInvalidationIsNotCached(t *testing.T) {
+	ctx := context.Background()
+	stale := models.Person{Id: "1", Name: "Stale"}
+	updated := models.Person{Id: "1", Name: "Updated"}
+	mockDB := dbMock{}
+	cache := NewCachedDB(&mockDB, nil, 10, time.Minute)
+	// person is updated while its old version is being read
+	mockDB.On("GetPerson", ctx, "1").Return(&stale, nil).Run(func(mock.Arguments) {
+		cache.UpdatePersonOptimistic(ctx, &updated)
+	}).Once()
+	mockDB.On("GetPerson", ctx, "1").Return(&updated, nil).Once()
+	mockDB.On("UpdatePersonOptimistic", ctx, &updated).Return(&updated, nil)
+
+	first, _ := cache.GetPerson(ctx, "1")
+	second, _ := cache.GetPerson(ctx, "1")
+
+	assert.Equal(t, "Stale", first.Name)
+	assert.Equal(t, "Updated", second.Name)
+	mockDB.AssertNumberOfCalls(t, "GetPerson", 2)
+	assert.Empty(t, cache.loads)
+}
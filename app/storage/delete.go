@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"go-microservice-assignment/app/models"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const purgeBatchSize = 100
+
+var (
+	ErrPersonDeleted    = errors.New("person is deleted")
+	ErrPersonNotDeleted = errors.New("person is not deleted")
+)
+
+// DeletePerson marks person as deleted. Tombstone stays stored, so person can
+// be undeleted until it is purged. Returns redis.Nil when person does not
+// exist and ErrPersonDeleted when it is already deleted.
+func (d *db) DeletePerson(ctx context.Context, id string) error {
+	return d.client.Watch(ctx, func(tx *redis.Tx) error {
+		before, err := d.readPerson(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if before.DeletedAt != nil {
+			return ErrPersonDeleted
+		}
+
+		now := time.Now()
+		tombstone := *before
+		deletedAt := now.UTC()
+		tombstone.DeletedAt = &deletedAt
+
+		trans := tx.TxPipeline()
+		version, err := d.appendHistory(ctx, tx, trans, before, now)
+		if err != nil {
+			return err
+		}
+		stampUpdated(&tombstone, version, now)
+		if err = d.setPerson(ctx, trans, &tombstone, before); err != nil {
+			return err
+		}
+		// publish change event in the same transaction
+		if err = d.appendEvent(ctx, trans, EventPersonDeleted, id, before, nil); err != nil {
+			return err
+		}
+		_, err = trans.Exec(ctx)
+		return err
+	}, personKey(ctx, id))
+}
+
+// UndeletePerson clears tombstone of deleted person and restarts its idle
+// period. Returns ErrPersonNotDeleted when person is not deleted.
+func (d *db) UndeletePerson(ctx context.Context, id string) (*models.Person, error) {
+	var undeleted *models.Person
+
+	err := d.client.Watch(ctx, func(tx *redis.Tx) error {
+		before, err := d.readPerson(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if before.DeletedAt == nil {
+			return ErrPersonNotDeleted
+		}
+		idleTime, err := d.personIdleTime(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		person := *before
+		person.DeletedAt = nil
+
+		trans := tx.TxPipeline()
+		version, err := d.appendHistory(ctx, tx, trans, before, now)
+		if err != nil {
+			return err
+		}
+		stampUpdated(&person, version, now)
+		if err = d.setPerson(ctx, trans, &person, before); err != nil {
+			return err
+		}
+		trans.Set(ctx, getExpireKey(ctx, id), now, idleTime)
+		// publish change event in the same transaction
+		if err = d.appendEvent(ctx, trans, EventPersonUndeleted, id, before, &person); err != nil {
+			return err
+		}
+		if _, err = trans.Exec(ctx); err != nil {
+			return err
+		}
+		undeleted = &person
+		return nil
+	}, personKey(ctx, id), getRetentionKey(ctx, id))
+
+	return undeleted, err
+}
+
+// PurgePerson permanently removes person deleted longer than gracePeriod ago,
+// together with its expiry, retention and history keys. Person under legal
+// hold is kept. Returns true when person was purged.
+func (d *db) PurgePerson(ctx context.Context, id string, gracePeriod time.Duration) (bool, error) {
+	purged := false
+
+	err := d.client.Watch(ctx, func(tx *redis.Tx) error {
+		tombstone, err := d.readPerson(ctx, tx, id)
+		if err == redis.Nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if tombstone.DeletedAt == nil || time.Since(*tombstone.DeletedAt) < gracePeriod {
+			return nil
+		}
+		policy, err := readRetention(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if policy.LegalHold {
+			return nil
+		}
+
+		trans := tx.TxPipeline()
+		trans.Del(ctx, personKey(ctx, id), getExpireKey(ctx, id), getRetentionKey(ctx, id), getRetentionAuditKey(ctx, id), getHistoryKey(ctx, id), getVersionKey(ctx, id))
+		d.unindexPerson(ctx, trans, tombstone)
+		// publish change event in the same transaction
+		if err = d.appendEvent(ctx, trans, EventPersonPurged, id, tombstone, nil); err != nil {
+			return err
+		}
+		if _, err = trans.Exec(ctx); err != nil {
+			return err
+		}
+		purged = true
+		return nil
+	}, personKey(ctx, id), getRetentionKey(ctx, id))
+
+	return purged, err
+}
+
+func (d *db) readPerson(ctx context.Context, c redis.Cmdable, id string) (*models.Person, error) {
+	personString, err := c.Get(ctx, personKey(ctx, id)).Result()
+	if err != nil {
+		return nil, err
+	}
+	return d.decodePerson(personString)
+}
+
+// Purger periodically purges persons whose soft delete grace period is over.
+// Purging is transactional, so it can run on every replica.
+type Purger struct {
+	db          RedisDB
+	gracePeriod time.Duration
+	interval    time.Duration
+}
+
+func NewPurger(db RedisDB, gracePeriod time.Duration, interval time.Duration) *Purger {
+	return &Purger{
+		db:          db,
+		gracePeriod: gracePeriod,
+		interval:    interval,
+	}
+}
+
+// Run purges deleted persons until ctx is cancelled
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.Sweep(ctx)
+		}
+	}
+}
+
+// Sweep purges persons of all tenants whose grace period is over
+func (p *Purger) Sweep(ctx context.Context) {
+	if err := ForEachTenant(ctx, p.db, p.sweepTenant); err != nil {
+		log.Println("Error listing tenants for purging:", err)
+	}
+}
+
+func (p *Purger) sweepTenant(ctx context.Context) {
+	var cursor uint64
+	for {
+		ids, next, err := p.db.ScanPersonIds(ctx, cursor, purgeBatchSize)
+		if err != nil {
+			log.Println("Error scanning persons for purging:", err)
+			return
+		}
+		for _, id := range ids {
+			purged, err := p.db.PurgePerson(ctx, id, p.gracePeriod)
+			if err != nil {
+				log.Println("Error purging person", id, err)
+				continue
+			}
+			if purged {
+				log.Println("Purged deleted person", id)
+			}
+		}
+		if next == 0 || ctx.Err() != nil {
+			return
+		}
+		cursor = next
+	}
+}
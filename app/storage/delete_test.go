@@ -0,0 +1,28 @@
+package storage
+
+import (
+	"context"
+	"go-microservice-assignment/app/tenant"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+func TestPurger_Sweep(t *testing.T) {
+	ctx := context.Background()
+	tenantCtx := mock.MatchedBy(func(ctx context.Context) bool {
+		return tenant.FromContext(ctx) == "acme"
+	})
+	mockDB := dbMock{}
+	mockDB.On("ListTenants", ctx).Return([]string{"acme"}, nil)
+	mockDB.On("ScanPersonIds", ctx, uint64(0), int64(purgeBatchSize)).Return([]string{"1", "2"}, uint64(7), nil)
+	mockDB.On("ScanPersonIds", ctx, uint64(7), int64(purgeBatchSize)).Return([]string{"3"}, uint64(0), nil)
+	mockDB.On("ScanPersonIds", tenantCtx, uint64(0), int64(purgeBatchSize)).Return([]string{"4"}, uint64(0), nil)
+	mockDB.On("PurgePerson", mock.Anything, mock.Anything, time.Hour).Return(false, nil)
+
+	NewPurger(&mockDB, time.Hour, time.Minute).Sweep(ctx)
+
+	mockDB.AssertNumberOfCalls(t, "PurgePerson", 4)
+	mockDB.AssertCalled(t, "PurgePerson", tenantCtx, "4", time.Hour)
+}
@@ -0,0 +1,424 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go-microservice-assignment/app/encryption"
+	"go-microservice-assignment/app/models"
+	"go-microservice-assignment/app/tenant"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const reencryptBatchSize = 100
+
+// record field holding encryption.Envelope of encrypted person
+const envelopeField = "encryption"
+
+// PIIFields are fields of person holding personal data, they are encrypted
+// when encryption is enabled
+var PIIFields = []string{"name", "address", "postalAddress", "dateOfBirth"}
+
+// SearchFields are PII fields persons can be searched by, structured address
+// is searched by its single-line form
+var SearchFields = []string{"name", "address", "dateOfBirth"}
+
+var (
+	ErrEncryptionDisabled = errors.New("person is encrypted, but encryption is not configured")
+	ErrUnsearchableField  = errors.New("persons cannot be searched by this field")
+)
+
+// WithEncryption encrypts PII fields of stored persons and their history and
+// keeps blind indexes of them, so persons can be searched without decrypting
+func WithEncryption(encryptor *encryption.FieldEncryptor) Option {
+	return func(d *db) {
+		d.encryptor = encryptor
+	}
+}
+
+// encodePerson returns person as stored in Redis
+func (d *db) encodePerson(p *models.Person) ([]byte, error) {
+	data, err := json.Marshal(p)
+	if err != nil || d.encryptor == nil {
+		return data, err
+	}
+	var record map[string]json.RawMessage
+	if err = json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	envelope, err := d.encryptor.Encrypt(record, PIIFields, p.Id)
+	if err != nil {
+		return nil, err
+	}
+	if record[envelopeField], err = json.Marshal(envelope); err != nil {
+		return nil, err
+	}
+	return json.Marshal(record)
+}
+
+// decodePerson reads person stored in Redis, which may still be in plaintext
+// when it was written before encryption was enabled
+func (d *db) decodePerson(data string) (*models.Person, error) {
+	var record map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(data), &record); err != nil {
+		return nil, err
+	}
+	var person models.Person
+	if _, encrypted := record[envelopeField]; !encrypted {
+		if err := json.Unmarshal([]byte(data), &person); err != nil {
+			return nil, err
+		}
+		return &person, nil
+	}
+	if d.encryptor == nil {
+		return nil, ErrEncryptionDisabled
+	}
+
+	var envelope encryption.Envelope
+	if err := json.Unmarshal(record[envelopeField], &envelope); err != nil {
+		return nil, err
+	}
+	var id string
+	if err := json.Unmarshal(record["id"], &id); err != nil {
+		return nil, err
+	}
+	if err := d.encryptor.Decrypt(record, &envelope, id); err != nil {
+		return nil, err
+	}
+	delete(record, envelopeField)
+	plaintext, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(plaintext, &person); err != nil {
+		return nil, err
+	}
+	return &person, nil
+}
+
+// storedVersion is PersonVersion as kept in history list, its person is encoded like current person
+type storedVersion struct {
+	Version   int             `json:"version"`
+	ValidFrom time.Time       `json:"validFrom"`
+	ValidTo   time.Time       `json:"validTo"`
+	Person    json.RawMessage `json:"person"`
+}
+
+func (d *db) encodeVersion(v *PersonVersion) ([]byte, error) {
+	person, err := d.encodePerson(&v.Person)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&storedVersion{
+		Version:   v.Version,
+		ValidFrom: v.ValidFrom,
+		ValidTo:   v.ValidTo,
+		Person:    person,
+	})
+}
+
+func (d *db) decodeVersion(data string) (*PersonVersion, error) {
+	var stored storedVersion
+	if err := json.Unmarshal([]byte(data), &stored); err != nil {
+		return nil, err
+	}
+	person, err := d.decodePerson(string(stored.Person))
+	if err != nil {
+		return nil, err
+	}
+	return &PersonVersion{
+		Version:   stored.Version,
+		ValidFrom: stored.ValidFrom,
+		ValidTo:   stored.ValidTo,
+		Person:    *person,
+	}, nil
+}
+
+// setPerson queues write of person and update of its blind indexes on the
+// given pipeline. before is the replaced person, nil when there is none.
+func (d *db) setPerson(ctx context.Context, pipe redis.Pipeliner, p *models.Person, before *models.Person) error {
+	data, err := d.encodePerson(p)
+	if err != nil {
+		return err
+	}
+	pipe.Set(ctx, personKey(ctx, p.Id), data, 0)
+	if before != nil {
+		d.unindexPerson(ctx, pipe, before)
+	}
+	indexOrder(ctx, pipe, p)
+	if d.encryptor != nil {
+		for field, value := range searchValues(p) {
+			pipe.SAdd(ctx, d.indexKey(ctx, field, value), p.Id)
+		}
+	}
+	return nil
+}
+
+// unindexPerson queues removal of person from order and blind indexes
+func (d *db) unindexPerson(ctx context.Context, pipe redis.Pipeliner, p *models.Person) {
+	unindexOrder(ctx, pipe, p.Id)
+	if d.encryptor == nil {
+		return
+	}
+	for field, value := range searchValues(p) {
+		pipe.SRem(ctx, d.indexKey(ctx, field, value), p.Id)
+	}
+}
+
+// indexKey is the set of ids of persons with normalized value of field
+func (d *db) indexKey(ctx context.Context, field string, value string) string {
+	return tenant.Key(ctx, "index:"+field+":"+d.encryptor.BlindIndex(field, value))
+}
+
+// FindPersons returns persons whose field equals value, ignoring case and
+// whitespace. Returns ErrUnsearchableField for fields not in SearchFields.
+func (d *db) FindPersons(ctx context.Context, field string, value string) ([]*models.Person, error) {
+	if !isSearchField(field) {
+		return nil, ErrUnsearchableField
+	}
+	if d.encryptor == nil {
+		persons, err := d.ListPersons(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return matchingPersons(persons, field, value), nil
+	}
+
+	ids, err := d.client.SMembers(ctx, d.indexKey(ctx, field, normalizeSearch(value))).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = personKey(ctx, id)
+	}
+	values, err := d.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	persons := make([]*models.Person, 0, len(values))
+	for _, value := range values {
+		// person expired after it was indexed
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		person, err := d.decodePerson(data)
+		if err != nil {
+			return nil, err
+		}
+		persons = append(persons, person)
+	}
+	// index is only a hint, persons are matched also by their decrypted value
+	return matchingPersons(persons, field, value), nil
+}
+
+// PersonMatches reports whether field of person equals value, ignoring case and whitespace
+func PersonMatches(p *models.Person, field string, value string) bool {
+	normalized := normalizeSearch(value)
+	return normalized != "" && searchValues(p)[field] == normalized
+}
+
+func matchingPersons(persons []*models.Person, field string, value string) []*models.Person {
+	var matching []*models.Person
+	for _, p := range persons {
+		if PersonMatches(p, field, value) {
+			matching = append(matching, p)
+		}
+	}
+	return matching
+}
+
+// isPIIField reports whether field, or field it is nested in, holds personal data
+func isPIIField(field string) bool {
+	return contains(PIIFields, strings.SplitN(field, ".", 2)[0])
+}
+
+func isSearchField(field string) bool {
+	return contains(SearchFields, field)
+}
+
+func contains(fields []string, field string) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// searchValues returns normalized non-empty values of SearchFields
+func searchValues(p *models.Person) map[string]string {
+	values := map[string]string{}
+	if name := normalizeSearch(p.Name); name != "" {
+		values["name"] = name
+	}
+	if address := normalizeSearch(p.Address); address != "" {
+		values["address"] = address
+	}
+	if dateOfBirth, err := p.DateOfBirth.MarshalText(); err == nil && dateOfBirth != "01/01/0001" {
+		values["dateOfBirth"] = dateOfBirth
+	}
+	return values
+}
+
+func normalizeSearch(value string) string {
+	return strings.ToLower(strings.Join(strings.Fields(value), " "))
+}
+
+// ReencryptPerson encrypts person and its history with the current key when
+// they are in plaintext or encrypted with an older key. Returns true when
+// person was re-encrypted.
+func (d *db) ReencryptPerson(ctx context.Context, id string) (bool, error) {
+	if d.encryptor == nil {
+		return false, ErrEncryptionDisabled
+	}
+	reencrypted := false
+	historyKey := getHistoryKey(ctx, id)
+
+	err := d.client.Watch(ctx, func(tx *redis.Tx) error {
+		data, err := tx.Get(ctx, personKey(ctx, id)).Result()
+		if err == redis.Nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		items, err := tx.LRange(ctx, historyKey, 0, -1).Result()
+		if err != nil {
+			return err
+		}
+		if !d.needsReencryption(data, items) {
+			return nil
+		}
+
+		person, err := d.decodePerson(data)
+		if err != nil {
+			return err
+		}
+		entries := make([]interface{}, len(items))
+		for i, item := range items {
+			version, err := d.decodeVersion(item)
+			if err != nil {
+				return err
+			}
+			if entries[i], err = d.encodeVersion(version); err != nil {
+				return err
+			}
+		}
+
+		trans := tx.TxPipeline()
+		// also indexes persons stored before encryption was enabled
+		if err = d.setPerson(ctx, trans, person, person); err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			trans.Del(ctx, historyKey)
+			trans.RPush(ctx, historyKey, entries...)
+		}
+		if _, err = trans.Exec(ctx); err != nil {
+			return err
+		}
+		reencrypted = true
+		return nil
+	}, personKey(ctx, id), historyKey)
+
+	return reencrypted, err
+}
+
+// needsReencryption reports whether person or any of its versions is not
+// encrypted with the current key
+func (d *db) needsReencryption(data string, history []string) bool {
+	current := d.encryptor.CurrentKeyId()
+	if keyIdOf(data) != current {
+		return true
+	}
+	for _, item := range history {
+		var stored storedVersion
+		if json.Unmarshal([]byte(item), &stored) != nil || keyIdOf(string(stored.Person)) != current {
+			return true
+		}
+	}
+	return false
+}
+
+// keyIdOf returns id of key that encrypted stored person, empty for plaintext
+func keyIdOf(data string) string {
+	var record struct {
+		Encryption *encryption.Envelope `json:"encryption"`
+	}
+	if json.Unmarshal([]byte(data), &record) != nil || record.Encryption == nil {
+		return ""
+	}
+	return record.Encryption.KeyId
+}
+
+// Reencryptor periodically re-encrypts persons not encrypted with the current
+// key, so old keys can be removed from keyring after rotation. Re-encryption
+// is transactional, so it can run on every replica.
+type Reencryptor struct {
+	db       RedisDB
+	interval time.Duration
+}
+
+func NewReencryptor(db RedisDB, interval time.Duration) *Reencryptor {
+	return &Reencryptor{
+		db:       db,
+		interval: interval,
+	}
+}
+
+// Run re-encrypts persons, right away and then every interval, until ctx is cancelled
+func (r *Reencryptor) Run(ctx context.Context) {
+	r.Sweep(ctx)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.Sweep(ctx)
+		}
+	}
+}
+
+// Sweep re-encrypts persons of all tenants
+func (r *Reencryptor) Sweep(ctx context.Context) {
+	if err := ForEachTenant(ctx, r.db, r.sweepTenant); err != nil {
+		log.Println("Error listing tenants for re-encryption:", err)
+	}
+}
+
+func (r *Reencryptor) sweepTenant(ctx context.Context) {
+	var cursor uint64
+	reencrypted := 0
+	for {
+		ids, next, err := r.db.ScanPersonIds(ctx, cursor, reencryptBatchSize)
+		if err != nil {
+			log.Println("Error scanning persons for re-encryption:", err)
+			return
+		}
+		for _, id := range ids {
+			done, err := r.db.ReencryptPerson(ctx, id)
+			if err != nil {
+				log.Println("Error re-encrypting person", id, err)
+				continue
+			}
+			if done {
+				reencrypted++
+			}
+		}
+		if next == 0 || ctx.Err() != nil {
+			break
+		}
+		cursor = next
+	}
+	if reencrypted > 0 {
+		log.Println("Re-encrypted", reencrypted, "persons")
+	}
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"encoding/base64"
+	"go-microservice-assignment/app/audit"
+	"go-microservice-assignment/app/encryption"
+	"go-microservice-assignment/app/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testEncryptor(t *testing.T, current string) *encryption.FieldEncryptor {
+	keyring, err := encryption.NewStaticKeyring(current, map[string]string{
+		"k1": base64.StdEncoding.EncodeToString([]byte(strings.Repeat("1", 32))),
+		"k2": base64.StdEncoding.EncodeToString([]byte(strings.Repeat("2", 32))),
+	}, base64.StdEncoding.EncodeToString([]byte(strings.Repeat("i", 32))))
+	assert.NoError(t, err)
+	return encryption.NewFieldEncryptor(keyring)
+}
+
+func TestEncodePerson(t *testing.T) {
+	d := &db{encryptor: testEncryptor(t, "k1")}
+	person := &models.Person{
+		Id:          "1",
+		Name:        "Jane Doe",
+		Address:     "Main St 1",
+		DateOfBirth: models.JSONDate(time.Date(1990, time.January, 2, 0, 0, 0, 0, time.UTC)),
+		Version:     3,
+	}
+
+	data, err := d.encodePerson(person)
+
+	assert.NoError(t, err)
+	for _, plaintext := range []string{"Jane", "Main", "1990"} {
+		assert.NotContains(t, string(data), plaintext)
+	}
+	assert.Equal(t, "k1", keyIdOf(string(data)))
+	decoded, err := d.decodePerson(string(data))
+	assert.NoError(t, err)
+	assert.Equal(t, person, decoded)
+
+	_, err = (&db{}).decodePerson(string(data))
+	assert.Equal(t, ErrEncryptionDisabled, err)
+}
+
+func TestDecodePerson_Plaintext(t *testing.T) {
+	d := &db{encryptor: testEncryptor(t, "k1")}
+
+	person, err := d.decodePerson(`{"id":"1","name":"Jane Doe","dateOfBirth":"02/01/1990"}`)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Jane Doe", person.Name)
+	assert.Equal(t, "", keyIdOf(`{"id":"1","name":"Jane Doe"}`))
+}
+
+func TestNeedsReencryption(t *testing.T) {
+	old := &db{encryptor: testEncryptor(t, "k1")}
+	d := &db{encryptor: testEncryptor(t, "k2")}
+	person := &models.Person{Id: "1", Name: "Jane Doe"}
+	current, _ := d.encodePerson(person)
+	version, _ := old.encodeVersion(&PersonVersion{Version: 1, Person: *person})
+
+	assert.False(t, d.needsReencryption(string(current), nil))
+	assert.True(t, d.needsReencryption(`{"id":"1","name":"Jane Doe"}`, nil))
+	assert.True(t, d.needsReencryption(string(current), []string{string(version)}))
+
+	// versions encrypted with retired key are still readable until re-encrypted
+	decoded, err := d.decodeVersion(string(version))
+	assert.NoError(t, err)
+	assert.Equal(t, "Jane Doe", decoded.Person.Name)
+}
+
+func TestPersonMatches(t *testing.T) {
+	person := &models.Person{
+		Name:        "Jane  Doe",
+		DateOfBirth: models.JSONDate(time.Date(1990, time.January, 2, 0, 0, 0, 0, time.UTC)),
+	}
+
+	assert.True(t, PersonMatches(person, "name", " jane doe"))
+	assert.True(t, PersonMatches(person, "dateOfBirth", "02/01/1990"))
+	assert.False(t, PersonMatches(person, "name", "jane"))
+	assert.False(t, PersonMatches(person, "address", ""))
+}
+
+func TestRedactPII(t *testing.T) {
+	changes := []audit.FieldChange{
+		{Field: "name", Old: "Jane", New: "Joan"},
+		{Field: "version", Old: 1.0, New: 2.0},
+		{Field: "postalAddress.city", Old: "Berlin", New: "Hamburg"},
+	}
+
+	redactPII(changes)
+
+	assert.Nil(t, changes[0].Old)
+	assert.Nil(t, changes[0].New)
+	assert.Equal(t, 2.0, changes[1].New)
+	assert.Nil(t, changes[2].New)
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"go-microservice-assignment/app/audit"
+	"go-microservice-assignment/app/tenant"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const eraseScanBatchSize = 500
+
+var ErrLegalHold = errors.New("person is under legal hold")
+
+// Erasure tells what ErasePerson removed
+type Erasure struct {
+	// current or soft deleted record was stored
+	Record          bool `json:"record"`
+	HistoryVersions int  `json:"historyVersions"`
+	Events          int  `json:"events"`
+	// audit entries are kept with values of personal data fields redacted
+	AuditEntries int `json:"auditEntries"`
+}
+
+// Empty reports whether nothing was stored about the person
+func (e *Erasure) Empty() bool {
+	return !e.Record && e.HistoryVersions == 0 && e.Events == 0 && e.AuditEntries == 0
+}
+
+// ErasePerson removes person together with its history, retention policy,
+// blind index entries and change events and redacts personal data in its
+// audit entries, all in one transaction. Erasure itself is recorded as person.erased event without
+// personal data. Returns ErrLegalHold when person is under legal hold.
+func (d *db) ErasePerson(ctx context.Context, id string) (*Erasure, error) {
+	var erasure *Erasure
+	historyKey := getHistoryKey(ctx, id)
+	auditKey := audit.PersonStream(tenant.FromContext(ctx), id)
+
+	err := d.client.Watch(ctx, func(tx *redis.Tx) error {
+		policy, err := readRetention(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if policy.LegalHold {
+			return ErrLegalHold
+		}
+		person, err := d.readPerson(ctx, tx, id)
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		versions, err := tx.LLen(ctx, historyKey).Result()
+		if err != nil {
+			return err
+		}
+		eventIds, err := d.personEventIds(ctx, id)
+		if err != nil {
+			return err
+		}
+		entries, err := d.personAuditEntries(ctx, id)
+		if err != nil {
+			return err
+		}
+		since, err := tx.Get(ctx, audit.IndexedSinceKey).Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		erasure = &Erasure{
+			Record:          person != nil,
+			HistoryVersions: int(versions),
+			Events:          len(eventIds),
+			AuditEntries:    len(entries),
+		}
+		if erasure.Empty() {
+			return nil
+		}
+
+		trans := tx.TxPipeline()
+		trans.Del(ctx, personKey(ctx, id), getExpireKey(ctx, id), getRetentionKey(ctx, id), getRetentionAuditKey(ctx, id), historyKey, getVersionKey(ctx, id))
+		if person != nil {
+			d.unindexPerson(ctx, trans, person)
+		}
+		if len(eventIds) > 0 {
+			trans.XDel(ctx, PersonEventsStream, eventIds...)
+		}
+		err = audit.Redact(ctx, trans, tenant.FromContext(ctx), id, entries, since, func(e *audit.Entry) bool {
+			return redactPII(e.Changes)
+		})
+		if err != nil {
+			return err
+		}
+		// publish change event in the same transaction
+		if err = d.appendEvent(ctx, trans, EventPersonErased, id, nil, nil); err != nil {
+			return err
+		}
+		_, err = trans.Exec(ctx)
+		return err
+	}, personKey(ctx, id), getRetentionKey(ctx, id), historyKey, auditKey)
+
+	if err != nil {
+		return nil, err
+	}
+	return erasure, nil
+}
+
+// personEventIds returns ids of change events of person in tenant of the context
+func (d *db) personEventIds(ctx context.Context, id string) ([]string, error) {
+	var ids []string
+	start := "-"
+	for {
+		messages, err := d.client.XRangeN(ctx, PersonEventsStream, start, "+", eraseScanBatchSize).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range messages {
+			// range start is inclusive, last message of previous batch is returned again
+			if msg.ID == start {
+				continue
+			}
+			if stringValue(msg.Values, "personId") == id && stringValue(msg.Values, "tenant") == tenant.FromContext(ctx) {
+				ids = append(ids, msg.ID)
+			}
+		}
+		if len(messages) < eraseScanBatchSize {
+			return ids, nil
+		}
+		start = messages[len(messages)-1].ID
+	}
+}
+
+// personAuditEntries returns audit entries of person in tenant of the context
+func (d *db) personAuditEntries(ctx context.Context, id string) ([]*audit.Entry, error) {
+	var entries []*audit.Entry
+	filter := audit.Filter{Tenant: tenant.FromContext(ctx), PersonId: id}
+	err := audit.NewRedisStore(d.client).Query(ctx, filter, func(e *audit.Entry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	return entries, err
+}
@@ -0,0 +1,255 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"go-microservice-assignment/app/audit"
+	"go-microservice-assignment/app/models"
+	"go-microservice-assignment/app/tenant"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// PersonEventsStream is the Redis Stream where person change events are appended
+const PersonEventsStream = "person-events"
+
+const defaultEventStreamMaxLen = 10000
+
+type EventType string
+
+const (
+	EventPersonCreated   EventType = "person.created"
+	EventPersonUpdated   EventType = "person.updated"
+	EventPersonDeleted   EventType = "person.deleted"
+	EventPersonExpired   EventType = "person.expired"
+	EventPersonRestored  EventType = "person.restored"
+	EventPersonUndeleted EventType = "person.undeleted"
+	EventPersonPurged    EventType = "person.purged"
+	EventPersonErased    EventType = "person.erased"
+)
+
+// PersonEvent describes a single change of a person. Before is empty for
+// created events and After is empty for deleted events, both are empty when
+// personal data is encrypted, so it is not kept in plaintext in the stream.
+// Owner is the user who created the person and Changes are names of changed
+// fields.
+type PersonEvent struct {
+	Id       string         `json:"id"`
+	Type     EventType      `json:"type"`
+	PersonId string         `json:"personId"`
+	Tenant   string         `json:"tenant,omitempty"`
+	Owner    string         `json:"owner,omitempty"`
+	Changes  []string       `json:"changes,omitempty"`
+	Before   *models.Person `json:"before,omitempty"`
+	After    *models.Person `json:"after,omitempty"`
+	Time     time.Time      `json:"time"`
+}
+
+// Option configures optional behaviour of the storage created by NewDB
+type Option func(*db)
+
+// WithEventStreamMaxLen sets approximate maximum length of the person events stream
+func WithEventStreamMaxLen(maxLen int64) Option {
+	return func(d *db) {
+		d.eventStreamMaxLen = maxLen
+	}
+}
+
+// WithAuditStreamMaxLen sets approximate maximum length of the audit stream, 0 keeps all entries
+func WithAuditStreamMaxLen(maxLen int64) Option {
+	return func(d *db) {
+		d.auditStreamMaxLen = maxLen
+	}
+}
+
+// appendEvent queues person event and its audit entry on the given pipeline,
+// so they are written in the same transaction as the change itself
+func (d *db) appendEvent(ctx context.Context, pipe redis.Pipeliner, eventType EventType, personId string, before, after *models.Person) error {
+	changes, err := audit.Diff(before, after)
+	if err != nil {
+		return err
+	}
+	values := map[string]interface{}{
+		"type":     string(eventType),
+		"personId": personId,
+		"time":     time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	if id := tenant.FromContext(ctx); id != tenant.Default {
+		values["tenant"] = id
+	}
+	if after != nil && after.CreatedBy != "" {
+		values["owner"] = after.CreatedBy
+	} else if before != nil && before.CreatedBy != "" {
+		values["owner"] = before.CreatedBy
+	}
+	if len(changes) > 0 {
+		fields := make([]string, len(changes))
+		for i, change := range changes {
+			fields[i] = change.Field
+		}
+		data, err := json.Marshal(fields)
+		if err != nil {
+			return err
+		}
+		values["changes"] = string(data)
+	}
+	// persons are only carried in plaintext when they are stored in plaintext
+	if before != nil && d.encryptor == nil {
+		data, err := json.Marshal(before)
+		if err != nil {
+			return err
+		}
+		values["before"] = string(data)
+	}
+	if after != nil && d.encryptor == nil {
+		data, err := json.Marshal(after)
+		if err != nil {
+			return err
+		}
+		values["after"] = string(data)
+	}
+
+	if err = d.appendAudit(ctx, pipe, eventType, personId, changes); err != nil {
+		return err
+	}
+	pipe.XAdd(ctx, &redis.XAddArgs{
+		Stream: PersonEventsStream,
+		MaxLen: d.eventStreamMaxLen,
+		Approx: true,
+		Values: values,
+	})
+	return nil
+}
+
+// appendAudit records who changed which fields of person, taking actor and
+// request details from the context
+func (d *db) appendAudit(ctx context.Context, pipe redis.Pipeliner, eventType EventType, personId string, changes []audit.FieldChange) error {
+	if d.encryptor != nil {
+		redactPII(changes)
+	}
+	// tenant is taken from context also for changes made outside of a request
+	metadata := audit.FromContext(ctx)
+	metadata.Tenant = tenant.FromContext(ctx)
+	return audit.Append(ctx, pipe, &audit.Entry{
+		Time:     time.Now().UTC(),
+		Action:   string(eventType),
+		PersonId: personId,
+		Changes:  changes,
+		Metadata: metadata,
+	}, d.auditStreamMaxLen)
+}
+
+// redactPII drops values of changed PII fields, so audit log does not keep
+// in plaintext what is encrypted in person records, reports whether any
+// value was dropped
+func redactPII(changes []audit.FieldChange) bool {
+	redacted := false
+	for i := range changes {
+		if isPIIField(changes[i].Field) && (changes[i].Old != nil || changes[i].New != nil) {
+			changes[i].Old, changes[i].New = nil, nil
+			redacted = true
+		}
+	}
+	return redacted
+}
+
+// ParsePersonEvent converts Redis stream message to PersonEvent
+func ParsePersonEvent(msg redis.XMessage) (*PersonEvent, error) {
+	event := PersonEvent{
+		Id:       msg.ID,
+		Type:     EventType(stringValue(msg.Values, "type")),
+		PersonId: stringValue(msg.Values, "personId"),
+		Tenant:   stringValue(msg.Values, "tenant"),
+	}
+	if t := stringValue(msg.Values, "time"); t != "" {
+		parsed, err := time.Parse(time.RFC3339Nano, t)
+		if err != nil {
+			return nil, err
+		}
+		event.Time = parsed
+	}
+	if changes := stringValue(msg.Values, "changes"); changes != "" {
+		if err := json.Unmarshal([]byte(changes), &event.Changes); err != nil {
+			return nil, err
+		}
+	}
+	if before := stringValue(msg.Values, "before"); before != "" {
+		if err := json.Unmarshal([]byte(before), &event.Before); err != nil {
+			return nil, err
+		}
+	}
+	if after := stringValue(msg.Values, "after"); after != "" {
+		if err := json.Unmarshal([]byte(after), &event.After); err != nil {
+			return nil, err
+		}
+	}
+	event.Owner = stringValue(msg.Values, "owner")
+	// events written before owner was recorded carry it only in persons
+	if event.Owner == "" && event.After != nil {
+		event.Owner = event.After.CreatedBy
+	} else if event.Owner == "" && event.Before != nil {
+		event.Owner = event.Before.CreatedBy
+	}
+	return &event, nil
+}
+
+func stringValue(values map[string]interface{}, key string) string {
+	if v, ok := values[key].(string); ok {
+		return v
+	}
+	return ""
+}
+
+// EventLog gives read access to the durable stream of person events
+type EventLog interface {
+	// LastEventId returns id of the newest event or "0-0" when there are none
+	LastEventId(ctx context.Context) (string, error)
+	// ReadEvents returns up to count events after the given id, waiting at most block for new ones
+	ReadEvents(ctx context.Context, afterId string, count int64, block time.Duration) ([]PersonEvent, error)
+}
+
+type eventLog struct {
+	client *redis.Client
+}
+
+func NewEventLog(client *redis.Client) EventLog {
+	return &eventLog{client}
+}
+
+func (l *eventLog) LastEventId(ctx context.Context) (string, error) {
+	messages, err := l.client.XRevRangeN(ctx, PersonEventsStream, "+", "-", 1).Result()
+	if err != nil {
+		return "", err
+	}
+	if len(messages) == 0 {
+		return "0-0", nil
+	}
+	return messages[0].ID, nil
+}
+
+func (l *eventLog) ReadEvents(ctx context.Context, afterId string, count int64, block time.Duration) ([]PersonEvent, error) {
+	streams, err := l.client.XRead(ctx, &redis.XReadArgs{
+		Streams: []string{PersonEventsStream, afterId},
+		Count:   count,
+		Block:   block,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var events []PersonEvent
+	for _, stream := range streams {
+		for _, msg := range stream.Messages {
+			event, err := ParsePersonEvent(msg)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, *event)
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePersonEvent(t *testing.T) {
+	msg := redis.XMessage{
+		ID: "1-0",
+		Values: map[string]interface{}{
+			"type":     "person.updated",
+			"personId": "123",
+			"owner":    "jane",
+			"changes":  `["address","name"]`,
+			"time":     "2021-09-01T10:00:00Z",
+		},
+	}
+
+	msg.Values["before"] = `{"id":"123","name":"Test123","createdBy":"jane"}`
+	msg.Values["after"] = `{"id":"123","name":"Test456","createdBy":"jane"}`
+
+	event, err := ParsePersonEvent(msg)
+	assert.NoError(t, err)
+	assert.Equal(t, "1-0", event.Id)
+	assert.Equal(t, EventPersonUpdated, event.Type)
+	assert.Equal(t, "123", event.PersonId)
+	assert.Equal(t, "jane", event.Owner)
+	assert.Equal(t, []string{"address", "name"}, event.Changes)
+	assert.Equal(t, "Test123", event.Before.Name)
+	assert.Equal(t, "Test456", event.After.Name)
+}
+
+func TestParsePersonEvent_Legacy(t *testing.T) {
+	msg := redis.XMessage{
+		ID: "1-0",
+		Values: map[string]interface{}{
+			"type":     "person.created",
+			"personId": "123",
+			"after":    `{"id":"123","name":"Test123","createdBy":"jane"}`,
+		},
+	}
+
+	event, err := ParsePersonEvent(msg)
+	assert.NoError(t, err)
+	assert.Equal(t, "jane", event.Owner)
+	assert.Empty(t, event.Changes)
+	assert.Equal(t, "Test123", event.After.Name)
+}
@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"go-microservice-assignment/app/models"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const defaultHistoryMaxLen = 50
+
+var ErrVersionNotFound = errors.New("person version not found")
+
+// PersonVersion is a prior version of person, valid from ValidFrom until ValidTo
+type PersonVersion struct {
+	Version   int           `json:"version"`
+	ValidFrom time.Time     `json:"validFrom"`
+	ValidTo   time.Time     `json:"validTo"`
+	Person    models.Person `json:"person"`
+}
+
+// WithHistoryMaxLen sets how many prior versions are kept per person
+func WithHistoryMaxLen(maxLen int64) Option {
+	return func(d *db) {
+		d.historyMaxLen = maxLen
+	}
+}
+
+// startHistory queues version tracking of newly created person on the given pipeline
+func startHistory(ctx context.Context, pipe redis.Pipeliner, id string, now time.Time) {
+	pipe.HSet(ctx, getVersionKey(ctx, id), "version", 1, "since", now.UTC().Format(time.RFC3339Nano))
+}
+
+// appendHistory queues storing of replaced person version on the given
+// pipeline, so it is written in the same transaction as the update itself.
+// Returns number of the new version.
+func (d *db) appendHistory(ctx context.Context, c redis.Cmdable, pipe redis.Pipeliner, before *models.Person, now time.Time) (int, error) {
+	version, since, err := currentVersion(ctx, c, before.Id)
+	if err != nil {
+		return 0, err
+	}
+	if err = d.queueHistory(ctx, pipe, before, version, since, now); err != nil {
+		return 0, err
+	}
+	return version + 1, nil
+}
+
+// queueHistory queues storing of replaced person version whose number and
+// start of validity are already known
+func (d *db) queueHistory(ctx context.Context, pipe redis.Pipeliner, before *models.Person, version int, since time.Time, now time.Time) error {
+	entry, err := d.encodeVersion(&PersonVersion{
+		Version:   version,
+		ValidFrom: since,
+		ValidTo:   now.UTC(),
+		Person:    *before,
+	})
+	if err != nil {
+		return err
+	}
+
+	historyKey := getHistoryKey(ctx, before.Id)
+	pipe.LPush(ctx, historyKey, entry)
+	pipe.LTrim(ctx, historyKey, 0, d.historyMaxLen-1)
+	pipe.HSet(ctx, getVersionKey(ctx, before.Id), "version", version+1, "since", now.UTC().Format(time.RFC3339Nano))
+	return nil
+}
+
+// GetHistory returns prior versions of person, newest first
+func (d *db) GetHistory(ctx context.Context, id string) ([]PersonVersion, error) {
+	items, err := d.client.LRange(ctx, getHistoryKey(ctx, id), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]PersonVersion, 0, len(items))
+	for _, item := range items {
+		version, err := d.decodeVersion(item)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, *version)
+	}
+	return versions, nil
+}
+
+// GetPersonAsOf returns person as it was at the given time. Returns
+// ErrVersionNotFound when person did not exist then or the version is no
+// longer kept in history.
+func (d *db) GetPersonAsOf(ctx context.Context, id string, asOf time.Time) (*models.Person, error) {
+	person, err := d.GetPerson(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	_, since, err := currentVersion(ctx, d.client, id)
+	if err != nil {
+		return nil, err
+	}
+	if !asOf.Before(since) {
+		return person, nil
+	}
+
+	history, err := d.GetHistory(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	for _, version := range history {
+		if !asOf.Before(version.ValidFrom) && asOf.Before(version.ValidTo) {
+			return &version.Person, nil
+		}
+	}
+	return nil, ErrVersionNotFound
+}
+
+// RevertPerson makes data of given prior version the current version of person
+func (d *db) RevertPerson(ctx context.Context, id string, version int) (*models.Person, error) {
+	var reverted *models.Person
+
+	err := d.client.Watch(ctx, func(tx *redis.Tx) error {
+		personString, err := tx.Get(ctx, personKey(ctx, id)).Result()
+		if err != nil {
+			return err
+		}
+		before, err := d.decodePerson(personString)
+		if err != nil {
+			return err
+		}
+		if before.DeletedAt != nil {
+			return ErrPersonDeleted
+		}
+
+		history, err := d.GetHistory(ctx, id)
+		if err != nil {
+			return err
+		}
+		var target *models.Person
+		for i := range history {
+			if history[i].Version == version {
+				target = &history[i].Person
+				break
+			}
+		}
+		if target == nil {
+			return ErrVersionNotFound
+		}
+		target.Id = id
+		// tombstone kept in history by undelete holds its deletion time, reverting
+		// restores data of the version and never deletes the person
+		target.DeletedAt = nil
+
+		now := time.Now()
+		idleTime, err := d.personIdleTime(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		trans := tx.TxPipeline()
+		newVersion, err := d.appendHistory(ctx, tx, trans, before, now)
+		if err != nil {
+			return err
+		}
+		// reverted data is a new version, creation metadata stays
+		target.CreatedAt = before.CreatedAt
+		target.CreatedBy = before.CreatedBy
+		stampUpdated(target, newVersion, now)
+		if err = d.setPerson(ctx, trans, target, before); err != nil {
+			return err
+		}
+		trans.Set(ctx, getExpireKey(ctx, id), now, idleTime)
+		// publish change event in the same transaction
+		if err = d.appendEvent(ctx, trans, EventPersonUpdated, id, before, target); err != nil {
+			return err
+		}
+		if _, err = trans.Exec(ctx); err != nil {
+			return err
+		}
+		reverted = target
+		return nil
+	}, personKey(ctx, id), getVersionKey(ctx, id))
+
+	return reverted, err
+}
+
+// stampUpdated sets metadata of person written as the given version
+func stampUpdated(p *models.Person, version int, now time.Time) {
+	p.UpdatedAt = now.UTC()
+	p.Version = version
+}
+
+// currentVersion returns number of current person version and time since it
+// is valid. Persons created before versioning start at version 1 valid since ever.
+func currentVersion(ctx context.Context, c redis.Cmdable, id string) (int, time.Time, error) {
+	values, err := c.HGetAll(ctx, getVersionKey(ctx, id)).Result()
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return parseVersion(values)
+}
+
+func parseVersion(values map[string]string) (int, time.Time, error) {
+	var err error
+	version := 1
+	if v, ok := values["version"]; ok {
+		if version, err = strconv.Atoi(v); err != nil {
+			return 0, time.Time{}, err
+		}
+	}
+	var since time.Time
+	if s, ok := values["since"]; ok {
+		if since, err = time.Parse(time.RFC3339Nano, s); err != nil {
+			return 0, time.Time{}, err
+		}
+	}
+	return version, since, nil
+}
+
+func getHistoryKey(ctx context.Context, id string) string {
+	return personKey(ctx, id) + "_history"
+}
+
+func getVersionKey(ctx context.Context, id string) string {
+	return personKey(ctx, id) + "_version"
+}
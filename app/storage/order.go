@@ -0,0 +1,250 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"go-microservice-assignment/app/models"
+	"go-microservice-assignment/app/tenant"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	indexedOrdersKey  = "persons:indexed"
+	backfillBatchSize = 100
+)
+
+// OrderFields are fields by which persons are kept ordered in sorted sets,
+// so their pages are read without loading all persons
+var OrderFields = []string{"createdAt", "updatedAt"}
+
+// ErrOrderNotIndexed is returned while persons of tenant are not all in order indexes yet
+var ErrOrderNotIndexed = errors.New("persons are not indexed yet")
+
+// PageQuery selects page of persons ordered by one of OrderFields
+type PageQuery struct {
+	Order          string
+	Descending     bool
+	Offset         int
+	Limit          int
+	IncludeDeleted bool
+}
+
+// orderKey is sorted set of ids of persons scored by Unix milliseconds of
+// field, separately for persons that are not deleted and for all stored persons
+func orderKey(ctx context.Context, field string, withDeleted bool) string {
+	if withDeleted {
+		return tenant.Key(ctx, "persons:all:"+field)
+	}
+	return tenant.Key(ctx, "persons:"+field)
+}
+
+func orderValue(p *models.Person, field string) time.Time {
+	if field == "createdAt" {
+		return p.CreatedAt
+	}
+	return p.UpdatedAt
+}
+
+// indexOrder queues adding person to order indexes, replacing its old scores
+func indexOrder(ctx context.Context, pipe redis.Pipeliner, p *models.Person) {
+	for _, field := range OrderFields {
+		member := &redis.Z{Score: float64(orderValue(p, field).UnixNano() / int64(time.Millisecond)), Member: p.Id}
+		pipe.ZAdd(ctx, orderKey(ctx, field, true), member)
+		if p.DeletedAt == nil {
+			pipe.ZAdd(ctx, orderKey(ctx, field, false), member)
+		} else {
+			pipe.ZRem(ctx, orderKey(ctx, field, false), p.Id)
+		}
+	}
+}
+
+// unindexOrder queues removal of person from order indexes
+func unindexOrder(ctx context.Context, pipe redis.Pipeliner, id string) {
+	for _, field := range OrderFields {
+		pipe.ZRem(ctx, orderKey(ctx, field, true), id)
+		pipe.ZRem(ctx, orderKey(ctx, field, false), id)
+	}
+}
+
+// ListPersonsPage returns page of persons in order of query and number of all
+// persons the page is taken from. Returns ErrOrderNotIndexed until
+// BackfillOrders indexed persons stored before the indexes existed.
+func (d *db) ListPersonsPage(ctx context.Context, query PageQuery) ([]*models.Person, int, error) {
+	indexed, err := d.client.Exists(ctx, tenant.Key(ctx, indexedOrdersKey)).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	if indexed == 0 {
+		return nil, 0, ErrOrderNotIndexed
+	}
+
+	key := orderKey(ctx, query.Order, query.IncludeDeleted)
+	start, stop := int64(query.Offset), int64(query.Offset+query.Limit-1)
+	var ids []string
+	if query.Descending {
+		ids, err = d.client.ZRevRange(ctx, key, start, stop).Result()
+	} else {
+		ids, err = d.client.ZRange(ctx, key, start, stop).Result()
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	total, err := d.client.ZCard(ctx, key).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	persons := []*models.Person{}
+	if len(ids) == 0 {
+		return persons, int(total), nil
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = personKey(ctx, id)
+	}
+	values, err := d.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, value := range values {
+		// person removed between ZRANGE and MGET
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		person, err := d.decodePerson(data)
+		if err != nil {
+			return nil, 0, err
+		}
+		persons = append(persons, person)
+	}
+	return persons, int(total), nil
+}
+
+// BackfillOrder adds person stored before order indexes existed to them.
+// Persons stored before their metadata was tracked get createdAt and
+// updatedAt of their last change, version and history are left unchanged.
+// Returns true when metadata of person was set.
+func (d *db) BackfillOrder(ctx context.Context, id string) (bool, error) {
+	stamped := false
+	err := d.client.Watch(ctx, func(tx *redis.Tx) error {
+		person, err := d.readPerson(ctx, tx, id)
+		if err == redis.Nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		stamped = person.CreatedAt.IsZero() || person.UpdatedAt.IsZero()
+		if stamped {
+			changed, err := lastChange(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if person.UpdatedAt.IsZero() {
+				person.UpdatedAt = changed
+			}
+			if person.CreatedAt.IsZero() {
+				person.CreatedAt = person.UpdatedAt
+			}
+		}
+
+		trans := tx.TxPipeline()
+		if stamped {
+			if err = d.setPerson(ctx, trans, person, person); err != nil {
+				return err
+			}
+		} else {
+			indexOrder(ctx, trans, person)
+		}
+		_, err = trans.Exec(ctx)
+		return err
+	}, personKey(ctx, id))
+
+	return stamped, err
+}
+
+// lastChange is time of the last write of person, kept as value of its expire
+// key, or now when the key is gone
+func lastChange(ctx context.Context, c redis.Cmdable, id string) (time.Time, error) {
+	value, err := c.Get(ctx, getExpireKey(ctx, id)).Result()
+	if err == redis.Nil {
+		return time.Now().UTC(), nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	changed, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Now().UTC(), nil
+	}
+	return changed.UTC(), nil
+}
+
+// OrderBackfiller adds persons of all tenants stored before order indexes
+// existed to them. Backfill is transactional and idempotent, so it can run on
+// every replica. Tenants are marked as indexed when all their persons were
+// added, lists of persons are served from the indexes only after that.
+type OrderBackfiller struct {
+	db     RedisDB
+	client *redis.Client
+}
+
+func NewOrderBackfiller(db RedisDB, client *redis.Client) *OrderBackfiller {
+	return &OrderBackfiller{db: db, client: client}
+}
+
+// Run backfills indexes of all tenants not marked as indexed once
+func (b *OrderBackfiller) Run(ctx context.Context) {
+	if err := ForEachTenant(ctx, b.db, b.backfillTenant); err != nil {
+		log.Println("Error listing tenants for order backfill:", err)
+	}
+}
+
+func (b *OrderBackfiller) backfillTenant(ctx context.Context) {
+	indexed, err := b.client.Exists(ctx, tenant.Key(ctx, indexedOrdersKey)).Result()
+	if err != nil {
+		log.Println("Error checking order indexes:", err)
+		return
+	}
+	if indexed > 0 {
+		return
+	}
+
+	var cursor uint64
+	added, stamped := 0, 0
+	for {
+		ids, next, err := b.db.ScanPersonIds(ctx, cursor, backfillBatchSize)
+		if err != nil {
+			log.Println("Error scanning persons for order backfill:", err)
+			return
+		}
+		for _, id := range ids {
+			done, err := b.db.BackfillOrder(ctx, id)
+			if err != nil {
+				// tenant stays unmarked and is backfilled again on next start
+				log.Println("Error backfilling order of person", id, err)
+				return
+			}
+			added++
+			if done {
+				stamped++
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	if err = b.client.Set(ctx, tenant.Key(ctx, indexedOrdersKey), time.Now().UTC(), 0).Err(); err != nil {
+		log.Println("Error marking persons as indexed:", err)
+		return
+	}
+	log.Println("Indexed order of", added, "persons,", stamped, "got missing metadata")
+}
@@ -2,94 +2,166 @@ package storage
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"github.com/go-redis/redis/v8"
 	"github.com/go-redsync/redsync/v4"
+	"go-microservice-assignment/app/audit"
+	"go-microservice-assignment/app/encryption"
 	"go-microservice-assignment/app/models"
+	"go-microservice-assignment/app/tenant"
+	"strings"
 	"time"
 )
 
 type db struct {
 	client *redis.Client
 	mutex *redsync.Mutex
+	redsync *redsync.Redsync
 	expireTimeInMinutes time.Duration
+	tenantIdleTimes map[string]time.Duration
+	eventStreamMaxLen int64
+	historyMaxLen int64
+	auditStreamMaxLen int64
+	encryptor *encryption.FieldEncryptor
 }
 
+const listBatchSize = 500
+
+var ErrPersonExists = errors.New("person already exists")
+
 type RedisDB interface {
 	CreatePerson(ctx context.Context, p *models.Person) error
 	GetPerson(ctx context.Context, id string) (*models.Person, error)
 	UpdatePersonOptimistic(ctx context.Context, p *models.Person) (*models.Person, error)
 	UpdatePersonPessimistic(ctx context.Context, p *models.Person) (*models.Person, error)
+	ExpirePerson(ctx context.Context, id string, archive func(p *models.Person) error) (bool, error)
+	ScanPersonIds(ctx context.Context, cursor uint64, count int64) ([]string, uint64, error)
+	RestorePerson(ctx context.Context, id string, load func(id string) (*models.Person, error)) (*models.Person, error)
+	TouchPerson(ctx context.Context, id string) error
+	GetPersonTTL(ctx context.Context, id string) (time.Duration, error)
+	GetRetention(ctx context.Context, id string) (*RetentionPolicy, error)
+	SetRetention(ctx context.Context, id string, policy *RetentionPolicy, actor string, reason string) error
+	ListRetentionAudit(ctx context.Context, id string) ([]RetentionAudit, error)
+	GetHistory(ctx context.Context, id string) ([]PersonVersion, error)
+	GetPersonAsOf(ctx context.Context, id string, asOf time.Time) (*models.Person, error)
+	RevertPerson(ctx context.Context, id string, version int) (*models.Person, error)
+	ListPersons(ctx context.Context) ([]*models.Person, error)
+	ListPersonsPage(ctx context.Context, query PageQuery) ([]*models.Person, int, error)
+	ScanPersons(ctx context.Context, cursor uint64, count int64) ([]*models.Person, uint64, error)
+	DeletePerson(ctx context.Context, id string) error
+	UndeletePerson(ctx context.Context, id string) (*models.Person, error)
+	PurgePerson(ctx context.Context, id string, gracePeriod time.Duration) (bool, error)
+	ExecuteBatch(ctx context.Context, ops []BatchOperation, atomic bool) ([]BatchResult, error)
+	ListTenants(ctx context.Context) ([]string, error)
+	FindPersons(ctx context.Context, field string, value string) ([]*models.Person, error)
+	ReencryptPerson(ctx context.Context, id string) (bool, error)
+	MigrateAddress(ctx context.Context, id string, defaultCountry string) (bool, error)
+	BackfillOrder(ctx context.Context, id string) (bool, error)
+	ErasePerson(ctx context.Context, id string) (*Erasure, error)
 }
 
-func NewDB(client *redis.Client, mutex *redsync.Mutex, expireTimeInMinutes time.Duration) RedisDB {
-	return &db{client, mutex,expireTimeInMinutes}
+func NewDB(client *redis.Client, mutex *redsync.Mutex, expireTimeInMinutes time.Duration, options ...Option) RedisDB {
+	d := &db{
+		client: client,
+		mutex: mutex,
+		expireTimeInMinutes: expireTimeInMinutes,
+		eventStreamMaxLen: defaultEventStreamMaxLen,
+		historyMaxLen: defaultHistoryMaxLen,
+		tenantIdleTimes: map[string]time.Duration{},
+	}
+	for _, option := range options {
+		option(d)
+	}
+	return d
 }
 
 func (d *db) CreatePerson(ctx context.Context, p *models.Person) error {
-	expireKey := getExpireKey(p.Id)
+	expireKey := getExpireKey(ctx, p.Id)
 	created := time.Now()
+	idleTime, err := d.personIdleTime(ctx, d.client, p.Id)
+	if err != nil {
+		return err
+	}
+
+	// server-managed metadata overrides anything sent by client
+	p.CreatedAt = created.UTC()
+	p.CreatedBy = audit.FromContext(ctx).Actor
+	stampUpdated(p, 1, created)
 
 	trans := d.client.TxPipeline()
 	// insert person with person.Id as key
-	trans.Set(ctx, p.Id, p, 0)
+	if err = d.setPerson(ctx, trans, p, nil); err != nil {
+		return err
+	}
 	// also insert key with updated date and expiration
-	trans.Set(ctx, expireKey, created, d.expireTimeInMinutes)
-	_, err := trans.Exec(ctx)
+	trans.Set(ctx, expireKey, created, idleTime)
+	startHistory(ctx, trans, p.Id, created)
+	registerTenant(ctx, trans)
+	// publish change event in the same transaction
+	if err = d.appendEvent(ctx, trans, EventPersonCreated, p.Id, nil, p); err != nil {
+		return err
+	}
+	_, err = trans.Exec(ctx)
 
 	return err
 }
 
 func (d *db) GetPerson(ctx context.Context, id string) (*models.Person, error) {
-	var person models.Person
-	res, err := d.client.Get(ctx, id).Result()
+	res, err := d.client.Get(ctx, personKey(ctx, id)).Result()
 	if err != nil {
 		return nil, err
 	}
-	err = json.Unmarshal([]byte(res), &person)
-	if err != nil {
-		return nil, err
-	}
-	return &person, nil
+	return d.decodePerson(res)
 }
 
 func (d *db) UpdatePersonOptimistic(ctx context.Context, p *models.Person) (*models.Person, error) {
 	var modifiedPerson *models.Person
 
 	err := d.client.Watch(ctx, func(tx *redis.Tx) error {
-		personString, err := tx.Get(ctx, p.Id).Result()
+		personString, err := tx.Get(ctx, personKey(ctx, p.Id)).Result()
 		if err != nil && err != redis.Nil {
 			return err
 		}
-		err = json.Unmarshal([]byte(personString), &modifiedPerson)
+		modifiedPerson, err = d.decodePerson(personString)
 		if err != nil {
 			return err
 		}
+		if modifiedPerson.DeletedAt != nil {
+			return ErrPersonDeleted
+		}
+		before := *modifiedPerson
 
 		// update person's data
-		if p.Name != "" {
-			modifiedPerson.Name = p.Name
-		}
-		if p.Address != "" {
-			modifiedPerson.Address = p.Address
-		}
-		dateOfBirth, err := p.DateOfBirth.MarshalText()
-		if err == nil && dateOfBirth != "01/01/0001" {
-			modifiedPerson.DateOfBirth = p.DateOfBirth
-		}
+		applyChanges(modifiedPerson, p)
 
-		expireKey := getExpireKey(modifiedPerson.Id)
+		expireKey := getExpireKey(ctx, modifiedPerson.Id)
 		updated := time.Now()
+		idleTime, err := d.personIdleTime(ctx, tx, modifiedPerson.Id)
+		if err != nil {
+			return err
+		}
 
 		trans := tx.TxPipeline()
+		// keep replaced version in history
+		version, err := d.appendHistory(ctx, tx, trans, &before, updated)
+		if err != nil {
+			return err
+		}
+		stampUpdated(modifiedPerson, version, updated)
 		// insert person with person.Id as key
-		trans.Set(ctx, modifiedPerson.Id, modifiedPerson, 0)
+		if err = d.setPerson(ctx, trans, modifiedPerson, &before); err != nil {
+			return err
+		}
 		// also insert key with updated date and expiration
-		trans.Set(ctx, expireKey, updated, d.expireTimeInMinutes)
+		trans.Set(ctx, expireKey, updated, idleTime)
+		// publish change event in the same transaction
+		if err := d.appendEvent(ctx, trans, EventPersonUpdated, modifiedPerson.Id, &before, modifiedPerson); err != nil {
+			return err
+		}
 		_, err = trans.Exec(ctx)
 
 		return err
-	}, p.Id)
+	}, personKey(ctx, p.Id), getRetentionKey(ctx, p.Id))
 
 	return modifiedPerson, err
 }
@@ -97,55 +169,313 @@ func (d *db) UpdatePersonOptimistic(ctx context.Context, p *models.Person) (*mod
 func (d *db) UpdatePersonPessimistic(ctx context.Context, p *models.Person) (*models.Person, error) {
 	var modifiedPerson *models.Person
 
-	if err := d.mutex.LockContext(ctx); err != nil {
+	mutex := d.tenantMutex(ctx)
+	if err := mutex.LockContext(ctx); err != nil {
 		return nil, err
 	}
 
-	personString, err := d.client.Get(ctx, p.Id).Result()
+	personString, err := d.client.Get(ctx, personKey(ctx, p.Id)).Result()
 	if err != nil && err != redis.Nil {
-		unlock(d, ctx)
+		unlock(mutex, ctx)
 		return nil, err
 	}
 
-	err = json.Unmarshal([]byte(personString), &modifiedPerson)
+	modifiedPerson, err = d.decodePerson(personString)
 	if err != nil {
-		unlock(d, ctx)
+		unlock(mutex, ctx)
 		return nil, err
 	}
+	if modifiedPerson.DeletedAt != nil {
+		unlock(mutex, ctx)
+		return nil, ErrPersonDeleted
+	}
+	before := *modifiedPerson
 
 	// update person's data
-	if p.Name != "" {
-		modifiedPerson.Name = p.Name
-	}
-	if p.Address != "" {
-		modifiedPerson.Address = p.Address
-	}
-	dateOfBirth, err := p.DateOfBirth.MarshalText()
-	if err == nil && dateOfBirth != "01/01/0001" {
-		modifiedPerson.DateOfBirth = p.DateOfBirth
-	}
+	applyChanges(modifiedPerson, p)
 
-	expireKey := getExpireKey(modifiedPerson.Id)
+	expireKey := getExpireKey(ctx, modifiedPerson.Id)
 	updated := time.Now()
+	idleTime, err := d.personIdleTime(ctx, d.client, modifiedPerson.Id)
+	if err != nil {
+		unlock(mutex, ctx)
+		return nil, err
+	}
 
 	trans := d.client.TxPipeline()
+	// keep replaced version in history
+	version, err := d.appendHistory(ctx, d.client, trans, &before, updated)
+	if err != nil {
+		unlock(mutex, ctx)
+		return nil, err
+	}
+	stampUpdated(modifiedPerson, version, updated)
 	// insert person with person.Id as key
-	trans.Set(ctx, modifiedPerson.Id, modifiedPerson, 0)
+	if err = d.setPerson(ctx, trans, modifiedPerson, &before); err != nil {
+		unlock(mutex, ctx)
+		return nil, err
+	}
 	// also insert key with updated date and expiration
-	trans.Set(ctx, expireKey, updated, d.expireTimeInMinutes)
+	trans.Set(ctx, expireKey, updated, idleTime)
+	// publish change event in the same transaction
+	if err = d.appendEvent(ctx, trans, EventPersonUpdated, modifiedPerson.Id, &before, modifiedPerson); err != nil {
+		unlock(mutex, ctx)
+		return nil, err
+	}
 	_, err = trans.Exec(ctx)
 
-	unlock(d, ctx)
+	unlock(mutex, ctx)
 
 	return modifiedPerson, err
 }
 
-func unlock(d *db, ctx context.Context) {
-	if _, err := d.mutex.UnlockContext(ctx); err != nil {
+// ExpirePerson archives and deletes person whose expire key is gone, together
+// with its retention policy, history and version. Person that was updated in
+// the meantime (expire key exists again) is left intact. Returns true when
+// person was archived and deleted. Archive is called before the deleting
+// transaction, redis.TxFailedErr tells the caller that person changed after
+// it was archived and the copy has to be discarded.
+func (d *db) ExpirePerson(ctx context.Context, id string, archive func(p *models.Person) error) (bool, error) {
+	expireKey := getExpireKey(ctx, id)
+	expired := false
+
+	err := d.client.Watch(ctx, func(tx *redis.Tx) error {
+		active, err := tx.Exists(ctx, expireKey).Result()
+		if err != nil {
+			return err
+		}
+		if active > 0 {
+			return nil
+		}
+		personString, err := tx.Get(ctx, personKey(ctx, id)).Result()
+		if err == redis.Nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		person, err := d.decodePerson(personString)
+		if err != nil {
+			return err
+		}
+		// deleted persons are purged, not archived
+		if person.DeletedAt != nil {
+			return nil
+		}
+
+		if err = archive(person); err != nil {
+			return err
+		}
+
+		trans := tx.TxPipeline()
+		trans.Del(ctx, personKey(ctx, id), getRetentionKey(ctx, id), getRetentionAuditKey(ctx, id), getHistoryKey(ctx, id), getVersionKey(ctx, id))
+		d.unindexPerson(ctx, trans, person)
+		// publish change event in the same transaction
+		if err = d.appendEvent(ctx, trans, EventPersonExpired, id, person, nil); err != nil {
+			return err
+		}
+		if _, err = trans.Exec(ctx); err != nil {
+			return err
+		}
+		expired = true
+		return nil
+	}, personKey(ctx, id), expireKey)
+
+	return expired, err
+}
+
+// RestorePerson re-inserts archived person returned by load together with a
+// fresh expire key. Fails with ErrPersonExists when person is still stored.
+func (d *db) RestorePerson(ctx context.Context, id string, load func(id string) (*models.Person, error)) (*models.Person, error) {
+	var restored *models.Person
+	expireKey := getExpireKey(ctx, id)
+
+	err := d.client.Watch(ctx, func(tx *redis.Tx) error {
+		exists, err := tx.Exists(ctx, personKey(ctx, id)).Result()
+		if err != nil {
+			return err
+		}
+		if exists > 0 {
+			return ErrPersonExists
+		}
+		person, err := load(id)
+		if err != nil {
+			return err
+		}
+		person.Id = id
+		idleTime, err := d.personIdleTime(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		trans := tx.TxPipeline()
+		if err = d.setPerson(ctx, trans, person, nil); err != nil {
+			return err
+		}
+		trans.Set(ctx, expireKey, time.Now(), idleTime)
+		registerTenant(ctx, trans)
+		// publish change event in the same transaction
+		if err = d.appendEvent(ctx, trans, EventPersonRestored, id, nil, person); err != nil {
+			return err
+		}
+		if _, err = trans.Exec(ctx); err != nil {
+			return err
+		}
+		restored = person
+		return nil
+	}, personKey(ctx, id))
+
+	return restored, err
+}
+
+// sets expire key only when person exists, so touching archived person does not leave orphan key behind.
+// Idle time of 0 means person is under legal hold and never expires.
+var touchScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+if ARGV[2] == "0" then
+	redis.call("SET", KEYS[2], ARGV[1])
+else
+	redis.call("SET", KEYS[2], ARGV[1], "PX", ARGV[2])
+end
+return 1
+`)
+
+// TouchPerson restarts idle period of person. Returns redis.Nil when person does not exist.
+func (d *db) TouchPerson(ctx context.Context, id string) error {
+	idleTime, err := d.personIdleTime(ctx, d.client, id)
+	if err != nil {
+		return err
+	}
+	touched, err := touchScript.Run(ctx, d.client, []string{personKey(ctx, id), getExpireKey(ctx, id)},
+		time.Now().Format(time.RFC3339Nano), idleTime.Milliseconds()).Int()
+	if err != nil {
+		return err
+	}
+	if touched == 0 {
+		return redis.Nil
+	}
+	return nil
+}
+
+// GetPersonTTL returns remaining time until person is archived. Returns
+// redis.Nil when person does not exist, zero when archiving is pending and
+// negative duration when person never expires (legal hold).
+func (d *db) GetPersonTTL(ctx context.Context, id string) (time.Duration, error) {
+	pipe := d.client.Pipeline()
+	exists := pipe.Exists(ctx, personKey(ctx, id))
+	ttl := pipe.PTTL(ctx, getExpireKey(ctx, id))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	if exists.Val() == 0 {
+		return 0, redis.Nil
+	}
+	// -2 means expire key is gone, -1 means it has no expiration
+	if ttl.Val() == -2 {
+		return 0, nil
+	}
+	return ttl.Val(), nil
+}
+
+// ScanPersonIds iterates over keys holding persons of tenant, using Redis SCAN
+// semantics for cursor and count
+func (d *db) ScanPersonIds(ctx context.Context, cursor uint64, count int64) ([]string, uint64, error) {
+	prefix := tenant.Key(ctx, "")
+	keys, next, err := d.client.ScanType(ctx, cursor, prefix+"*", count, "string").Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	var ids []string
+	for _, key := range keys {
+		// keys of default tenant are not prefixed, so they never match keys of other tenants
+		if id := strings.TrimPrefix(key, prefix); isPersonKey(id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, next, nil
+}
+
+// ListPersons returns all stored persons in no particular order
+func (d *db) ListPersons(ctx context.Context) ([]*models.Person, error) {
+	var persons []*models.Person
+	var cursor uint64
+	for {
+		page, next, err := d.ScanPersons(ctx, cursor, listBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		persons = append(persons, page...)
+		if next == 0 {
+			return persons, nil
+		}
+		cursor = next
+	}
+}
+
+// ScanPersons returns persons of a single SCAN iteration, so callers can
+// stream all persons without holding them in memory
+func (d *db) ScanPersons(ctx context.Context, cursor uint64, count int64) ([]*models.Person, uint64, error) {
+	ids, next, err := d.ScanPersonIds(ctx, cursor, count)
+	if err != nil || len(ids) == 0 {
+		return nil, next, err
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = personKey(ctx, id)
+	}
+	values, err := d.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	persons := make([]*models.Person, 0, len(values))
+	for _, value := range values {
+		// person deleted between SCAN and MGET
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		person, err := d.decodePerson(data)
+		if err != nil {
+			return nil, 0, err
+		}
+		persons = append(persons, person)
+	}
+	return persons, next, nil
+}
+
+// applyChanges copies non-empty fields of p to person
+func applyChanges(person *models.Person, p *models.Person) {
+	if p.Name != "" {
+		person.Name = p.Name
+	}
+	if p.PostalAddress != nil {
+		address := *p.PostalAddress
+		person.PostalAddress = &address
+		person.Address = address.String()
+	} else if p.Address != "" && p.Address != person.Address {
+		// old clients replacing the single-line address make structured one stale
+		person.Address = p.Address
+		person.PostalAddress = nil
+	}
+	dateOfBirth, err := p.DateOfBirth.MarshalText()
+	if err == nil && dateOfBirth != "01/01/0001" {
+		person.DateOfBirth = p.DateOfBirth
+	}
+}
+
+func unlock(mutex *redsync.Mutex, ctx context.Context) {
+	if _, err := mutex.UnlockContext(ctx); err != nil {
 		panic(err)
 	}
 }
 
-func getExpireKey(id string) string {
-	return id + "_expire"
+func getExpireKey(ctx context.Context, id string) string {
+	return personKey(ctx, id) + "_expire"
+}
+
+// persons are stored under their UUID
+func isPersonKey(key string) bool {
+	return models.ValidId(key)
 }
\ No newline at end of file
@@ -4,11 +4,19 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/go-redis/redis/v8"
 	"github.com/go-redsync/redsync/v4"
 	"github.com/go-redsync/redsync/v4/redis/goredis/v8"
 	"github.com/google/uuid"
+	"go-microservice-assignment/app/audit"
 	"go-microservice-assignment/app/models"
+	"go-microservice-assignment/app/tenant"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -150,3 +158,737 @@ func updatePersonPessimistic2(db RedisDB, ctx context.Context, person *models.Pe
 	updateChanP2 <- err
 }
 
+
+func TestRedisPersonEvents(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "test",
+		DB:       0, // use default DB
+	})
+	defer rdb.Close()
+
+	db := NewDB(rdb, nil, time.Duration(1)*time.Minute, WithEventStreamMaxLen(100))
+
+	dummyPerson := models.Person{
+		Id: uuid.New().String(),
+		Name: "Test123",
+		Address: "Berlin 123",
+	}
+	if err := db.CreatePerson(ctx, &dummyPerson); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.UpdatePersonOptimistic(ctx, &models.Person{Id: dummyPerson.Id, Name: "Person1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := rdb.XRevRangeN(ctx, PersonEventsStream, "+", "-", 2).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(messages))
+	}
+
+	updated, err := ParsePersonEvent(messages[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Type != EventPersonUpdated || updated.PersonId != dummyPerson.Id {
+		t.Fatalf("unexpected event %+v", updated)
+	}
+	if len(updated.Changes) != 1 || updated.Changes[0] != "name" {
+		t.Fatalf("unexpected changed fields %v", updated.Changes)
+	}
+	if updated.Before.Name != "Test123" || updated.After.Name != "Person1" {
+		t.Fatalf("unexpected event payload %+v %+v", updated.Before, updated.After)
+	}
+
+	created, err := ParsePersonEvent(messages[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Type != EventPersonCreated || created.PersonId != dummyPerson.Id || created.Before != nil {
+		t.Fatalf("unexpected event %+v", created)
+	}
+
+	// persons stored encrypted are not carried by events in plaintext
+	encrypted := NewDB(rdb, nil, time.Duration(1)*time.Minute, WithEventStreamMaxLen(100), WithEncryption(testEncryptor(t, "k1")))
+	if _, err := encrypted.UpdatePersonOptimistic(ctx, &models.Person{Id: dummyPerson.Id, Name: "Person2"}); err != nil {
+		t.Fatal(err)
+	}
+	messages, err = rdb.XRevRangeN(ctx, PersonEventsStream, "+", "-", 1).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for field, value := range messages[0].Values {
+		if strings.Contains(fmt.Sprint(value), "Person") {
+			t.Fatalf("event field %s holds personal data", field)
+		}
+	}
+}
+
+func TestRedisExpirePerson(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "test",
+		DB:       0, // use default DB
+	})
+	defer rdb.Close()
+
+	db := NewDB(rdb, nil, time.Duration(1)*time.Minute)
+
+	dummyPerson := models.Person{
+		Id: uuid.New().String(),
+		Name: "Test123",
+		Address: "Berlin 123",
+	}
+	if err := db.CreatePerson(ctx, &dummyPerson); err != nil {
+		t.Fatal(err)
+	}
+
+	var archived []*models.Person
+	archive := func(p *models.Person) error {
+		archived = append(archived, p)
+		return nil
+	}
+
+	// person is still active
+	expired, err := db.ExpirePerson(ctx, dummyPerson.Id, archive)
+	if err != nil || expired || len(archived) != 0 {
+		t.Fatalf("active person must not be archived: %v %v", expired, err)
+	}
+
+	// simulate expiry of idle time
+	rdb.Del(ctx, getExpireKey(ctx, dummyPerson.Id))
+	expired, err = db.ExpirePerson(ctx, dummyPerson.Id, archive)
+	if err != nil || !expired || len(archived) != 1 {
+		t.Fatalf("idle person must be archived: %v %v", expired, err)
+	}
+	if _, err = db.GetPerson(ctx, dummyPerson.Id); err != redis.Nil {
+		t.Fatalf("archived person must be deleted: %v", err)
+	}
+	// keys kept next to person are deleted too
+	remaining, err := rdb.Exists(ctx, getRetentionKey(ctx, dummyPerson.Id), getRetentionAuditKey(ctx, dummyPerson.Id),
+		getHistoryKey(ctx, dummyPerson.Id), getVersionKey(ctx, dummyPerson.Id)).Result()
+	if err != nil || remaining != 0 {
+		t.Fatalf("keys of archived person must be deleted: %d %v", remaining, err)
+	}
+}
+
+func TestRedisTouchPerson(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "test",
+		DB:       0, // use default DB
+	})
+	defer rdb.Close()
+
+	db := NewDB(rdb, nil, time.Duration(1)*time.Minute)
+
+	dummyPerson := models.Person{
+		Id: uuid.New().String(),
+		Name: "Test123",
+	}
+	if err := db.CreatePerson(ctx, &dummyPerson); err != nil {
+		t.Fatal(err)
+	}
+
+	rdb.Expire(ctx, getExpireKey(ctx, dummyPerson.Id), time.Second)
+	if err := db.TouchPerson(ctx, dummyPerson.Id); err != nil {
+		t.Fatal(err)
+	}
+	ttl, err := db.GetPersonTTL(ctx, dummyPerson.Id)
+	if err != nil || ttl < 50*time.Second {
+		t.Fatalf("touch must restart idle period: %v %v", ttl, err)
+	}
+
+	if err = db.TouchPerson(ctx, uuid.New().String()); err != redis.Nil {
+		t.Fatalf("touch of missing person must fail: %v", err)
+	}
+}
+
+func TestRedisRetentionLegalHold(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "test",
+		DB:       0, // use default DB
+	})
+	defer rdb.Close()
+
+	db := NewDB(rdb, nil, time.Duration(1)*time.Minute)
+
+	dummyPerson := models.Person{
+		Id: uuid.New().String(),
+		Name: "Test123",
+	}
+	if err := db.CreatePerson(ctx, &dummyPerson); err != nil {
+		t.Fatal(err)
+	}
+
+	err := db.SetRetention(ctx, dummyPerson.Id, &RetentionPolicy{LegalHold: true}, "tester", "litigation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// updates must keep person without expiration
+	if _, err = db.UpdatePersonOptimistic(ctx, &models.Person{Id: dummyPerson.Id, Name: "Person1"}); err != nil {
+		t.Fatal(err)
+	}
+	ttl, err := db.GetPersonTTL(ctx, dummyPerson.Id)
+	if err != nil || ttl >= 0 {
+		t.Fatalf("person under legal hold must not expire: %v %v", ttl, err)
+	}
+
+	err = db.SetRetention(ctx, dummyPerson.Id, &RetentionPolicy{IdleMinutes: 10}, "tester", "case closed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ttl, err = db.GetPersonTTL(ctx, dummyPerson.Id)
+	if err != nil || ttl < 9*time.Minute {
+		t.Fatalf("person must expire using custom idle time: %v %v", ttl, err)
+	}
+
+	audit, err := db.ListRetentionAudit(ctx, dummyPerson.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// hold placed, then hold lifted together with idle time change
+	if len(audit) != 3 || audit[2].Action != RetentionHoldPlaced {
+		t.Fatalf("unexpected audit trail %+v", audit)
+	}
+}
+
+func TestRedisPersonHistory(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "test",
+		DB:       0, // use default DB
+	})
+	defer rdb.Close()
+
+	db := NewDB(rdb, nil, time.Duration(1)*time.Minute, WithHistoryMaxLen(10))
+
+	dummyPerson := models.Person{
+		Id: uuid.New().String(),
+		Name: "Test123",
+		Address: "Berlin 123",
+	}
+	if err := db.CreatePerson(ctx, &dummyPerson); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	beforeUpdate := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	if _, err := db.UpdatePersonOptimistic(ctx, &models.Person{Id: dummyPerson.Id, Address: "Munich 1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := db.GetHistory(ctx, dummyPerson.Id)
+	if err != nil || len(history) != 1 || history[0].Version != 1 || history[0].Person.Address != "Berlin 123" {
+		t.Fatalf("unexpected history %+v %v", history, err)
+	}
+
+	old, err := db.GetPersonAsOf(ctx, dummyPerson.Id, beforeUpdate)
+	if err != nil || old.Address != "Berlin 123" {
+		t.Fatalf("unexpected person as of %v: %+v %v", beforeUpdate, old, err)
+	}
+	if _, err = db.GetPersonAsOf(ctx, dummyPerson.Id, beforeUpdate.Add(-time.Hour)); err != ErrVersionNotFound {
+		t.Fatalf("person did not exist an hour ago: %v", err)
+	}
+
+	reverted, err := db.RevertPerson(ctx, dummyPerson.Id, 1)
+	if err != nil || reverted.Address != "Berlin 123" {
+		t.Fatalf("unexpected reverted person %+v %v", reverted, err)
+	}
+	history, _ = db.GetHistory(ctx, dummyPerson.Id)
+	if len(history) != 2 || history[0].Version != 2 {
+		t.Fatalf("revert must be recorded in history %+v", history)
+	}
+}
+
+func TestRedisPersonMetadata(t *testing.T) {
+	ctx := audit.WithMetadata(context.Background(), audit.Metadata{Actor: "jane"})
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "test",
+		DB:       0, // use default DB
+	})
+	defer rdb.Close()
+
+	db := NewDB(rdb, nil, time.Duration(1)*time.Minute)
+
+	dummyPerson := models.Person{
+		Id: uuid.New().String(),
+		Name: "Test123",
+		// client supplied metadata is ignored
+		Version: 42,
+		CreatedBy: "mallory",
+	}
+	if err := db.CreatePerson(ctx, &dummyPerson); err != nil {
+		t.Fatal(err)
+	}
+	created, err := db.GetPerson(ctx, dummyPerson.Id)
+	if err != nil || created.Version != 1 || created.CreatedBy != "jane" || created.CreatedAt.IsZero() {
+		t.Fatalf("unexpected created person %+v %v", created, err)
+	}
+
+	updated, err := db.UpdatePersonOptimistic(ctx, &models.Person{Id: dummyPerson.Id, Name: "Test456", Version: 42})
+	if err != nil || updated.Version != 2 || !updated.CreatedAt.Equal(created.CreatedAt) || !updated.UpdatedAt.After(created.UpdatedAt) {
+		t.Fatalf("unexpected updated person %+v %v", updated, err)
+	}
+}
+
+func TestRedisAuditOfPerson(t *testing.T) {
+	ctx := audit.WithMetadata(context.Background(), audit.Metadata{Actor: "jane"})
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "test",
+		DB:       0, // use default DB
+	})
+	defer rdb.Close()
+
+	db := NewDB(rdb, nil, time.Duration(1)*time.Minute)
+
+	dummyPerson := models.Person{
+		Id: uuid.New().String(),
+		Name: "Test123",
+	}
+	if err := db.CreatePerson(ctx, &dummyPerson); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.UpdatePersonOptimistic(ctx, &models.Person{Id: dummyPerson.Id, Name: "Test456"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// entries of person are read from its own stream with the same ids as in stream of all persons
+	var entries []audit.Entry
+	err := audit.NewRedisStore(rdb).Query(ctx, audit.Filter{PersonId: dummyPerson.Id}, func(e *audit.Entry) error {
+		entries = append(entries, *e)
+		return nil
+	})
+	if err != nil || len(entries) != 2 || entries[0].Action != string(EventPersonCreated) || entries[1].Actor != "jane" {
+		t.Fatalf("unexpected audit entries %+v %v", entries, err)
+	}
+	if all, _ := rdb.XRange(ctx, audit.Stream, entries[1].Id, entries[1].Id).Result(); len(all) != 1 {
+		t.Fatalf("entry must be in stream of all persons")
+	}
+}
+
+func TestRedisSoftDelete(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "test",
+		DB:       0, // use default DB
+	})
+	defer rdb.Close()
+
+	db := NewDB(rdb, nil, time.Duration(1)*time.Minute)
+
+	dummyPerson := models.Person{
+		Id: uuid.New().String(),
+		Name: "Test123",
+	}
+	if err := db.CreatePerson(ctx, &dummyPerson); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeletePerson(ctx, dummyPerson.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeletePerson(ctx, dummyPerson.Id); err != ErrPersonDeleted {
+		t.Fatalf("second delete must fail: %v", err)
+	}
+	if _, err := db.UpdatePersonOptimistic(ctx, &models.Person{Id: dummyPerson.Id, Name: "Test456"}); err != ErrPersonDeleted {
+		t.Fatalf("deleted person must not be updated: %v", err)
+	}
+
+	undeleted, err := db.UndeletePerson(ctx, dummyPerson.Id)
+	if err != nil || undeleted.DeletedAt != nil {
+		t.Fatalf("unexpected undeleted person %+v %v", undeleted, err)
+	}
+
+	history, err := db.GetHistory(ctx, dummyPerson.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tombstone := 0
+	for _, version := range history {
+		if version.Person.DeletedAt != nil {
+			tombstone = version.Version
+		}
+	}
+	if tombstone == 0 {
+		t.Fatalf("tombstone must be kept in history %+v", history)
+	}
+	reverted, err := db.RevertPerson(ctx, dummyPerson.Id, tombstone)
+	if err != nil || reverted.DeletedAt != nil {
+		t.Fatalf("revert to tombstone must not delete person %+v %v", reverted, err)
+	}
+	if person, err := db.GetPerson(ctx, dummyPerson.Id); err != nil || person.DeletedAt != nil {
+		t.Fatalf("reverted person must not be deleted %+v %v", person, err)
+	}
+
+	db.DeletePerson(ctx, dummyPerson.Id)
+	if purged, err := db.PurgePerson(ctx, dummyPerson.Id, time.Hour); err != nil || purged {
+		t.Fatalf("person must be kept during grace period: %v", err)
+	}
+	if purged, err := db.PurgePerson(ctx, dummyPerson.Id, 0); err != nil || !purged {
+		t.Fatalf("person must be purged after grace period: %v", err)
+	}
+	if _, err = db.GetPerson(ctx, dummyPerson.Id); err != redis.Nil {
+		t.Fatalf("purged person must not exist: %v", err)
+	}
+}
+
+func TestRedisExecuteBatch(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "test",
+		DB:       0, // use default DB
+	})
+	defer rdb.Close()
+
+	db := NewDB(rdb, nil, time.Duration(1)*time.Minute)
+
+	id := uuid.New().String()
+	results, err := db.ExecuteBatch(ctx, []BatchOperation{
+		{Op: BatchCreate, Person: &models.Person{Id: id, Name: "Test123"}},
+		{Op: BatchGet, Id: uuid.New().String()},
+	}, false)
+	if err != nil || results[0].Err != nil || results[1].Err != redis.Nil {
+		t.Fatalf("unexpected batch results %+v %v", results, err)
+	}
+
+	// all-or-nothing batch with missing person must not update anything
+	results, err = db.ExecuteBatch(ctx, []BatchOperation{
+		{Op: BatchUpdate, Person: &models.Person{Id: id, Name: "Test456"}},
+		{Op: BatchUpdate, Person: &models.Person{Id: uuid.New().String(), Name: "Test789"}},
+	}, true)
+	if err != nil || results[0].Err != ErrBatchAborted || results[1].Err != redis.Nil {
+		t.Fatalf("unexpected atomic batch results %+v %v", results, err)
+	}
+	person, _ := db.GetPerson(ctx, id)
+	if person.Name != "Test123" || person.Version != 1 {
+		t.Fatalf("aborted batch changed person %+v", person)
+	}
+
+	results, err = db.ExecuteBatch(ctx, []BatchOperation{
+		{Op: BatchUpdate, Person: &models.Person{Id: id, Name: "Test456"}},
+		{Op: BatchUpdate, Person: &models.Person{Id: id, Address: "Berlin 1"}},
+	}, true)
+	if err != nil || results[1].Err != nil || results[1].Person.Version != 3 || results[1].Person.Name != "Test456" {
+		t.Fatalf("unexpected atomic batch results %+v %v", results, err)
+	}
+
+	// concurrent non-atomic batches must not overwrite each other's changes
+	var wg sync.WaitGroup
+	var updated int32
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results, err := db.ExecuteBatch(ctx, []BatchOperation{
+				{Op: BatchUpdate, Person: &models.Person{Id: id, Address: fmt.Sprintf("Berlin %d", i)}},
+			}, false)
+			if err == nil && results[0].Err == nil {
+				atomic.AddInt32(&updated, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	person, _ = db.GetPerson(ctx, id)
+	if person.Version != 3+int(updated) {
+		t.Fatalf("concurrent batches lost updates, version %d after %d updates", person.Version, updated)
+	}
+}
+
+func TestRedisTenantIsolation(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "test",
+		DB:       0, // use default DB
+	})
+	defer rdb.Close()
+
+	db := NewDB(rdb, nil, time.Duration(1)*time.Minute, WithTenantIdleTime("acme", time.Hour))
+	acme := tenant.WithTenant(ctx, "acme")
+	globex := tenant.WithTenant(ctx, "globex")
+
+	dummyPerson := models.Person{
+		Id: uuid.New().String(),
+		Name: "Test123",
+	}
+	if err := db.CreatePerson(acme, &dummyPerson); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := rdb.Exists(ctx, "tenant:acme:"+dummyPerson.Id, "tenant:acme:"+dummyPerson.Id+"_expire").Result(); exists != 2 {
+		t.Fatalf("person keys must be prefixed with tenant")
+	}
+	if ttl, _ := db.GetPersonTTL(acme, dummyPerson.Id); ttl < 59*time.Minute {
+		t.Fatalf("tenant idle time must apply, got %s", ttl)
+	}
+
+	for _, ctx := range []context.Context{ctx, globex} {
+		if _, err := db.GetPerson(ctx, dummyPerson.Id); err != redis.Nil {
+			t.Fatalf("person must not be visible to other tenant: %v", err)
+		}
+		if _, err := db.UpdatePersonOptimistic(ctx, &models.Person{Id: dummyPerson.Id, Name: "Test456"}); err == nil {
+			t.Fatalf("person must not be updated by other tenant")
+		}
+		if err := db.DeletePerson(ctx, dummyPerson.Id); err != redis.Nil {
+			t.Fatalf("person must not be deleted by other tenant: %v", err)
+		}
+		if err := db.TouchPerson(ctx, dummyPerson.Id); err != redis.Nil {
+			t.Fatalf("person must not be touched by other tenant: %v", err)
+		}
+	}
+
+	ids, _, err := db.ScanPersonIds(acme, 0, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, id := range ids {
+		found = found || id == dummyPerson.Id
+	}
+	if !found {
+		t.Fatalf("scan must return unprefixed ids of tenant")
+	}
+	if tenants, _ := db.ListTenants(ctx); len(tenants) == 0 {
+		t.Fatalf("tenant must be registered")
+	}
+}
+
+func TestRedisEncryption(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "test",
+		DB:       0, // use default DB
+	})
+	defer rdb.Close()
+
+	plain := NewDB(rdb, nil, time.Duration(1)*time.Minute)
+	dummyPerson := models.Person{
+		Id: uuid.New().String(),
+		Name: "Encrypted " + uuid.New().String(),
+		Address: "Berlin 123",
+	}
+	if err := plain.CreatePerson(ctx, &dummyPerson); err != nil {
+		t.Fatal(err)
+	}
+
+	db := NewDB(rdb, nil, time.Duration(1)*time.Minute, WithEncryption(testEncryptor(t, "k1")))
+	// persons stored before encryption was enabled are readable, but not yet indexed
+	if found, _ := db.FindPersons(ctx, "name", dummyPerson.Name); len(found) != 0 {
+		t.Fatalf("plaintext person must not be indexed")
+	}
+	if reencrypted, err := db.ReencryptPerson(ctx, dummyPerson.Id); err != nil || !reencrypted {
+		t.Fatalf("plaintext person must be encrypted: %v", err)
+	}
+	if data, _ := rdb.Get(ctx, dummyPerson.Id).Result(); strings.Contains(data, "Berlin") {
+		t.Fatalf("PII must not be stored in plaintext: %s", data)
+	}
+
+	if _, err := db.UpdatePersonOptimistic(ctx, &models.Person{Id: dummyPerson.Id, Address: "Hamburg 456"}); err != nil {
+		t.Fatal(err)
+	}
+	found, err := db.FindPersons(ctx, "name", strings.ToUpper(dummyPerson.Name))
+	if err != nil || len(found) != 1 || found[0].Address != "Hamburg 456" {
+		t.Fatalf("person must be found by blind index: %v %v", found, err)
+	}
+	if found, _ := db.FindPersons(ctx, "address", "Berlin 123"); len(found) != 0 {
+		t.Fatalf("replaced value must be removed from index")
+	}
+
+	// rotation re-encrypts person and its history with the new key
+	rotated := NewDB(rdb, nil, time.Duration(1)*time.Minute, WithEncryption(testEncryptor(t, "k2")))
+	if reencrypted, err := rotated.ReencryptPerson(ctx, dummyPerson.Id); err != nil || !reencrypted {
+		t.Fatalf("person must be re-encrypted: %v", err)
+	}
+	if reencrypted, _ := rotated.ReencryptPerson(ctx, dummyPerson.Id); reencrypted {
+		t.Fatalf("person already encrypted with current key must be left alone")
+	}
+	history, err := rotated.GetHistory(ctx, dummyPerson.Id)
+	if err != nil || len(history) != 1 || history[0].Person.Address != "Berlin 123" {
+		t.Fatalf("history must be readable after rotation: %v %v", history, err)
+	}
+	items, _ := rdb.LRange(ctx, getHistoryKey(ctx, dummyPerson.Id), 0, -1).Result()
+	var stored storedVersion
+	if err = json.Unmarshal([]byte(items[0]), &stored); err != nil || keyIdOf(string(stored.Person)) != "k2" {
+		t.Fatalf("history must be encrypted with new key")
+	}
+}
+
+func TestRedisErasePerson(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "test",
+		DB:       0, // use default DB
+	})
+	defer rdb.Close()
+
+	store := NewDB(rdb, nil, time.Duration(1)*time.Minute, WithEncryption(testEncryptor(t, "k1")))
+	dummyPerson := models.Person{
+		Id: uuid.New().String(),
+		Name: "Erased " + uuid.New().String(),
+	}
+	if err := store.CreatePerson(ctx, &dummyPerson); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.UpdatePersonOptimistic(ctx, &models.Person{Id: dummyPerson.Id, Address: "Berlin 123"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetRetention(ctx, dummyPerson.Id, &RetentionPolicy{LegalHold: true}, "test", "litigation"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ErasePerson(ctx, dummyPerson.Id); err != ErrLegalHold {
+		t.Fatalf("person under legal hold must not be erased: %v", err)
+	}
+	if err := store.SetRetention(ctx, dummyPerson.Id, &RetentionPolicy{}, "test", "settled"); err != nil {
+		t.Fatal(err)
+	}
+
+	erasure, err := store.ErasePerson(ctx, dummyPerson.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !erasure.Record || erasure.HistoryVersions != 1 || erasure.Events < 2 || erasure.AuditEntries < 2 {
+		t.Fatalf("unexpected erasure %+v", erasure)
+	}
+	keys, _ := rdb.Keys(ctx, dummyPerson.Id+"*").Result()
+	if len(keys) != 1 || keys[0] != audit.PersonStream("", dummyPerson.Id) {
+		t.Fatalf("only audit stream of person must be kept, got %v", keys)
+	}
+	if found, _ := store.FindPersons(ctx, "name", dummyPerson.Name); len(found) != 0 {
+		t.Fatalf("person must be removed from index")
+	}
+	if ids, _ := store.(*db).personEventIds(ctx, dummyPerson.Id); len(ids) != 1 {
+		t.Fatalf("only person.erased event must be left, got %d events", len(ids))
+	}
+	entries, err := store.(*db).personAuditEntries(ctx, dummyPerson.Id)
+	if err != nil || len(entries) != erasure.AuditEntries+1 {
+		t.Fatalf("audit entries must be kept, got %d: %v", len(entries), err)
+	}
+	for _, entry := range entries {
+		for _, change := range entry.Changes {
+			if isPIIField(change.Field) && (change.Old != nil || change.New != nil) {
+				t.Fatalf("audit entry %s must not keep value of %s", entry.Id, change.Field)
+			}
+		}
+	}
+	if erasure, _ = store.ErasePerson(ctx, dummyPerson.Id); erasure.Record || erasure.HistoryVersions != 0 {
+		t.Fatalf("second erasure must find no person data, got %+v", erasure)
+	}
+}
+
+func TestRedisStructuredAddress(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "test",
+		DB:       0, // use default DB
+	})
+	defer rdb.Close()
+
+	store := NewDB(rdb, nil, time.Duration(1)*time.Minute)
+	dummyPerson := models.Person{
+		Id: uuid.New().String(),
+		Name: "Migrated",
+		Address: "Unter den Linden 77, 10117 Berlin, DE",
+	}
+	if err := store.CreatePerson(ctx, &dummyPerson); err != nil {
+		t.Fatal(err)
+	}
+
+	migrated, err := store.MigrateAddress(ctx, dummyPerson.Id, "")
+	if err != nil || !migrated {
+		t.Fatalf("person must be migrated: %v", err)
+	}
+	person, _ := store.GetPerson(ctx, dummyPerson.Id)
+	if person.PostalAddress == nil || person.PostalAddress.PostalCode != "10117" || person.Address != dummyPerson.Address || person.Version != 1 {
+		t.Fatalf("unexpected migrated person %+v", person)
+	}
+	if migrated, _ = store.MigrateAddress(ctx, dummyPerson.Id, ""); migrated {
+		t.Fatalf("migrated person must not be migrated again")
+	}
+
+	// old clients replacing single-line address drop the structured one
+	person, err = store.UpdatePersonOptimistic(ctx, &models.Person{Id: dummyPerson.Id, Address: "Berlin 123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if person.PostalAddress != nil || person.Address != "Berlin 123" {
+		t.Fatalf("unexpected person %+v", person)
+	}
+	if _, err = store.MigrateAddress(ctx, dummyPerson.Id, "DE"); !errors.Is(err, ErrInvalidAddress) {
+		t.Fatalf("address that cannot be parsed must fail migration: %v", err)
+	}
+	person, err = store.UpdatePersonOptimistic(ctx, &models.Person{Id: dummyPerson.Id, PostalAddress: &models.Address{
+		Street: "Damrak", HouseNumber: "1", PostalCode: "1012 LG", City: "Amsterdam", Country: "NL",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if person.Address != "Damrak 1, 1012 LG Amsterdam, NL" {
+		t.Fatalf("single-line address must be derived, got %q", person.Address)
+	}
+}
+
+func TestRedisListPersonsPage(t *testing.T) {
+	ctx := tenant.WithTenant(context.Background(), "list"+uuid.New().String()[:8])
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "test",
+		DB:       0, // use default DB
+	})
+	defer rdb.Close()
+
+	db := NewDB(rdb, nil, time.Duration(1)*time.Minute)
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		dummyPerson := models.Person{
+			Id: uuid.New().String(),
+			Name: "Test123",
+		}
+		if err := db.CreatePerson(ctx, &dummyPerson); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, dummyPerson.Id)
+		time.Sleep(2 * time.Millisecond)
+	}
+	if err := db.DeletePerson(ctx, ids[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	// persons of new tenant are indexed from the start
+	page, total, err := db.ListPersonsPage(ctx, PageQuery{Order: "createdAt", Descending: true, Limit: 10})
+	if err != nil || total != 2 || len(page) != 2 || page[0].Id != ids[2] || page[1].Id != ids[0] {
+		t.Fatalf("unexpected page %+v %d %v", page, total, err)
+	}
+	page, total, err = db.ListPersonsPage(ctx, PageQuery{Order: "updatedAt", Offset: 1, Limit: 1, IncludeDeleted: true})
+	if err != nil || total != 3 || len(page) != 1 || page[0].Id != ids[2] {
+		t.Fatalf("unexpected page with deleted %+v %d %v", page, total, err)
+	}
+
+	// person stored before metadata and indexes existed
+	legacy := uuid.New().String()
+	rdb.Set(ctx, tenant.Key(ctx, legacy), `{"id":"`+legacy+`","name":"Legacy"}`, 0)
+	rdb.Del(ctx, tenant.Key(ctx, indexedOrdersKey))
+	if _, _, err = db.ListPersonsPage(ctx, PageQuery{Order: "createdAt", Limit: 10}); err != ErrOrderNotIndexed {
+		t.Fatalf("listing must wait for backfill: %v", err)
+	}
+	NewOrderBackfiller(db, rdb).Run(ctx)
+	page, total, err = db.ListPersonsPage(ctx, PageQuery{Order: "createdAt", Descending: true, Limit: 10})
+	if err != nil || total != 3 || page[0].Id != legacy || page[0].CreatedAt.IsZero() || page[0].UpdatedAt.IsZero() {
+		t.Fatalf("legacy person must be backfilled %+v %d %v", page, total, err)
+	}
+}
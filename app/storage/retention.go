@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"go-microservice-assignment/app/tenant"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const maxRetentionAuditLength = 1000
+
+type RetentionAction string
+
+const (
+	RetentionHoldPlaced  RetentionAction = "hold_placed"
+	RetentionHoldLifted  RetentionAction = "hold_lifted"
+	RetentionIdleChanged RetentionAction = "idle_changed"
+)
+
+// RetentionPolicy overrides global idle time of a single person. Person under
+// legal hold is never archived.
+type RetentionPolicy struct {
+	IdleMinutes int       `json:"idleMinutes,omitempty"`
+	LegalHold   bool      `json:"legalHold"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	UpdatedBy   string    `json:"updatedBy,omitempty"`
+}
+
+// RetentionAudit records a single change of person's retention policy
+type RetentionAudit struct {
+	Action RetentionAction `json:"action"`
+	Actor  string          `json:"actor"`
+	Reason string          `json:"reason,omitempty"`
+	Policy RetentionPolicy `json:"policy"`
+	Time   time.Time       `json:"time"`
+}
+
+// GetRetention returns retention policy of person, which is empty (global
+// idle time applies) when it was never set. Returns redis.Nil when person does not exist.
+func (d *db) GetRetention(ctx context.Context, id string) (*RetentionPolicy, error) {
+	exists, err := d.client.Exists(ctx, personKey(ctx, id)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if exists == 0 {
+		return nil, redis.Nil
+	}
+	return readRetention(ctx, d.client, id)
+}
+
+// SetRetention replaces retention policy of person, records the change in
+// audit trail and applies new idle time to the expire key, all in one transaction
+func (d *db) SetRetention(ctx context.Context, id string, policy *RetentionPolicy, actor string, reason string) error {
+	retentionKey := getRetentionKey(ctx, id)
+
+	return d.client.Watch(ctx, func(tx *redis.Tx) error {
+		exists, err := tx.Exists(ctx, personKey(ctx, id)).Result()
+		if err != nil {
+			return err
+		}
+		if exists == 0 {
+			return redis.Nil
+		}
+		current, err := readRetention(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		policy.UpdatedAt = time.Now().UTC()
+		policy.UpdatedBy = actor
+		data, err := json.Marshal(policy)
+		if err != nil {
+			return err
+		}
+
+		trans := tx.TxPipeline()
+		trans.Set(ctx, retentionKey, data, 0)
+		trans.Set(ctx, getExpireKey(ctx, id), time.Now(), d.idleTime(ctx, policy))
+		for _, action := range retentionActions(current, policy) {
+			entry, err := json.Marshal(&RetentionAudit{
+				Action: action,
+				Actor:  actor,
+				Reason: reason,
+				Policy: *policy,
+				Time:   policy.UpdatedAt,
+			})
+			if err != nil {
+				return err
+			}
+			trans.LPush(ctx, getRetentionAuditKey(ctx, id), entry)
+		}
+		trans.LTrim(ctx, getRetentionAuditKey(ctx, id), 0, maxRetentionAuditLength-1)
+		_, err = trans.Exec(ctx)
+		return err
+	}, personKey(ctx, id), retentionKey)
+}
+
+// ListRetentionAudit returns changes of person's retention policy, newest first
+func (d *db) ListRetentionAudit(ctx context.Context, id string) ([]RetentionAudit, error) {
+	items, err := d.client.LRange(ctx, getRetentionAuditKey(ctx, id), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]RetentionAudit, 0, len(items))
+	for _, item := range items {
+		var entry RetentionAudit
+		if err = json.Unmarshal([]byte(item), &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// personIdleTime returns idle time of person respecting its retention policy,
+// zero means person never expires
+func (d *db) personIdleTime(ctx context.Context, c redis.Cmdable, id string) (time.Duration, error) {
+	policy, err := readRetention(ctx, c, id)
+	if err != nil {
+		return 0, err
+	}
+	return d.idleTime(ctx, policy), nil
+}
+
+func (d *db) idleTime(ctx context.Context, policy *RetentionPolicy) time.Duration {
+	if policy.LegalHold {
+		return 0
+	}
+	if policy.IdleMinutes > 0 {
+		return time.Duration(policy.IdleMinutes) * time.Minute
+	}
+	if idleTime, ok := d.tenantIdleTimes[tenant.FromContext(ctx)]; ok {
+		return idleTime
+	}
+	return d.expireTimeInMinutes
+}
+
+func readRetention(ctx context.Context, c redis.Cmdable, id string) (*RetentionPolicy, error) {
+	var policy RetentionPolicy
+	data, err := c.Get(ctx, getRetentionKey(ctx, id)).Result()
+	if err == redis.Nil {
+		return &policy, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal([]byte(data), &policy); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+func retentionActions(current, updated *RetentionPolicy) []RetentionAction {
+	var actions []RetentionAction
+	if !current.LegalHold && updated.LegalHold {
+		actions = append(actions, RetentionHoldPlaced)
+	}
+	if current.LegalHold && !updated.LegalHold {
+		actions = append(actions, RetentionHoldLifted)
+	}
+	if current.IdleMinutes != updated.IdleMinutes {
+		actions = append(actions, RetentionIdleChanged)
+	}
+	return actions
+}
+
+func getRetentionKey(ctx context.Context, id string) string {
+	return personKey(ctx, id) + "_retention"
+}
+
+func getRetentionAuditKey(ctx context.Context, id string) string {
+	return personKey(ctx, id) + "_retention_audit"
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"go-microservice-assignment/app/tenant"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/go-redsync/redsync/v4"
+)
+
+// set of tenants that ever stored a person, so background jobs can visit all of them
+const tenantsKey = "tenants"
+
+// WithTenantIdleTime overrides global idle time for persons of tenant without own retention policy
+func WithTenantIdleTime(tenantId string, idleTime time.Duration) Option {
+	return func(d *db) {
+		d.tenantIdleTimes[tenantId] = idleTime
+	}
+}
+
+// WithTenantLocks gives every tenant its own lock of pessimistic updates,
+// named like the global lock and prefixed with tenant
+func WithTenantLocks(rs *redsync.Redsync) Option {
+	return func(d *db) {
+		d.redsync = rs
+	}
+}
+
+// ListTenants returns tenants that stored persons, besides the default one
+func (d *db) ListTenants(ctx context.Context) ([]string, error) {
+	return d.client.SMembers(ctx, tenantsKey).Result()
+}
+
+// ForEachTenant calls fn with context of the default tenant and then of every
+// other tenant that stored persons
+func ForEachTenant(ctx context.Context, db RedisDB, fn func(ctx context.Context)) error {
+	fn(ctx)
+	tenants, err := db.ListTenants(ctx)
+	if err != nil {
+		return err
+	}
+	for _, id := range tenants {
+		if ctx.Err() != nil {
+			return nil
+		}
+		fn(tenant.WithTenant(ctx, id))
+	}
+	return nil
+}
+
+// personKey is the key of person in keyspace of tenant of the context. Every
+// other key of the person is derived from it, so no method can reach another
+// tenant's data.
+func personKey(ctx context.Context, id string) string {
+	return tenant.Key(ctx, id)
+}
+
+// registers tenant and marks persons of tenant registered for the first time
+// as indexed, as all of them are stored with order indexes
+var registerTenantScript = redis.NewScript(`
+if redis.call("SADD", KEYS[1], ARGV[1]) == 1 then
+	redis.call("SET", KEYS[2], ARGV[2], "NX")
+end
+`)
+
+// registerTenant queues recording of tenant of the context on the given pipeline
+func registerTenant(ctx context.Context, pipe redis.Pipeliner) {
+	if id := tenant.FromContext(ctx); id != tenant.Default {
+		// script is sent in full, NOSCRIPT error of EVALSHA would not abort transaction
+		registerTenantScript.Eval(ctx, pipe, []string{tenantsKey, tenant.Key(ctx, indexedOrdersKey)},
+			id, time.Now().UTC().Format(time.RFC3339Nano))
+	}
+}
+
+func (d *db) tenantMutex(ctx context.Context) *redsync.Mutex {
+	id := tenant.FromContext(ctx)
+	if id == tenant.Default || d.redsync == nil {
+		return d.mutex
+	}
+	return d.redsync.NewMutex(tenant.KeyPrefix(id) + d.mutex.Name())
+}
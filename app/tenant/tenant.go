@@ -0,0 +1,69 @@
+package tenant
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+// Default is the tenant of callers that do not name one. Its keys are not
+// prefixed, so data of single-tenant deployments stays where it was.
+const Default = ""
+
+const keyPrefix = "tenant:"
+
+// lowercase letters, digits and dashes, so ids are safe in keys, SCAN patterns and paths
+var idPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+type contextKey struct{}
+
+// Valid reports whether id can be used as tenant id
+func Valid(id string) bool {
+	return idPattern.MatchString(id)
+}
+
+// WithTenant returns context of requests made on behalf of tenant
+func WithTenant(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns tenant of the current request, Default when there is none
+func FromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(contextKey{}).(string); ok {
+		return id
+	}
+	return Default
+}
+
+// KeyPrefix returns prefix of all Redis keys of tenant
+func KeyPrefix(id string) string {
+	if id == Default {
+		return ""
+	}
+	return keyPrefix + id + ":"
+}
+
+// Key prefixes Redis key with tenant of the context
+func Key(ctx context.Context, key string) string {
+	return KeyPrefix(FromContext(ctx)) + key
+}
+
+// SplitKey returns tenant owning Redis key and the key without tenant prefix
+func SplitKey(key string) (string, string) {
+	if !strings.HasPrefix(key, keyPrefix) {
+		return Default, key
+	}
+	parts := strings.SplitN(strings.TrimPrefix(key, keyPrefix), ":", 2)
+	if len(parts) != 2 || !Valid(parts[0]) {
+		return Default, key
+	}
+	return parts[0], parts[1]
+}
+
+// ObjectPrefix returns prefix of tenant objects kept outside of Redis (e.g. archive)
+func ObjectPrefix(ctx context.Context) string {
+	if id := FromContext(ctx); id != Default {
+		return id + "/"
+	}
+	return ""
+}
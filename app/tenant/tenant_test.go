@@ -0,0 +1,35 @@
+package tenant
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKey(t *testing.T) {
+	ctx := context.Background()
+	id := "9b3c2d8e-5f1a-4e7b-8c6d-0a1b2c3d4e5f"
+
+	assert.Equal(t, id+"_expire", Key(ctx, id+"_expire"))
+	assert.Equal(t, "tenant:acme:"+id+"_expire", Key(WithTenant(ctx, "acme"), id+"_expire"))
+}
+
+func TestSplitKey(t *testing.T) {
+	tenantId, key := SplitKey("tenant:acme:123_expire")
+	assert.Equal(t, "acme", tenantId)
+	assert.Equal(t, "123_expire", key)
+
+	tenantId, key = SplitKey("123_expire")
+	assert.Equal(t, Default, tenantId)
+	assert.Equal(t, "123_expire", key)
+}
+
+func TestValid(t *testing.T) {
+	assert.True(t, Valid("acme"))
+	assert.True(t, Valid("team-42"))
+	assert.False(t, Valid(""))
+	assert.False(t, Valid("Acme"))
+	assert.False(t, Valid("acme:*"))
+	assert.False(t, Valid("../acme"))
+}
@@ -0,0 +1,81 @@
+package app
+
+import (
+	"go-microservice-assignment/app/auth"
+	"go-microservice-assignment/app/models"
+	"go-microservice-assignment/app/tenant"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// tenantHeader names tenant of requests whose credentials are not bound to one
+const tenantHeader = "X-Tenant-ID"
+
+// tenantMiddleware puts tenant the request is made for into request context,
+// from where storage layer takes it to select keyspace of the tenant. Tenant
+// comes from credentials of the caller, or from X-Tenant-ID header when
+// authentication is disabled or caller is admin not bound to a tenant.
+func (a *app) tenantMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if openPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		tenantId, status, detail := resolveTenant(r)
+		if status == 0 && tenantId == tenant.Default && a.tenantRequired {
+			status, detail = http.StatusBadRequest, "Missing "+tenantHeader+" header"
+		}
+		if status != 0 {
+			problemResponse(w, r, status, detail)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(tenant.WithTenant(r.Context(), tenantId)))
+	})
+}
+
+// personIdMiddleware rejects person routes with id that is not a UUID. Keys
+// of the default tenant are not prefixed, so such id, e.g. "tenant:acme:<id>",
+// would reach person of another tenant.
+func (a *app) personIdMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := mux.Vars(r)["id"]
+		if ok && isPersonRoute(r) && !models.ValidId(id) {
+			badRequest(w, "Invalid person ID")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isPersonRoute(r *http.Request) bool {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+	template, err := route.GetPathTemplate()
+	return err == nil && strings.Contains(template, "/person/{id}")
+}
+
+// resolveTenant returns tenant of request, or status and detail of error response
+func resolveTenant(r *http.Request) (string, int, string) {
+	requested := r.Header.Get(tenantHeader)
+	if requested != "" && !tenant.Valid(requested) {
+		return "", http.StatusBadRequest, "Invalid " + tenantHeader + " header"
+	}
+	principal := auth.FromContext(r.Context())
+	switch {
+	case principal == nil:
+		// authentication is disabled, header is set by trusted gateway
+		return requested, 0, ""
+	case principal.Tenant != "":
+		if !tenant.Valid(principal.Tenant) || (requested != "" && requested != principal.Tenant) {
+			return "", http.StatusForbidden, "Credentials are not valid for requested tenant"
+		}
+		return principal.Tenant, 0, ""
+	case requested != "" && !principal.HasScope(auth.ScopeAdmin):
+		return "", http.StatusForbidden, "Requires admin scope to select tenant"
+	}
+	return requested, 0, ""
+}
@@ -0,0 +1,112 @@
+package app
+
+import (
+	"context"
+	"go-microservice-assignment/app/auth"
+	"go-microservice-assignment/app/models"
+	"go-microservice-assignment/app/tenant"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func tenantRequest(principal *auth.Principal, header string) *http.Request {
+	r, _ := http.NewRequest("GET", "/api/v1/person", nil)
+	if header != "" {
+		r.Header.Set(tenantHeader, header)
+	}
+	if principal != nil {
+		r = r.WithContext(auth.WithPrincipal(r.Context(), principal))
+	}
+	return r
+}
+
+func TestResolveTenant(t *testing.T) {
+	bound := &auth.Principal{Subject: "jane", Tenant: "acme"}
+	admin := &auth.Principal{Subject: "root", Scopes: []string{auth.ScopeAdmin}}
+	reader := &auth.Principal{Subject: "joe", Scopes: []string{auth.ScopePersonRead}}
+
+	cases := []struct {
+		name      string
+		principal *auth.Principal
+		header    string
+		tenant    string
+		status    int
+	}{
+		{"authentication disabled", nil, "acme", "acme", 0},
+		{"no tenant", nil, "", tenant.Default, 0},
+		{"invalid header", nil, "ACME/../", "", http.StatusBadRequest},
+		{"bound credentials", bound, "", "acme", 0},
+		{"bound credentials, same header", bound, "acme", "acme", 0},
+		{"bound credentials, other tenant", bound, "globex", "", http.StatusForbidden},
+		{"admin selects tenant", admin, "globex", "globex", 0},
+		{"non-admin selects tenant", reader, "globex", "", http.StatusForbidden},
+		{"non-admin without tenant", reader, "", tenant.Default, 0},
+	}
+	for _, c := range cases {
+		tenantId, status, _ := resolveTenant(tenantRequest(c.principal, c.header))
+		assert.Equal(t, c.status, status, c.name)
+		assert.Equal(t, c.tenant, tenantId, c.name)
+	}
+}
+
+func TestTenantMiddleware(t *testing.T) {
+	acme := mock.MatchedBy(func(ctx context.Context) bool {
+		return tenant.FromContext(ctx) == "acme"
+	})
+	mockRedis := redisMock{}
+	mockRedis.On("GetPerson", acme, personId).Return(&models.Person{Id: personId}, nil)
+	app := New(&mockRedis, WithTenantRequired(true))
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/api/v1/person/"+personId, nil)
+	app.Router.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Equal(t, "application/problem+json", recorder.Header().Get("Content-Type"))
+
+	recorder = httptest.NewRecorder()
+	request.Header.Set(tenantHeader, "acme")
+	app.Router.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	mockRedis.AssertExpectations(t)
+
+	// probes do not belong to any tenant
+	recorder = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "/health", nil)
+	app.Router.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestPersonIdMiddleware_RejectsKeyOfAnotherTenant(t *testing.T) {
+	mockRedis := redisMock{}
+	app := New(&mockRedis)
+	// keys of the default tenant are not prefixed, this id names key of person of tenant acme
+	foreign := "tenant:acme:" + personId
+
+	for _, request := range []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"GET", "/api/v1/person/" + foreign, ""},
+		{"DELETE", "/api/v1/person/" + foreign, ""},
+		{"POST", "/api/v1/person/" + foreign + "/revert", `{"version":1}`},
+		{"PATCH", "/api/v1/person", `{"id":"` + foreign + `","name":"Test123"}`},
+		{"PATCH", "/api/v1/person/pessimistic", `{"id":"` + foreign + `","name":"Test123"}`},
+	} {
+		recorder := httptest.NewRecorder()
+		r, _ := http.NewRequest(request.method, request.path, strings.NewReader(request.body))
+		app.Router.ServeHTTP(recorder, r)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, request.method+" "+request.path)
+	}
+
+	recorder, response := executeBatch(app, `{"operations":[{"op":"get","id":"`+foreign+`"}]}`)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, http.StatusBadRequest, response.Results[0].Status)
+	mockRedis.AssertNotCalled(t, "GetPerson", mock.Anything, mock.Anything)
+	mockRedis.AssertNotCalled(t, "ExecuteBatch", mock.Anything, mock.Anything, mock.Anything)
+}
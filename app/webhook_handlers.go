@@ -0,0 +1,181 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"go-microservice-assignment/app/auth"
+	"go-microservice-assignment/app/storage"
+	"go-microservice-assignment/app/webhooks"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+var webhookEvents = map[storage.EventType]bool{
+	storage.EventPersonCreated:   true,
+	storage.EventPersonUpdated:   true,
+	storage.EventPersonDeleted:   true,
+	storage.EventPersonExpired:   true,
+	storage.EventPersonRestored:  true,
+	storage.EventPersonUndeleted: true,
+	storage.EventPersonPurged:    true,
+	storage.EventPersonErased:    true,
+}
+
+func (a *app) CreateWebhookHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			log.Println("Error processing body request:", err)
+			badRequest(w, "Invalid request")
+			return
+		}
+		var hook webhooks.Webhook
+		if err = json.Unmarshal(body, &hook); err != nil {
+			log.Println("Error unmarshalling body request:", err)
+			badRequest(w, "Invalid request")
+			return
+		}
+		if msg := validateWebhook(&hook); msg != "" {
+			badRequest(w, msg)
+			return
+		}
+
+		if hook.Secret == "" {
+			secret := make([]byte, 32)
+			if _, err = rand.Read(secret); err != nil {
+				log.Println("Error generating webhook secret:", err)
+				serverError(w)
+				return
+			}
+			hook.Secret = hex.EncodeToString(secret)
+		}
+		hook.Id = uuid.New().String()
+		hook.CreatedAt = time.Now().UTC()
+		// webhooks of non-admins only get events of persons their owner created
+		hook.CreatedBy, hook.OwnPersonsOnly = "", false
+		if principal := auth.FromContext(r.Context()); principal != nil {
+			hook.CreatedBy = principal.Subject
+			hook.OwnPersonsOnly = !principal.HasScope(auth.ScopeAdmin)
+		}
+
+		if err = a.Webhooks.CreateWebhook(r.Context(), &hook); err != nil {
+			log.Println("Error writing webhook to storage:", err)
+			serverError(w)
+			return
+		}
+		// secret is returned only on registration
+		jsonResponse(w, http.StatusCreated, &hook)
+	}
+}
+
+func (a *app) ListWebhooksHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hooks, err := a.Webhooks.ListWebhooks(r.Context())
+		if err != nil {
+			log.Println("Error listing webhooks:", err)
+			serverError(w)
+			return
+		}
+		owned := make([]webhooks.Webhook, 0, len(hooks))
+		for _, hook := range hooks {
+			if managesWebhook(r, &hook) {
+				hook.Secret = ""
+				owned = append(owned, hook)
+			}
+		}
+		jsonResponse(w, http.StatusOK, owned)
+	}
+}
+
+func (a *app) DeleteWebhookHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		if _, ok := a.managedWebhook(w, r, id); !ok {
+			return
+		}
+		err := a.Webhooks.DeleteWebhook(r.Context(), id)
+		if err == webhooks.ErrWebhookNotFound {
+			notFoundResponse(w)
+			return
+		}
+		if err != nil {
+			log.Println("Error deleting webhook:", err)
+			serverError(w)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (a *app) ListWebhookDeliveriesHandler() http.HandlerFunc {
+	return a.webhookLogHandler(a.Webhooks.ListDeliveries)
+}
+
+func (a *app) ListWebhookDeadLettersHandler() http.HandlerFunc {
+	return a.webhookLogHandler(a.Webhooks.ListDeadLetters)
+}
+
+func (a *app) webhookLogHandler(list func(ctx context.Context, webhookId string) ([]webhooks.Delivery, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		if _, ok := a.managedWebhook(w, r, id); !ok {
+			return
+		}
+		deliveries, err := list(r.Context(), id)
+		if err != nil {
+			log.Println("Error listing webhook deliveries:", err)
+			serverError(w)
+			return
+		}
+		jsonResponse(w, http.StatusOK, deliveries)
+	}
+}
+
+// managedWebhook reads webhook caller may manage, otherwise writes error
+// response. Webhooks of other callers are not found, so their ids do not leak.
+func (a *app) managedWebhook(w http.ResponseWriter, r *http.Request, id string) (*webhooks.Webhook, bool) {
+	hook, err := a.Webhooks.GetWebhook(r.Context(), id)
+	if err == webhooks.ErrWebhookNotFound || err == nil && !managesWebhook(r, hook) {
+		notFoundResponse(w)
+		return nil, false
+	}
+	if err != nil {
+		log.Println("Error reading webhook:", err)
+		serverError(w)
+		return nil, false
+	}
+	return hook, true
+}
+
+// managesWebhook reports whether caller may manage webhook. Admins manage all
+// webhooks of tenant, other callers only those they registered.
+func managesWebhook(r *http.Request, hook *webhooks.Webhook) bool {
+	principal := auth.FromContext(r.Context())
+	if principal == nil || principal.HasScope(auth.ScopeAdmin) {
+		// without authentication every caller manages all webhooks
+		return true
+	}
+	return hook.CreatedBy == principal.Subject
+}
+
+func validateWebhook(hook *webhooks.Webhook) string {
+	if err := webhooks.ValidateURL(hook.Url); err != nil {
+		return "Invalid webhook URL: " + err.Error()
+	}
+	if len(hook.Events) == 0 {
+		return "Missing webhook events"
+	}
+	for _, event := range hook.Events {
+		if !webhookEvents[event] {
+			return "Unknown webhook event " + string(event)
+		}
+	}
+	return ""
+}
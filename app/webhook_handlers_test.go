@@ -0,0 +1,205 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"go-microservice-assignment/app/auth"
+	"go-microservice-assignment/app/webhooks"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// mock for webhooks.Store
+type webhookStoreMock struct {
+	mock.Mock
+}
+
+func (s *webhookStoreMock) CreateWebhook(ctx context.Context, w *webhooks.Webhook) error {
+	args := s.Called(ctx, w)
+	return args.Error(0)
+}
+
+func (s *webhookStoreMock) GetWebhook(ctx context.Context, id string) (*webhooks.Webhook, error) {
+	args := s.Called(ctx, id)
+	return args.Get(0).(*webhooks.Webhook), args.Error(1)
+}
+
+func (s *webhookStoreMock) ListWebhooks(ctx context.Context) ([]webhooks.Webhook, error) {
+	args := s.Called(ctx)
+	return args.Get(0).([]webhooks.Webhook), args.Error(1)
+}
+
+func (s *webhookStoreMock) DeleteWebhook(ctx context.Context, id string) error {
+	args := s.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (s *webhookStoreMock) RecordDelivery(ctx context.Context, d *webhooks.Delivery) error {
+	args := s.Called(ctx, d)
+	return args.Error(0)
+}
+
+func (s *webhookStoreMock) ListDeliveries(ctx context.Context, webhookId string) ([]webhooks.Delivery, error) {
+	args := s.Called(ctx, webhookId)
+	return args.Get(0).([]webhooks.Delivery), args.Error(1)
+}
+
+func (s *webhookStoreMock) AddDeadLetter(ctx context.Context, d *webhooks.Delivery) error {
+	args := s.Called(ctx, d)
+	return args.Error(0)
+}
+
+func (s *webhookStoreMock) ListDeadLetters(ctx context.Context, webhookId string) ([]webhooks.Delivery, error) {
+	args := s.Called(ctx, webhookId)
+	return args.Get(0).([]webhooks.Delivery), args.Error(1)
+}
+
+func TestCreateWebhookHandler_OkResponse(t *testing.T) {
+	mockStore := webhookStoreMock{}
+	mockStore.On("CreateWebhook", mock.Anything, mock.AnythingOfType("*webhooks.Webhook")).Return(nil)
+
+	body := strings.NewReader(`{"url":"https://partner.test/hook","events":["person.created","person.expired"]}`)
+	testRequest, _ := http.NewRequest("POST", "/api/v1/webhooks", body)
+	recorder := httptest.NewRecorder()
+
+	app := New(nil, WithWebhooks(&mockStore))
+	app.CreateWebhookHandler().ServeHTTP(recorder, testRequest)
+
+	assert.Equal(t, http.StatusCreated, recorder.Code)
+	var hook webhooks.Webhook
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &hook))
+	assert.NotEmpty(t, hook.Id)
+	// generated secret is shown on registration
+	assert.Len(t, hook.Secret, 64)
+	mockStore.AssertExpectations(t)
+}
+
+func TestCreateWebhookHandler_OwnPersonsOfNonAdmin(t *testing.T) {
+	for _, principal := range []*auth.Principal{
+		{Subject: "jane", Scopes: []string{auth.ScopePersonRead}},
+		{Subject: "root", Scopes: []string{auth.ScopeAdmin}},
+	} {
+		mockStore := webhookStoreMock{}
+		mockStore.On("CreateWebhook", mock.Anything, mock.AnythingOfType("*webhooks.Webhook")).Return(nil)
+
+		// owner cannot be chosen by client
+		body := strings.NewReader(`{"url":"https://partner.test/hook","events":["person.created"],"createdBy":"admin"}`)
+		testRequest, _ := http.NewRequest("POST", "/api/v1/webhooks", body)
+		testRequest = testRequest.WithContext(auth.WithPrincipal(testRequest.Context(), principal))
+		recorder := httptest.NewRecorder()
+
+		app := New(nil, WithWebhooks(&mockStore))
+		app.CreateWebhookHandler().ServeHTTP(recorder, testRequest)
+
+		assert.Equal(t, http.StatusCreated, recorder.Code)
+		hook := mockStore.Calls[0].Arguments.Get(1).(*webhooks.Webhook)
+		assert.Equal(t, principal.Subject, hook.CreatedBy)
+		assert.Equal(t, principal.Subject == "jane", hook.OwnPersonsOnly)
+	}
+}
+
+func TestCreateWebhookHandler_InvalidWebhook(t *testing.T) {
+	requests := []string{
+		`{"url":"ftp://partner.test/hook","events":["person.created"]}`,
+		`{"url":"http://partner.test/hook","events":["person.created"]}`,
+		`{"url":"https://169.254.169.254/latest/meta-data","events":["person.created"]}`,
+		`{"url":"https://10.0.0.5/hook","events":["person.created"]}`,
+		`{"url":"https://partner.test/hook","events":[]}`,
+		`{"url":"https://partner.test/hook","events":["person.unknown"]}`,
+	}
+	for _, request := range requests {
+		testRequest, _ := http.NewRequest("POST", "/api/v1/webhooks", strings.NewReader(request))
+		recorder := httptest.NewRecorder()
+
+		app := New(nil)
+		app.CreateWebhookHandler().ServeHTTP(recorder, testRequest)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, request)
+	}
+}
+
+func TestListWebhooksHandler_HidesSecrets(t *testing.T) {
+	mockStore := webhookStoreMock{}
+	mockStore.On("ListWebhooks", mock.Anything).Return([]webhooks.Webhook{{Id: "1", Secret: "secret"}}, nil)
+
+	testRequest, _ := http.NewRequest("GET", "/api/v1/webhooks", nil)
+	recorder := httptest.NewRecorder()
+
+	app := New(nil, WithWebhooks(&mockStore))
+	app.ListWebhooksHandler().ServeHTTP(recorder, testRequest)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.NotContains(t, recorder.Body.String(), "secret")
+}
+
+func TestListWebhookDeliveriesHandler_NotFound(t *testing.T) {
+	mockStore := webhookStoreMock{}
+	mockStore.On("GetWebhook", mock.Anything, "1").Return((*webhooks.Webhook)(nil), webhooks.ErrWebhookNotFound)
+
+	testRequest, _ := http.NewRequest("GET", "/api/v1/webhooks/1/deliveries", nil)
+	testRequest = mux.SetURLVars(testRequest, map[string]string{"id": "1"})
+	recorder := httptest.NewRecorder()
+
+	app := New(nil, WithWebhooks(&mockStore))
+	app.ListWebhookDeliveriesHandler().ServeHTTP(recorder, testRequest)
+
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	mockStore.AssertNotCalled(t, "ListDeliveries", mock.Anything, mock.Anything)
+}
+
+func TestListWebhooksHandler_OwnWebhooksOfNonAdmin(t *testing.T) {
+	mockStore := webhookStoreMock{}
+	mockStore.On("ListWebhooks", mock.Anything).Return([]webhooks.Webhook{{Id: "1", CreatedBy: "jane"}, {Id: "2", CreatedBy: "john"}}, nil)
+
+	for principal, expected := range map[*auth.Principal][]string{
+		{Subject: "jane", Scopes: []string{auth.ScopePersonRead}}: {"1"},
+		{Subject: "root", Scopes: []string{auth.ScopeAdmin}}:      {"1", "2"},
+	} {
+		testRequest, _ := http.NewRequest("GET", "/api/v1/webhooks", nil)
+		testRequest = testRequest.WithContext(auth.WithPrincipal(testRequest.Context(), principal))
+		recorder := httptest.NewRecorder()
+
+		app := New(nil, WithWebhooks(&mockStore))
+		app.ListWebhooksHandler().ServeHTTP(recorder, testRequest)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		var hooks []webhooks.Webhook
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &hooks))
+		var ids []string
+		for _, hook := range hooks {
+			ids = append(ids, hook.Id)
+		}
+		assert.Equal(t, expected, ids, principal.Subject)
+	}
+}
+
+func TestWebhookHandlers_WebhookOfAnotherCaller(t *testing.T) {
+	mockStore := webhookStoreMock{}
+	mockStore.On("GetWebhook", mock.Anything, "1").Return(&webhooks.Webhook{Id: "1", CreatedBy: "john"}, nil)
+	app := New(nil, WithWebhooks(&mockStore))
+	jane := &auth.Principal{Subject: "jane", Scopes: []string{auth.ScopePersonRead}}
+
+	for _, handler := range []http.HandlerFunc{
+		app.DeleteWebhookHandler(),
+		app.ListWebhookDeliveriesHandler(),
+		app.ListWebhookDeadLettersHandler(),
+	} {
+		testRequest, _ := http.NewRequest("GET", "/api/v1/webhooks/1", nil)
+		testRequest = mux.SetURLVars(testRequest, map[string]string{"id": "1"})
+		testRequest = testRequest.WithContext(auth.WithPrincipal(testRequest.Context(), jane))
+		recorder := httptest.NewRecorder()
+
+		handler.ServeHTTP(recorder, testRequest)
+
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+	}
+	mockStore.AssertNotCalled(t, "DeleteWebhook", mock.Anything, mock.Anything)
+	mockStore.AssertNotCalled(t, "ListDeliveries", mock.Anything, mock.Anything)
+	mockStore.AssertNotCalled(t, "ListDeadLetters", mock.Anything, mock.Anything)
+}
@@ -0,0 +1,453 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go-microservice-assignment/app/storage"
+	"go-microservice-assignment/app/tenant"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+const (
+	consumerGroup = "webhooks"
+
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// Sign returns HMAC-SHA256 signature of "<timestamp>.<body>" in form "sha256=<hex>"
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks signature and timestamp headers of received webhook request
+func Verify(secret string, header http.Header, body []byte, tolerance time.Duration) bool {
+	timestamp, err := strconv.ParseInt(header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return false
+	}
+	age := time.Since(time.Unix(timestamp, 0))
+	if age > tolerance || age < -tolerance {
+		return false
+	}
+	expected := Sign(secret, timestamp, body)
+	return hmac.Equal([]byte(expected), []byte(header.Get(HeaderSignature)))
+}
+
+const (
+	// pending events of consumers idle for this long are claimed by other
+	// consumers, events being delivered are kept fresh by their consumer
+	claimIdle = 5 * time.Minute
+	// events waiting for delivery to a single webhook, events that do not fit
+	// stay pending and are claimed again later
+	webhookQueueSize = 100
+)
+
+// Dispatcher consumes person events and delivers them to registered webhooks.
+// Events are read through a consumer group, so each event is delivered by
+// only one replica. Every webhook gets its events in order from its own
+// worker, so slow webhooks do not delay the others. Event is acknowledged
+// when it was delivered to all its webhooks or ended in their dead letters.
+type Dispatcher struct {
+	client      *redis.Client
+	store       Store
+	httpClient  *http.Client
+	consumer    string
+	maxAttempts int
+	backoff     time.Duration
+
+	mu sync.Mutex
+	// queues of webhook workers by tenant and webhook id
+	queues map[string]chan job
+	// ids of events read by this consumer and not yet acknowledged
+	inflight map[string]bool
+}
+
+// job is delivery of event to a single webhook, done is called when it finished
+type job struct {
+	hook  Webhook
+	event *storage.PersonEvent
+	done  func()
+}
+
+func NewDispatcher(client *redis.Client, store Store, consumer string, maxAttempts int, backoff time.Duration) *Dispatcher {
+	return &Dispatcher{
+		client:      client,
+		store:       store,
+		httpClient:  newHTTPClient(),
+		consumer:    consumer,
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		queues:      map[string]chan job{},
+		inflight:    map[string]bool{},
+	}
+}
+
+// Run delivers events until ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	err := d.client.XGroupCreateMkStream(ctx, storage.PersonEventsStream, consumerGroup, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		log.Println("Error creating webhooks consumer group:", err)
+		return
+	}
+	go d.claim(ctx)
+
+	// first finish events this consumer read but did not acknowledge before
+	// restart, reading on after the last of them until all were read
+	lastId := "0"
+	for ctx.Err() == nil {
+		streams, err := d.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    consumerGroup,
+			Consumer: d.consumer,
+			Streams:  []string{storage.PersonEventsStream, lastId},
+			Count:    10,
+			Block:    5 * time.Second,
+		}).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Println("Error reading person events for webhooks:", err)
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+
+		received := 0
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				received++
+				d.process(ctx, msg)
+				if lastId != ">" {
+					lastId = msg.ID
+				}
+			}
+		}
+		if received == 0 {
+			// backlog is drained, read new events
+			lastId = ">"
+		}
+	}
+}
+
+// process queues event for delivery and acknowledges it once all its webhooks are done
+func (d *Dispatcher) process(ctx context.Context, msg redis.XMessage) {
+	d.mu.Lock()
+	if d.inflight[msg.ID] {
+		// pending event read again after restart or claimed back is already being delivered
+		d.mu.Unlock()
+		return
+	}
+	d.inflight[msg.ID] = true
+	d.mu.Unlock()
+
+	// incomplete event stays pending and is claimed again once it is idle
+	ack := func(complete bool) {
+		if complete {
+			if err := d.client.XAck(ctx, storage.PersonEventsStream, consumerGroup, msg.ID).Err(); err != nil {
+				log.Println("Error acknowledging person event:", err)
+			}
+		}
+		d.mu.Lock()
+		delete(d.inflight, msg.ID)
+		d.mu.Unlock()
+	}
+	event, err := storage.ParsePersonEvent(msg)
+	if err != nil {
+		log.Println("Error parsing person event:", err)
+		ack(true)
+		return
+	}
+	d.enqueue(ctx, event, false, ack)
+}
+
+// Dispatch delivers event to all webhooks of its tenant that receive it and
+// waits until the deliveries finished
+func (d *Dispatcher) Dispatch(ctx context.Context, event *storage.PersonEvent) {
+	done := make(chan struct{})
+	d.enqueue(ctx, event, true, func(bool) { close(done) })
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// enqueue queues event to workers of webhooks that receive it, done is
+// called after the last delivery finished. Unless wait is set, event is not
+// queued to webhooks whose queue is full and done reports it incomplete, so
+// it is delivered again later. When ctx is cancelled before that, done is
+// never called.
+func (d *Dispatcher) enqueue(ctx context.Context, event *storage.PersonEvent, wait bool, done func(complete bool)) {
+	ctx = tenant.WithTenant(ctx, event.Tenant)
+	hooks, err := d.store.ListWebhooks(ctx)
+	if err != nil {
+		log.Println("Error listing webhooks:", err)
+		done(false)
+		return
+	}
+
+	var receivers []Webhook
+	for _, hook := range hooks {
+		if hook.Receives(event) {
+			receivers = append(receivers, hook)
+		}
+	}
+	if len(receivers) == 0 {
+		done(true)
+		return
+	}
+	remaining, complete := int32(len(receivers)), int32(1)
+	finished := func() {
+		if atomic.AddInt32(&remaining, -1) == 0 {
+			done(atomic.LoadInt32(&complete) == 1)
+		}
+	}
+	for _, hook := range receivers {
+		queue, j := d.queue(ctx, &hook), job{hook: hook, event: event, done: finished}
+		if !wait {
+			select {
+			case queue <- j:
+			default:
+				// reading events does not wait for slow webhook, webhooks that
+				// got the event already get it again when it is redelivered
+				log.Println("Queue of webhook is full, event is delivered later:", hook.Id)
+				atomic.StoreInt32(&complete, 0)
+				finished()
+			}
+			continue
+		}
+		select {
+		case queue <- j:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// queue returns queue of webhook, starting its worker on first use
+func (d *Dispatcher) queue(ctx context.Context, hook *Webhook) chan job {
+	key := tenant.Key(ctx, hook.Id)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	queue, ok := d.queues[key]
+	if !ok {
+		queue = make(chan job, webhookQueueSize)
+		d.queues[key] = queue
+		go d.work(ctx, key, queue)
+	}
+	return queue
+}
+
+// work delivers events of one webhook in order until ctx is cancelled
+func (d *Dispatcher) work(ctx context.Context, key string, queue chan job) {
+	defer func() {
+		d.mu.Lock()
+		delete(d.queues, key)
+		d.mu.Unlock()
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-queue:
+			d.deliver(ctx, &j.hook, j.event)
+			if ctx.Err() != nil {
+				// event stays unacknowledged and is delivered again
+				return
+			}
+			j.done()
+		}
+	}
+}
+
+// claim periodically keeps events this consumer is delivering from being
+// claimed, takes over events of consumers that stopped and removes them
+func (d *Dispatcher) claim(ctx context.Context) {
+	ticker := time.NewTicker(claimIdle / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		d.mu.Lock()
+		inflight := make([]string, 0, len(d.inflight))
+		for id := range d.inflight {
+			inflight = append(inflight, id)
+		}
+		d.mu.Unlock()
+		if len(inflight) > 0 {
+			// claiming resets idle time of the events
+			err := d.client.XClaimJustID(ctx, &redis.XClaimArgs{
+				Stream:   storage.PersonEventsStream,
+				Group:    consumerGroup,
+				Consumer: d.consumer,
+				Messages: inflight,
+			}).Err()
+			if err != nil && ctx.Err() == nil {
+				log.Println("Error refreshing pending webhook events:", err)
+			}
+		}
+
+		if err := d.claimStale(ctx); err != nil && ctx.Err() == nil {
+			log.Println("Error claiming pending webhook events:", err)
+		}
+	}
+}
+
+// claimStale delivers events left pending for longer than claimIdle, by other
+// consumers or by this one when they were not queued, and removes consumers
+// without pending events idle as long
+func (d *Dispatcher) claimStale(ctx context.Context) error {
+	pending, err := d.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: storage.PersonEventsStream,
+		Group:  consumerGroup,
+		Start:  "-",
+		End:    "+",
+		Count:  100,
+	}).Result()
+	if err != nil {
+		return err
+	}
+	var stale []string
+	for _, p := range pending {
+		// events this consumer is delivering are kept fresh and never idle
+		if p.Idle >= claimIdle {
+			stale = append(stale, p.ID)
+		}
+	}
+	if len(stale) > 0 {
+		// only events still idle are claimed, when another consumer claims them first
+		messages, err := d.client.XClaim(ctx, &redis.XClaimArgs{
+			Stream:   storage.PersonEventsStream,
+			Group:    consumerGroup,
+			Consumer: d.consumer,
+			MinIdle:  claimIdle,
+			Messages: stale,
+		}).Result()
+		if err != nil {
+			return err
+		}
+		for _, msg := range messages {
+			d.process(ctx, msg)
+		}
+	}
+
+	consumers, err := d.client.XInfoConsumers(ctx, storage.PersonEventsStream, consumerGroup).Result()
+	if err != nil {
+		return err
+	}
+	for _, c := range consumers {
+		if c.Name != d.consumer && c.Pending == 0 && time.Duration(c.Idle)*time.Millisecond >= claimIdle {
+			if err = d.client.XGroupDelConsumer(ctx, storage.PersonEventsStream, consumerGroup, c.Name).Err(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deliver sends event with exponential backoff between attempts. Failed
+// deliveries end up in the dead-letter list of the webhook.
+func (d *Dispatcher) deliver(ctx context.Context, hook *Webhook, event *storage.PersonEvent) *Delivery {
+	delivery := &Delivery{
+		Id:        uuid.New().String(),
+		WebhookId: hook.Id,
+		EventId:   event.Id,
+		EventType: event.Type,
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		delivery.Error = err.Error()
+		d.finish(ctx, delivery)
+		return delivery
+	}
+
+	wait := d.backoff
+	for delivery.Attempts < d.maxAttempts {
+		delivery.Attempts++
+		delivery.StatusCode, err = d.send(ctx, hook, delivery.Id, event.Type, body)
+		if err == nil {
+			delivery.Succeeded = true
+			delivery.Error = ""
+			break
+		}
+		delivery.Error = err.Error()
+		if delivery.Attempts == d.maxAttempts {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			// event stays unacknowledged and is delivered again after restart
+			return delivery
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+
+	d.finish(ctx, delivery)
+	return delivery
+}
+
+func (d *Dispatcher) send(ctx context.Context, hook *Webhook, deliveryId string, eventType storage.EventType, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", hook.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, string(eventType))
+	req.Header.Set(HeaderDelivery, deliveryId)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(hook.Secret, timestamp, body))
+
+	res, err := d.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("unexpected response status %d", res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
+
+func (d *Dispatcher) finish(ctx context.Context, delivery *Delivery) {
+	delivery.Time = time.Now().UTC()
+	// use fresh context so outcome is recorded also during shutdown
+	recordCtx, cancel := context.WithTimeout(tenant.WithTenant(context.Background(), tenant.FromContext(ctx)), 5*time.Second)
+	defer cancel()
+
+	if err := d.store.RecordDelivery(recordCtx, delivery); err != nil {
+		log.Println("Error recording webhook delivery:", err)
+	}
+	if !delivery.Succeeded {
+		if err := d.store.AddDeadLetter(recordCtx, delivery); err != nil {
+			log.Println("Error adding webhook dead letter:", err)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package webhooks
+
+import (
+	"context"
+	"go-microservice-assignment/app/storage"
+	"go-microservice-assignment/app/tenant"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// in-memory Store used by dispatcher tests
+type memoryStore struct {
+	mu          sync.Mutex
+	hooks       []Webhook
+	deliveries  []Delivery
+	deadLetters []Delivery
+	// tenants of contexts the store was called with
+	tenants []string
+}
+
+func (s *memoryStore) CreateWebhook(ctx context.Context, w *Webhook) error {
+	s.hooks = append(s.hooks, *w)
+	return nil
+}
+
+func (s *memoryStore) GetWebhook(ctx context.Context, id string) (*Webhook, error) {
+	for i := range s.hooks {
+		if s.hooks[i].Id == id {
+			return &s.hooks[i], nil
+		}
+	}
+	return nil, ErrWebhookNotFound
+}
+
+func (s *memoryStore) ListWebhooks(ctx context.Context) ([]Webhook, error) {
+	s.tenants = append(s.tenants, tenant.FromContext(ctx))
+	return append([]Webhook{}, s.hooks...), nil
+}
+
+func (s *memoryStore) DeleteWebhook(ctx context.Context, id string) error {
+	return nil
+}
+
+func (s *memoryStore) RecordDelivery(ctx context.Context, d *Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tenants = append(s.tenants, tenant.FromContext(ctx))
+	s.deliveries = append(s.deliveries, *d)
+	return nil
+}
+
+func (s *memoryStore) ListDeliveries(ctx context.Context, webhookId string) ([]Delivery, error) {
+	return s.deliveries, nil
+}
+
+func (s *memoryStore) AddDeadLetter(ctx context.Context, d *Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deadLetters = append(s.deadLetters, *d)
+	return nil
+}
+
+func (s *memoryStore) ListDeadLetters(ctx context.Context, webhookId string) ([]Delivery, error) {
+	return s.deadLetters, nil
+}
+
+// newTestDispatcher returns dispatcher allowed to deliver to local test servers
+func newTestDispatcher(store Store, maxAttempts int, backoff time.Duration) *Dispatcher {
+	dispatcher := NewDispatcher(nil, store, "test", maxAttempts, backoff)
+	dispatcher.httpClient = &http.Client{Timeout: time.Second}
+	return dispatcher
+}
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"type":"person.created"}`)
+	timestamp := time.Now().Unix()
+	header := http.Header{}
+	header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	header.Set(HeaderSignature, Sign("secret", timestamp, body))
+
+	assert.True(t, Verify("secret", header, body, time.Minute))
+	assert.False(t, Verify("other", header, body, time.Minute))
+	assert.False(t, Verify("secret", header, []byte("{}"), time.Minute))
+
+	old := time.Now().Add(-time.Hour).Unix()
+	header.Set(HeaderTimestamp, strconv.FormatInt(old, 10))
+	header.Set(HeaderSignature, Sign("secret", old, body))
+	assert.False(t, Verify("secret", header, body, time.Minute))
+}
+
+func TestDispatch_RetriesUntilSuccess(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	verified := true
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		verified = verified && Verify("secret", r.Header, body, time.Minute)
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer receiver.Close()
+
+	store := &memoryStore{hooks: []Webhook{
+		{Id: "1", Url: receiver.URL, Secret: "secret", Events: []storage.EventType{storage.EventPersonCreated}},
+	}}
+	dispatcher := newTestDispatcher(store, 5, time.Millisecond)
+	dispatcher.Dispatch(context.Background(), &storage.PersonEvent{Id: "1-0", Type: storage.EventPersonCreated})
+
+	assert.Equal(t, 3, calls)
+	assert.True(t, verified)
+	assert.Len(t, store.deliveries, 1)
+	assert.True(t, store.deliveries[0].Succeeded)
+	assert.Equal(t, 3, store.deliveries[0].Attempts)
+	assert.Empty(t, store.deadLetters)
+}
+
+func TestDispatch_DeadLetterAfterMaxAttempts(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	store := &memoryStore{hooks: []Webhook{
+		{Id: "1", Url: receiver.URL, Secret: "secret", Events: []storage.EventType{storage.EventPersonUpdated}},
+	}}
+	dispatcher := newTestDispatcher(store, 2, time.Millisecond)
+	dispatcher.Dispatch(context.Background(), &storage.PersonEvent{Id: "1-0", Type: storage.EventPersonUpdated})
+
+	assert.Len(t, store.deadLetters, 1)
+	assert.Equal(t, 2, store.deadLetters[0].Attempts)
+	assert.Equal(t, http.StatusInternalServerError, store.deadLetters[0].StatusCode)
+}
+
+func TestDispatch_SkipsUnsubscribedWebhooks(t *testing.T) {
+	called := false
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer receiver.Close()
+
+	store := &memoryStore{hooks: []Webhook{
+		{Id: "1", Url: receiver.URL, Events: []storage.EventType{storage.EventPersonDeleted}},
+	}}
+	dispatcher := newTestDispatcher(store, 1, time.Millisecond)
+	dispatcher.Dispatch(context.Background(), &storage.PersonEvent{Id: "1-0", Type: storage.EventPersonCreated})
+
+	assert.False(t, called)
+	assert.Empty(t, store.deliveries)
+}
+
+func TestDispatch_UsesWebhooksOfEventTenant(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer receiver.Close()
+
+	store := &memoryStore{hooks: []Webhook{
+		{Id: "1", Url: receiver.URL, Events: []storage.EventType{storage.EventPersonCreated}},
+	}}
+	dispatcher := newTestDispatcher(store, 1, time.Millisecond)
+	dispatcher.Dispatch(context.Background(), &storage.PersonEvent{Id: "1-0", Type: storage.EventPersonCreated, Tenant: "acme"})
+
+	// webhooks are listed and delivery recorded in keyspace of the tenant
+	assert.Equal(t, []string{"acme", "acme"}, store.tenants)
+}
+
+func TestDispatch_OnlyOwnPersonsOfNonAdminWebhooks(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, r.URL.Path)
+	}))
+	defer receiver.Close()
+
+	events := []storage.EventType{storage.EventPersonCreated}
+	store := &memoryStore{hooks: []Webhook{
+		{Id: "1", Url: receiver.URL + "/jane", Events: events, CreatedBy: "jane", OwnPersonsOnly: true},
+		{Id: "2", Url: receiver.URL + "/admin", Events: events, CreatedBy: "admin"},
+	}}
+	dispatcher := newTestDispatcher(store, 1, time.Millisecond)
+	dispatcher.Dispatch(context.Background(), &storage.PersonEvent{Id: "1-0", Type: storage.EventPersonCreated, PersonId: "1", Owner: "john"})
+	dispatcher.Dispatch(context.Background(), &storage.PersonEvent{Id: "2-0", Type: storage.EventPersonCreated, PersonId: "2", Owner: "jane"})
+
+	assert.ElementsMatch(t, []string{"/admin", "/admin", "/jane"}, received)
+}
+
+func TestDispatch_SlowWebhookDoesNotDelayOthers(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer fast.Close()
+
+	events := []storage.EventType{storage.EventPersonCreated}
+	store := &memoryStore{hooks: []Webhook{{Id: "slow", Url: slow.URL, Events: events}}}
+	dispatcher := newTestDispatcher(store, 1, time.Millisecond)
+	dispatcher.enqueue(context.Background(), &storage.PersonEvent{Id: "1-0", Type: storage.EventPersonCreated}, false, func(bool) {})
+
+	store.hooks = []Webhook{{Id: "fast", Url: fast.URL, Events: events}}
+	done := make(chan struct{})
+	dispatcher.enqueue(context.Background(), &storage.PersonEvent{Id: "2-0", Type: storage.EventPersonCreated}, false, func(bool) { close(done) })
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("delivery waited for slow webhook")
+	}
+}
+
+func TestDispatch_FullQueueDoesNotBlockReading(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	store := &memoryStore{hooks: []Webhook{{Id: "slow", Url: slow.URL, Events: []storage.EventType{storage.EventPersonCreated}}}}
+	dispatcher := newTestDispatcher(store, 1, time.Millisecond)
+	// worker delivers first event while the others fill its queue
+	for i := 0; i <= webhookQueueSize; i++ {
+		dispatcher.enqueue(context.Background(), &storage.PersonEvent{Id: strconv.Itoa(i) + "-0", Type: storage.EventPersonCreated}, false, func(bool) {})
+		time.Sleep(time.Millisecond)
+	}
+
+	completed := make(chan bool, 1)
+	go dispatcher.enqueue(context.Background(), &storage.PersonEvent{Id: "full-0", Type: storage.EventPersonCreated}, false, func(complete bool) { completed <- complete })
+	select {
+	case complete := <-completed:
+		assert.False(t, complete)
+	case <-time.After(time.Second):
+		t.Fatal("reading waited for full webhook queue")
+	}
+}
+
+func TestDispatch_RefusesPrivateAddresses(t *testing.T) {
+	called := false
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer receiver.Close()
+
+	store := &memoryStore{hooks: []Webhook{
+		{Id: "1", Url: receiver.URL, Events: []storage.EventType{storage.EventPersonCreated}},
+	}}
+	// default client of dispatcher does not connect to loopback test server
+	dispatcher := NewDispatcher(nil, store, "test", 1, time.Millisecond)
+	dispatcher.Dispatch(context.Background(), &storage.PersonEvent{Id: "1-0", Type: storage.EventPersonCreated})
+
+	assert.False(t, called)
+	assert.Len(t, store.deadLetters, 1)
+	assert.Contains(t, store.deadLetters[0].Error, "is not public")
+}
+
+func TestValidateURL(t *testing.T) {
+	assert.NoError(t, ValidateURL("https://partner.example.com/hooks"))
+	assert.NoError(t, ValidateURL("https://8.8.8.8/hooks"))
+	for _, invalid := range []string{
+		"http://partner.example.com/hooks",
+		"ftp://partner.example.com/hooks",
+		"https://",
+		"https://localhost:8080/hooks",
+		"https://api.localhost/hooks",
+		"https://127.0.0.1/hooks",
+		"https://10.0.0.5/hooks",
+		"https://192.168.1.1/hooks",
+		"https://169.254.169.254/latest/meta-data",
+		"https://100.100.100.200/hooks",
+		"https://[::1]/hooks",
+		"https://[fe80::1]/hooks",
+		"https://[fd00::1]/hooks",
+		"https://0.0.0.0/hooks",
+	} {
+		assert.Error(t, ValidateURL(invalid), invalid)
+	}
+}
@@ -0,0 +1,187 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go-microservice-assignment/app/storage"
+	"go-microservice-assignment/app/tenant"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	webhooksKey      = "webhooks"
+	maxLogLength     = 100
+	deliveriesSuffix = ":deliveries"
+	deadLetterSuffix = ":dead-letters"
+)
+
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+type Webhook struct {
+	Id        string              `json:"id"`
+	Url       string              `json:"url"`
+	Events    []storage.EventType `json:"events"`
+	Secret    string              `json:"secret,omitempty"`
+	CreatedAt time.Time           `json:"createdAt"`
+	// subject of principal that registered webhook
+	CreatedBy string `json:"createdBy,omitempty"`
+	// webhooks registered by non-admins only get events of persons their owner created
+	OwnPersonsOnly bool `json:"ownPersonsOnly,omitempty"`
+}
+
+// Subscribed reports whether webhook wants to receive events of given type
+func (w *Webhook) Subscribed(eventType storage.EventType) bool {
+	for _, e := range w.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Receives reports whether event is delivered to webhook
+func (w *Webhook) Receives(event *storage.PersonEvent) bool {
+	if !w.Subscribed(event.Type) {
+		return false
+	}
+	if !w.OwnPersonsOnly {
+		return true
+	}
+	return event.Owner != "" && event.Owner == w.CreatedBy
+}
+
+// Delivery records the outcome of sending one event to one webhook
+type Delivery struct {
+	Id         string            `json:"id"`
+	WebhookId  string            `json:"webhookId"`
+	EventId    string            `json:"eventId"`
+	EventType  storage.EventType `json:"eventType"`
+	Attempts   int               `json:"attempts"`
+	StatusCode int               `json:"statusCode,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	Succeeded  bool              `json:"succeeded"`
+	Time       time.Time         `json:"time"`
+}
+
+type Store interface {
+	CreateWebhook(ctx context.Context, w *Webhook) error
+	GetWebhook(ctx context.Context, id string) (*Webhook, error)
+	ListWebhooks(ctx context.Context) ([]Webhook, error)
+	DeleteWebhook(ctx context.Context, id string) error
+	RecordDelivery(ctx context.Context, d *Delivery) error
+	ListDeliveries(ctx context.Context, webhookId string) ([]Delivery, error)
+	AddDeadLetter(ctx context.Context, d *Delivery) error
+	ListDeadLetters(ctx context.Context, webhookId string) ([]Delivery, error)
+}
+
+type redisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore keeps webhooks in a Redis hash and their delivery log and
+// dead letters in capped Redis lists, separately for every tenant
+func NewRedisStore(client *redis.Client) Store {
+	return &redisStore{client}
+}
+
+func (s *redisStore) CreateWebhook(ctx context.Context, w *Webhook) error {
+	data, err := json.Marshal(w)
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(ctx, tenant.Key(ctx, webhooksKey), w.Id, data).Err()
+}
+
+func (s *redisStore) GetWebhook(ctx context.Context, id string) (*Webhook, error) {
+	data, err := s.client.HGet(ctx, tenant.Key(ctx, webhooksKey), id).Result()
+	if err == redis.Nil {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var w Webhook
+	if err = json.Unmarshal([]byte(data), &w); err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func (s *redisStore) ListWebhooks(ctx context.Context) ([]Webhook, error) {
+	all, err := s.client.HGetAll(ctx, tenant.Key(ctx, webhooksKey)).Result()
+	if err != nil {
+		return nil, err
+	}
+	webhooks := make([]Webhook, 0, len(all))
+	for _, data := range all {
+		var w Webhook
+		if err = json.Unmarshal([]byte(data), &w); err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, nil
+}
+
+func (s *redisStore) DeleteWebhook(ctx context.Context, id string) error {
+	deleted, err := s.client.HDel(ctx, tenant.Key(ctx, webhooksKey), id).Result()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrWebhookNotFound
+	}
+	return s.client.Del(ctx, webhookKey(ctx, id)+deliveriesSuffix, webhookKey(ctx, id)+deadLetterSuffix).Err()
+}
+
+func (s *redisStore) RecordDelivery(ctx context.Context, d *Delivery) error {
+	return s.pushCapped(ctx, webhookKey(ctx, d.WebhookId)+deliveriesSuffix, d)
+}
+
+func (s *redisStore) ListDeliveries(ctx context.Context, webhookId string) ([]Delivery, error) {
+	return s.list(ctx, webhookKey(ctx, webhookId)+deliveriesSuffix)
+}
+
+func (s *redisStore) AddDeadLetter(ctx context.Context, d *Delivery) error {
+	return s.pushCapped(ctx, webhookKey(ctx, d.WebhookId)+deadLetterSuffix, d)
+}
+
+func (s *redisStore) ListDeadLetters(ctx context.Context, webhookId string) ([]Delivery, error) {
+	return s.list(ctx, webhookKey(ctx, webhookId)+deadLetterSuffix)
+}
+
+// pushCapped prepends delivery to the list and keeps only the newest entries
+func (s *redisStore) pushCapped(ctx context.Context, key string, d *Delivery) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	trans := s.client.TxPipeline()
+	trans.LPush(ctx, key, data)
+	trans.LTrim(ctx, key, 0, maxLogLength-1)
+	_, err = trans.Exec(ctx)
+	return err
+}
+
+func (s *redisStore) list(ctx context.Context, key string) ([]Delivery, error) {
+	items, err := s.client.LRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	deliveries := make([]Delivery, 0, len(items))
+	for _, item := range items {
+		var d Delivery
+		if err = json.Unmarshal([]byte(item), &d); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, nil
+}
+
+func webhookKey(ctx context.Context, id string) string {
+	return tenant.Key(ctx, "webhook:"+id)
+}
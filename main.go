@@ -2,15 +2,32 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
+	"fmt"
 	"github.com/go-redis/redis/v8"
 	"github.com/go-redsync/redsync/v4"
 	"github.com/go-redsync/redsync/v4/redis/goredis/v8"
+	"github.com/google/uuid"
 	"go-microservice-assignment/app"
+	"go-microservice-assignment/app/archive"
+	"go-microservice-assignment/app/audit"
+	"go-microservice-assignment/app/auth"
+	"go-microservice-assignment/app/encryption"
+	"go-microservice-assignment/app/gdpr"
+	"go-microservice-assignment/app/idempotency"
+	"go-microservice-assignment/app/projection"
+	"go-microservice-assignment/app/ratelimit"
 	"go-microservice-assignment/app/storage"
+	"go-microservice-assignment/app/tenant"
+	"go-microservice-assignment/app/webhooks"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -18,6 +35,11 @@ var rdb *redis.Client
 var ctx = context.Background()
 
 func main() {
+	// cancelled on shutdown, stops background jobs
+	var stop context.CancelFunc
+	ctx, stop = signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	log.Println("Connecting to Redis database...")
 	check(connectToRedis())
 
@@ -29,14 +51,173 @@ func main() {
 	rs := redsync.New(pool)
 	mutex := rs.NewMutex("update-person-lock")
 
-	db := storage.NewDB(rdb, mutex, time.Duration(keyExpireTime)*time.Minute)
+	// every tenant gets own update lock and optionally own idle time
+	tenantOptions, err := tenantIdleTimes(os.Getenv("TENANT_IDLE_TIME_MINUTES"))
+	check(err)
+
+	// field-level encryption of PII, keys are rotated by changing current key of keyring
+	storageOptions := tenantOptions
+	var idempotencyOptions []idempotency.Option
+	encrypted := false
+	if path := os.Getenv("ENCRYPTION_KEYRING_FILE"); path != "" {
+		keyring, err := encryption.LoadFileKeyring(path)
+		check(err)
+		encryptor := encryption.NewFieldEncryptor(keyring)
+		storageOptions = append(storageOptions, storage.WithEncryption(encryptor))
+		idempotencyOptions = append(idempotencyOptions, idempotency.WithEncryption(encryptor))
+		encrypted = true
+		log.Println("Encryption of personal data enabled, current key:", keyring.CurrentKeyId())
+	}
+
+	var db storage.RedisDB
+	db = storage.NewDB(rdb, mutex, time.Duration(keyExpireTime)*time.Minute, append(storageOptions,
+		storage.WithTenantLocks(rs),
+		storage.WithEventStreamMaxLen(int64(getEnvInt("EVENT_STREAM_MAX_LEN", 10000))),
+		storage.WithHistoryMaxLen(int64(getEnvInt("PERSON_HISTORY_MAX_LEN", 50))),
+		storage.WithAuditStreamMaxLen(int64(getEnvInt("AUDIT_STREAM_MAX_LEN", 1000000))))...)
+
+	// in-process read-through cache, enabled unless PERSON_CACHE_ENABLED=false
+	if getEnvBool("PERSON_CACHE_ENABLED", true) {
+		cacheSize := getEnvInt("PERSON_CACHE_SIZE", 1000)
+		cacheTTL := time.Duration(getEnvInt("PERSON_CACHE_TTL_SECONDS", 5)) * time.Second
+		cache := storage.NewCachedDB(db, rdb, cacheSize, cacheTTL)
+		go cache.Listen(ctx)
+		db = cache
+		log.Printf("Person cache enabled (size: %d, ttl: %s)\n", cacheSize, cacheTTL)
+	}
+
+	// optional in-process archiver of idle persons (replaces external backup service)
+	archiveSink, err := newArchiveSink()
+	check(err)
+	if getEnvBool("ARCHIVER_ENABLED", false) {
+		if archiveSink == nil {
+			check(fmt.Errorf("ARCHIVER_ENABLED requires ARCHIVE_SINK to be set"))
+		}
+		sweepInterval := time.Duration(getEnvInt("ARCHIVE_SWEEP_INTERVAL_SECONDS", 300)) * time.Second
+		archiver := archive.NewArchiver(rdb, rs, db, archiveSink, sweepInterval)
+		go archiver.Run(ctx)
+		log.Println("Archiver of idle persons enabled")
+	}
+
+	// permanently remove soft deleted persons after grace period
+	purger := storage.NewPurger(db,
+		time.Duration(getEnvInt("DELETE_GRACE_PERIOD_HOURS", 720))*time.Hour,
+		time.Duration(getEnvInt("PURGE_INTERVAL_SECONDS", 3600))*time.Second)
+	go purger.Run(ctx)
+
+	// encrypt persons stored in plaintext or with a retired key
+	if encrypted {
+		reencryptor := storage.NewReencryptor(db,
+			time.Duration(getEnvInt("REENCRYPT_INTERVAL_SECONDS", 3600))*time.Second)
+		go reencryptor.Run(ctx)
+	}
+
+	// index order of persons stored before lists were paged from indexes
+	go storage.NewOrderBackfiller(db, rdb).Run(ctx)
+
+	// structure single-line addresses of persons stored before postal addresses
+	if getEnvBool("ADDRESS_MIGRATION_ENABLED", false) {
+		go storage.NewAddressMigrator(db, os.Getenv("ADDRESS_DEFAULT_COUNTRY")).Run(ctx)
+	}
+
+	// deliver person events to registered webhooks
+	webhookStore := webhooks.NewRedisStore(rdb)
+	// consumer name is unique per process, events left pending by stopped
+	// replicas are claimed by the running ones
+	consumer := os.Getenv("WEBHOOK_CONSUMER")
+	if consumer == "" {
+		hostname, _ := os.Hostname()
+		consumer = hostname + "-" + uuid.New().String()[:8]
+	}
+	dispatcher := webhooks.NewDispatcher(rdb, webhookStore, consumer,
+		getEnvInt("WEBHOOK_MAX_ATTEMPTS", 5),
+		time.Duration(getEnvInt("WEBHOOK_BACKOFF_SECONDS", 1))*time.Second)
+	go dispatcher.Run(ctx)
+
+	authenticators, err := newAuthenticators()
+	check(err)
+
+	trustedProxies, err := parseNetworks(os.Getenv("TRUSTED_PROXIES"))
+	check(err)
+
+	options := []app.Option{app.WithAuthenticators(authenticators...), app.WithTrustedProxies(trustedProxies)}
+	if path := os.Getenv("AUTHZ_POLICY_FILE"); path != "" {
+		policy, err := auth.LoadPolicy(path)
+		check(err)
+		options = append(options, app.WithPolicy(policy))
+	}
+	if path := os.Getenv("PROJECTION_POLICY_FILE"); path != "" {
+		policy, err := projection.LoadPolicy(path)
+		check(err)
+		options = append(options, app.WithProjection(policy))
+	}
+	if getEnvBool("API_KEYS_ENABLED", false) {
+		options = append(options, app.WithAPIKeys(auth.NewRedisAPIKeyStore(rdb), os.Getenv("API_KEYS_BOOTSTRAP_KEY")))
+		log.Println("API key authentication enabled")
+	}
+	if seed := os.Getenv("ERASURE_SIGNING_KEY"); seed != "" {
+		key, err := base64.StdEncoding.DecodeString(seed)
+		check(err)
+		signer, err := gdpr.NewSigner(key)
+		check(err)
+		options = append(options, app.WithErasure(signer))
+		log.Println("Erasure of persons enabled, receipt key:", signer.KeyId())
+	}
+	if getEnvBool("RATE_LIMIT_ENABLED", false) {
+		limits := ratelimit.DefaultConfig()
+		if path := os.Getenv("RATE_LIMIT_CONFIG_FILE"); path != "" {
+			limits, err = ratelimit.LoadConfig(path)
+			check(err)
+		}
+		options = append(options, app.WithRateLimit(ratelimit.NewRedisLimiter(rdb), limits))
+		log.Println("Rate limiting enabled")
+	}
 
-	application := app.New(db)
+	// event streams block in XREAD on own connections, so they cannot exhaust the shared pool
+	maxEventStreams := getEnvInt("EVENTS_MAX_STREAMS", 100)
+	eventsClient := redis.NewClient(&redis.Options{
+		Addr:     os.Getenv("REDIS_URL"),
+		Password: os.Getenv("REDIS_PASSWORD"),
+		DB:       0, // use default DB
+		PoolSize: maxEventStreams,
+	})
+	defer eventsClient.Close()
+
+	application := app.New(db, append(options,
+		app.WithEventLog(storage.NewEventLog(eventsClient)),
+		app.WithMaxEventStreams(maxEventStreams),
+		app.WithWebhooks(webhookStore),
+		app.WithAudit(audit.NewRedisStore(rdb)),
+		app.WithArchive(archiveSink, getEnvBool("ARCHIVE_READ_FALLBACK", false)),
+		app.WithIdempotency(idempotency.NewRedisStore(rdb,
+			time.Duration(getEnvInt("IDEMPOTENCY_WINDOW_HOURS", 24))*time.Hour, idempotencyOptions...)),
+		app.WithMaxBatchSize(getEnvInt("BATCH_MAX_SIZE", 100)),
+		app.WithTenantRequired(getEnvBool("TENANT_REQUIRED", false)),
+		app.WithReadRefreshesExpiry(getEnvBool("READ_REFRESHES_EXPIRY", false)),
+		app.WithHeartbeatInterval(time.Duration(getEnvInt("EVENTS_HEARTBEAT_SECONDS", 15))*time.Second))...)
 	http.HandleFunc("/", application.Router.ServeHTTP)
 
+	server := &http.Server{Addr: ":8000"}
+	// requests in flight complete, only event streams are ended
+	server.RegisterOnShutdown(application.CloseEventStreams)
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		log.Println("Shutting down application...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Println(err)
+		}
+	}()
+
 	log.Println("Application started at port 8000")
-	err = http.ListenAndServe(":8000", nil)
-	check(err)
+	err = server.ListenAndServe()
+	if err != http.ErrServerClosed {
+		check(err)
+	}
+	<-shutdownDone
 }
 
 func connectToRedis() error {
@@ -50,6 +231,130 @@ func connectToRedis() error {
 	return err
 }
 
+// newArchiveSink creates sink configured by ARCHIVE_SINK ("file" or "s3"),
+// or returns nil when archiving is not configured
+func newArchiveSink() (archive.Sink, error) {
+	switch os.Getenv("ARCHIVE_SINK") {
+	case "":
+		return nil, nil
+	case "file":
+		return archive.NewFileSink(getEnv("ARCHIVE_DIR", "archive"))
+	case "s3":
+		return archive.NewS3Sink(archive.S3Config{
+			Endpoint:  os.Getenv("ARCHIVE_S3_ENDPOINT"),
+			Region:    getEnv("ARCHIVE_S3_REGION", "us-east-1"),
+			Bucket:    os.Getenv("ARCHIVE_S3_BUCKET"),
+			Prefix:    os.Getenv("ARCHIVE_S3_PREFIX"),
+			AccessKey: os.Getenv("ARCHIVE_S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("ARCHIVE_S3_SECRET_KEY"),
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown ARCHIVE_SINK %q", os.Getenv("ARCHIVE_SINK"))
+	}
+}
+
+// newAuthenticators configures JWT bearer authentication when signing secret or keys are set
+func newAuthenticators() ([]auth.Authenticator, error) {
+	config := auth.JWTConfig{
+		Issuer:   os.Getenv("JWT_ISSUER"),
+		Audience: os.Getenv("JWT_AUDIENCE"),
+	}
+	if secret := os.Getenv("JWT_HS256_SECRET"); secret != "" {
+		config.HMACSecret = []byte(secret)
+	}
+	if path := os.Getenv("JWT_JWKS_FILE"); path != "" {
+		keys, err := auth.LoadJWKSFile(path)
+		if err != nil {
+			return nil, err
+		}
+		config.Keys = keys
+	} else if url := os.Getenv("JWT_JWKS_URL"); url != "" {
+		config.Keys = auth.NewRemoteKeySet(url)
+	}
+
+	var authenticators []auth.Authenticator
+	if config.HMACSecret != nil || config.Keys != nil {
+		authenticators = append(authenticators, auth.NewJWTAuthenticator(config))
+		log.Println("JWT authentication enabled")
+	}
+	return authenticators, nil
+}
+
+// tenantIdleTimes parses idle times of tenants given as "acme=60,globex=1440" (minutes)
+func tenantIdleTimes(value string) ([]storage.Option, error) {
+	var options []storage.Option
+	for _, item := range strings.Split(value, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || !tenant.Valid(strings.TrimSpace(parts[0])) {
+			return nil, fmt.Errorf("invalid TENANT_IDLE_TIME_MINUTES item %q", item)
+		}
+		minutes, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || minutes <= 0 {
+			return nil, fmt.Errorf("invalid TENANT_IDLE_TIME_MINUTES item %q", item)
+		}
+		options = append(options, storage.WithTenantIdleTime(strings.TrimSpace(parts[0]), time.Duration(minutes)*time.Minute))
+	}
+	return options, nil
+}
+
+// parseNetworks parses comma separated CIDRs or single addresses
+func parseNetworks(spec string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, value := range strings.Split(spec, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", value)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				bits = 8 * net.IPv4len
+			}
+			value = fmt.Sprintf("%s/%d", value, bits)
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func getEnv(name string, defaultValue string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvInt(name string, defaultValue int) int {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return defaultValue
+	}
+	i, err := strconv.Atoi(value)
+	check(err)
+	return i
+}
+
+func getEnvBool(name string, defaultValue bool) bool {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(value)
+	check(err)
+	return b
+}
+
 func check(e error) {
 	if e != nil {
 		log.Println(e)